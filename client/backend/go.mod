@@ -5,10 +5,16 @@ go 1.23.0
 require (
 	github.com/gin-gonic/gin v1.11.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/k0ngk0ng/wire-socket/pkg/wstunnel v0.0.0
 	github.com/kardianos/service v1.2.4
+	wire-socket/pkg/metrics v0.0.0
 	wire-socket/pkg/wireguard v0.0.0
 )
 
+replace github.com/k0ngk0ng/wire-socket/pkg/wstunnel => ../../pkg/wstunnel
+
+replace wire-socket/pkg/metrics => ../../pkg/metrics
+
 replace wire-socket/pkg/wireguard => ../../pkg/wireguard
 
 require (
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"time"
+	"wire-socket-client/internal/connection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsInterval is how often the event stream sends traffic stats while connected
+const statsInterval = time.Second
+
+// keepaliveTicks is how many stats intervals pass without a stats event
+// (while disconnected) before a keepalive comment is sent
+const keepaliveTicks = 15
+
+// statsEvent is the payload of "stats" events
+type statsEvent struct {
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	RxSpeed uint64 `json:"rx_speed"` // bytes/sec
+	TxSpeed uint64 `json:"tx_speed"`
+	Latency int    `json:"latency"` // ms
+}
+
+// streamEvents serves a Server-Sent Events stream of connection events, so
+// the UI doesn't have to poll /api/status. The stream starts with a "state"
+// event carrying the current status, then sends "state", "routes", "quota",
+// "notice" and "error" events as they happen and "stats" every second while
+// connected.
+func (s *Server) streamEvents(c *gin.Context) {
+	events, unsubscribe := s.connMgr.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(connection.EventState, s.connMgr.GetStatus())
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	idle := 0
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(e.Type, e.Data)
+		case <-ticker.C:
+			status := s.connMgr.GetStatus()
+			if status.State != connection.StateConnected {
+				if idle++; idle >= keepaliveTicks {
+					idle = 0
+					fmt.Fprint(w, ": keepalive\n\n")
+				}
+				return true
+			}
+			c.SSEvent("stats", statsEvent{
+				RxBytes: status.RxBytes,
+				TxBytes: status.TxBytes,
+				RxSpeed: status.RxSpeed,
+				TxSpeed: status.TxSpeed,
+				Latency: status.Latency,
+			})
+		}
+		return true
+	})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"wire-socket-client/internal/connection"
+
+	"github.com/gin-gonic/gin"
+	pm "wire-socket/pkg/metrics"
+)
+
+// connectionStates are the values of the state label, reported as 1 for the
+// current state and 0 for the others
+var connectionStates = []connection.State{
+	connection.StateDisconnected,
+	connection.StateConnecting,
+	connection.StateConnected,
+	connection.StateFailed,
+}
+
+// newMetricsHandler creates the /metrics handler. Everything is read from the
+// connection manager at scrape time.
+func newMetricsHandler(connMgr *connection.Manager) gin.HandlerFunc {
+	r := pm.NewRegistry()
+
+	r.NewGaugeFunc("wiresocket_client_connection_state", "Current connection state (1 for the active state).",
+		func(emit func(float64, ...string)) {
+			state := connMgr.GetStatus().State
+			for _, s := range connectionStates {
+				value := 0.0
+				if s == state {
+					value = 1
+				}
+				emit(value, string(s))
+			}
+		}, "state")
+
+	r.NewCounterFunc("wiresocket_client_connects_total", "Connection attempts by result.",
+		func(emit func(float64, ...string)) {
+			succeeded, failed := connMgr.ConnectStats()
+			emit(float64(succeeded), "success")
+			emit(float64(failed), "failure")
+		}, "result")
+
+	r.NewGaugeFunc("wiresocket_client_bytes", "WireGuard traffic of the current connection (rx = received, tx = sent).",
+		func(emit func(float64, ...string)) {
+			status := connMgr.GetStatus()
+			if status.State != connection.StateConnected {
+				return
+			}
+			emit(float64(status.RxBytes), "rx")
+			emit(float64(status.TxBytes), "tx")
+		}, "direction")
+
+	r.NewGaugeFunc("wiresocket_client_latency_seconds", "Latest measured latency to the server.",
+		func(emit func(float64, ...string)) {
+			status := connMgr.GetStatus()
+			if status.State != connection.StateConnected || status.Latency <= 0 {
+				return
+			}
+			emit(float64(status.Latency) / 1000)
+		})
+
+	r.NewGaugeFunc("wiresocket_client_quota_remaining_bytes", "Data remaining in the monthly quota, if the server sets one.",
+		func(emit func(float64, ...string)) {
+			status := connMgr.GetStatus()
+			if status.Quota == nil || status.Quota.QuotaBytes <= 0 {
+				return
+			}
+			emit(float64(status.Quota.RemainingBytes))
+		})
+
+	handler := r.Handler()
+	return func(c *gin.Context) {
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
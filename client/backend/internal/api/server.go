@@ -51,12 +51,14 @@ func NewServer(connMgr *connection.Manager, addr string) *Server {
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
 	s.engine.GET("/health", s.healthCheck)
+	s.engine.GET("/metrics", newMetricsHandler(s.connMgr))
 
 	api := s.engine.Group("/api")
 	{
 		api.POST("/connect", s.connect)
 		api.POST("/disconnect", s.disconnect)
 		api.GET("/status", s.getStatus)
+		api.GET("/events", s.streamEvents)
 		api.GET("/servers", s.getServers)
 
 		// Route management
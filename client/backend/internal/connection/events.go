@@ -0,0 +1,93 @@
+package connection
+
+import "sync"
+
+// Event types sent to subscribers
+const (
+	EventState  = "state"  // Data: Status, sent on every state transition
+	EventRoutes = "routes" // Data: RoutesEvent, sent when routes are applied
+	EventQuota  = "quota"  // Data: *Quota, sent when the quota is refreshed
+	EventError  = "error"  // Data: ErrorEvent
+	EventNotice = "notice" // Data: NoticeEvent, sent when the server sends a notice
+)
+
+// Event is a change in the connection, delivered to subscribers
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// RoutesEvent is the payload of EventRoutes
+type RoutesEvent struct {
+	AvailableRoutes []string `json:"available_routes"`
+	ActiveRoutes    []string `json:"active_routes"`
+}
+
+// ErrorEvent is the payload of EventError
+type ErrorEvent struct {
+	Error string `json:"error"`
+}
+
+// NoticeEvent is the payload of EventNotice
+type NoticeEvent struct {
+	Message string `json:"message"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriberBuffer = 32
+
+// eventHub fans events out to subscribers
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving connection events and a function
+// that unsubscribes and closes it
+func (m *Manager) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	m.events.mu.Lock()
+	if m.events.subs == nil {
+		m.events.subs = make(map[chan Event]struct{})
+	}
+	m.events.subs[ch] = struct{}{}
+	m.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			delete(m.events.subs, ch)
+			m.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// emit sends an event to every subscriber without blocking
+func (m *Manager) emit(eventType string, data interface{}) {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+
+	for ch := range m.events.subs {
+		select {
+		case ch <- Event{Type: eventType, Data: data}:
+		default:
+		}
+	}
+}
+
+// emitState sends the current status. Must be called without holding mu.
+func (m *Manager) emitState() {
+	m.emit(EventState, m.GetStatus())
+}
+
+// emitRoutes sends the current routes. Must be called without holding mu.
+func (m *Manager) emitRoutes() {
+	m.emit(EventRoutes, RoutesEvent{
+		AvailableRoutes: m.GetAvailableRoutes(),
+		ActiveRoutes:    m.GetActiveRoutes(),
+	})
+}
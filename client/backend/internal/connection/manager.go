@@ -2,6 +2,7 @@ package connection
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +11,16 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"wire-socket-client/internal/wireguard"
-	"wire-socket-client/internal/wstunnel"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/proxy"
 )
 
 // State represents the connection state
@@ -29,11 +35,12 @@ const (
 
 // ServerConfig represents a saved server configuration
 type ServerConfig struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Address     string    `json:"address"` // Server API address
-	Username    string    `json:"username"`
-	LastUsed    time.Time `json:"last_used,omitempty"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Address  string    `json:"address"` // Server API address
+	Username string    `json:"username"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+	Trust              // How the server's certificates are verified
 }
 
 // RouteSettings stores user's route preferences
@@ -47,26 +54,97 @@ type ConnectRequest struct {
 	TunnelURL     string `json:"tunnel_url"`     // Tunnel URL (e.g., "https://vpn.example.com/tunnel")
 	Username      string `json:"username"`
 	Password      string `json:"password"`
+
+	// Upstream proxy URL (http, https or socks5); empty uses the system
+	// proxy settings (HTTPS_PROXY, HTTP_PROXY, NO_PROXY), "direct" none
+	Proxy         string `json:"proxy,omitempty"`
+	ProxyUsername string `json:"proxy_username,omitempty"`
+	ProxyPassword string `json:"proxy_password,omitempty"`
+
+	// TLS trust, saved in the server's profile (see Trust): a PEM CA bundle
+	// replacing the saved one, pins to add (e.g. the key of a certificate
+	// the user confirmed), whether to forget the saved pins first, and
+	// whether to skip verification (absent keeps the saved setting)
+	CACert    string   `json:"ca_cert,omitempty"`
+	Pins      []string `json:"pins,omitempty"`
+	ResetPins bool     `json:"reset_pins,omitempty"`
+	Insecure  *bool    `json:"insecure,omitempty"`
+
+	// Connect address, SNI, Host and extra headers for the API and the
+	// tunnel, e.g. to reach the server through a CDN
+	wstunnel.Fronting
+
+	// Spread the tunnel over several connections, e.g. over different
+	// networks or tunnel URLs
+	Bonding wstunnel.Bonding `json:"bonding,omitempty"`
+}
+
+// proxyConfig returns the upstream proxy settings of the request
+func (req ConnectRequest) proxyConfig() proxy.Config {
+	return proxy.Config{URL: req.Proxy, Username: req.ProxyUsername, Password: req.ProxyPassword}
 }
 
 // Status represents the current connection status
 type Status struct {
-	State          State     `json:"state"`
-	ServerName     string    `json:"server_name,omitempty"`
-	AssignedIP     string    `json:"assigned_ip,omitempty"`
-	PublicIP       string    `json:"public_ip,omitempty"`
-	ConnectedSince time.Time `json:"connected_since,omitempty"`
-	RxBytes        uint64    `json:"rx_bytes"`
-	TxBytes        uint64    `json:"tx_bytes"`
-	RxSpeed        uint64    `json:"rx_speed"` // bytes/sec
-	TxSpeed        uint64    `json:"tx_speed"`
-	Latency        int       `json:"latency"` // ms
-	Error          string    `json:"error,omitempty"`
-	AvailableRoutes []string `json:"available_routes,omitempty"` // Routes received from server
-	ActiveRoutes    []string `json:"active_routes,omitempty"`    // Routes actually applied
-	Token           string   `json:"token,omitempty"`            // Auth token for API calls
+	State           State     `json:"state"`
+	ServerName      string    `json:"server_name,omitempty"`
+	AssignedIP      string    `json:"assigned_ip,omitempty"`
+	PublicIP        string    `json:"public_ip,omitempty"`
+	ConnectedSince  time.Time `json:"connected_since,omitempty"`
+	RxBytes         uint64    `json:"rx_bytes"`
+	TxBytes         uint64    `json:"tx_bytes"`
+	RxSpeed         uint64    `json:"rx_speed"` // bytes/sec
+	TxSpeed         uint64    `json:"tx_speed"`
+	Latency         int       `json:"latency"` // ms
+	Error           string    `json:"error,omitempty"`
+	AvailableRoutes []string  `json:"available_routes,omitempty"` // Routes received from server
+	ActiveRoutes    []string  `json:"active_routes,omitempty"`    // Routes actually applied
+	Token           string    `json:"token,omitempty"`            // Auth token for API calls
+	Quota           *Quota    `json:"quota,omitempty"`            // Data quota reported by the server
+	Notice          string    `json:"notice,omitempty"`           // Last notice from the server, e.g. about maintenance
+	CertificatePin  string    `json:"certificate_pin,omitempty"`  // Key of an untrusted server certificate, for the user to confirm
+	Sessions        []Session `json:"sessions,omitempty"`         // Tunnel sessions, one per local UDP source
+}
+
+// Session is the tunnel session of one local UDP source, e.g. the WireGuard
+// interface
+type Session struct {
+	LocalAddr  string    `json:"local_addr"`
+	Since      time.Time `json:"since"`
+	LastActive time.Time `json:"last_active"`
+	RxPackets  uint64    `json:"rx_packets"`
+	RxBytes    uint64    `json:"rx_bytes"`
+	TxPackets  uint64    `json:"tx_packets"`
+	TxBytes    uint64    `json:"tx_bytes"`
+	Latency    int       `json:"latency"`         // ms
+	Paths      []Path    `json:"paths,omitempty"` // Connections of the session with bonding
+}
+
+// Path is one connection of a bonded tunnel session
+type Path struct {
+	URL       string    `json:"url"`
+	Interface string    `json:"interface,omitempty"`
+	Up        bool      `json:"up"`
+	Since     time.Time `json:"since,omitempty"`
+	RxPackets uint64    `json:"rx_packets"`
+	RxBytes   uint64    `json:"rx_bytes"`
+	TxPackets uint64    `json:"tx_packets"`
+	TxBytes   uint64    `json:"tx_bytes"`
+	Latency   int       `json:"latency"` // ms
 }
 
+// Quota is the user's monthly data quota as reported by the server
+type Quota struct {
+	QuotaBytes     int64     `json:"quota_bytes"`     // 0 = unlimited
+	UsedBytes      int64     `json:"used_bytes"`      // Traffic in the current period
+	RemainingBytes int64     `json:"remaining_bytes"` // Only meaningful when quota_bytes > 0
+	RateLimitKbps  int       `json:"rate_limit_kbps"` // 0 = unlimited
+	ResetsAt       time.Time `json:"resets_at"`
+}
+
+// quotaRefreshInterval is how often the quota is refreshed while connected
+const quotaRefreshInterval = time.Minute
+
 // Manager manages VPN connections
 type Manager struct {
 	mu              sync.RWMutex
@@ -85,6 +163,14 @@ type Manager struct {
 	routeConfigPath string
 	availableRoutes []string // Routes from server
 	activeRoutes    []string // Routes actually applied
+	quota           *Quota
+	quotaStop       chan struct{} // Closed on disconnect to stop quota refreshes
+	notice          string        // Last notice from the server
+
+	connectsSucceeded atomic.Uint64
+	connectsFailed    atomic.Uint64
+
+	events eventHub // Subscribers to state, route, quota and error events
 }
 
 // NewManager creates a new connection manager
@@ -137,6 +223,18 @@ func (m *Manager) Connect(req ConnectRequest) error {
 	if m.state == StateConnected || m.state == StateConnecting {
 		return errors.New("already connected or connecting")
 	}
+	if err := req.proxyConfig().Validate(); err != nil {
+		return err
+	}
+	if err := validatePins(req.Pins); err != nil {
+		return err
+	}
+	if err := req.Fronting.Validate(); err != nil {
+		return err
+	}
+	if err := req.Bonding.Validate(); err != nil {
+		return err
+	}
 
 	m.state = StateConnecting
 	m.lastError = nil
@@ -148,13 +246,44 @@ func (m *Manager) Connect(req ConnectRequest) error {
 }
 
 func (m *Manager) doConnect(req ConnectRequest) {
+	m.emitState()
+
 	// Step 1: Authenticate with server and get WireGuard config
-	wgConfig, token, tunnelURL, routes, err := m.authenticate(req)
+	trust := m.serverTrust(req)
+	verifier, err := newVerifier(trust)
+	if err != nil {
+		m.setError(fmt.Errorf("invalid TLS settings: %w", err))
+		return
+	}
+	apiClient, err := newHTTPClient(req.proxyConfig(), req.Fronting, verifier, normalizeServerURL(req.ServerAddress))
+	if err != nil {
+		m.setError(err)
+		return
+	}
+	serverCfg, token, err := m.authenticate(apiClient, req)
 	if err != nil {
 		m.setError(fmt.Errorf("authentication failed: %w", err))
 		return
 	}
 
+	// Pins published by the server (over the connection just verified) are
+	// added to those the user configured, confirmed or learned before, which
+	// only ResetPins removes
+	if len(serverCfg.TLSPins) > 0 && !trust.Insecure {
+		pins := trust.Pins
+		for _, pin := range serverCfg.TLSPins {
+			if !slices.Contains(pins, pin) {
+				pins = append(slices.Clip(pins), pin)
+			}
+		}
+		if err := validatePins(serverCfg.TLSPins); err != nil {
+			fmt.Printf("Ignoring pins from server: %v\n", err)
+		} else if verifier, err = newVerifier(Trust{CACert: trust.CACert, Pins: pins}); err == nil {
+			trust.Pins = pins
+		}
+	}
+	wgConfig, tunnelURL, routes, quota := &serverCfg.Config, serverCfg.TunnelURL, serverCfg.Routes, serverCfg.Quota
+
 	m.token = token
 	m.assignedIP = wgConfig.Address
 	m.peerPublicKey = wgConfig.Peer.PublicKey
@@ -170,10 +299,25 @@ func (m *Manager) doConnect(req ConnectRequest) {
 		}
 	}
 
-	wstunnelClient := wstunnel.NewClient(wstunnel.Config{
+	tunnelHost := ""
+	if u, err := url.Parse(wsURL); err == nil {
+		tunnelHost = u.Hostname()
+	}
+	wstunnelClient := wstunnel.NewClient(wstunnel.ClientConfig{
 		LocalAddr: "127.0.0.1:0", // Use dynamic port to avoid conflicts
 		ServerURL: wsURL,
-		Insecure:  true, // TODO: Add proper TLS verification
+		TLSConfig: verifier.tlsConfig(req.Fronting.ServerName(tunnelHost)),
+		TLSConfigFor: func(host string) *tls.Config {
+			return verifier.tlsConfig(req.Fronting.ServerName(host))
+		},
+		Token:     token,
+		Shaping:   serverCfg.TunnelShaping,
+		Keepalive: serverCfg.TunnelKeepalive,
+		Proxy:     req.proxyConfig(),
+		Fronting:  req.Fronting,
+		Bonding:   req.Bonding,
+		OnNotice:  m.handleNotice,
+		OnClose:   m.handleTunnelClosed,
 	})
 
 	if err := wstunnelClient.Start(); err != nil {
@@ -230,28 +374,39 @@ func (m *Manager) doConnect(req ConnectRequest) {
 	m.mu.Lock()
 	m.state = StateConnected
 	m.connectedAt = time.Now()
+	m.quota = quota
+	m.quotaStop = make(chan struct{})
+	go m.refreshQuota(apiClient, normalizeServerURL(req.ServerAddress), token, m.quotaStop)
+	if len(trust.Pins) == 0 && !trust.Insecure {
+		trust.Pins = verifier.learnedPins()
+	}
 	m.currentServer = &ServerConfig{
 		Name:     req.ServerAddress,
 		Address:  req.ServerAddress,
 		Username: req.Username,
 		LastUsed: time.Now(),
+		Trust:    trust,
 	}
 	m.mu.Unlock()
 
 	// Save server config
 	m.saveServer(*m.currentServer)
 
+	m.connectsSucceeded.Add(1)
+	m.emitState()
+	m.emitRoutes()
 	fmt.Println("VPN connected successfully!")
 }
 
 // Disconnect closes the VPN connection
 func (m *Manager) Disconnect() error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	if m.state == StateDisconnected {
+		m.mu.Unlock()
 		return nil
 	}
+	defer m.emitState() // Runs after the unlock below
+	defer m.mu.Unlock()
 
 	// Stop wstunnel
 	if m.wstunnelClient != nil {
@@ -265,10 +420,17 @@ func (m *Manager) Disconnect() error {
 		m.wgInterface = nil
 	}
 
+	if m.quotaStop != nil {
+		close(m.quotaStop)
+		m.quotaStop = nil
+	}
+
 	m.state = StateDisconnected
 	m.currentServer = nil
 	m.token = ""
 	m.assignedIP = ""
+	m.quota = nil
+	m.notice = ""
 
 	return nil
 }
@@ -290,6 +452,11 @@ func (m *Manager) GetStatus() Status {
 		status.AssignedIP = m.assignedIP
 		status.ConnectedSince = m.connectedAt
 		status.Token = m.token
+		status.Notice = m.notice
+		if m.quota != nil {
+			quota := *m.quota
+			status.Quota = &quota
+		}
 
 		// Include route information
 		status.AvailableRoutes = make([]string, len(m.availableRoutes))
@@ -297,6 +464,36 @@ func (m *Manager) GetStatus() Status {
 		status.ActiveRoutes = make([]string, len(m.activeRoutes))
 		copy(status.ActiveRoutes, m.activeRoutes)
 
+		if m.wstunnelClient != nil {
+			status.Latency = milliseconds(m.wstunnelClient.Latency())
+			for _, s := range m.wstunnelClient.Sessions() {
+				session := Session{
+					LocalAddr:  s.LocalAddr,
+					Since:      s.Since,
+					LastActive: s.LastActive,
+					RxPackets:  s.PacketsReceived,
+					RxBytes:    s.BytesReceived,
+					TxPackets:  s.PacketsSent,
+					TxBytes:    s.BytesSent,
+					Latency:    milliseconds(s.Latency),
+				}
+				for _, p := range s.Paths {
+					session.Paths = append(session.Paths, Path{
+						URL:       p.URL,
+						Interface: p.Interface,
+						Up:        p.Up,
+						Since:     p.Since,
+						RxPackets: p.PacketsReceived,
+						RxBytes:   p.BytesReceived,
+						TxPackets: p.PacketsSent,
+						TxBytes:   p.BytesSent,
+						Latency:   milliseconds(p.Latency),
+					})
+				}
+				status.Sessions = append(status.Sessions, session)
+			}
+		}
+
 		// Get traffic stats from WireGuard
 		if m.wgInterface != nil {
 			stats, err := m.wgInterface.GetStats()
@@ -311,13 +508,79 @@ func (m *Manager) GetStatus() Status {
 
 	if m.lastError != nil {
 		status.Error = m.lastError.Error()
+		var certErr *CertificateError
+		if errors.As(m.lastError, &certErr) {
+			status.CertificatePin = certErr.Pin
+		}
 	}
 
 	return status
 }
 
-// authenticate performs authentication with the server
-func (m *Manager) authenticate(req ConnectRequest) (*wireguard.WGConfig, string, string, []string, error) {
+// milliseconds returns d in milliseconds, rounded up, so that sub-millisecond
+// latencies don't read as unmeasured
+func milliseconds(d time.Duration) int {
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// serverConfig is the response of the server's /api/config
+type serverConfig struct {
+	Config          wireguard.WGConfig `json:"config"`
+	TunnelURL       string             `json:"tunnel_url"`
+	TunnelShaping   framing.Shaping    `json:"tunnel_shaping"`   // Absent unless the server shapes tunnel traffic
+	TunnelKeepalive framing.Keepalive  `json:"tunnel_keepalive"` // Absent if the server uses the default
+	TLSPins         []string           `json:"tls_pins"`         // Pins the server asks clients to require
+	Routes          []string           `json:"routes"`
+	Quota           *Quota             `json:"quota"`
+}
+
+// newHTTPClient returns a client for the API at apiBase that connects through
+// the upstream proxy and the front, and verifies the server with verifier
+func newHTTPClient(cfg proxy.Config, fronting wstunnel.Fronting, verifier *verifier, apiBase string) (*http.Client, error) {
+	dial, err := cfg.Dialer(apiBase)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(apiBase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &frontingTransport{
+			fronting: fronting,
+			base: &http.Transport{
+				DialContext:         fronting.Dialer(dial),
+				TLSClientConfig:     verifier.tlsConfig(fronting.ServerName(u.Hostname())),
+				TLSHandshakeTimeout: 10 * time.Second,
+				ForceAttemptHTTP2:   true,
+			},
+		},
+	}, nil
+}
+
+// frontingTransport sets the Host and extra headers of a front on requests
+type frontingTransport struct {
+	fronting wstunnel.Fronting
+	base     http.RoundTripper
+}
+
+func (t *frontingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fronting.Host == "" && len(t.fronting.Headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	t.fronting.SetHeaders(req.Header)
+	if t.fronting.Host != "" {
+		req.Host = t.fronting.Host
+		req.Header.Del("Host")
+	}
+	return t.base.RoundTrip(req)
+}
+
+// authenticate logs in and fetches the client configuration. It returns the
+// configuration and the login token.
+func (m *Manager) authenticate(client *http.Client, req ConnectRequest) (*serverConfig, string, error) {
 	// Build API URL - normalize the server address
 	apiBase := normalizeServerURL(req.ServerAddress)
 	apiURL := apiBase + "/api/auth/login"
@@ -330,18 +593,18 @@ func (m *Manager) authenticate(req ConnectRequest) (*wireguard.WGConfig, string,
 
 	jsonData, err := json.Marshal(loginData)
 	if err != nil {
-		return nil, "", "", nil, err
+		return nil, "", err
 	}
 
 	// Send login request
-	resp, err := http.Post(apiURL, "application/json", bytes.NewReader(jsonData))
+	resp, err := client.Post(apiURL, "application/json", bytes.NewReader(jsonData))
 	if err != nil {
-		return nil, "", "", nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", "", nil, fmt.Errorf("authentication failed with status: %d", resp.StatusCode)
+		return nil, "", fmt.Errorf("authentication failed with status: %d", resp.StatusCode)
 	}
 
 	// Parse response
@@ -350,7 +613,7 @@ func (m *Manager) authenticate(req ConnectRequest) (*wireguard.WGConfig, string,
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&loginResp); err != nil {
-		return nil, "", "", nil, err
+		return nil, "", err
 	}
 
 	// Get WireGuard config
@@ -358,36 +621,125 @@ func (m *Manager) authenticate(req ConnectRequest) (*wireguard.WGConfig, string,
 	configReq, _ := http.NewRequest("GET", configURL, nil)
 	configReq.Header.Set("Authorization", "Bearer "+loginResp.Token)
 
-	client := &http.Client{}
 	configResp, err := client.Do(configReq)
 	if err != nil {
-		return nil, "", "", nil, err
+		return nil, "", err
 	}
 	defer configResp.Body.Close()
 
 	if configResp.StatusCode != http.StatusOK {
-		return nil, "", "", nil, fmt.Errorf("failed to get config with status: %d", configResp.StatusCode)
+		// The server explains refusals such as an exhausted quota
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(configResp.Body).Decode(&errResp) == nil && errResp.Error != "" {
+			return nil, "", fmt.Errorf("%s", errResp.Error)
+		}
+		return nil, "", fmt.Errorf("failed to get config with status: %d", configResp.StatusCode)
 	}
 
-	var configData struct {
-		Config    wireguard.WGConfig `json:"config"`
-		TunnelURL string             `json:"tunnel_url"`
-		Routes    []string           `json:"routes"`
+	var configData serverConfig
+	if err := json.NewDecoder(configResp.Body).Decode(&configData); err != nil {
+		return nil, "", err
 	}
 
-	if err := json.NewDecoder(configResp.Body).Decode(&configData); err != nil {
-		return nil, "", "", nil, err
+	return &configData, loginResp.Token, nil
+}
+
+// refreshQuota polls the server for the remaining quota until stop is closed
+func (m *Manager) refreshQuota(client *http.Client, apiBase, token string, stop chan struct{}) {
+	ticker := time.NewTicker(quotaRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		req, err := http.NewRequest("GET", apiBase+"/api/status", nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		var result struct {
+			Quota *Quota `json:"quota"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || result.Quota == nil {
+			continue
+		}
+
+		var exceeded error
+		m.mu.Lock()
+		current := m.quotaStop == stop
+		if current {
+			m.quota = result.Quota
+			if result.Quota.QuotaBytes > 0 && result.Quota.RemainingBytes <= 0 {
+				exceeded = fmt.Errorf("monthly data quota exceeded (resets %s)", result.Quota.ResetsAt.Format("2006-01-02"))
+				m.lastError = exceeded
+			}
+		}
+		m.mu.Unlock()
+
+		if current {
+			m.emit(EventQuota, result.Quota)
+		}
+		if exceeded != nil {
+			m.emit(EventError, ErrorEvent{Error: exceeded.Error()})
+		}
 	}
+}
 
-	return &configData.Config, loginResp.Token, configData.TunnelURL, configData.Routes, nil
+// handleNotice records a notice from the server and passes it on to
+// subscribers
+func (m *Manager) handleNotice(text string) {
+	m.mu.Lock()
+	m.notice = text
+	m.mu.Unlock()
+
+	m.emit(EventNotice, NoticeEvent{Message: text})
+}
+
+// handleTunnelClosed tears the connection down after the server ended the
+// tunnel for good, e.g. because the quota ran out
+func (m *Manager) handleTunnelClosed(err error) {
+	go func() {
+		m.Disconnect()
+
+		m.mu.Lock()
+		m.state = StateFailed
+		m.lastError = err
+		m.mu.Unlock()
+
+		m.emit(EventError, ErrorEvent{Error: err.Error()})
+		m.emitState()
+		fmt.Printf("Tunnel closed by server: %v\n", err)
+	}()
+}
+
+// ConnectStats returns the number of connection attempts that succeeded and
+// failed since startup
+func (m *Manager) ConnectStats() (succeeded, failed uint64) {
+	return m.connectsSucceeded.Load(), m.connectsFailed.Load()
 }
 
 func (m *Manager) setError(err error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.state = StateFailed
 	m.lastError = err
+	m.mu.Unlock()
+
+	m.connectsFailed.Add(1)
+	m.emit(EventError, ErrorEvent{Error: err.Error()})
+	m.emitState()
 	fmt.Printf("Connection error: %v\n", err)
 }
 
@@ -400,6 +752,37 @@ func (m *Manager) GetServers() []ServerConfig {
 	return append([]ServerConfig{}, m.servers...)
 }
 
+// serverTrust returns the saved trust of the request's server updated by the
+// request. Profiles of the same server with another username are used if the
+// user has none.
+func (m *Manager) serverTrust(req ConnectRequest) Trust {
+	m.mu.RLock()
+	var trust Trust
+	found := false
+	for _, s := range m.servers {
+		if s.Address == req.ServerAddress && (!found || s.Username == req.Username) {
+			trust, found = s.Trust, true
+		}
+	}
+	m.mu.RUnlock()
+
+	if req.CACert != "" {
+		trust.CACert = req.CACert
+	}
+	if req.ResetPins {
+		trust.Pins = nil
+	}
+	for _, pin := range req.Pins {
+		if !slices.Contains(trust.Pins, pin) {
+			trust.Pins = append(slices.Clip(trust.Pins), pin)
+		}
+	}
+	if req.Insecure != nil {
+		trust.Insecure = *req.Insecure
+	}
+	return trust
+}
+
 func (m *Manager) saveServer(server ServerConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -654,6 +1037,7 @@ func (m *Manager) ApplyRoutes() error {
 	m.activeRoutes = activeRoutes
 	m.mu.Unlock()
 
+	m.emitRoutes()
 	fmt.Printf("Routes applied: %v\n", activeRoutes)
 	return nil
 }
@@ -730,8 +1114,15 @@ func (m *Manager) ChangePassword(req ChangePasswordRequest) error {
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Bearer "+req.Token)
 
-	// Send request
-	client := &http.Client{Timeout: 30 * time.Second}
+	// Send request, trusting the server as when connecting
+	verifier, err := newVerifier(m.serverTrust(ConnectRequest{ServerAddress: req.ServerAddress}))
+	if err != nil {
+		return fmt.Errorf("invalid TLS settings: %w", err)
+	}
+	client, err := newHTTPClient(proxy.Config{}, wstunnel.Fronting{}, verifier, apiBase)
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
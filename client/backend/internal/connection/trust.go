@@ -0,0 +1,163 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// pinPrefix starts SPKI pins: the base64 SHA-256 hash of a certificate's
+// public key, as printed by
+//
+//	openssl x509 -pubkey -noout -in cert.pem | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+const pinPrefix = "sha256/"
+
+// Trust is how the certificates of a server are verified, for both the API
+// and the tunnel. It is saved in the server's profile.
+type Trust struct {
+	// PEM CA bundle to verify the certificates against instead of the system
+	// roots (e.g. a private CA or a self-signed certificate)
+	CACert string `json:"ca_cert,omitempty"`
+
+	// SPKI pins ("sha256/<base64>"). The certificate chain must contain one
+	// of these keys; a certificate with a pinned key is trusted even if it
+	// doesn't verify (e.g. self-signed). Learned on the first connection
+	// (the key of the root CA) unless supplied by the user or the server.
+	Pins []string `json:"pins,omitempty"`
+
+	// Skip certificate verification (testing only)
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// CertificateError is returned when the server's certificate isn't trusted.
+// Pin is the key of the certificate, for the user to confirm and pin it.
+type CertificateError struct {
+	Pin string
+	Err error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("server certificate not trusted: %v (key pin %s)", e.Err, e.Pin)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// validatePins checks the format of SPKI pins
+func validatePins(pins []string) error {
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+		if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("invalid pin %q (want %s<base64 SHA-256 of the public key>)", pin, pinPrefix)
+		}
+	}
+	return nil
+}
+
+// spkiPin returns the SPKI pin of a certificate
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// verifier verifies server certificates according to a Trust, learning the
+// pin of the root CA on the first connection to a server without pins
+type verifier struct {
+	roots    *x509.CertPool // nil: the system roots
+	pins     []string
+	insecure bool
+
+	mu      sync.Mutex
+	learned []string
+}
+
+// newVerifier returns a verifier for t
+func newVerifier(t Trust) (*verifier, error) {
+	v := &verifier{pins: t.Pins, insecure: t.Insecure}
+	if err := validatePins(t.Pins); err != nil {
+		return nil, err
+	}
+	if t.CACert != "" {
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM([]byte(t.CACert)) {
+			return nil, errors.New("CA bundle contains no PEM certificates")
+		}
+	}
+	return v, nil
+}
+
+// tlsConfig returns the TLS settings for connections to host
+func (v *verifier) tlsConfig(host string) *tls.Config {
+	// Verification is done by verify, which also accepts pinned keys
+	// without a valid chain
+	cfg := &tls.Config{ServerName: host, InsecureSkipVerify: true}
+	if !v.insecure {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return v.verify(host, cs)
+		}
+	}
+	return cfg
+}
+
+// verify checks the certificates of a connection to host
+func (v *verifier) verify(host string, cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server sent no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	if slices.Contains(v.pins, spkiPin(leaf)) {
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		DNSName:       host,
+	})
+	if err != nil {
+		return &CertificateError{Pin: spkiPin(leaf), Err: err}
+	}
+
+	if len(v.pins) == 0 {
+		// Trust on first use: pin the root CA, which outlives the
+		// server's certificates
+		chain := chains[0]
+		v.learn(spkiPin(chain[len(chain)-1]))
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if slices.Contains(v.pins, spkiPin(cert)) {
+				return nil
+			}
+		}
+	}
+	return &CertificateError{Pin: spkiPin(leaf), Err: errors.New("the certificate chain contains none of the pinned keys")}
+}
+
+// learn records the pin of a verified connection
+func (v *verifier) learn(pin string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !slices.Contains(v.learned, pin) {
+		v.learned = append(v.learned, pin)
+	}
+}
+
+// learnedPins returns the pins learned from the connections so far
+func (v *verifier) learnedPins() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.learned...)
+}
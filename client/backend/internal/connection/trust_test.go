@@ -0,0 +1,127 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCert returns a certificate for name, signed by parent (self-signed if
+// nil) and able to sign others if isCA is set
+func testCert(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func certPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func TestVerify(t *testing.T) {
+	const host = "vpn.example.com"
+	ca, caKey := testCert(t, "Test CA", true, nil, nil)
+	leaf, _ := testCert(t, host, false, ca, caKey)
+	selfSigned, _ := testCert(t, host, false, nil, nil)
+	otherCA, _ := testCert(t, "Other CA", true, nil, nil)
+
+	tests := []struct {
+		name        string
+		trust       Trust
+		host        string
+		certs       []*x509.Certificate
+		wantErr     bool
+		wantLearned []string
+	}{
+		{"custom CA", Trust{CACert: certPEM(ca)}, host, []*x509.Certificate{leaf}, false, []string{spkiPin(ca)}},
+		{"custom CA with chain", Trust{CACert: certPEM(ca)}, host, []*x509.Certificate{leaf, ca}, false, []string{spkiPin(ca)}},
+		{"untrusted CA", Trust{}, host, []*x509.Certificate{leaf, ca}, true, nil},
+		{"other CA", Trust{CACert: certPEM(otherCA)}, host, []*x509.Certificate{leaf, ca}, true, nil},
+		{"wrong host", Trust{CACert: certPEM(ca)}, "other.example.com", []*x509.Certificate{leaf}, true, nil},
+		{"pinned CA", Trust{CACert: certPEM(ca), Pins: []string{spkiPin(ca)}}, host, []*x509.Certificate{leaf}, false, nil},
+		{"pinned leaf", Trust{CACert: certPEM(ca), Pins: []string{spkiPin(leaf)}}, host, []*x509.Certificate{leaf}, false, nil},
+		{"pin not in chain", Trust{CACert: certPEM(ca), Pins: []string{spkiPin(otherCA)}}, host, []*x509.Certificate{leaf}, true, nil},
+		{"pinned self-signed", Trust{Pins: []string{spkiPin(selfSigned)}}, host, []*x509.Certificate{selfSigned}, false, nil},
+		{"unpinned self-signed", Trust{}, host, []*x509.Certificate{selfSigned}, true, nil},
+		{"no certificate", Trust{CACert: certPEM(ca)}, host, nil, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newVerifier(tt.trust)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = v.verify(tt.host, tls.ConnectionState{PeerCertificates: tt.certs})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify error = %v, want error %v", err, tt.wantErr)
+			}
+			var certErr *CertificateError
+			if errors.As(err, &certErr) && certErr.Pin != spkiPin(tt.certs[0]) {
+				t.Errorf("CertificateError pin = %s, want the leaf's %s", certErr.Pin, spkiPin(tt.certs[0]))
+			}
+			if learned := v.learnedPins(); !slices.Equal(learned, tt.wantLearned) {
+				t.Errorf("learned pins %v, want %v", learned, tt.wantLearned)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	ca, _ := testCert(t, "Test CA", true, nil, nil)
+	tests := []struct {
+		name    string
+		trust   Trust
+		wantErr bool
+	}{
+		{"system roots", Trust{}, false},
+		{"custom CA", Trust{CACert: certPEM(ca)}, false},
+		{"not PEM", Trust{CACert: "not a certificate"}, true},
+		{"pin", Trust{Pins: []string{spkiPin(ca)}}, false},
+		{"pin without prefix", Trust{Pins: []string{spkiPin(ca)[len(pinPrefix):]}}, true},
+		{"short pin", Trust{Pins: []string{pinPrefix + "AAAA"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newVerifier(tt.trust); (err != nil) != tt.wantErr {
+				t.Errorf("newVerifier error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
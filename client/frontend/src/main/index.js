@@ -403,12 +403,102 @@ async function initializeService() {
       if (mainWindow) {
         mainWindow.webContents.send('service:status', { running: true });
       }
+      startEventStream();
     }
   } catch (error) {
     showServiceErrorDialog(error.message);
   }
 }
 
+// Event stream from the backend (Server-Sent Events on /api/events).
+// Events are forwarded to the renderer as 'vpn:event' so it doesn't have to
+// poll; 'vpn:stream' tells it whether the stream is up.
+const EVENT_STREAM_RETRY_MS = 3000;
+let eventStream = null;
+let eventStreamRetry = null;
+
+function sendToRenderer(channel, data) {
+  if (mainWindow && !mainWindow.isDestroyed()) {
+    mainWindow.webContents.send(channel, data);
+  }
+}
+
+// Parse one SSE message block into { type, data }
+function parseServerSentEvent(block) {
+  let type = 'message';
+  const dataLines = [];
+  for (const line of block.split('\n')) {
+    if (line.startsWith('event:')) {
+      type = line.slice(6).trim();
+    } else if (line.startsWith('data:')) {
+      dataLines.push(line.slice(5).replace(/^ /, ''));
+    }
+  }
+  if (dataLines.length === 0) {
+    return null; // Comment (keepalive)
+  }
+  try {
+    return { type, data: JSON.parse(dataLines.join('\n')) };
+  } catch (e) {
+    return { type, data: dataLines.join('\n') };
+  }
+}
+
+function scheduleEventStreamRetry() {
+  if (eventStreamRetry || isQuitting) return;
+  eventStreamRetry = setTimeout(() => {
+    eventStreamRetry = null;
+    startEventStream();
+  }, EVENT_STREAM_RETRY_MS);
+}
+
+function startEventStream() {
+  if (eventStream || isQuitting) return;
+
+  axios.get(`${getApiBase()}/api/events`, { responseType: 'stream', timeout: 0 })
+    .then((response) => {
+      eventStream = response.data;
+      sendToRenderer('vpn:stream', { connected: true });
+
+      let buffer = '';
+      eventStream.on('data', (chunk) => {
+        buffer += chunk.toString('utf-8').replace(/\r/g, '');
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          const event = parseServerSentEvent(buffer.slice(0, end));
+          buffer = buffer.slice(end + 2);
+          if (event) {
+            sendToRenderer('vpn:event', event);
+          }
+        }
+      });
+
+      const onClose = () => {
+        if (!eventStream) return;
+        eventStream = null;
+        sendToRenderer('vpn:stream', { connected: false });
+        scheduleEventStreamRetry();
+      };
+      eventStream.on('end', onClose);
+      eventStream.on('error', onClose);
+    })
+    .catch((error) => {
+      console.log('Event stream unavailable:', error.message);
+      sendToRenderer('vpn:stream', { connected: false });
+      scheduleEventStreamRetry();
+    });
+}
+
+function stopEventStream() {
+  clearTimeout(eventStreamRetry);
+  eventStreamRetry = null;
+  if (eventStream) {
+    const stream = eventStream;
+    eventStream = null;
+    stream.destroy();
+  }
+}
+
 function getAppIconPath() {
   const isPackaged = app.isPackaged;
   if (isPackaged) {
@@ -445,6 +535,11 @@ function createWindow() {
     mainWindow.show();
   });
 
+  // The event stream may come up before the page has loaded
+  mainWindow.webContents.on('did-finish-load', () => {
+    sendToRenderer('vpn:stream', { connected: eventStream !== null });
+  });
+
   mainWindow.on('closed', () => {
     mainWindow = null;
   });
@@ -583,6 +678,7 @@ app.on('window-all-closed', () => {
 
 app.on('before-quit', () => {
   isQuitting = true;
+  stopEventStream();
 });
 
 // IPC Handlers
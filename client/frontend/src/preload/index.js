@@ -12,6 +12,10 @@ contextBridge.exposeInMainWorld('electronAPI', {
   updateTrayStatus: (isConnected) => ipcRenderer.invoke('tray:updateStatus', isConnected),
   onServiceStatus: (callback) => ipcRenderer.on('service:status', (event, status) => callback(status)),
 
+  // Live events from the backend: { type, data }
+  onVPNEvent: (callback) => ipcRenderer.on('vpn:event', (event, data) => callback(data)),
+  onEventStream: (callback) => ipcRenderer.on('vpn:stream', (event, status) => callback(status)),
+
   // Dev tools
   activateDevTools: () => ipcRenderer.invoke('devtools:activate'),
 
@@ -0,0 +1,3 @@
+module wire-socket/pkg/metrics
+
+go 1.21
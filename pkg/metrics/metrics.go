@@ -0,0 +1,442 @@
+// Package metrics is a small, dependency-free metrics registry that serves
+// the Prometheus text exposition format. It supports counters, gauges and
+// histograms with labels, plus metrics computed at scrape time.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets (seconds)
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family is a registered metric name
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them in registration order
+type Registry struct {
+	mu       sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// Write writes all metrics in the text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry over HTTP
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// ============ Vectors ============
+
+// vec stores one child per label value combination
+type vec[T any] struct {
+	name, help, typ string
+	labels          []string
+	newChild        func() T
+	writeChild      func(w *bufio.Writer, name, labels string, child T)
+
+	mu       sync.Mutex
+	children map[string]T
+	values   map[string][]string
+}
+
+func newVec[T any](name, help, typ string, labels []string, newChild func() T, writeChild func(*bufio.Writer, string, string, T)) *vec[T] {
+	return &vec[T]{
+		name: name, help: help, typ: typ, labels: labels,
+		newChild: newChild, writeChild: writeChild,
+		children: make(map[string]T),
+		values:   make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+func (v *vec[T]) delete(values []string) {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, key)
+	delete(v.values, key)
+}
+
+func (v *vec[T]) write(w *bufio.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]T, len(keys))
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+		labels[i] = formatLabels(v.labels, v.values[k])
+	}
+	v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, v.typ)
+	for i := range children {
+		v.writeChild(w, v.name, labels[i], children[i])
+	}
+}
+
+// ============ Counter ============
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc adds 1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ v *vec[*Counter] }
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	v := newVec(name, help, TypeCounter, labels, func() *Counter { return &Counter{} },
+		func(w *bufio.Writer, name, labels string, c *Counter) { writeSample(w, name, labels, c.Value()) })
+	r.register(name, v)
+	return &CounterVec{v}
+}
+
+// With returns the counter for the label values
+func (cv *CounterVec) With(values ...string) *Counter { return cv.v.with(values) }
+
+// Delete removes the counter for the label values
+func (cv *CounterVec) Delete(values ...string) { cv.v.delete(values) }
+
+// ============ Gauge ============
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set sets the value
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Add adds v (may be negative)
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Inc adds 1
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts 1
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ v *vec[*Gauge] }
+
+// NewGauge registers a gauge with the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	v := newVec(name, help, TypeGauge, labels, func() *Gauge { return &Gauge{} },
+		func(w *bufio.Writer, name, labels string, g *Gauge) { writeSample(w, name, labels, g.Value()) })
+	r.register(name, v)
+	return &GaugeVec{v}
+}
+
+// With returns the gauge for the label values
+func (gv *GaugeVec) With(values ...string) *Gauge { return gv.v.with(values) }
+
+// Delete removes the gauge for the label values
+func (gv *GaugeVec) Delete(values ...string) { gv.v.delete(values) }
+
+// ============ Histogram ============
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // Per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe adds one observation
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += counts[i]
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(le)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct{ v *vec[*Histogram] }
+
+// NewHistogram registers a histogram with sorted upper bounds (DefBuckets if nil)
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = normalizeBuckets(buckets)
+	v := newVec(name, help, TypeHistogram, labels, func() *Histogram { return newHistogram(buckets) },
+		func(w *bufio.Writer, name, labels string, h *Histogram) { h.write(w, name, labels) })
+	r.register(name, v)
+	return &HistogramVec{v}
+}
+
+// With returns the histogram for the label values
+func (hv *HistogramVec) With(values ...string) *Histogram { return hv.v.with(values) }
+
+func normalizeBuckets(buckets []float64) []float64 {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// ============ Scrape-time metrics ============
+
+// funcFamily computes its samples on every scrape
+type funcFamily struct {
+	name, help, typ string
+	labels          []string
+	collect         func(emit func(value float64, labelValues ...string))
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	f.collect(func(value float64, labelValues ...string) {
+		writeSample(w, f.name, formatLabels(f.labels, labelValues), value)
+	})
+}
+
+// NewGaugeFunc registers a gauge whose values are reported by collect on
+// every scrape. collect calls emit once per label value combination.
+func (r *Registry) NewGaugeFunc(name, help string, collect func(emit func(value float64, labelValues ...string)), labels ...string) {
+	r.register(name, &funcFamily{name: name, help: help, typ: TypeGauge, labels: labels, collect: collect})
+}
+
+// NewCounterFunc registers a counter read from a monotonic source on every
+// scrape, such as an atomic counter kept by another package
+func (r *Registry) NewCounterFunc(name, help string, collect func(emit func(value float64, labelValues ...string)), labels ...string) {
+	r.register(name, &funcFamily{name: name, help: help, typ: TypeCounter, labels: labels, collect: collect})
+}
+
+// histogramFuncFamily builds a fresh histogram on every scrape
+type histogramFuncFamily struct {
+	name, help string
+	buckets    []float64
+	collect    func(observe func(float64))
+}
+
+func (f *histogramFuncFamily) write(w *bufio.Writer) {
+	h := newHistogram(f.buckets)
+	f.collect(h.Observe)
+	writeHeader(w, f.name, f.help, TypeHistogram)
+	h.write(w, f.name, "")
+}
+
+// NewHistogramFunc registers a histogram of the current state, rebuilt from
+// the values collect observes on every scrape (e.g. ages of live sessions)
+func (r *Registry) NewHistogramFunc(name, help string, buckets []float64, collect func(observe func(float64))) {
+	r.register(name, &histogramFuncFamily{name: name, help: help, buckets: normalizeBuckets(buckets), collect: collect})
+}
+
+// ============ Label cardinality ============
+
+// OtherLabel is reported in place of label values beyond a LabelLimiter's cap
+const OtherLabel = "other"
+
+// LabelLimiter caps the number of distinct values of a label, such as a
+// username. The first max values seen are kept; later ones become OtherLabel.
+type LabelLimiter struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]bool
+}
+
+// NewLabelLimiter creates a LabelLimiter for up to max values
+func NewLabelLimiter(max int) *LabelLimiter {
+	return &LabelLimiter{max: max, seen: make(map[string]bool)}
+}
+
+// Value returns v if it is (or can become) one of the kept values, or OtherLabel
+func (l *LabelLimiter) Value(v string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[v] {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return OtherLabel
+	}
+	l.seen[v] = true
+	return v
+}
+
+// ============ Text format ============
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(names), len(values)))
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return b.String()
+}
+
+// TestCounterAndGauge tests labelled counters and gauges
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	logins := r.NewCounter("logins_total", "Login attempts.", "result")
+	peers := r.NewGauge("peers", "Connected peers.")
+
+	logins.With("success").Inc()
+	logins.With("success").Add(2)
+	logins.With("invalid_credentials").Inc()
+	peers.With().Set(5)
+	peers.With().Dec()
+
+	want := `# HELP logins_total Login attempts.
+# TYPE logins_total counter
+logins_total{result="invalid_credentials"} 1
+logins_total{result="success"} 3
+# HELP peers Connected peers.
+# TYPE peers gauge
+peers 4
+`
+	if got := render(t, r); got != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestHistogram tests cumulative buckets, sum and count
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	h.With().Observe(0.05)
+	h.With().Observe(0.1)
+	h.With().Observe(0.5)
+	h.With().Observe(3)
+
+	out := render(t, r)
+	for _, line := range []string{
+		`latency_seconds_bucket{le="0.1"} 2`,
+		`latency_seconds_bucket{le="1"} 3`,
+		`latency_seconds_bucket{le="+Inf"} 4`,
+		`latency_seconds_sum 3.65`,
+		`latency_seconds_count 4`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+// TestFuncMetrics tests metrics computed at scrape time
+func TestFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	sessions := 2
+	r.NewGaugeFunc("sessions", "Sessions.", func(emit func(float64, ...string)) {
+		emit(float64(sessions))
+	})
+	r.NewCounterFunc("bytes_total", "Bytes.", func(emit func(float64, ...string)) {
+		emit(10, "rx")
+		emit(20, "tx")
+	}, "direction")
+	r.NewHistogramFunc("age_seconds", "Ages.", []float64{60}, func(observe func(float64)) {
+		observe(30)
+		observe(90)
+	})
+
+	sessions = 3
+	out := render(t, r)
+	for _, line := range []string{
+		"sessions 3",
+		`bytes_total{direction="rx"} 10`,
+		`bytes_total{direction="tx"} 20`,
+		`age_seconds_bucket{le="60"} 1`,
+		`age_seconds_count 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+// TestLabelEscaping tests escaping of label values
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("c_total", "C.", "user").With("a\"b\\c\nd").Inc()
+
+	if out := render(t, r); !strings.Contains(out, `c_total{user="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label not escaped:\n%s", out)
+	}
+}
+
+// TestLabelLimiter tests the cardinality cap
+func TestLabelLimiter(t *testing.T) {
+	l := NewLabelLimiter(2)
+	if l.Value("alice") != "alice" || l.Value("bob") != "bob" {
+		t.Fatal("Values under the cap should be kept")
+	}
+	if v := l.Value("carol"); v != OtherLabel {
+		t.Errorf("Expected %q beyond the cap, got %q", OtherLabel, v)
+	}
+	if l.Value("alice") != "alice" {
+		t.Error("Kept values should stay kept")
+	}
+}
+
+// TestDuplicateRegistration tests that duplicate names panic
+func TestDuplicateRegistration(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "Dup.")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate registration")
+		}
+	}()
+	r.NewGauge("dup_total", "Dup.")
+}
+
+// TestHandler tests the HTTP handler content type
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").With().Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %q, got %q", ContentType, ct)
+	}
+	if !strings.Contains(rec.Body.String(), "requests_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", rec.Body.String())
+	}
+}
@@ -4,7 +4,7 @@ package wireguard
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os/exec"
 	"strings"
@@ -18,7 +18,7 @@ func setTunAddress(name, address string) error {
 		return fmt.Errorf("invalid address %s: %w", address, err)
 	}
 
-	log.Printf("Setting TUN address: interface=%s, address=%s", name, address)
+	slog.Debug("setting TUN address", "interface", name, "address", address)
 
 	// Set the address using ip command
 	cmd := exec.Command("ip", "addr", "add", address, "dev", name)
@@ -27,9 +27,9 @@ func setTunAddress(name, address string) error {
 		if !strings.Contains(string(output), "File exists") {
 			return fmt.Errorf("failed to add address: %s: %w", string(output), err)
 		}
-		log.Printf("Address already exists on %s", name)
+		slog.Debug("address already exists", "interface", name, "address", address)
 	} else {
-		log.Printf("Address %s added to %s", address, name)
+		slog.Info("address added", "interface", name, "address", address)
 	}
 
 	// Bring interface up
@@ -37,7 +37,7 @@ func setTunAddress(name, address string) error {
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to bring interface up: %s: %w", string(output), err)
 	}
-	log.Printf("Interface %s is up", name)
+	slog.Info("interface is up", "interface", name)
 
 	// Verify the route was added (Linux should add it automatically when setting address)
 	// If not, add it manually
@@ -48,10 +48,10 @@ func setTunAddress(name, address string) error {
 	cmd = exec.Command("ip", "route", "add", routeCIDR, "dev", name)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		if !strings.Contains(string(output), "File exists") {
-			log.Printf("Note: route add returned: %s", strings.TrimSpace(string(output)))
+			slog.Debug("route add failed", "route", routeCIDR, "output", strings.TrimSpace(string(output)))
 		}
 	} else {
-		log.Printf("Route %s added to %s", routeCIDR, name)
+		slog.Info("route added", "interface", name, "route", routeCIDR)
 	}
 
 	return nil
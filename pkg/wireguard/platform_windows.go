@@ -4,7 +4,7 @@ package wireguard
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os/exec"
 	"strconv"
@@ -40,7 +40,7 @@ func setTunAddress(name, address string) error {
 	copy(gatewayIP, ip4)
 	gatewayIP[3] = 1
 
-	log.Printf("Setting TUN address: interface=%s, ip=%s, mask=%s, gateway=%s", name, ip4.String(), mask, gatewayIP.String())
+	slog.Debug("setting TUN address", "interface", name, "ip", ip4.String(), "mask", mask, "gateway", gatewayIP.String())
 
 	// Store the IP and gateway for routing decisions
 	tunInterfaceIP = ip4
@@ -58,7 +58,7 @@ func setTunAddress(name, address string) error {
 		return fmt.Errorf("failed to set address: %s: %w", string(output), err)
 	}
 
-	log.Printf("TUN address set successfully")
+	slog.Info("TUN address set", "interface", name, "ip", ip4.String())
 	return nil
 }
 
@@ -93,12 +93,12 @@ func setRoutes(name string, routes []net.IPNet) error {
 	// Get the interface index
 	ifIndex, err := getInterfaceIndex(name)
 	if err != nil {
-		log.Printf("Warning: could not get interface index for %s: %v", name, err)
+		slog.Warn("could not get interface index", "interface", name, "error", err)
 		// Try using netsh as fallback
 		return setRoutesNetsh(name, routes)
 	}
 
-	log.Printf("Got interface index %d for %s", ifIndex, name)
+	slog.Debug("got interface index", "interface", name, "index", ifIndex)
 
 	// Use the VPN gateway (e.g., 10.250.99.1) as next hop
 	gatewayIP := ""
@@ -116,19 +116,19 @@ func setRoutes(name string, routes []net.IPNet) error {
 		}
 
 		cmd := exec.Command("route", "add", route.IP.String(), "mask", mask, gateway, "if", strconv.Itoa(ifIndex))
-		log.Printf("Executing: route add %s mask %s %s if %d", route.IP.String(), mask, gateway, ifIndex)
+		slog.Debug("executing route add", "route", route.String(), "mask", mask, "gateway", gateway, "index", ifIndex)
 		output, err := cmd.CombinedOutput()
 		if err != nil {
 			outputStr := string(output)
 			if !strings.Contains(outputStr, "already exists") && !strings.Contains(outputStr, "object already exists") {
-				log.Printf("Warning: failed to add route %s via route command: %s", route.String(), outputStr)
+				slog.Warn("failed to add route via route command", "route", route.String(), "output", outputStr)
 				// Try netsh as fallback
 				if err := addRouteNetsh(name, route, gatewayIP); err != nil {
-					log.Printf("Warning: failed to add route %s via netsh: %v", route.String(), err)
+					slog.Warn("failed to add route via netsh", "route", route.String(), "error", err)
 				}
 			}
 		} else {
-			log.Printf("Added route %s (gateway %s) via interface %d", route.String(), gateway, ifIndex)
+			slog.Info("route added", "route", route.String(), "gateway", gateway, "index", ifIndex)
 		}
 	}
 	return nil
@@ -143,7 +143,7 @@ func setRoutesNetsh(name string, routes []net.IPNet) error {
 
 	for _, route := range routes {
 		if err := addRouteNetsh(name, route, gatewayIP); err != nil {
-			log.Printf("Warning: failed to add route %s: %v", route.String(), err)
+			slog.Warn("failed to add route", "route", route.String(), "error", err)
 		}
 	}
 	return nil
@@ -168,18 +168,18 @@ func addRouteNetsh(name string, route net.IPNet, gatewayIP string) error {
 			"store=active")
 	}
 
-	log.Printf("Executing netsh: %v", cmd.Args)
+	slog.Debug("executing netsh", "args", cmd.Args)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		outputStr := string(output)
 		if strings.Contains(outputStr, "already exists") || strings.Contains(outputStr, "object already exists") {
-			log.Printf("Route %s already exists", prefix)
+			slog.Debug("route already exists", "route", prefix)
 			return nil
 		}
 		return fmt.Errorf("netsh add route failed: %s: %w", outputStr, err)
 	}
 
-	log.Printf("Added route %s via netsh", prefix)
+	slog.Info("route added via netsh", "route", prefix)
 	return nil
 }
 
@@ -2,8 +2,9 @@
 package wireguard
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/netip"
 	"strings"
@@ -35,6 +36,7 @@ type UserspaceBackend struct {
 type UserspaceConfig struct {
 	InterfaceName string
 	MTU           int
+	Logger        *slog.Logger // Device log output (default: slog.Default()); verbose messages are logged at debug level
 }
 
 // NewUserspaceBackend creates a new userspace WireGuard backend
@@ -63,9 +65,11 @@ func NewUserspaceBackend(cfg UserspaceConfig) (*UserspaceBackend, error) {
 	}
 
 	// Create WireGuard device
-	// Using LogLevelVerbose for debugging - change to LogLevelError in production
-	logger := device.NewLogger(device.LogLevelVerbose, fmt.Sprintf("(%s) ", actualName))
-	wgDev := device.NewDevice(tunDev, conn.NewDefaultBind(), logger)
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	wgDev := device.NewDevice(tunDev, conn.NewDefaultBind(), deviceLogger(logger.With("interface", actualName)))
 
 	return &UserspaceBackend{
 		name:       actualName,
@@ -75,6 +79,21 @@ func NewUserspaceBackend(cfg UserspaceConfig) (*UserspaceBackend, error) {
 	}, nil
 }
 
+// deviceLogger adapts logger to the wireguard-go device logger. The level is
+// checked per message, so verbose output follows level changes at runtime.
+func deviceLogger(logger *slog.Logger) *device.Logger {
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			if logger.Enabled(context.Background(), slog.LevelDebug) {
+				logger.Debug(fmt.Sprintf(format, args...))
+			}
+		},
+		Errorf: func(format string, args ...any) {
+			logger.Error(fmt.Sprintf(format, args...))
+		},
+	}
+}
+
 // Configure sets up the WireGuard interface
 func (u *UserspaceBackend) Configure(cfg Config) error {
 	u.mu.Lock()
@@ -313,7 +332,7 @@ func (u *UserspaceBackend) Close() error {
 
 	if u.tunDevice != nil {
 		if err := u.tunDevice.Close(); err != nil {
-			log.Printf("Failed to close TUN device: %v", err)
+			slog.Warn("failed to close TUN device", "interface", u.name, "error", err)
 		}
 		u.tunDevice = nil
 	}
@@ -0,0 +1,299 @@
+package wstunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/bond"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+const (
+	// MaxBondConnections is the most connections a session may bond
+	MaxBondConnections = 8
+
+	// joinTimeout bounds how long a connection waits for the server to
+	// confirm that it joined the session
+	joinTimeout = 10 * time.Second
+)
+
+// Bonding spreads the packets of every session over several WebSocket
+// connections (paths), e.g. over different networks or tunnel URLs, which the
+// server merges back into one UDP flow towards WireGuard. Connection i uses
+// URLs[i] and Interfaces[i], wrapping around; the first is the session's own
+// connection, the others join it.
+type Bonding struct {
+	Connections int         `json:"connections,omitempty"` // Connections per session (default: one per URL or interface; 0 or 1 without them: no bonding)
+	URLs        []string    `json:"urls,omitempty"`        // Tunnel URLs (ws:// or wss://) of the connections (default: the server's)
+	Interfaces  []string    `json:"interfaces,omitempty"`  // Network interfaces or local IP addresses to connect from (default: any)
+	Policy      bond.Policy `json:"policy,omitempty"`      // round-robin (default) or weighted-rtt
+}
+
+// Validate checks the bonding settings
+func (b Bonding) Validate() error {
+	if b.Connections < 0 || b.connections() > MaxBondConnections {
+		return fmt.Errorf("bonding: connections must be between 1 and %d", MaxBondConnections)
+	}
+	for _, raw := range b.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("bonding: invalid tunnel URL %q (use ws:// or wss://)", raw)
+		}
+	}
+	for _, iface := range b.Interfaces {
+		if iface == "" {
+			return errors.New("bonding: empty interface")
+		}
+	}
+	return b.Policy.Validate()
+}
+
+// connections returns the number of connections per session
+func (b Bonding) connections() int {
+	if b.Connections > 0 {
+		return b.Connections
+	}
+	return max(len(b.URLs), len(b.Interfaces), 1)
+}
+
+// path returns the settings of connection i of a session
+func (b Bonding) path(i int, serverURL string) *path {
+	p := &path{index: i, url: serverURL}
+	if len(b.URLs) > 0 {
+		p.url = b.URLs[i%len(b.URLs)]
+	}
+	if len(b.Interfaces) > 0 {
+		p.iface = b.Interfaces[i%len(b.Interfaces)]
+	}
+	return p
+}
+
+// localAddr returns the address to connect from for a network interface or
+// IP address, preferring IPv4
+func localAddr(iface string) (net.Addr, error) {
+	if ip := net.ParseIP(iface); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+		if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", iface)
+	}
+	return &net.TCPAddr{IP: v6}, nil
+}
+
+// path is one WebSocket connection of a session. The session's own
+// connection is its path 0, whose conn is session.conn; the others are
+// joined to it and only carry packets once the server confirmed that.
+type path struct {
+	index int
+	url   string
+	iface string // Interface or local address to connect from; empty for any
+
+	mu     sync.Mutex
+	conn   datagramConn // nil while down
+	joined bool         // The server confirmed the join
+	since  time.Time    // When the connection came up
+
+	packetsSent     atomic.Uint64
+	bytesSent       atomic.Uint64
+	packetsReceived atomic.Uint64
+	bytesReceived   atomic.Uint64
+}
+
+// PathStats describes one connection of a bonded session
+type PathStats struct {
+	URL             string        // Tunnel URL
+	Interface       string        // Interface or local address connected from; empty for any
+	Up              bool          // Connected (and joined)
+	Since           time.Time     // When the connection came up
+	PacketsSent     uint64        // Datagrams sent on the connection
+	BytesSent       uint64        // Bytes sent on the connection
+	PacketsReceived uint64        // Datagrams received on the connection
+	BytesReceived   uint64        // Bytes received on the connection
+	Latency         time.Duration // Round-trip time of the last answered ping
+}
+
+// up marks the path up on conn
+func (p *path) up(conn datagramConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == conn {
+		p.joined = true
+		p.since = time.Now()
+	}
+}
+
+// joinedConn returns the connection of the path if it is up
+func (p *path) joinedConn() datagramConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.joined {
+		return nil
+	}
+	return p.conn
+}
+
+// setConn sets the connection of the path, which is up once joined; nil
+// marks it down. It returns whether the previous connection was up.
+func (p *path) setConn(conn datagramConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wasUp := p.joined
+	p.conn, p.joined = conn, false
+	return wasUp
+}
+
+// close closes the connection of the path
+func (p *path) close() {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// stats returns the statistics of the path, which is up on conn (nil if down)
+func (p *path) stats(conn datagramConn) PathStats {
+	stats := PathStats{
+		URL:             p.url,
+		Interface:       p.iface,
+		Up:              conn != nil,
+		PacketsSent:     p.packetsSent.Load(),
+		BytesSent:       p.bytesSent.Load(),
+		PacketsReceived: p.packetsReceived.Load(),
+		BytesReceived:   p.bytesReceived.Load(),
+	}
+	p.mu.Lock()
+	stats.Since = p.since
+	p.mu.Unlock()
+	if conn != nil {
+		stats.Latency = conn.RTT()
+	}
+	return stats
+}
+
+// runPath keeps a connection joined to the session until the session ends:
+// once the server gave the session a token, it dials, joins and forwards the
+// server's packets to the source, and starts over with backoff when the
+// connection fails or the server refuses the join
+func (s *session) runPath(p *path) {
+	delay := time.Duration(0)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		s.mu.Lock()
+		token, ready := s.resumeToken, s.tokenReady
+		s.mu.Unlock()
+		if token == "" {
+			// Not connected yet, or the server can't bond: waiting isn't a
+			// failure, so the next attempt starts without delay
+			select {
+			case <-s.stop:
+				return
+			case <-ready:
+			}
+			delay = 0
+			continue
+		}
+		delay = min(max(2*delay, reconnectMinDelay), reconnectMaxDelay)
+
+		conn, err := s.client.dialWebSocket(p.url, p.iface, false)
+		if err != nil {
+			log.Printf("Tunnel path %d (%s) failed: %v", p.index, p.url, err)
+			continue
+		}
+		conn.SetControlHandler(func(typ byte, payload []byte) {
+			if typ == framing.ControlJoin {
+				p.up(conn)
+				log.Printf("Tunnel path %d joined via %s", p.index, p.url)
+			}
+		})
+		p.setConn(conn)
+		if s.closed() {
+			conn.Close()
+			return
+		}
+		if err := conn.SendControl(framing.ControlJoin, []byte(token)); err != nil {
+			p.setConn(nil)
+			conn.Close()
+			continue
+		}
+		timer := time.AfterFunc(joinTimeout, func() {
+			if p.joinedConn() != conn {
+				conn.Close()
+			}
+		})
+
+		err = s.forward(conn, p)
+		timer.Stop()
+		wasUp := p.setConn(nil)
+		conn.Close()
+		if s.closed() {
+			return
+		}
+		if wasUp {
+			// Dropped from the schedule; rejoined after the minimum delay
+			log.Printf("Tunnel path %d lost (%v), reconnecting", p.index, err)
+			delay = reconnectMinDelay
+		} else {
+			log.Printf("Tunnel path %d could not join: %v", p.index, err)
+		}
+	}
+}
+
+// pick returns the connection to send the next packet on, and its path: own,
+// the session's own connection, or a joined one, as the bonding policy says
+func (s *session) pick(own datagramConn) (datagramConn, *path) {
+	if len(s.extra) == 0 {
+		return own, s.own
+	}
+	conns := []datagramConn{own}
+	paths := []*path{s.own}
+	for _, p := range s.extra {
+		if conn := p.joinedConn(); conn != nil {
+			conns = append(conns, conn)
+			paths = append(paths, p)
+		}
+	}
+	if len(conns) == 1 {
+		return own, s.own
+	}
+
+	rtts := make([]time.Duration, len(conns))
+	for i, conn := range conns {
+		rtts[i] = conn.RTT()
+	}
+	i := s.scheduler.Pick(rtts)
+	return conns[i], paths[i]
+}
@@ -0,0 +1,112 @@
+// Package bond spreads the datagrams of a tunnel session over several
+// connections (paths) joined with framing.ControlJoin, so that one slow or
+// lossy TCP connection doesn't hold back the others.
+package bond
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy selects how datagrams are spread over the paths
+type Policy string
+
+const (
+	// RoundRobin sends on the paths in turn
+	RoundRobin Policy = "round-robin"
+
+	// WeightedRTT sends on each path in inverse proportion to its
+	// round-trip time, so faster paths carry more
+	WeightedRTT Policy = "weighted-rtt"
+)
+
+// Validate checks the policy; empty selects RoundRobin
+func (p Policy) Validate() error {
+	switch p {
+	case "", RoundRobin, WeightedRTT:
+		return nil
+	}
+	return fmt.Errorf("unknown bonding policy %q (use %s or %s)", p, RoundRobin, WeightedRTT)
+}
+
+// Scheduler picks the path of each datagram. It is safe for concurrent use.
+type Scheduler struct {
+	policy Policy
+
+	mu      sync.Mutex
+	next    int       // RoundRobin: the path after the last one picked
+	current []float64 // WeightedRTT: the credit of each path
+}
+
+// NewScheduler returns a scheduler applying policy
+func NewScheduler(policy Policy) *Scheduler {
+	if policy == "" {
+		policy = RoundRobin
+	}
+	return &Scheduler{policy: policy}
+}
+
+// Policy returns the policy of the scheduler
+func (s *Scheduler) Policy() Policy {
+	return s.policy
+}
+
+// Pick returns the index of the path for the next datagram, given the
+// round-trip times of the paths that are up (0 for paths not measured yet).
+// When paths come or go, the caller passes the new list; the schedule then
+// starts over. rtts must not be empty.
+func (s *Scheduler) Pick(rtts []time.Duration) int {
+	if len(rtts) == 1 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.policy != WeightedRTT {
+		i := s.next % len(rtts)
+		s.next = i + 1
+		return i
+	}
+
+	// Smooth weighted round-robin: every path earns its weight in credit,
+	// the richest is picked and pays the total
+	if len(s.current) != len(rtts) {
+		s.current = make([]float64, len(rtts))
+	}
+	weights := weights(rtts)
+	total, best := 0.0, 0
+	for i, w := range weights {
+		s.current[i] += w
+		total += w
+		if s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	s.current[best] -= total
+	return best
+}
+
+// weights returns the weight of each path: the inverse of its round-trip
+// time, or the mean weight of the measured paths for those not measured yet
+func weights(rtts []time.Duration) []float64 {
+	weights := make([]float64, len(rtts))
+	sum, measured := 0.0, 0
+	for i, rtt := range rtts {
+		if rtt > 0 {
+			weights[i] = float64(time.Second) / float64(rtt)
+			sum += weights[i]
+			measured++
+		}
+	}
+	mean := 1.0
+	if measured > 0 {
+		mean = sum / float64(measured)
+	}
+	for i, rtt := range rtts {
+		if rtt <= 0 {
+			weights[i] = mean
+		}
+	}
+	return weights
+}
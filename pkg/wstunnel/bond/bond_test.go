@@ -0,0 +1,72 @@
+package bond
+
+import (
+	"testing"
+	"time"
+)
+
+// counts picks n paths and returns how often each was picked
+func counts(s *Scheduler, rtts []time.Duration, n int) []int {
+	picked := make([]int, len(rtts))
+	for i := 0; i < n; i++ {
+		picked[s.Pick(rtts)]++
+	}
+	return picked
+}
+
+func TestRoundRobin(t *testing.T) {
+	s := NewScheduler("")
+	if s.Policy() != RoundRobin {
+		t.Fatalf("default policy = %q, want %q", s.Policy(), RoundRobin)
+	}
+
+	rtts := []time.Duration{10 * time.Millisecond, 100 * time.Millisecond, 0}
+	for i := 0; i < 6; i++ {
+		if got := s.Pick(rtts); got != i%3 {
+			t.Fatalf("pick %d = %d, want %d", i, got, i%3)
+		}
+	}
+
+	// A path went away: the schedule continues within the new list
+	if got := s.Pick(rtts[:2]); got != 0 && got != 1 {
+		t.Fatalf("pick = %d, out of range", got)
+	}
+}
+
+func TestWeightedRTT(t *testing.T) {
+	s := NewScheduler(WeightedRTT)
+
+	// Twice as fast carries twice as much, interleaved
+	rtts := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond}
+	if got := counts(s, rtts, 300); got[0] != 200 || got[1] != 100 {
+		t.Errorf("picked %v, want [200 100]", got)
+	}
+	for i := 0; i < 10; i++ {
+		if s.Pick(rtts) == 1 && s.Pick(rtts) == 1 {
+			t.Fatal("slower path picked twice in a row")
+		}
+	}
+
+	// Paths not measured yet weigh as the mean of the others
+	rtts = []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 0}
+	got := counts(NewScheduler(WeightedRTT), rtts, 1000)
+	if got[0] <= got[2] || got[2] <= got[1] {
+		t.Errorf("picked %v, want the unmeasured path between the others", got)
+	}
+
+	// None measured: equal shares
+	if got := counts(NewScheduler(WeightedRTT), make([]time.Duration, 4), 400); got[0] != 100 || got[3] != 100 {
+		t.Errorf("picked %v, want 100 each", got)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, p := range []Policy{"", RoundRobin, WeightedRTT} {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%q: %v", p, err)
+		}
+	}
+	if err := Policy("fastest").Validate(); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
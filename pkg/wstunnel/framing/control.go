@@ -0,0 +1,186 @@
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Control message types. A control record holds the type followed by the
+// payload.
+const (
+	ControlPing   byte = 0x01 // Payload: opaque, echoed in the pong
+	ControlPong   byte = 0x02 // Payload: the payload of the ping
+	ControlNotice byte = 0x03 // Payload: UTF-8 text for the user, e.g. about maintenance
+	ControlClose  byte = 0x04 // Payload: reason (2 bytes, big endian) | UTF-8 text
+	ControlResume byte = 0x05 // Payload: resume token (see below)
+	ControlJoin   byte = 0x06 // Payload: resume token of the session to join; empty in the answer
+)
+
+// Session resume: after accepting a v2 connection the server may send a
+// ControlResume message with a token for the session. When the connection
+// drops without a close reason, the client reconnects and sends the token in
+// a ControlResume message before anything else; within the server's resume
+// timeout, the new connection continues the old session (same UDP endpoint
+// towards WireGuard).
+//
+// Bonding: a client may open more connections for a session and send the
+// session's resume token in a ControlJoin message on each. The server answers
+// with an empty ControlJoin once the connection is a path of the session:
+// datagrams may then be sent on any path and reach the session's UDP socket.
+// Clients send datagrams on a joining connection only after the answer, so
+// servers that don't bond never see them.
+
+// CloseReason tells the peer why a connection ends
+type CloseReason uint16
+
+// Close reasons
+const (
+	CloseNormal   CloseReason = 0 // The peer is done, e.g. the user disconnected
+	CloseShutdown CloseReason = 1 // The server is shutting down or restarting
+	CloseAdmin    CloseReason = 2 // Closed by an administrator
+	CloseQuota    CloseReason = 3 // The user's data quota is exhausted
+)
+
+// String returns the name of the reason
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNormal:
+		return "normal"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseAdmin:
+		return "closed by administrator"
+	case CloseQuota:
+		return "quota exceeded"
+	}
+	return fmt.Sprintf("reason %d", uint16(r))
+}
+
+// Retry reports whether reconnecting later may succeed
+func (r CloseReason) Retry() bool {
+	return r == CloseShutdown
+}
+
+// CloseError is returned by ReadDatagram after the peer sent a close reason
+type CloseError struct {
+	Reason CloseReason
+	Text   string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return "connection closed by peer: " + e.Reason.String()
+	}
+	return fmt.Sprintf("connection closed by peer: %s: %s", e.Reason, e.Text)
+}
+
+// ErrNotFramed is returned when sending a control message in the v1 format
+var ErrNotFramed = errors.New("control messages need the framed subprotocol")
+
+// maxCloseText is the longest text of a WebSocket close frame
+const maxCloseText = 123 - 2
+
+// SetControlHandler sets a function receiving the control messages that the
+// Conn doesn't handle itself: notices, resume tokens, unknown types and pongs
+// of pings not sent by the keepalive. Pings are answered and close reasons
+// are returned by ReadDatagram. fn is called by ReadDatagram and gets a copy
+// of the payload; it must not block.
+func (c *Conn) SetControlHandler(fn func(typ byte, payload []byte)) {
+	c.onControl = fn
+}
+
+// SendControl sends a control message at once, without waiting for the
+// shaper. It fails with ErrNotFramed if the v1 format is used.
+func (c *Conn) SendControl(typ byte, payload []byte) error {
+	if !c.framed {
+		return ErrNotFramed
+	}
+	if 1+len(payload) > maxRecord {
+		return fmt.Errorf("control message too large: %d bytes", len(payload))
+	}
+	msg := make([]byte, 0, 2*recordHeader+1+len(payload)+c.shaping.PaddingMax)
+	return c.writeRecords(AppendControl(msg, typ, payload), false)
+}
+
+// SendNotice sends text for the user to the peer
+func (c *Conn) SendNotice(text string) error {
+	return c.SendControl(ControlNotice, []byte(text))
+}
+
+// SendClose tells the peer why the connection ends: with a close reason in
+// the v2 format, followed by a WebSocket close frame in both formats. The
+// connection stays open for the peer to answer the close frame.
+func (c *Conn) SendClose(reason CloseReason, text string, deadline time.Time) error {
+	if c.framed {
+		if err := c.SendControl(ControlClose, EncodeClose(reason, text)); err != nil {
+			return err
+		}
+	}
+
+	code := websocket.CloseNormalClosure
+	if reason == CloseShutdown {
+		code = websocket.CloseGoingAway
+	}
+	if len(text) > maxCloseText {
+		text = text[:maxCloseText]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	return c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+}
+
+// handleControl handles a control record received
+func (c *Conn) handleControl(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty control record")
+	}
+	typ, payload := data[0], data[1:]
+
+	switch typ {
+	case ControlPing:
+		return c.SendControl(ControlPong, payload)
+	case ControlPong:
+		if !c.pong(payload) && c.onControl != nil {
+			c.onControl(typ, append([]byte(nil), payload...))
+		}
+	case ControlClose:
+		reason, text, err := DecodeClose(payload)
+		if err != nil {
+			return err
+		}
+		c.closeErr = &CloseError{Reason: reason, Text: text}
+	default:
+		if c.onControl != nil {
+			c.onControl(typ, append([]byte(nil), payload...))
+		}
+	}
+	return nil
+}
+
+// AppendControl appends a control record to b
+func AppendControl(b []byte, typ byte, payload []byte) []byte {
+	b = append(b, RecordControl, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(1+len(payload)))
+	b = append(b, typ)
+	return append(b, payload...)
+}
+
+// EncodeClose returns the payload of a ControlClose message
+func EncodeClose(reason CloseReason, text string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(reason))
+	return append(b, text...)
+}
+
+// DecodeClose parses the payload of a ControlClose message
+func DecodeClose(payload []byte) (CloseReason, string, error) {
+	if len(payload) < 2 {
+		return 0, "", fmt.Errorf("truncated close message")
+	}
+	return CloseReason(binary.BigEndian.Uint16(payload)), string(payload[2:]), nil
+}
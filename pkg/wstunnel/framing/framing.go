@@ -0,0 +1,478 @@
+// Package framing carries WireGuard datagrams over a WebSocket connection.
+//
+// Two WebSocket subprotocols are defined. With "wiresocket.v1" (and for peers
+// that negotiate no subprotocol) every datagram is one binary message. With
+// "wiresocket.v2" a binary message is a sequence of records:
+//
+//	type (1 byte) | length (2 bytes, big endian) | data
+//
+// Datagram records carry one datagram each and padding records are ignored,
+// so a sender can add random-length padding, coalesce several small datagrams
+// into one message and send padding-only messages as cover traffic. Message
+// sizes then no longer reveal WireGuard's fixed-size handshake and keepalive
+// packets. Control records carry typed messages next to the datagrams: pings,
+// notices for the user, close reasons and session resume tokens (see
+// control.go). Records of unknown types are skipped, so later versions can
+// add record types without breaking older peers.
+//
+// Clients and servers offer both subprotocols and prefer v2. Peers that don't
+// know the subprotocols (older clients and servers) keep working in the v1
+// format, without control messages.
+//
+// A third subprotocol, "wiresocket.mux1", uses the v2 format to carry many
+// UDP flows over one connection: its datagram records hold the frames of
+// package mux. Servers offer it first (see MuxSubprotocols); clients offer it
+// only when they multiplex.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols
+const (
+	ProtocolV1 = "wiresocket.v1" // One datagram per message
+	ProtocolV2 = "wiresocket.v2" // Records with padding and coalescing
+
+	// Multiplexed flows in the v2 format (see package mux)
+	ProtocolMux = "wiresocket.mux1"
+)
+
+// Subprotocols lists the supported subprotocols in order of preference, for
+// websocket.Upgrader.Subprotocols and websocket.Dialer.Subprotocols
+var Subprotocols = []string{ProtocolV2, ProtocolV1}
+
+// MuxSubprotocols adds ProtocolMux to Subprotocols, for servers that accept
+// multiplexed connections and for clients that multiplex
+var MuxSubprotocols = []string{ProtocolMux, ProtocolV2, ProtocolV1}
+
+// Record types of the v2 format
+const (
+	RecordDatagram byte = 0x00
+	RecordPadding  byte = 0x01
+	RecordControl  byte = 0x02
+)
+
+// recordHeader is the size of a record header
+const recordHeader = 3
+
+// maxRecord is the largest record payload
+const maxRecord = 0xFFFF
+
+// DefaultCoalesceSize is the message size at which coalesced datagrams are
+// sent without waiting for more
+const DefaultCoalesceSize = 1200
+
+// queueSize is how many datagrams may wait for the shaper
+const queueSize = 256
+
+// ErrClosed is returned when writing to a closed Conn
+var ErrClosed = errors.New("connection closed")
+
+// Shaping configures how a sender hides its traffic pattern in the v2 format.
+// The zero value sends one message per datagram without padding.
+type Shaping struct {
+	// Random padding added to every message, between PaddingMin and
+	// PaddingMax bytes
+	PaddingMin int `yaml:"padding_min" json:"padding_min,omitempty"`
+	PaddingMax int `yaml:"padding_max" json:"padding_max,omitempty"`
+
+	// How long a datagram may wait for others to share its message (0 sends
+	// at once), and the message size that is sent without waiting longer
+	// (default: 1200)
+	CoalesceDelay time.Duration `yaml:"coalesce_delay" json:"coalesce_delay,omitempty"`
+	CoalesceSize  int           `yaml:"coalesce_size" json:"coalesce_size,omitempty"`
+
+	// Random delay of up to Jitter before each message
+	Jitter time.Duration `yaml:"jitter" json:"jitter,omitempty"`
+
+	// While idle, padding-only messages are sent at random intervals
+	// averaging CoverInterval (0 disables cover traffic)
+	CoverInterval time.Duration `yaml:"cover_interval" json:"cover_interval,omitempty"`
+}
+
+// Enabled reports whether any shaping is configured
+func (s Shaping) Enabled() bool {
+	return s != Shaping{}
+}
+
+// Validate checks the settings
+func (s Shaping) Validate() error {
+	switch {
+	case s.PaddingMin < 0 || s.PaddingMax < 0:
+		return fmt.Errorf("padding must not be negative")
+	case s.PaddingMax > maxRecord:
+		return fmt.Errorf("padding_max must be at most %d", maxRecord)
+	case s.PaddingMin > s.PaddingMax:
+		return fmt.Errorf("padding_min must not exceed padding_max")
+	case s.CoalesceDelay < 0 || s.Jitter < 0 || s.CoverInterval < 0:
+		return fmt.Errorf("durations must not be negative")
+	case s.CoalesceSize < 0 || s.CoalesceSize > maxRecord:
+		return fmt.Errorf("coalesce_size must be between 0 and %d", maxRecord)
+	}
+	return nil
+}
+
+// Conn sends and receives datagrams over a WebSocket connection in the
+// format of the negotiated subprotocol. ReadDatagram must not be called
+// concurrently with itself; WriteDatagram and the control methods may be
+// called from any goroutine.
+type Conn struct {
+	ws      *websocket.Conn
+	framed  bool
+	shaping Shaping
+
+	pending   [][]byte                       // Datagrams of the last message not yet read
+	closeErr  error                          // Close reason received, returned once pending is read
+	onControl func(typ byte, payload []byte) // Optional; see SetControlHandler
+
+	readTimeout time.Duration // Set by StartKeepalive
+	deadlineSet time.Time     // When the read deadline was last extended
+	pingMu      sync.Mutex
+	pingSeq     uint64    // Last ping not answered yet; 0 if none
+	pingSent    time.Time // When pingSeq was sent
+	rtt         atomic.Int64
+	onRTT       func(rtt time.Duration) // Optional; see SetRTTHandler
+
+	writeMu   sync.Mutex  // Serializes writes of the shaper, the writer and control messages
+	queue     chan []byte // Datagrams for the shaper; nil when writing directly
+	done      chan struct{}
+	closeOnce sync.Once
+	errMu     sync.Mutex
+	err       error // First write error of the shaper
+}
+
+// NewConn wraps an established WebSocket connection. The v2 format is used if
+// it (or ProtocolMux) was negotiated, and shaping then applies to the
+// datagrams written.
+func NewConn(ws *websocket.Conn, shaping Shaping) *Conn {
+	c := &Conn{
+		ws:      ws,
+		framed:  ws.Subprotocol() == ProtocolV2 || ws.Subprotocol() == ProtocolMux,
+		shaping: shaping,
+		done:    make(chan struct{}),
+	}
+	if c.shaping.CoalesceSize <= 0 {
+		c.shaping.CoalesceSize = DefaultCoalesceSize
+	}
+	if c.framed && (shaping.CoalesceDelay > 0 || shaping.Jitter > 0 || shaping.CoverInterval > 0) {
+		c.queue = make(chan []byte, queueSize)
+		go c.shape()
+	}
+	return c
+}
+
+// Framed reports whether the v2 format is used
+func (c *Conn) Framed() bool {
+	return c.framed
+}
+
+// Multiplexed reports whether the connection carries the frames of package mux
+func (c *Conn) Multiplexed() bool {
+	return c.ws.Subprotocol() == ProtocolMux
+}
+
+// Subprotocol returns the negotiated subprotocol; empty means v1
+func (c *Conn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// ReadDatagram returns the next datagram received
+func (c *Conn) ReadDatagram() ([]byte, error) {
+	for len(c.pending) == 0 {
+		if c.closeErr != nil {
+			return nil, c.closeErr
+		}
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		c.extendDeadline()
+		if !c.framed {
+			return msg, nil
+		}
+		if err := c.readRecords(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	p := c.pending[0]
+	c.pending[0] = nil
+	c.pending = c.pending[1:]
+	return p, nil
+}
+
+// WriteDatagram sends a datagram. With coalescing, jitter or cover traffic it
+// is queued for the shaper and p may be reused once WriteDatagram returns.
+func (c *Conn) WriteDatagram(p []byte) error {
+	if len(p) > maxRecord {
+		return fmt.Errorf("datagram too large: %d bytes", len(p))
+	}
+	if !c.framed {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		return c.ws.WriteMessage(websocket.BinaryMessage, p)
+	}
+	if c.queue == nil {
+		return c.writeMessage([][]byte{p})
+	}
+
+	select {
+	case <-c.done:
+		if err := c.shaperErr(); err != nil {
+			return err
+		}
+		return ErrClosed
+	default:
+	}
+	select {
+	case c.queue <- append([]byte(nil), p...):
+		return nil
+	case <-c.done:
+		if err := c.shaperErr(); err != nil {
+			return err
+		}
+		return ErrClosed
+	}
+}
+
+// Close stops the shaper and closes the WebSocket connection. Queued
+// datagrams are dropped.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.ws.Close()
+}
+
+func (c *Conn) shaperErr() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// shape sends the queued datagrams, coalescing them for up to CoalesceDelay,
+// and sends cover traffic while idle
+func (c *Conn) shape() {
+	var (
+		batch  [][]byte
+		size   int
+		flush  *time.Timer
+		flushC <-chan time.Time
+	)
+
+	var cover *time.Timer
+	var coverC <-chan time.Time
+	resetCover := func() {
+		if c.shaping.CoverInterval <= 0 {
+			return
+		}
+		// Uniform in [interval/2, 3*interval/2): averages the interval
+		// without a recognizable period
+		d := c.shaping.CoverInterval/2 + randDuration(c.shaping.CoverInterval)
+		if cover == nil {
+			cover = time.NewTimer(d)
+			coverC = cover.C
+			return
+		}
+		cover.Reset(d)
+	}
+	resetCover()
+	defer func() {
+		if flush != nil {
+			flush.Stop()
+		}
+		if cover != nil {
+			cover.Stop()
+		}
+	}()
+
+	send := func(datagrams [][]byte) bool {
+		if c.shaping.Jitter > 0 {
+			select {
+			case <-time.After(randDuration(c.shaping.Jitter)):
+			case <-c.done:
+				return false
+			}
+		}
+		if err := c.writeMessage(datagrams); err != nil {
+			c.errMu.Lock()
+			c.err = err
+			c.errMu.Unlock()
+			c.closeOnce.Do(func() { close(c.done) })
+			return false
+		}
+		resetCover()
+		return true
+	}
+	sendBatch := func() bool {
+		if flush != nil {
+			flush.Stop()
+			flushC = nil
+		}
+		if len(batch) == 0 {
+			return true
+		}
+		ok := send(batch)
+		batch, size = batch[:0], 0
+		return ok
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case p := <-c.queue:
+			if size > 0 && size+recordHeader+len(p) > c.shaping.CoalesceSize {
+				if !sendBatch() {
+					return
+				}
+			}
+			batch = append(batch, p)
+			size += recordHeader + len(p)
+			if size >= c.shaping.CoalesceSize || c.shaping.CoalesceDelay <= 0 {
+				if !sendBatch() {
+					return
+				}
+			} else if flushC == nil {
+				if flush == nil {
+					flush = time.NewTimer(c.shaping.CoalesceDelay)
+				} else {
+					flush.Reset(c.shaping.CoalesceDelay)
+				}
+				flushC = flush.C
+			}
+
+		case <-flushC:
+			flushC = nil
+			if !sendBatch() {
+				return
+			}
+
+		case <-coverC:
+			if len(batch) == 0 {
+				if !send(nil) {
+					return
+				}
+			} else {
+				resetCover()
+			}
+		}
+	}
+}
+
+// writeMessage sends datagrams as one v2 message with padding. Without
+// datagrams it sends a cover message.
+func (c *Conn) writeMessage(datagrams [][]byte) error {
+	size := recordHeader + c.shaping.PaddingMax
+	for _, p := range datagrams {
+		size += recordHeader + len(p)
+	}
+	msg := make([]byte, 0, size)
+	for _, p := range datagrams {
+		msg = AppendRecord(msg, RecordDatagram, p)
+	}
+	return c.writeRecords(msg, len(datagrams) == 0)
+}
+
+// writeRecords sends the records in msg as one v2 message with padding.
+// Cover messages get padding even if no padding is configured.
+func (c *Conn) writeRecords(msg []byte, cover bool) error {
+	padding := c.shaping.PaddingMin
+	if span := c.shaping.PaddingMax - c.shaping.PaddingMin; span > 0 {
+		padding += rand.Intn(span + 1)
+	}
+	if cover && padding == 0 {
+		// Cover messages need some size to be worth sending
+		padding = 64 + rand.Intn(c.shaping.CoalesceSize)
+	}
+	if padding > 0 {
+		msg = AppendPadding(msg, padding)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// AppendRecord appends a v2 record to b
+func AppendRecord(b []byte, typ byte, data []byte) []byte {
+	b = append(b, typ, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(data)))
+	return append(b, data...)
+}
+
+// AppendPadding appends a padding record with n bytes of zeros to b
+func AppendPadding(b []byte, n int) []byte {
+	if n > maxRecord {
+		n = maxRecord
+	}
+	b = append(b, RecordPadding, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(n))
+	return append(b, make([]byte, n)...)
+}
+
+// Decode appends the datagrams of a v2 message to datagrams, skipping all
+// other records. The datagrams share msg's memory.
+func Decode(msg []byte, datagrams [][]byte) ([][]byte, error) {
+	for len(msg) > 0 {
+		typ, data, rest, err := nextRecord(msg)
+		if err != nil {
+			return datagrams, err
+		}
+		if typ == RecordDatagram {
+			datagrams = append(datagrams, data)
+		}
+		msg = rest
+	}
+	return datagrams, nil
+}
+
+// readRecords queues the datagrams of a v2 message for ReadDatagram and
+// handles its control records
+func (c *Conn) readRecords(msg []byte) error {
+	c.pending = c.pending[:0]
+	for len(msg) > 0 {
+		typ, data, rest, err := nextRecord(msg)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case RecordDatagram:
+			c.pending = append(c.pending, data)
+		case RecordControl:
+			if err := c.handleControl(data); err != nil {
+				return err
+			}
+		}
+		msg = rest
+	}
+	return nil
+}
+
+// nextRecord splits the first record off msg
+func nextRecord(msg []byte) (typ byte, data, rest []byte, err error) {
+	if len(msg) < recordHeader {
+		return 0, nil, nil, fmt.Errorf("truncated record header")
+	}
+	n := int(binary.BigEndian.Uint16(msg[1:3]))
+	if len(msg) < recordHeader+n {
+		return 0, nil, nil, fmt.Errorf("truncated record: want %d bytes, have %d", n, len(msg)-recordHeader)
+	}
+	return msg[0], msg[recordHeader : recordHeader+n], msg[recordHeader+n:], nil
+}
+
+// randDuration returns a random duration in [0, max)
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
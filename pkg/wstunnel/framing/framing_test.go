@@ -0,0 +1,375 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/internal/wstest"
+)
+
+// wsPair connects a client offering subprotocols to a server supporting
+// Subprotocols and returns both ends
+func wsPair(t *testing.T, subprotocols []string) (client, server *websocket.Conn) {
+	t.Helper()
+	return wstest.Pair(t, subprotocols, Subprotocols)
+}
+
+// TestDecode tests decoding records, skipping padding and unknown types
+func TestDecode(t *testing.T) {
+	var msg []byte
+	msg = AppendRecord(msg, RecordDatagram, []byte("first"))
+	msg = AppendPadding(msg, 10)
+	msg = AppendRecord(msg, 0x7F, []byte("future record type"))
+	msg = AppendRecord(msg, RecordDatagram, []byte("second"))
+	msg = AppendRecord(msg, RecordDatagram, nil)
+
+	datagrams, err := Decode(msg, nil)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	want := []string{"first", "second", ""}
+	if len(datagrams) != len(want) {
+		t.Fatalf("Expected %d datagrams, got %d", len(want), len(datagrams))
+	}
+	for i, w := range want {
+		if string(datagrams[i]) != w {
+			t.Errorf("Datagram %d: expected %q, got %q", i, w, datagrams[i])
+		}
+	}
+}
+
+// TestDecodeTruncated tests that truncated messages are rejected
+func TestDecodeTruncated(t *testing.T) {
+	msg := AppendRecord(nil, RecordDatagram, []byte("datagram"))
+
+	for _, n := range []int{1, 2, len(msg) - 1} {
+		if _, err := Decode(msg[:n], nil); err == nil {
+			t.Errorf("Expected error for message truncated to %d bytes", n)
+		}
+	}
+}
+
+// TestShapingValidate tests the validation of shaping settings
+func TestShapingValidate(t *testing.T) {
+	valid := Shaping{PaddingMin: 16, PaddingMax: 256, CoalesceDelay: time.Millisecond, CoverInterval: time.Second}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid settings, got %v", err)
+	}
+	if !valid.Enabled() || (Shaping{}).Enabled() {
+		t.Error("Enabled should report whether any setting is made")
+	}
+
+	invalid := []Shaping{
+		{PaddingMin: -1},
+		{PaddingMin: 10, PaddingMax: 5},
+		{PaddingMax: maxRecord + 1},
+		{Jitter: -time.Second},
+		{CoalesceSize: -1},
+	}
+	for _, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", s)
+		}
+	}
+}
+
+// TestNegotiationWithOldClient tests that a client offering no subprotocol
+// gets one message per datagram
+func TestNegotiationWithOldClient(t *testing.T) {
+	client, server := wsPair(t, nil)
+
+	conn := NewConn(server, Shaping{PaddingMin: 100, PaddingMax: 100})
+	if conn.Framed() {
+		t.Fatal("Expected v1 format for a client without subprotocols")
+	}
+
+	if err := conn.WriteDatagram([]byte("hello")); err != nil {
+		t.Fatalf("WriteDatagram failed: %v", err)
+	}
+	_, msg, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if string(msg) != "hello" {
+		t.Errorf("Expected unframed datagram, got %q", msg)
+	}
+
+	client.WriteMessage(websocket.BinaryMessage, []byte("reply"))
+	p, err := conn.ReadDatagram()
+	if err != nil {
+		t.Fatalf("ReadDatagram failed: %v", err)
+	}
+	if string(p) != "reply" {
+		t.Errorf("Expected %q, got %q", "reply", p)
+	}
+}
+
+// TestFramedRoundTrip tests datagrams in both directions with v2 and padding
+func TestFramedRoundTrip(t *testing.T) {
+	clientWS, serverWS := wsPair(t, Subprotocols)
+
+	shaping := Shaping{PaddingMin: 1, PaddingMax: 300}
+	client := NewConn(clientWS, shaping)
+	server := NewConn(serverWS, shaping)
+	if !client.Framed() || !server.Framed() {
+		t.Fatalf("Expected v2 on both ends, got %q and %q", client.Subprotocol(), server.Subprotocol())
+	}
+
+	for i := 0; i < 20; i++ {
+		want := bytes.Repeat([]byte{byte(i)}, 148)
+		if err := client.WriteDatagram(want); err != nil {
+			t.Fatalf("WriteDatagram failed: %v", err)
+		}
+		got, err := server.ReadDatagram()
+		if err != nil {
+			t.Fatalf("ReadDatagram failed: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Datagram %d corrupted", i)
+		}
+	}
+}
+
+// TestCoalescing tests that small datagrams written within the coalesce
+// delay share one padded message
+func TestCoalescing(t *testing.T) {
+	clientWS, serverWS := wsPair(t, Subprotocols)
+
+	conn := NewConn(clientWS, Shaping{PaddingMin: 32, PaddingMax: 64, CoalesceDelay: 50 * time.Millisecond})
+	defer conn.Close()
+	for _, p := range []string{"one", "two", "three"} {
+		if err := conn.WriteDatagram([]byte(p)); err != nil {
+			t.Fatalf("WriteDatagram failed: %v", err)
+		}
+	}
+
+	serverWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := serverWS.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	datagrams, err := Decode(msg, nil)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(datagrams) != 3 {
+		t.Fatalf("Expected 3 coalesced datagrams, got %d", len(datagrams))
+	}
+
+	payload := 3*recordHeader + len("one") + len("two") + len("three")
+	if padding := len(msg) - payload - recordHeader; padding < 32 || padding > 64 {
+		t.Errorf("Expected 32-64 bytes of padding, got %d", padding)
+	}
+}
+
+// TestCoalesceSize tests that a full message is sent without waiting
+func TestCoalesceSize(t *testing.T) {
+	clientWS, serverWS := wsPair(t, Subprotocols)
+
+	conn := NewConn(clientWS, Shaping{CoalesceDelay: time.Hour, CoalesceSize: 200})
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteDatagram(make([]byte, 120)); err != nil {
+			t.Fatalf("WriteDatagram failed: %v", err)
+		}
+	}
+
+	// The second datagram doesn't fit, so the first is sent alone
+	serverWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := serverWS.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected the first datagram before the coalesce delay: %v", err)
+	}
+	if datagrams, _ := Decode(msg, nil); len(datagrams) != 1 {
+		t.Errorf("Expected 1 datagram, got %d", len(datagrams))
+	}
+}
+
+// TestCoverTraffic tests that padding-only messages are sent while idle and
+// yield no datagrams
+func TestCoverTraffic(t *testing.T) {
+	clientWS, serverWS := wsPair(t, Subprotocols)
+
+	conn := NewConn(clientWS, Shaping{CoverInterval: 20 * time.Millisecond})
+	defer conn.Close()
+
+	serverWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := serverWS.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected a cover message: %v", err)
+	}
+	datagrams, err := Decode(msg, nil)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(datagrams) != 0 || len(msg) <= recordHeader {
+		t.Errorf("Expected a padding-only message, got %d datagrams in %d bytes", len(datagrams), len(msg))
+	}
+}
+
+// TestWriteAfterClose tests that writes fail once the connection is closed
+func TestWriteAfterClose(t *testing.T) {
+	clientWS, _ := wsPair(t, Subprotocols)
+
+	conn := NewConn(clientWS, Shaping{CoalesceDelay: time.Millisecond})
+	conn.Close()
+	if err := conn.WriteDatagram([]byte("late")); err == nil {
+		t.Error("Expected error writing to a closed connection")
+	}
+}
+
+// TestControlMessages tests that pings are answered, notices reach the
+// handler and a close reason ends reading after the preceding datagrams
+func TestControlMessages(t *testing.T) {
+	clientWS, serverWS := wsPair(t, Subprotocols)
+	client := NewConn(clientWS, Shaping{PaddingMin: 8, PaddingMax: 32})
+	server := NewConn(serverWS, Shaping{})
+
+	controls := make(chan string, 4)
+	client.SetControlHandler(func(typ byte, payload []byte) {
+		controls <- fmt.Sprintf("%d:%s", typ, payload)
+	})
+	pongs := make(chan string, 1)
+	server.SetControlHandler(func(typ byte, payload []byte) {
+		if typ == ControlPong {
+			pongs <- string(payload)
+		}
+	})
+
+	// The server reads in the background to receive the pong
+	go func() {
+		for {
+			if _, err := server.ReadDatagram(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := server.SendControl(ControlPing, []byte("nonce")); err != nil {
+		t.Fatalf("SendControl failed: %v", err)
+	}
+	if err := server.SendNotice("maintenance at 22:00"); err != nil {
+		t.Fatalf("SendNotice failed: %v", err)
+	}
+	if err := server.WriteDatagram([]byte("last datagram")); err != nil {
+		t.Fatalf("WriteDatagram failed: %v", err)
+	}
+	if err := server.SendClose(CloseAdmin, "bye", time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("SendClose failed: %v", err)
+	}
+
+	p, err := client.ReadDatagram()
+	if err != nil {
+		t.Fatalf("ReadDatagram failed: %v", err)
+	}
+	if string(p) != "last datagram" {
+		t.Errorf("Expected the datagram before the close reason, got %q", p)
+	}
+	_, err = client.ReadDatagram()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Reason != CloseAdmin || closeErr.Text != "bye" {
+		t.Fatalf("Expected close reason %v, got %v", CloseAdmin, err)
+	}
+	if closeErr.Reason.Retry() {
+		t.Error("Expected no retry after an administrator closed the connection")
+	}
+
+	if got := <-controls; got != fmt.Sprintf("%d:maintenance at 22:00", ControlNotice) {
+		t.Errorf("Expected the notice, got %q", got)
+	}
+	select {
+	case got := <-pongs:
+		if got != "nonce" {
+			t.Errorf("Expected the pong to echo the ping, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected a pong")
+	}
+}
+
+// TestControlNotFramed tests that control messages need v2
+func TestControlNotFramed(t *testing.T) {
+	_, serverWS := wsPair(t, nil)
+
+	conn := NewConn(serverWS, Shaping{})
+	if err := conn.SendNotice("hello"); !errors.Is(err, ErrNotFramed) {
+		t.Errorf("Expected ErrNotFramed, got %v", err)
+	}
+	if err := conn.SendClose(CloseShutdown, "restarting", time.Now().Add(time.Second)); err != nil {
+		t.Errorf("Expected a plain close frame in v1, got %v", err)
+	}
+}
+
+// TestKeepaliveRTT tests that pings are answered and measured in both
+// formats
+func TestKeepaliveRTT(t *testing.T) {
+	for _, subprotocols := range [][]string{Subprotocols, nil} {
+		clientWS, serverWS := wsPair(t, subprotocols)
+		client := NewConn(clientWS, Shaping{})
+		server := NewConn(serverWS, Shaping{})
+
+		rtts := make(chan time.Duration, 4)
+		server.SetRTTHandler(func(rtt time.Duration) { rtts <- rtt })
+		server.StartKeepalive(Keepalive{Interval: 20 * time.Millisecond})
+
+		// Both ends read: the client to answer pings, the server to get pongs
+		go client.ReadDatagram()
+		go server.ReadDatagram()
+
+		select {
+		case rtt := <-rtts:
+			if rtt <= 0 || server.RTT() <= 0 {
+				t.Errorf("Expected a positive RTT with subprotocols %v, got %v", subprotocols, rtt)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("Expected a pong with subprotocols %v", subprotocols)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// TestKeepaliveTimeout tests that reading fails when the peer stops answering
+func TestKeepaliveTimeout(t *testing.T) {
+	_, serverWS := wsPair(t, Subprotocols)
+
+	// The client never reads, so it never answers
+	server := NewConn(serverWS, Shaping{})
+	server.StartKeepalive(Keepalive{Interval: 20 * time.Millisecond, Timeout: 100 * time.Millisecond})
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := server.ReadDatagram()
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("Expected a read error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected reading to fail after the timeout")
+	}
+}
+
+// TestKeepaliveValidate tests the validation of keepalive settings
+func TestKeepaliveValidate(t *testing.T) {
+	valid := []Keepalive{{}, {Interval: -1}, {Interval: 10 * time.Second, Timeout: 25 * time.Second}}
+	for _, k := range valid {
+		if err := k.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", k, err)
+		}
+	}
+
+	invalid := []Keepalive{{Timeout: -time.Second}, {Interval: 10 * time.Second, Timeout: 5 * time.Second}, {Timeout: time.Second}}
+	for _, k := range invalid {
+		if err := k.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", k)
+		}
+	}
+}
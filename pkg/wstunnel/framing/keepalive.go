@@ -0,0 +1,161 @@
+package framing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultPingInterval is the ping interval of a zero Keepalive. It is well
+// below the idle timeouts of common proxies and CDNs (often 60-100s).
+const DefaultPingInterval = 30 * time.Second
+
+// deadlineSlack is how often the read deadline is extended at most while
+// messages arrive (or a quarter of the timeout, if shorter)
+const deadlineSlack = time.Second
+
+// Keepalive configures pings that keep idle connections open through proxies,
+// detect dead peers and measure the round-trip time. The zero value pings
+// every DefaultPingInterval.
+type Keepalive struct {
+	// Time between pings (default: 30s; negative disables pings)
+	Interval time.Duration `yaml:"interval" json:"interval,omitempty"`
+
+	// The connection fails when nothing arrives from the peer for this long
+	// (default: three intervals)
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// Validate checks the settings
+func (k Keepalive) Validate() error {
+	interval, timeout := k.durations()
+	switch {
+	case k.Timeout < 0:
+		return fmt.Errorf("timeout must not be negative")
+	case interval > 0 && timeout <= interval:
+		return fmt.Errorf("timeout must be longer than the interval")
+	}
+	return nil
+}
+
+// durations returns the interval and timeout with defaults applied; both are
+// 0 if pings are disabled
+func (k Keepalive) durations() (interval, timeout time.Duration) {
+	if k.Interval < 0 {
+		return 0, 0
+	}
+	interval = k.Interval
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+	timeout = k.Timeout
+	if timeout == 0 {
+		timeout = 3 * interval
+	}
+	return interval, timeout
+}
+
+// StartKeepalive starts pinging the peer: with ping control messages in the
+// v2 format, which proxies see as data, and with WebSocket pings in v1. The
+// round-trip time of every answered ping is recorded (see RTT), and
+// ReadDatagram fails once nothing arrived from the peer for the timeout.
+// Call it once, before reading.
+func (c *Conn) StartKeepalive(k Keepalive) {
+	interval, timeout := k.durations()
+	if interval <= 0 {
+		return
+	}
+
+	c.readTimeout = timeout
+	c.extendDeadline()
+	if !c.framed {
+		c.ws.SetPongHandler(func(data string) error {
+			c.pong([]byte(data))
+			c.extendDeadline()
+			return nil
+		})
+	}
+	go c.ping(interval)
+}
+
+// RTT returns the round-trip time of the last answered ping, or 0 if no ping
+// was answered yet
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// SetRTTHandler sets a function called with the round-trip time of every
+// answered ping. It is called by ReadDatagram and must not block.
+func (c *Conn) SetRTTHandler(fn func(rtt time.Duration)) {
+	c.onRTT = fn
+}
+
+// ping sends a ping every interval until the connection is closed
+func (c *Conn) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var seq uint64
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		seq++
+		payload := binary.BigEndian.AppendUint64(nil, seq)
+		c.pingMu.Lock()
+		c.pingSeq, c.pingSent = seq, time.Now()
+		c.pingMu.Unlock()
+
+		var err error
+		if c.framed {
+			err = c.SendControl(ControlPing, payload)
+		} else {
+			err = c.ws.WriteControl(websocket.PingMessage, payload, time.Now().Add(interval))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pong records the round-trip time if payload answers the last ping. It
+// returns false for pongs of other pings.
+func (c *Conn) pong(payload []byte) bool {
+	if len(payload) != 8 {
+		return false
+	}
+	c.pingMu.Lock()
+	if binary.BigEndian.Uint64(payload) != c.pingSeq {
+		c.pingMu.Unlock()
+		return false
+	}
+	rtt := time.Since(c.pingSent)
+	c.pingSeq = 0
+	c.pingMu.Unlock()
+
+	c.rtt.Store(int64(rtt))
+	if c.onRTT != nil {
+		c.onRTT(rtt)
+	}
+	return true
+}
+
+// extendDeadline moves the read deadline to the timeout from now. Called for
+// every message received, it only touches the connection once per
+// deadlineSlack.
+func (c *Conn) extendDeadline() {
+	if c.readTimeout <= 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.deadlineSet) < min(deadlineSlack, c.readTimeout/4) {
+		return
+	}
+	c.deadlineSet = now
+	c.ws.SetReadDeadline(now.Add(c.readTimeout))
+}
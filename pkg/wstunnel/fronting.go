@@ -0,0 +1,94 @@
+package wstunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/proxy"
+)
+
+// Fronting reaches the server through a CDN or another front: connections go
+// to ConnectAddr, TLS names SNI and HTTP requests carry Host and Headers, each
+// instead of what the server URL implies
+type Fronting struct {
+	ConnectAddr string            `json:"connect_address,omitempty"` // Host or host:port to dial; without a port, the URL's is used
+	SNI         string            `json:"sni,omitempty"`             // TLS server name (default: the URL's host)
+	Host        string            `json:"host,omitempty"`            // HTTP Host header (default: the URL's host)
+	Headers     map[string]string `json:"headers,omitempty"`         // Extra request headers, e.g. CF-Access-Client-Id or User-Agent
+}
+
+// reservedHeaders are set by the client itself
+var reservedHeaders = map[string]bool{
+	"Host":          true,
+	"Authorization": true,
+	"Connection":    true,
+	"Upgrade":       true,
+}
+
+// Validate checks the settings
+func (f Fronting) Validate() error {
+	if f.ConnectAddr != "" {
+		host := f.ConnectAddr
+		if h, _, err := net.SplitHostPort(f.ConnectAddr); err == nil {
+			host = h
+		}
+		if host == "" || strings.ContainsAny(f.ConnectAddr, "/ ") {
+			return fmt.Errorf("invalid connect address %q", f.ConnectAddr)
+		}
+	}
+	if strings.ContainsAny(f.SNI, ":/ ") {
+		return fmt.Errorf("invalid SNI %q (want a host name)", f.SNI)
+	}
+	for name, value := range f.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		switch {
+		case name == "" || strings.ContainsAny(name, ": \t\r\n"):
+			return fmt.Errorf("invalid header name %q", name)
+		case strings.ContainsAny(value, "\r\n"):
+			return fmt.Errorf("invalid value of header %s", name)
+		case reservedHeaders[canonical] || strings.HasPrefix(canonical, "Sec-Websocket-"):
+			return fmt.Errorf("header %s is set by the client", canonical)
+		}
+	}
+	return nil
+}
+
+// Dialer returns dial redirected to the connect address
+func (f Fronting) Dialer(dial proxy.DialFunc) proxy.DialFunc {
+	if f.ConnectAddr == "" {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, f.connectAddr(addr))
+	}
+}
+
+// connectAddr returns the address to dial instead of addr
+func (f Fronting) connectAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(f.ConnectAddr); err == nil {
+		return f.ConnectAddr
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	return net.JoinHostPort(strings.Trim(f.ConnectAddr, "[]"), port)
+}
+
+// ServerName returns the TLS server name for a server at host
+func (f Fronting) ServerName(host string) string {
+	if f.SNI != "" {
+		return f.SNI
+	}
+	return host
+}
+
+// SetHeaders adds Host and the extra headers to header
+func (f Fronting) SetHeaders(header http.Header) {
+	if f.Host != "" {
+		header.Set("Host", f.Host)
+	}
+	for name, value := range f.Headers {
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,54 @@
+package wstunnel
+
+import "testing"
+
+// TestFrontingValidate tests checking the fronting settings
+func TestFrontingValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fronting Fronting
+		wantErr  bool
+	}{
+		{"empty", Fronting{}, false},
+		{"full", Fronting{ConnectAddr: "cdn.example.net:443", SNI: "cdn.example.net", Host: "vpn.example.com",
+			Headers: map[string]string{"CF-Access-Client-Id": "id", "User-Agent": "Mozilla/5.0"}}, false},
+		{"connect host", Fronting{ConnectAddr: "cdn.example.net"}, false},
+		{"connect IPv6", Fronting{ConnectAddr: "[2001:db8::1]:443"}, false},
+		{"connect port only", Fronting{ConnectAddr: ":443"}, true},
+		{"connect URL", Fronting{ConnectAddr: "https://cdn.example.net"}, true},
+		{"SNI with port", Fronting{SNI: "cdn.example.net:443"}, true},
+		{"SNI with space", Fronting{SNI: "cdn example"}, true},
+		{"empty header name", Fronting{Headers: map[string]string{"": "x"}}, true},
+		{"header name with colon", Fronting{Headers: map[string]string{"X-A:": "x"}}, true},
+		{"header injection", Fronting{Headers: map[string]string{"X-A": "x\r\nX-B: y"}}, true},
+		{"Host header", Fronting{Headers: map[string]string{"host": "vpn.example.com"}}, true},
+		{"Authorization header", Fronting{Headers: map[string]string{"Authorization": "Bearer x"}}, true},
+		{"WebSocket header", Fronting{Headers: map[string]string{"Sec-WebSocket-Protocol": "x"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fronting.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestFrontingConnectAddr tests the address dialed instead of the URL's
+func TestFrontingConnectAddr(t *testing.T) {
+	tests := []struct {
+		connectAddr string
+		addr        string
+		want        string
+	}{
+		{"cdn.example.net:8443", "vpn.example.com:443", "cdn.example.net:8443"},
+		{"cdn.example.net", "vpn.example.com:443", "cdn.example.net:443"},
+		{"2001:db8::1", "vpn.example.com:80", "[2001:db8::1]:80"},
+		{"[2001:db8::1]", "vpn.example.com:443", "[2001:db8::1]:443"},
+	}
+	for _, tt := range tests {
+		if got := (Fronting{ConnectAddr: tt.connectAddr}).connectAddr(tt.addr); got != tt.want {
+			t.Errorf("connectAddr(%q) with %q = %q, want %q", tt.addr, tt.connectAddr, got, tt.want)
+		}
+	}
+}
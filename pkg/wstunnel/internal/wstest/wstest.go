@@ -0,0 +1,43 @@
+// Package wstest connects WebSocket pairs for the tests of the tunnel
+// packages.
+package wstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Pair connects a client offering the subprotocols offered to a server that
+// supports those in supported, and returns both ends. They are closed when
+// the test ends.
+func Pair(t testing.TB, offered, supported []string) (client, server *websocket.Conn) {
+	t.Helper()
+
+	serverConn := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{Subprotocols: supported}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		serverConn <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	dialer := websocket.Dialer{Subprotocols: offered}
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	server = <-serverConn
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
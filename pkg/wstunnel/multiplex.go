@@ -0,0 +1,101 @@
+package wstunnel
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/mux"
+)
+
+const (
+	// muxReconnectMinDelay is the first delay before redialing a lost
+	// multiplexed connection
+	muxReconnectMinDelay = time.Second
+
+	// muxReconnectMaxDelay caps the backoff between redials
+	muxReconnectMaxDelay = 30 * time.Second
+)
+
+// errMuxReconnecting is returned when opening a stream while the multiplexed
+// connection is reconnecting
+var errMuxReconnecting = errors.New("multiplexed connection reconnecting")
+
+// isMultiplexed reports whether the sessions are streams of the multiplexed
+// connection
+func (c *Client) isMultiplexed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.multiplexed
+}
+
+// runMux reads the multiplexed connection until the client stops. When the
+// connection is lost, the sessions on it end and it is redialed with backoff;
+// if the server no longer multiplexes, the client falls back to a connection
+// per source.
+func (c *Client) runMux(sess *mux.Session, stop chan struct{}) {
+	for {
+		err := sess.Run()
+		c.mu.Lock()
+		if c.mux == sess {
+			c.mux = nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.Printf("Multiplexed tunnel connection lost (%v), reconnecting", err)
+
+		conn := c.redial(stop)
+		if conn == nil {
+			return
+		}
+
+		c.mu.Lock()
+		if !c.running {
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if !conn.Multiplexed() {
+			spare := c.newSession()
+			spare.conn = conn
+			conn.SetControlHandler(spare.handleControl)
+			c.spare = spare
+			c.multiplexed = false
+			c.mu.Unlock()
+
+			log.Printf("Server %s no longer multiplexes, using a connection per source", c.serverURL)
+			go spare.run(conn)
+			return
+		}
+		sess = mux.NewSession(conn, mux.Config{})
+		c.mux = sess
+		c.mu.Unlock()
+		log.Printf("Multiplexed tunnel reconnected to %s", c.serverURL)
+	}
+}
+
+// redial dials the server with backoff until it connects. It returns nil if
+// the client stops first.
+func (c *Client) redial(stop chan struct{}) *framing.Conn {
+	delay := muxReconnectMinDelay
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := c.dialWebSocket(c.serverURL, "", true)
+		if err == nil {
+			return conn
+		}
+		log.Printf("Tunnel connection failed: %v", err)
+		delay = min(2*delay, muxReconnectMaxDelay)
+	}
+}
@@ -0,0 +1,283 @@
+// Package mux carries many UDP flows (streams) over one framed WebSocket
+// connection, for gateways relaying many peers and for proxies that limit the
+// number of connections.
+//
+// A connection negotiates framing.ProtocolMux and uses the v2 record format;
+// each of its datagram records holds one frame:
+//
+//	type (1 byte) | stream ID (4 bytes, big endian) | payload
+//
+// The client opens streams with FrameOpen; either end closes them with
+// FrameClose, optionally with a close reason in the payload (encoded as by
+// framing.EncodeClose). FrameData carries one datagram of a stream.
+//
+// Flow control is per stream and counts the bytes of datagrams: a sender may
+// have InitialWindow bytes in flight, and the receiver grants more with
+// FrameWindow (payload: 4 bytes, big endian) as its reader consumes them, or
+// up front when it wants a larger window. Like a congested UDP path, a sender
+// without credit drops datagrams rather than stalling the other streams.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// Frame types
+const (
+	FrameData   byte = 0x00
+	FrameOpen   byte = 0x01
+	FrameClose  byte = 0x02
+	FrameWindow byte = 0x03
+)
+
+// frameHeader is the size of a frame header
+const frameHeader = 5
+
+// MaxDatagram is the largest datagram a stream carries
+const MaxDatagram = 0xFFFF - frameHeader
+
+// InitialWindow is how many bytes a stream may have in flight before the
+// receiver grants more
+const InitialWindow = 256 << 10
+
+// DefaultMaxStreams is the default limit of streams open at once on a
+// connection
+const DefaultMaxStreams = 256
+
+var (
+	// ErrWindowFull is returned when a datagram is dropped because the
+	// receiver hasn't granted enough credit
+	ErrWindowFull = errors.New("stream window full")
+
+	// ErrStreamClosed is returned when using a stream closed by either end
+	ErrStreamClosed = errors.New("stream closed")
+
+	// ErrSessionClosed is returned when using a closed session
+	ErrSessionClosed = errors.New("session closed")
+)
+
+// Config configures a Session. The zero value uses the defaults.
+type Config struct {
+	// Bytes each stream may receive ahead of its reader (default and
+	// minimum: InitialWindow)
+	Window int
+
+	// Streams the peer may open at once; more are refused (default:
+	// DefaultMaxStreams)
+	MaxStreams int
+}
+
+// Session multiplexes streams over a connection. The client opens streams
+// with Open, the server accepts them with Accept; Run reads the connection
+// and must be running for either to work.
+type Session struct {
+	conn       *framing.Conn
+	window     int
+	maxStreams int
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error // Why the session ended; nil while open
+	accept  chan *Stream
+	done    chan struct{}
+}
+
+// NewSession returns a session on conn, which must have negotiated
+// framing.ProtocolMux
+func NewSession(conn *framing.Conn, cfg Config) *Session {
+	s := &Session{
+		conn:       conn,
+		window:     max(cfg.Window, InitialWindow),
+		maxStreams: cfg.MaxStreams,
+		streams:    make(map[uint32]*Stream),
+		done:       make(chan struct{}),
+	}
+	if s.maxStreams <= 0 {
+		s.maxStreams = DefaultMaxStreams
+	}
+	s.accept = make(chan *Stream, s.maxStreams)
+	return s
+}
+
+// Run reads the connection and dispatches its frames to the streams until
+// the connection fails, then closes the session and the connection. It
+// returns the error that ended the connection.
+func (s *Session) Run() error {
+	defer s.conn.Close()
+	for {
+		data, err := s.conn.ReadDatagram()
+		if err != nil {
+			s.end(err)
+			return s.Err()
+		}
+		if len(data) < frameHeader {
+			s.end(fmt.Errorf("truncated mux frame"))
+			return s.Err()
+		}
+		typ, id, payload := data[0], binary.BigEndian.Uint32(data[1:frameHeader]), data[frameHeader:]
+
+		switch typ {
+		case FrameOpen:
+			s.opened(id)
+		case FrameData:
+			if st := s.stream(id); st != nil {
+				st.receive(payload)
+			}
+		case FrameWindow:
+			if st := s.stream(id); st != nil && len(payload) >= 4 {
+				st.sendWindow.grant(int(binary.BigEndian.Uint32(payload)))
+			}
+		case FrameClose:
+			if st := s.stream(id); st != nil {
+				st.closedByPeer(payload)
+			}
+		}
+		// Unknown frame types are skipped, so later versions can add some
+	}
+}
+
+// Open opens a stream
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.nextID++
+	st := s.newStream(s.nextID)
+	s.mu.Unlock()
+
+	if err := s.writeFrame(FrameOpen, st.id, nil); err != nil {
+		st.Close()
+		return nil, err
+	}
+	st.grantExtra()
+	return st, nil
+}
+
+// Accept returns the next stream opened by the peer. It fails once the
+// session is closed.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Close closes the streams and the connection
+func (s *Session) Close() error {
+	s.end(ErrSessionClosed)
+	return s.conn.Close()
+}
+
+// Err returns why the session ended, or nil while it is open
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// NumStreams returns the number of open streams
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Conn returns the connection of the session
+func (s *Session) Conn() *framing.Conn {
+	return s.conn
+}
+
+// newStream adds a stream; s.mu must be held
+func (s *Session) newStream(id uint32) *Stream {
+	st := &Stream{
+		id:         id,
+		sess:       s,
+		sendWindow: window{n: InitialWindow},
+		recvWindow: s.window,
+		notify:     make(chan struct{}, 1),
+	}
+	s.streams[id] = st
+	return st
+}
+
+// opened accepts a stream opened by the peer, or refuses it
+func (s *Session) opened(id uint32) {
+	s.mu.Lock()
+	if s.err != nil || s.streams[id] != nil {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.streams) >= s.maxStreams {
+		s.mu.Unlock()
+		s.writeFrame(FrameClose, id, nil)
+		return
+	}
+	st := s.newStream(id)
+	s.mu.Unlock()
+
+	select {
+	case s.accept <- st:
+		st.grantExtra()
+	default:
+		// Opened and closed again faster than accepted
+		s.remove(st)
+		s.writeFrame(FrameClose, id, nil)
+	}
+}
+
+// stream returns the open stream with the given ID
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// remove forgets a closed stream
+func (s *Session) remove(st *Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[st.id] == st {
+		delete(s.streams, st.id)
+	}
+}
+
+// end closes the session and its streams with err
+func (s *Session) end(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	close(s.done)
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
+
+// writeFrame sends a frame
+func (s *Session) writeFrame(typ byte, id uint32, payload []byte) error {
+	frame := make([]byte, frameHeader, frameHeader+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:], id)
+	return s.conn.WriteDatagram(append(frame, payload...))
+}
+
+// writeWindow grants the peer n more bytes on a stream
+func (s *Session) writeWindow(id uint32, n int) error {
+	return s.writeFrame(FrameWindow, id, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
@@ -0,0 +1,247 @@
+package mux
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/internal/wstest"
+)
+
+// connPair connects a client offering subprotocols to a server supporting
+// framing.MuxSubprotocols and returns both ends
+func connPair(t *testing.T, subprotocols []string) (client, server *framing.Conn) {
+	t.Helper()
+
+	clientWS, serverWS := wstest.Pair(t, subprotocols, framing.MuxSubprotocols)
+	client, server = framing.NewConn(clientWS, framing.Shaping{}), framing.NewConn(serverWS, framing.Shaping{})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// sessionPair returns running client and server sessions
+func sessionPair(t *testing.T, serverCfg Config) (client, server *Session) {
+	t.Helper()
+	clientConn, serverConn := connPair(t, framing.MuxSubprotocols)
+	client, server = NewSession(clientConn, Config{}), NewSession(serverConn, serverCfg)
+	go client.Run()
+	go server.Run()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// readTimeout reads a datagram from st, failing the test after a second
+func readTimeout(t *testing.T, st *Stream) ([]byte, error) {
+	t.Helper()
+	type result struct {
+		p   []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		p, err := st.ReadDatagram()
+		ch <- result{p, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.p, r.err
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out reading stream %d", st.ID())
+		return nil, nil
+	}
+}
+
+// acceptTimeout accepts a stream, failing the test after a second
+func acceptTimeout(t *testing.T, s *Session) *Stream {
+	t.Helper()
+	ch := make(chan *Stream, 1)
+	go func() {
+		st, err := s.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		ch <- st
+	}()
+	select {
+	case st := <-ch:
+		return st
+	case <-time.After(time.Second):
+		t.Fatal("Timed out accepting a stream")
+		return nil
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	client, server := connPair(t, framing.MuxSubprotocols)
+	if !client.Multiplexed() || !server.Multiplexed() || !client.Framed() {
+		t.Errorf("Expected mux on both ends, got %q and %q", client.Subprotocol(), server.Subprotocol())
+	}
+
+	// Clients that don't multiplex get v2 from a mux server
+	client, server = connPair(t, framing.Subprotocols)
+	if client.Multiplexed() || server.Multiplexed() || server.Subprotocol() != framing.ProtocolV2 {
+		t.Errorf("Expected v2 on both ends, got %q and %q", client.Subprotocol(), server.Subprotocol())
+	}
+}
+
+func TestStreams(t *testing.T) {
+	client, server := sessionPair(t, Config{})
+
+	var clientStreams, serverStreams []*Stream
+	for i := 0; i < 3; i++ {
+		st, err := client.Open()
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if err := st.WriteDatagram([]byte{byte(i)}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		clientStreams = append(clientStreams, st)
+		serverStreams = append(serverStreams, acceptTimeout(t, server))
+	}
+
+	for i, st := range serverStreams {
+		if st.ID() != clientStreams[i].ID() {
+			t.Errorf("Stream %d accepted as %d", clientStreams[i].ID(), st.ID())
+		}
+		p, err := readTimeout(t, st)
+		if err != nil || len(p) != 1 || p[0] != byte(i) {
+			t.Fatalf("Stream %d read %v, %v", st.ID(), p, err)
+		}
+		// Replies go to the stream they answer
+		if err := st.WriteDatagram([]byte{byte(10 + i)}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	for i, st := range clientStreams {
+		p, err := readTimeout(t, st)
+		if err != nil || len(p) != 1 || p[0] != byte(10+i) {
+			t.Errorf("Stream %d read %v, %v", st.ID(), p, err)
+		}
+	}
+	if n := server.NumStreams(); n != 3 {
+		t.Errorf("Expected 3 streams, got %d", n)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	client, server := sessionPair(t, Config{})
+
+	st, _ := client.Open()
+	peer := acceptTimeout(t, server)
+
+	// The receiver doesn't read: the sender runs out of credit
+	datagram := make([]byte, 1400)
+	sent := 0
+	for ; sent < 2*InitialWindow/len(datagram); sent++ {
+		if err := st.WriteDatagram(datagram); err != nil {
+			if !errors.Is(err, ErrWindowFull) {
+				t.Fatalf("Expected ErrWindowFull, got %v", err)
+			}
+			break
+		}
+	}
+	if want := InitialWindow / len(datagram); sent != want {
+		t.Fatalf("Expected %d datagrams before the window is full, sent %d", want, sent)
+	}
+
+	// Reading grants the credit back
+	for i := 0; i < sent; i++ {
+		if _, err := readTimeout(t, peer); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for st.WriteDatagram(datagram) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Credit wasn't granted after reading")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLargerWindow(t *testing.T) {
+	client, server := sessionPair(t, Config{Window: 2 * InitialWindow})
+
+	st, _ := client.Open()
+	acceptTimeout(t, server)
+
+	// The extra credit arrives right after the stream is accepted
+	datagram := make([]byte, 1000)
+	deadline := time.Now().Add(time.Second)
+	for sent := 0; sent < InitialWindow/len(datagram)+10; {
+		if err := st.WriteDatagram(datagram); err != nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("Window full after %d datagrams", sent)
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		sent++
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	client, server := sessionPair(t, Config{MaxStreams: 1})
+
+	first, _ := client.Open()
+	acceptTimeout(t, server)
+	second, _ := client.Open()
+
+	if _, err := readTimeout(t, second); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Expected the second stream to be refused, got %v", err)
+	}
+	if err := first.WriteDatagram([]byte("ok")); err != nil {
+		t.Errorf("First stream failed: %v", err)
+	}
+}
+
+func TestCloseWithReason(t *testing.T) {
+	client, server := sessionPair(t, Config{})
+
+	st, _ := client.Open()
+	peer := acceptTimeout(t, server)
+	peer.WriteDatagram([]byte("last"))
+	peer.CloseWithReason(framing.CloseQuota, "quota exceeded")
+
+	// Datagrams sent before the close are read first
+	if p, err := readTimeout(t, st); err != nil || string(p) != "last" {
+		t.Fatalf("Expected the last datagram, got %q, %v", p, err)
+	}
+	_, err := readTimeout(t, st)
+	var closeErr *framing.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Reason != framing.CloseQuota {
+		t.Fatalf("Expected a quota close error, got %v", err)
+	}
+	if err := st.WriteDatagram([]byte("x")); err == nil {
+		t.Error("Expected writing to a closed stream to fail")
+	}
+	if n := server.NumStreams(); n != 0 {
+		t.Errorf("Expected no streams on the server, got %d", n)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	client, server := sessionPair(t, Config{})
+
+	st, _ := client.Open()
+	acceptTimeout(t, server)
+	server.Close()
+
+	if _, err := readTimeout(t, st); err == nil {
+		t.Fatal("Expected the stream to fail with the connection")
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Error("Expected Accept to fail on a closed session")
+	}
+	if _, err := client.Open(); err == nil {
+		t.Error("Expected Open to fail on a closed session")
+	}
+}
@@ -0,0 +1,186 @@
+package mux
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// Stream is one UDP flow of a Session. ReadDatagram must not be called
+// concurrently with itself; the other methods may be called from any
+// goroutine.
+type Stream struct {
+	id         uint32
+	sess       *Session
+	sendWindow window // Credit granted by the peer
+	recvWindow int    // Bytes the peer may send ahead of the reader
+
+	mu       sync.Mutex
+	queue    [][]byte // Datagrams received, not yet read
+	queued   int      // Bytes in queue
+	consumed int      // Bytes read since the peer was last granted credit
+	err      error    // Why the stream ended; nil while open
+	notify   chan struct{}
+}
+
+// window is flow control credit in bytes
+type window struct {
+	mu sync.Mutex
+	n  int
+}
+
+// take uses n bytes of credit; it returns false if there isn't enough
+func (w *window) take(n int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n > w.n {
+		return false
+	}
+	w.n -= n
+	return true
+}
+
+// grant adds n bytes of credit
+func (w *window) grant(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.n += n
+}
+
+// ID returns the stream ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// RTT returns the round-trip time of the session's connection (see
+// framing.Conn.RTT)
+func (st *Stream) RTT() time.Duration {
+	return st.sess.conn.RTT()
+}
+
+// ReadDatagram returns the next datagram received. It fails once the stream
+// is closed: with a *framing.CloseError if the peer gave a reason.
+func (st *Stream) ReadDatagram() ([]byte, error) {
+	for {
+		st.mu.Lock()
+		if len(st.queue) > 0 {
+			p := st.queue[0]
+			st.queue[0] = nil
+			st.queue = st.queue[1:]
+			st.queued -= len(p)
+
+			// Grant credit in batches, once a quarter of the window was read
+			st.consumed += len(p)
+			grant := 0
+			if st.consumed >= st.recvWindow/4 {
+				grant, st.consumed = st.consumed, 0
+			}
+			st.mu.Unlock()
+
+			if grant > 0 {
+				st.sess.writeWindow(st.id, grant)
+			}
+			return p, nil
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return nil, err
+		}
+		st.mu.Unlock()
+		<-st.notify
+	}
+}
+
+// WriteDatagram sends a datagram. It fails with ErrWindowFull, dropping the
+// datagram, if the peer's reader is too far behind.
+func (st *Stream) WriteDatagram(p []byte) error {
+	if len(p) > MaxDatagram {
+		return fmt.Errorf("datagram too large: %d bytes", len(p))
+	}
+	st.mu.Lock()
+	err := st.err
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if !st.sendWindow.take(len(p)) {
+		return ErrWindowFull
+	}
+	return st.sess.writeFrame(FrameData, st.id, p)
+}
+
+// Close closes the stream and tells the peer
+func (st *Stream) Close() error {
+	return st.close(nil)
+}
+
+// CloseWithReason closes the stream and tells the peer why (see
+// framing.CloseReason)
+func (st *Stream) CloseWithReason(reason framing.CloseReason, text string) error {
+	return st.close(framing.EncodeClose(reason, text))
+}
+
+// close closes the stream and sends the peer a FrameClose with payload
+func (st *Stream) close(payload []byte) error {
+	if !st.fail(ErrStreamClosed) {
+		return nil
+	}
+	st.sess.remove(st)
+	return st.sess.writeFrame(FrameClose, st.id, payload)
+}
+
+// closedByPeer closes the stream after a FrameClose with payload
+func (st *Stream) closedByPeer(payload []byte) {
+	err := ErrStreamClosed
+	if len(payload) > 0 {
+		if reason, text, decodeErr := framing.DecodeClose(payload); decodeErr == nil {
+			err = &framing.CloseError{Reason: reason, Text: text}
+		}
+	}
+	st.fail(err)
+	st.sess.remove(st)
+}
+
+// fail ends the stream with err; it returns false if it already ended.
+// Datagrams received before remain readable.
+func (st *Stream) fail(err error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil {
+		return false
+	}
+	st.err = err
+	st.wake()
+	return true
+}
+
+// receive queues a datagram for the reader. Datagrams beyond the window are
+// dropped: the peer sent more than it was granted.
+func (st *Stream) receive(p []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil || st.queued+len(p) > st.recvWindow {
+		return
+	}
+	st.queue = append(st.queue, p)
+	st.queued += len(p)
+	st.wake()
+}
+
+// wake wakes the reader; st.mu must be held
+func (st *Stream) wake() {
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+// grantExtra grants the peer the part of the window beyond InitialWindow
+func (st *Stream) grantExtra() {
+	if extra := st.recvWindow - InitialWindow; extra > 0 {
+		st.sess.writeWindow(st.id, extra)
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StatusError is returned when an HTTP proxy refuses a CONNECT request
+type StatusError struct {
+	Addr       string // Address the CONNECT request was for
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("proxy refused connection to %s: %s", e.Addr, e.Status)
+	if e.StatusCode == http.StatusProxyAuthRequired {
+		msg += " (check the proxy credentials)"
+	}
+	return msg
+}
+
+// challengeError is returned by connect for a 407 response; it holds the
+// authentication challenges of the proxy
+type challengeError struct {
+	StatusError
+	challenges []string
+}
+
+// dialHTTP connects to addr with a CONNECT request. Credentials are only
+// sent when the proxy asks for them, with the scheme it asks for: digest
+// where offered, basic otherwise.
+func (d *dialer) dialHTTP(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := d.connect(ctx, addr, "")
+
+	var challenge *challengeError
+	if !errors.As(err, &challenge) {
+		return conn, err
+	}
+	if d.proxy.User == nil {
+		return nil, &challenge.StatusError
+	}
+	auth, err := authorization(challenge.challenges, d.proxy.User, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.connect(ctx, addr, auth)
+}
+
+// connect sends one CONNECT request on a new connection to the proxy
+func (d *dialer) connect(ctx context.Context, addr, auth string) (net.Conn, error) {
+	return d.handshake(ctx, func(conn net.Conn) (net.Conn, error) {
+		req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+		if auth != "" {
+			req += "Proxy-Authorization: " + auth + "\r\n"
+		}
+		if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+			return nil, fmt.Errorf("failed to send request to proxy: %w", err)
+		}
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy response: %w", err)
+		}
+		if resp.StatusCode/100 != 2 {
+			resp.Body.Close()
+			status := StatusError{Addr: addr, StatusCode: resp.StatusCode, Status: resp.Status}
+			if resp.StatusCode == http.StatusProxyAuthRequired && auth == "" {
+				return nil, &challengeError{StatusError: status, challenges: resp.Header.Values("Proxy-Authenticate")}
+			}
+			return nil, &status
+		}
+
+		if br.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
+	})
+}
+
+// authorization answers the challenges of a 407 response
+func authorization(challenges []string, user *url.Userinfo, addr string) (string, error) {
+	basic := false
+	for _, c := range challenges {
+		scheme, params := parseChallenge(c)
+		switch strings.ToLower(scheme) {
+		case "digest":
+			if auth, ok := digestAuthorization(params, user, addr); ok {
+				return auth, nil
+			}
+		case "basic":
+			basic = true
+		}
+	}
+	if !basic {
+		return "", fmt.Errorf("proxy asks for unsupported authentication: %s", strings.Join(challenges, ", "))
+	}
+
+	password, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)), nil
+}
+
+// digestAuthorization answers a digest challenge (RFC 7616) for a CONNECT
+// request. It returns false for algorithms and qop values it doesn't support.
+func digestAuthorization(params map[string]string, user *url.Userinfo, addr string) (string, bool) {
+	var newHash func() hash.Hash
+	algorithm := params["algorithm"]
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", false
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	qop := ""
+	if offered, ok := params["qop"]; ok {
+		for _, q := range strings.Split(offered, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", false
+		}
+	}
+
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	const nc = "00000001"
+
+	password, _ := user.Password()
+	realm, nonce := params["realm"], params["nonce"]
+	ha1 := h(user.Username() + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(http.MethodConnect + ":" + addr)
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	auth := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		user.Username(), realm, nonce, addr, response)
+	if algorithm != "" {
+		auth += ", algorithm=" + algorithm
+	}
+	if qop != "" {
+		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce=%q`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		auth += fmt.Sprintf(`, opaque=%q`, opaque)
+	}
+	return auth, true
+}
+
+// parseChallenge splits a Proxy-Authenticate (or Proxy-Authorization) value
+// such as `Digest realm="proxy", qop="auth", nonce="abc"` into its scheme
+// and parameters
+func parseChallenge(s string) (string, map[string]string) {
+	s = strings.TrimSpace(s)
+	scheme, rest, _ := strings.Cut(s, " ")
+	params := make(map[string]string)
+
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if rest == "" {
+			return scheme, params
+		}
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			return scheme, params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " ")
+
+		if strings.HasPrefix(value, `"`) {
+			// Quoted string with backslash escapes
+			var b strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			params[key] = b.String()
+			rest = value[min(i+1, len(value)):]
+		} else {
+			token, next, _ := strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(token)
+			rest = next
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package proxy dials tunnel servers through upstream proxies: HTTP proxies
+// with the CONNECT method, over plain TCP or TLS and with basic or digest
+// authentication, and SOCKS5 proxies with username/password authentication.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Direct is the proxy URL that disables proxies, including those configured
+// in the environment
+const Direct = "direct"
+
+// Config selects the proxy the client reaches the server through
+type Config struct {
+	// Proxy URL such as http://proxy.corp:3128, https://proxy.corp or
+	// socks5://127.0.0.1:1080, optionally with user:password@. Empty uses
+	// the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables; Direct
+	// connects directly.
+	URL string `yaml:"url" json:"url,omitempty"`
+
+	// Credentials for the proxy; they replace those in the URL
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"password,omitempty"`
+
+	// TLS settings for https proxies (default: verify against the system
+	// roots)
+	TLSConfig *tls.Config `yaml:"-" json:"-"`
+
+	// Local address to connect from, to the server or the proxy, e.g. to use
+	// a given network interface (default: chosen by the system)
+	LocalAddr net.Addr `yaml:"-" json:"-"`
+}
+
+// DialFunc dials addr (host:port). It fits websocket.Dialer.NetDialContext
+// and http.Transport.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Validate checks the proxy URL
+func (c Config) Validate() error {
+	if c.URL == "" || c.URL == Direct {
+		return nil
+	}
+	_, err := c.parse(c.URL)
+	return err
+}
+
+// Dialer returns the function dialing connections to the server at target
+// (a ws, wss, http or https URL): through the proxy if one applies to
+// target, directly otherwise.
+func (c Config) Dialer(target string) (DialFunc, error) {
+	proxyURL, err := c.proxyFor(target)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		d := &net.Dialer{LocalAddr: c.LocalAddr}
+		return d.DialContext, nil
+	}
+	d := &dialer{proxy: proxyURL, tlsConfig: c.TLSConfig}
+	d.forward.LocalAddr = c.LocalAddr
+	return d.DialContext, nil
+}
+
+// proxyFor returns the proxy URL for target, or nil to connect directly
+func (c Config) proxyFor(target string) (*url.URL, error) {
+	switch c.URL {
+	case Direct:
+		return nil, nil
+	case "":
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server URL: %w", err)
+		}
+		// The environment names proxies by the scheme of the request
+		switch u.Scheme {
+		case "ws":
+			u.Scheme = "http"
+		case "wss":
+			u.Scheme = "https"
+		}
+		proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: u})
+		if err != nil || proxyURL == nil {
+			return nil, err
+		}
+		return c.parse(proxyURL.String())
+	}
+	return c.parse(c.URL)
+}
+
+// parse validates a proxy URL and applies the configured credentials
+func (c Config) parse(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	if c.Username != "" {
+		u.User = url.UserPassword(c.Username, c.Password)
+	}
+	return u, nil
+}
+
+// dialer dials through one proxy
+type dialer struct {
+	proxy     *url.URL
+	tlsConfig *tls.Config
+	forward   net.Dialer
+}
+
+// DialContext connects to addr through the proxy
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("proxy: unsupported network %q", network)
+	}
+	if d.proxy.Scheme == "socks5" || d.proxy.Scheme == "socks5h" {
+		return d.dialSOCKS5(ctx, addr)
+	}
+	return d.dialHTTP(ctx, addr)
+}
+
+// handshake connects to the proxy and runs fn on the connection, both
+// bounded by ctx. fn returns the connection to addr.
+func (d *dialer) handshake(ctx context.Context, fn func(conn net.Conn) (net.Conn, error)) (net.Conn, error) {
+	proxyAddr := d.proxyAddr()
+	conn, err := d.forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+
+	if d.proxy.Scheme == "https" {
+		cfg := &tls.Config{}
+		if d.tlsConfig != nil {
+			cfg = d.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = d.proxy.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			stop()
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", proxyAddr, err)
+		}
+		conn = tlsConn
+	}
+
+	tunnel, err := fn(conn)
+	if !stop() {
+		// The context ended during the handshake
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// proxyAddr returns the host:port of the proxy
+func (d *dialer) proxyAddr() string {
+	if d.proxy.Port() != "" {
+		return d.proxy.Host
+	}
+	port := "80"
+	switch d.proxy.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(d.proxy.Hostname(), port)
+}
+
+// bufferedConn is a connection whose first bytes were read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
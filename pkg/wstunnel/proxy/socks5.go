@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksUserPass     = 0x02
+	socksNoAcceptable = 0xff
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+)
+
+// socksReplies names the failure replies of a SOCKS5 proxy
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// dialSOCKS5 connects to addr through a SOCKS5 proxy. Host names are
+// resolved by the proxy, so the client needn't resolve the server's name.
+func (d *dialer) dialSOCKS5(ctx context.Context, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("host name too long for SOCKS5: %s", host)
+	}
+
+	return d.handshake(ctx, func(conn net.Conn) (net.Conn, error) {
+		if err := d.socksAuthenticate(conn); err != nil {
+			return nil, err
+		}
+
+		req := []byte{socksVersion, socksConnect, 0}
+		if ip := net.ParseIP(host); ip == nil {
+			req = append(req, socksDomain, byte(len(host)))
+			req = append(req, host...)
+		} else if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socksIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socksIPv6)
+			req = append(req, ip...)
+		}
+		req = binary.BigEndian.AppendUint16(req, uint16(port))
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("failed to send request to proxy: %w", err)
+		}
+
+		// Reply: version, status, reserved, bound address
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, fmt.Errorf("failed to read proxy response: %w", err)
+		}
+		if reply[1] != 0 {
+			reason, ok := socksReplies[reply[1]]
+			if !ok {
+				reason = fmt.Sprintf("error %d", reply[1])
+			}
+			return nil, fmt.Errorf("proxy refused connection to %s: %s", addr, reason)
+		}
+
+		var skip int
+		switch reply[3] {
+		case socksIPv4:
+			skip = net.IPv4len
+		case socksIPv6:
+			skip = net.IPv6len
+		case socksDomain:
+			var n [1]byte
+			if _, err := io.ReadFull(conn, n[:]); err != nil {
+				return nil, fmt.Errorf("failed to read proxy response: %w", err)
+			}
+			skip = int(n[0])
+		default:
+			return nil, fmt.Errorf("proxy sent unknown address type %d", reply[3])
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+			return nil, fmt.Errorf("failed to read proxy response: %w", err)
+		}
+		return conn, nil
+	})
+}
+
+// socksAuthenticate negotiates the authentication method and authenticates
+// with the credentials of the proxy URL, if the proxy asks for them
+func (d *dialer) socksAuthenticate(conn net.Conn) error {
+	methods := []byte{socksNoAuth}
+	if d.proxy.User != nil {
+		methods = append(methods, socksUserPass)
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("failed to send request to proxy: %w", err)
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("failed to read proxy response: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return errors.New("proxy is not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+		return nil
+	case socksUserPass:
+	case socksNoAcceptable:
+		if d.proxy.User == nil {
+			return errors.New("proxy requires authentication")
+		}
+		return errors.New("proxy accepts none of the offered authentication methods")
+	default:
+		return fmt.Errorf("proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	user := d.proxy.User.Username()
+	password, _ := d.proxy.User.Password()
+	if len(user) > 255 || len(password) > 255 {
+		return errors.New("proxy credentials too long for SOCKS5")
+	}
+	req := []byte{0x01, byte(len(user))}
+	req = append(req, user...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("failed to send credentials to proxy: %w", err)
+	}
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("failed to read proxy response: %w", err)
+	}
+	if reply[1] != 0 {
+		return errors.New("proxy rejected the credentials")
+	}
+	return nil
+}
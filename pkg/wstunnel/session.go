@@ -0,0 +1,511 @@
+package wstunnel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/bond"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+const (
+	// DefaultMaxSessions is the default limit of local UDP sources tunneled
+	// at once
+	DefaultMaxSessions = 64
+
+	// DefaultIdleTimeout is the default time after which the session of a
+	// source is closed when no datagrams passed either way
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// Bounds of the backoff between reconnection attempts
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// session tunnels the datagrams of one local UDP source. Every source gets its
+// own WebSocket connection, or its own stream of the multiplexed connection,
+// and so its own UDP socket on the server, so that replies go back to the
+// source they answer. A lost connection of its own is redialed and the
+// session resumed; with bonding, more connections join it on the server (see
+// Bonding).
+type session struct {
+	client    *Client
+	addr      atomic.Pointer[net.UDPAddr] // nil until a source claims the session
+	created   time.Time                   // Guarded by client.mu
+	stop      chan struct{}               // Closed when the session ends
+	own       *path                       // The session's own connection
+	extra     []*path                     // Connections joined to it; nil without bonding
+	scheduler *bond.Scheduler             // Picks the connection of each datagram with bonding
+
+	mu          sync.Mutex
+	conn        datagramConn  // nil while dialing; replaced on reconnect
+	pending     []byte        // Last datagram of the source while dialing
+	resumeToken string        // Session to resume after reconnecting
+	tokenReady  chan struct{} // Closed while there is a resume token
+	done        bool
+
+	lastActive      atomic.Int64 // Unix nanoseconds of the last datagram either way
+	packetsSent     atomic.Uint64
+	bytesSent       atomic.Uint64
+	packetsReceived atomic.Uint64
+	bytesReceived   atomic.Uint64
+}
+
+// SessionStats describes the session of one local UDP source
+type SessionStats struct {
+	LocalAddr       string        // Address of the source
+	Since           time.Time     // When the source sent its first datagram
+	LastActive      time.Time     // Last datagram either way
+	PacketsSent     uint64        // Datagrams forwarded to the server
+	BytesSent       uint64        // Bytes forwarded to the server
+	PacketsReceived uint64        // Datagrams forwarded to the source
+	BytesReceived   uint64        // Bytes forwarded to the source
+	Latency         time.Duration // Round-trip time of the last answered ping
+	Paths           []PathStats   // Connections of the session with bonding, its own first
+}
+
+// newSession returns a session without a source or connection
+func (c *Client) newSession() *session {
+	s := &session{
+		client:     c,
+		created:    time.Now(),
+		stop:       make(chan struct{}),
+		own:        c.bonding.path(0, c.serverURL),
+		tokenReady: make(chan struct{}),
+	}
+	for i := 1; i < c.bonding.connections(); i++ {
+		s.extra = append(s.extra, c.bonding.path(i, c.serverURL))
+	}
+	if s.extra != nil {
+		s.scheduler = bond.NewScheduler(c.bonding.Policy)
+	}
+	s.touch()
+	return s
+}
+
+// session returns the session of the local source addr, starting one for a
+// new source. It returns nil when the session limit is reached.
+func (c *Client) session(addr *net.UDPAddr) *session {
+	key := addr.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s := c.sessions[key]; s != nil {
+		return s
+	}
+	if !c.running {
+		return nil
+	}
+	if len(c.sessions) >= c.maxSessions {
+		if !c.limitLogged {
+			log.Printf("Session limit (%d) reached, dropping datagrams from new sources such as %s", c.maxSessions, key)
+			c.limitLogged = true
+		}
+		return nil
+	}
+
+	// While the multiplexed connection is reconnecting, new sources are
+	// dropped (WireGuard retransmits)
+	if c.multiplexed && c.mux == nil {
+		return nil
+	}
+
+	// The first source takes the connection dialed by Start
+	s := c.spare
+	c.spare = nil
+	if s != nil {
+		s.created = time.Now()
+		s.addr.Store(addr)
+	} else {
+		s = c.newSession()
+		s.addr.Store(addr)
+		go s.run(nil)
+	}
+	c.sessions[key] = s
+	log.Printf("Tunnel session started for %s", key)
+	return s
+}
+
+// endSession closes s and forgets it
+func (c *Client) endSession(s *session) {
+	s.close("")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.spare == s {
+		c.spare = nil
+	}
+	if addr := s.addr.Load(); addr != nil && c.sessions[addr.String()] == s {
+		delete(c.sessions, addr.String())
+		c.limitLogged = false
+		log.Printf("Tunnel session ended for %s", addr)
+	}
+}
+
+// expireSessions closes the sessions that were idle for the idle timeout,
+// until the client stops
+func (c *Client) expireSessions(stop chan struct{}) {
+	ticker := time.NewTicker(c.idleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		idleSince := time.Now().Add(-c.idleTimeout).UnixNano()
+		var idle []*session
+		c.mu.Lock()
+		for key, s := range c.sessions {
+			if s.lastActive.Load() < idleSince {
+				delete(c.sessions, key)
+				idle = append(idle, s)
+			}
+		}
+		if len(idle) > 0 {
+			c.limitLogged = false
+		}
+		c.mu.Unlock()
+
+		for _, s := range idle {
+			log.Printf("Tunnel session for %s idle, closing", s.addr.Load())
+			s.close("idle")
+		}
+	}
+}
+
+// Sessions returns the statistics of the sessions of the local UDP sources,
+// oldest first
+func (c *Client) Sessions() []SessionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]SessionStats, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		stats = append(stats, s.stats())
+	}
+	slices.SortFunc(stats, func(a, b SessionStats) int {
+		return a.Since.Compare(b.Since)
+	})
+	return stats
+}
+
+// run dials the server unless conn is given, then forwards the datagrams of
+// the server to the source until the session ends. A lost connection of its
+// own is redialed; a stream of the multiplexed connection ends the session
+// with it, as streams can't be resumed.
+func (s *session) run(conn datagramConn) {
+	c := s.client
+	defer c.endSession(s)
+
+	for _, p := range s.extra {
+		go s.runPath(p)
+	}
+
+	delay := time.Duration(0)
+	for {
+		if conn == nil {
+			var err error
+			if conn, err = s.connect(delay); err != nil {
+				c.closed(err)
+				return
+			}
+			if conn == nil {
+				return
+			}
+		}
+
+		s.own.setConn(conn)
+		s.own.up(conn)
+		err := s.forward(conn, s.own)
+		s.own.setConn(nil)
+		if s.closed() {
+			return
+		}
+		if _, ok := conn.(*framing.Conn); !ok {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+
+		var closeErr *framing.CloseError
+		if errors.As(err, &closeErr) {
+			// The server ended the session on purpose; there is nothing to resume
+			s.mu.Lock()
+			s.setResumeToken("")
+			s.mu.Unlock()
+
+			if !closeErr.Reason.Retry() {
+				log.Printf("Tunnel closed by server: %v", closeErr)
+				c.closed(closeErr)
+				return
+			}
+		}
+
+		log.Printf("Tunnel connection lost (%v), reconnecting", err)
+		conn, delay = nil, reconnectMinDelay
+	}
+}
+
+// connect dials the server after delay, with backoff until it accepts the
+// connection. It fails if the server refuses the connection; it returns nil
+// without a connection when the session ends.
+func (s *session) connect(delay time.Duration) (datagramConn, error) {
+	for {
+		select {
+		case <-s.stop:
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		conn, err := s.dial()
+		if err != nil {
+			var refused *refusedError
+			if errors.As(err, &refused) {
+				log.Printf("Tunnel connection refused: %v", err)
+				return nil, err
+			}
+			if s.client.isMultiplexed() {
+				// Streams aren't redialed; the next datagram of the source
+				// starts a new session
+				log.Printf("Failed to connect tunnel session for %s: %v", s.addr.Load(), err)
+				return nil, nil
+			}
+			log.Printf("Tunnel connection failed: %v", err)
+			delay = min(max(2*delay, reconnectMinDelay), reconnectMaxDelay)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			conn.Close()
+			return nil, nil
+		}
+		old, pending := s.conn, s.pending
+		s.conn, s.pending = conn, nil
+		s.mu.Unlock()
+
+		if old != nil {
+			old.Close()
+			log.Printf("Tunnel reconnected to %s", s.client.serverURL)
+		}
+		if pending != nil {
+			s.write(conn, s.own, pending)
+		}
+		return conn, nil
+	}
+}
+
+// dial opens the connection of the session: a stream of the multiplexed
+// connection, or a WebSocket connection of its own
+func (s *session) dial() (datagramConn, error) {
+	c := s.client
+	c.mu.Lock()
+	multiplexed, muxSess := c.multiplexed, c.mux
+	c.mu.Unlock()
+
+	if !multiplexed {
+		conn, err := s.dialWebSocket()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	if muxSess == nil {
+		return nil, errMuxReconnecting
+	}
+	stream, err := muxSess.Open()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// dialWebSocket connects the session's own connection. After a lost
+// connection it asks the server to resume the session, so the server side
+// keeps its WireGuard endpoint.
+func (s *session) dialWebSocket() (*framing.Conn, error) {
+	conn, err := s.client.dialWebSocket(s.own.url, s.own.iface, false)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetControlHandler(s.handleControl)
+
+	s.mu.Lock()
+	token := s.resumeToken
+	s.mu.Unlock()
+	if token != "" && conn.Framed() {
+		if err := conn.SendControl(framing.ControlResume, []byte(token)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// handleControl handles the control messages of the framed subprotocol
+func (s *session) handleControl(typ byte, payload []byte) {
+	switch typ {
+	case framing.ControlResume:
+		s.mu.Lock()
+		s.setResumeToken(string(payload))
+		s.mu.Unlock()
+	case framing.ControlNotice:
+		log.Printf("Server notice: %s", payload)
+		if s.client.onNotice != nil {
+			s.client.onNotice(string(payload))
+		}
+	}
+}
+
+// setResumeToken sets the token of the session, waking the joined
+// connections waiting for one. s.mu must be held.
+func (s *session) setResumeToken(token string) {
+	switch {
+	case token != "" && s.resumeToken == "":
+		close(s.tokenReady)
+	case token == "" && s.resumeToken != "":
+		s.tokenReady = make(chan struct{})
+	}
+	s.resumeToken = token
+}
+
+// send forwards a datagram of the source to the server. While reconnecting,
+// datagrams are dropped (WireGuard retransmits); while dialing a new session,
+// the last one is kept and sent once connected: it is usually a handshake,
+// which would otherwise only be retried after seconds.
+func (s *session) send(data []byte) {
+	s.touch()
+	s.mu.Lock()
+	conn := s.conn
+	if conn == nil {
+		s.pending = append(s.pending[:0], data...)
+	}
+	s.mu.Unlock()
+
+	if conn != nil {
+		// A joined connection that fails is left to its reader; the datagram
+		// goes on the own connection instead
+		if out, p := s.pick(conn); !s.write(out, p, data) && out != conn {
+			s.write(conn, s.own, data)
+		}
+	}
+}
+
+// write sends a datagram to the server on conn, the connection of path p. It
+// returns false if that failed; failures are noticed by the reader, so they
+// aren't reported here.
+func (s *session) write(conn datagramConn, p *path, data []byte) bool {
+	if err := conn.WriteDatagram(data); err != nil {
+		return false
+	}
+	s.packetsSent.Add(1)
+	s.bytesSent.Add(uint64(len(data)))
+	p.packetsSent.Add(1)
+	p.bytesSent.Add(uint64(len(data)))
+	return true
+}
+
+// forward reads datagrams from conn, the connection of path p, and sends them
+// to the source until conn fails
+func (s *session) forward(conn datagramConn, p *path) error {
+	for {
+		data, err := conn.ReadDatagram()
+		if err != nil {
+			return err
+		}
+		s.touch()
+
+		addr := s.addr.Load()
+		if addr == nil {
+			continue // Not claimed by a source yet
+		}
+		if _, err := s.client.udpConn.WriteToUDP(data, addr); err != nil {
+			log.Printf("UDP write error: %v", err)
+			continue
+		}
+		s.packetsReceived.Add(1)
+		s.bytesReceived.Add(uint64(len(data)))
+		p.packetsReceived.Add(1)
+		p.bytesReceived.Add(uint64(len(data)))
+	}
+}
+
+// close ends the session. Unless text is empty, the server is told why, so
+// that it doesn't keep the session for resuming.
+func (s *session) close(text string) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	close(s.stop)
+	conn := s.conn
+	s.mu.Unlock()
+
+	for _, p := range s.extra {
+		p.close()
+	}
+
+	if conn != nil {
+		if framed, ok := conn.(*framing.Conn); ok && text != "" {
+			framed.SendClose(framing.CloseNormal, text, time.Now().Add(time.Second))
+		}
+		conn.Close()
+	}
+}
+
+// closed reports whether the session ended
+func (s *session) closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// touch records activity of the session
+func (s *session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// latency returns the round-trip time of the last answered ping
+func (s *session) latency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return 0
+	}
+	return s.conn.RTT()
+}
+
+// stats returns the statistics of the session
+func (s *session) stats() SessionStats {
+	stats := SessionStats{
+		Since:           s.created,
+		LastActive:      time.Unix(0, s.lastActive.Load()),
+		PacketsSent:     s.packetsSent.Load(),
+		BytesSent:       s.bytesSent.Load(),
+		PacketsReceived: s.packetsReceived.Load(),
+		BytesReceived:   s.bytesReceived.Load(),
+		Latency:         s.latency(),
+	}
+	if addr := s.addr.Load(); addr != nil {
+		stats.LocalAddr = addr.String()
+	}
+	if s.extra != nil {
+		stats.Paths = append(stats.Paths, s.own.stats(s.own.joinedConn()))
+		for _, p := range s.extra {
+			stats.Paths = append(stats.Paths, p.stats(p.joinedConn()))
+		}
+	}
+	return stats
+}
@@ -0,0 +1,205 @@
+package wstunnel
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startDelayedEcho starts a UDP echo server that answers after delay, so that
+// several sources can send before the first answer arrives
+func startDelayedEcho(t *testing.T, delay time.Duration) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, DefaultBufferSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			data := append([]byte(nil), buf[:n]...)
+			time.AfterFunc(delay, func() { conn.WriteToUDP(data, addr) })
+		}
+	}()
+	return conn
+}
+
+// startSessionClient starts a tunnel client with cfg through a tunnel server
+// to target
+func startSessionClient(t *testing.T, target *net.UDPConn, cfg ClientConfig) *Client {
+	t.Helper()
+	tunnelServer := NewServer(ServerConfig{TargetAddr: target.LocalAddr().String()})
+	testServer := httptest.NewServer(http.HandlerFunc(tunnelServer.handleWebSocket))
+	t.Cleanup(testServer.Close)
+
+	cfg.LocalAddr = "127.0.0.1:0"
+	cfg.ServerURL = "ws" + strings.TrimPrefix(testServer.URL, "http")
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("Failed to start tunnel client: %v", err)
+	}
+	t.Cleanup(func() { client.Stop() })
+	return client
+}
+
+// dialSource returns a local UDP source connected to the client
+func dialSource(t *testing.T, client *Client) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, client.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to create UDP source: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readReply reads a datagram from source, failing the test on timeout
+func readReply(t *testing.T, source *net.UDPConn) string {
+	t.Helper()
+	buf := make([]byte, DefaultBufferSize)
+	source.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := source.Read(buf)
+	if err != nil {
+		t.Fatalf("No reply for %s: %v", source.LocalAddr(), err)
+	}
+	return string(buf[:n])
+}
+
+// TestSessionPerSource tests that replies go back to the source they answer
+// when several sources send at once
+func TestSessionPerSource(t *testing.T) {
+	target := startDelayedEcho(t, 50*time.Millisecond)
+	client := startSessionClient(t, target, ClientConfig{})
+
+	sources := []*net.UDPConn{dialSource(t, client), dialSource(t, client), dialSource(t, client)}
+	for round := 0; round < 2; round++ {
+		for i, source := range sources {
+			if _, err := source.Write([]byte{'a' + byte(i)}); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+		}
+		for i, source := range sources {
+			if got, want := readReply(t, source), string(rune('a'+i)); got != want {
+				t.Errorf("Source %d got %q, want %q", i, got, want)
+			}
+		}
+	}
+
+	stats := client.Sessions()
+	if len(stats) != len(sources) {
+		t.Fatalf("Expected %d sessions, got %d", len(sources), len(stats))
+	}
+	for _, s := range stats {
+		if s.PacketsSent != 2 || s.PacketsReceived != 2 || s.BytesSent != 2 || s.BytesReceived != 2 {
+			t.Errorf("Unexpected stats for %s: %+v", s.LocalAddr, s)
+		}
+	}
+}
+
+// TestSessionLimit tests that datagrams of new sources are dropped while
+// MaxSessions are open
+func TestSessionLimit(t *testing.T) {
+	target := startDelayedEcho(t, 0)
+	client := startSessionClient(t, target, ClientConfig{MaxSessions: 1})
+
+	first, second := dialSource(t, client), dialSource(t, client)
+	first.Write([]byte("first"))
+	if got := readReply(t, first); got != "first" {
+		t.Fatalf("Expected %q, got %q", "first", got)
+	}
+
+	second.Write([]byte("second"))
+	second.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := second.Read(make([]byte, DefaultBufferSize)); err == nil {
+		t.Fatalf("Expected the datagram to be dropped, got a reply of %d bytes", n)
+	}
+	if n := len(client.Sessions()); n != 1 {
+		t.Errorf("Expected 1 session, got %d", n)
+	}
+}
+
+// TestSessionIdleTimeout tests that idle sessions are closed and that a
+// source that sends again gets a new session
+func TestSessionIdleTimeout(t *testing.T) {
+	target := startDelayedEcho(t, 0)
+	client := startSessionClient(t, target, ClientConfig{IdleTimeout: 100 * time.Millisecond})
+
+	source := dialSource(t, client)
+	source.Write([]byte("hello"))
+	readReply(t, source)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(client.Sessions()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Idle session wasn't closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The first datagram of the new session is sent once it is connected
+	source.Write([]byte("again"))
+	if got := readReply(t, source); got != "again" {
+		t.Fatalf("Expected %q, got %q", "again", got)
+	}
+}
+
+// TestMultiplexedSessions tests that with Multiplex, the sessions of all
+// sources share one WebSocket connection and still get their own replies
+func TestMultiplexedSessions(t *testing.T) {
+	target := startDelayedEcho(t, 50*time.Millisecond)
+	tunnelServer := NewServer(ServerConfig{TargetAddr: target.LocalAddr().String()})
+	var connections atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		tunnelServer.handleWebSocket(w, r)
+	}))
+	t.Cleanup(testServer.Close)
+
+	client := NewClient(ClientConfig{
+		LocalAddr: "127.0.0.1:0",
+		ServerURL: "ws" + strings.TrimPrefix(testServer.URL, "http"),
+		Multiplex: true,
+	})
+	if err := client.Start(); err != nil {
+		t.Fatalf("Failed to start tunnel client: %v", err)
+	}
+	t.Cleanup(func() { client.Stop() })
+
+	sources := []*net.UDPConn{dialSource(t, client), dialSource(t, client), dialSource(t, client)}
+	for i, source := range sources {
+		if _, err := source.Write([]byte{'a' + byte(i)}); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+	for i, source := range sources {
+		if got, want := readReply(t, source), string(rune('a'+i)); got != want {
+			t.Errorf("Source %d got %q, want %q", i, got, want)
+		}
+	}
+
+	if n := connections.Load(); n != 1 {
+		t.Errorf("Expected 1 WebSocket connection, got %d", n)
+	}
+	client.mu.Lock()
+	sess := client.mux
+	client.mu.Unlock()
+	if sess == nil {
+		t.Fatal("Expected a multiplexed connection")
+	}
+	if n := sess.NumStreams(); n != len(sources) {
+		t.Errorf("Expected %d streams, got %d", len(sources), n)
+	}
+	if n := len(client.Sessions()); n != len(sources) {
+		t.Errorf("Expected %d sessions, got %d", len(sources), n)
+	}
+}
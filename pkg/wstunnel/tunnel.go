@@ -5,14 +5,21 @@ package wstunnel
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/mux"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/proxy"
 )
 
 const (
@@ -22,8 +29,8 @@ const (
 	// DefaultTimeout is the default connection timeout
 	DefaultTimeout = 30 * time.Second
 
-	// PingInterval is the WebSocket ping interval
-	PingInterval = 30 * time.Second
+	// PingInterval is the default keepalive ping interval
+	PingInterval = framing.DefaultPingInterval
 )
 
 // Server handles WebSocket connections and forwards data to UDP
@@ -32,6 +39,9 @@ type Server struct {
 	targetAddr    string       // UDP target address (e.g., "127.0.0.1:51820")
 	tlsCert       string       // TLS certificate file path
 	tlsKey        string       // TLS key file path
+	shaping       framing.Shaping
+	keepalive     framing.Keepalive
+	maxStreams    int
 	upgrader      websocket.Upgrader
 	server        *http.Server
 	mu            sync.Mutex
@@ -44,6 +54,17 @@ type ServerConfig struct {
 	TargetAddr string // UDP target address (WireGuard)
 	TLSCert    string // TLS certificate file (optional, for WSS)
 	TLSKey     string // TLS key file (optional, for WSS)
+
+	// Shaping of the datagrams sent to clients that negotiate the framed
+	// subprotocol (see package framing)
+	Shaping framing.Shaping
+
+	// Keepalive pings (default: every PingInterval)
+	Keepalive framing.Keepalive
+
+	// Streams a multiplexed connection may have open at once (default:
+	// mux.DefaultMaxStreams)
+	MaxStreams int
 }
 
 // NewServer creates a new WebSocket tunnel server
@@ -53,10 +74,14 @@ func NewServer(cfg ServerConfig) *Server {
 		targetAddr: cfg.TargetAddr,
 		tlsCert:    cfg.TLSCert,
 		tlsKey:     cfg.TLSKey,
+		shaping:    cfg.Shaping,
+		keepalive:  withPingInterval(cfg.Keepalive),
+		maxStreams: cfg.MaxStreams,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  DefaultBufferSize,
 			WriteBufferSize: DefaultBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
+			Subprotocols:    framing.MuxSubprotocols,
 		},
 	}
 }
@@ -111,30 +136,92 @@ func (s *Server) Stop() error {
 
 // handleWebSocket handles incoming WebSocket connections
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
+	conn := framing.NewConn(ws, s.shaping)
 	defer conn.Close()
+	conn.StartKeepalive(s.keepalive)
 
-	// Connect to UDP target
-	udpAddr, err := net.ResolveUDPAddr("udp", s.targetAddr)
-	if err != nil {
-		log.Printf("Failed to resolve UDP address %s: %v", s.targetAddr, err)
+	if conn.Multiplexed() {
+		s.serveMux(conn, r.RemoteAddr)
 		return
 	}
 
-	udpConn, err := net.DialUDP("udp", nil, udpAddr)
+	udpConn, err := s.dialTarget()
 	if err != nil {
-		log.Printf("Failed to connect to UDP %s: %v", s.targetAddr, err)
+		log.Printf("UDP target error: %v", err)
 		return
 	}
-	defer udpConn.Close()
 
 	log.Printf("New tunnel connection from %s", r.RemoteAddr)
+	s.forward(conn, udpConn)
+	log.Printf("Tunnel connection closed from %s", r.RemoteAddr)
+}
+
+// serveMux serves the streams of a multiplexed connection, each with its own
+// UDP socket to the target, until the connection fails
+func (s *Server) serveMux(conn *framing.Conn, remoteAddr string) {
+	sess := mux.NewSession(conn, mux.Config{MaxStreams: s.maxStreams})
+	log.Printf("New multiplexed tunnel connection from %s", remoteAddr)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			stream, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			udpConn, err := s.dialTarget()
+			if err != nil {
+				log.Printf("UDP target error: %v", err)
+				stream.Close()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.forward(stream, udpConn)
+			}()
+		}
+	}()
+
+	err := sess.Run()
+	if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		log.Printf("WebSocket read error: %v", err)
+	}
+	wg.Wait()
+	log.Printf("Multiplexed tunnel connection closed from %s", remoteAddr)
+}
+
+// dialTarget opens a UDP socket to the target
+func (s *Server) dialTarget() (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", s.targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve UDP address %s: %w", s.targetAddr, err)
+	}
+	udpConn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to UDP %s: %w", s.targetAddr, err)
+	}
+	return udpConn, nil
+}
+
+// datagramConn is a connection or stream carrying datagrams
+type datagramConn interface {
+	ReadDatagram() ([]byte, error)
+	WriteDatagram(p []byte) error
+	RTT() time.Duration
+	Close() error
+}
 
-	// Bidirectional forwarding
+// forward forwards datagrams both ways between dc and udp until either
+// fails, then closes both
+func (s *Server) forward(dc datagramConn, udp *net.UDPConn) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -145,22 +232,25 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		s.wsToUDP(ctx, conn, udpConn)
+		s.wsToUDP(ctx, dc, udp)
 	}()
 
 	// UDP -> WebSocket
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		s.udpToWS(ctx, udpConn, conn)
+		s.udpToWS(ctx, udp, dc)
 	}()
 
+	// Unblock the direction still reading
+	<-ctx.Done()
+	dc.Close()
+	udp.Close()
 	wg.Wait()
-	log.Printf("Tunnel connection closed from %s", r.RemoteAddr)
 }
 
 // wsToUDP forwards data from WebSocket to UDP
-func (s *Server) wsToUDP(ctx context.Context, ws *websocket.Conn, udp *net.UDPConn) {
+func (s *Server) wsToUDP(ctx context.Context, ws datagramConn, udp *net.UDPConn) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -168,7 +258,7 @@ func (s *Server) wsToUDP(ctx context.Context, ws *websocket.Conn, udp *net.UDPCo
 		default:
 		}
 
-		_, data, err := ws.ReadMessage()
+		data, err := ws.ReadDatagram()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
@@ -185,7 +275,7 @@ func (s *Server) wsToUDP(ctx context.Context, ws *websocket.Conn, udp *net.UDPCo
 }
 
 // udpToWS forwards data from UDP to WebSocket
-func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws *websocket.Conn) {
+func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws datagramConn) {
 	buf := make([]byte, DefaultBufferSize)
 	for {
 		select {
@@ -200,13 +290,20 @@ func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws *websocket.Co
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			log.Printf("UDP read error: %v", err)
+			if ctx.Err() == nil {
+				log.Printf("UDP read error: %v", err)
+			}
 			return
 		}
 
-		err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+		err = ws.WriteDatagram(buf[:n])
+		if errors.Is(err, mux.ErrWindowFull) {
+			continue // Dropped, like on a congested path
+		}
 		if err != nil {
-			log.Printf("WebSocket write error: %v", err)
+			if ctx.Err() == nil {
+				log.Printf("WebSocket write error: %v", err)
+			}
 			return
 		}
 	}
@@ -214,31 +311,132 @@ func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws *websocket.Co
 
 // Client handles UDP listening and forwards to WebSocket
 type Client struct {
-	localAddr   string          // Local UDP listen address (e.g., "127.0.0.1:51820")
-	serverURL   string          // WebSocket server URL (e.g., "wss://server:443")
-	conn        *websocket.Conn
+	localAddr   string // Local UDP listen address (e.g., "127.0.0.1:51820")
+	serverURL   string // WebSocket server URL (e.g., "wss://server:443")
 	udpConn     *net.UDPConn
 	mu          sync.Mutex
 	running     bool
 	stopChan    chan struct{}
-	insecure    bool            // Skip TLS verification
+	insecure    bool                          // Skip TLS verification
+	tlsConfig   *tls.Config                   // Verifies the server; overrides insecure
+	tlsFor      func(host string) *tls.Config // TLS settings for bonding URLs on other hosts
+	token       string                        // Bearer token sent on the WebSocket upgrade
+	shaping     framing.Shaping
+	keepalive   framing.Keepalive
+	proxy       proxy.Config
+	fronting    Fronting
+	bonding     Bonding
+	maxSessions int
+	idleTimeout time.Duration
+	sessions    map[string]*session // Keyed by local source address
+	spare       *session            // Dialed by Start, for the first source
+	limitLogged bool                // The session limit was reported
+	multiplex   bool                // Offer the multiplexed subprotocol
+	multiplexed bool                // The server multiplexes the sessions
+	mux         *mux.Session        // nil while reconnecting
+	ended       bool                // onClose was called
+	onNotice    func(text string)
+	onClose     func(err error)
+	actualPort  int // Actual port after binding (useful when using port 0)
 }
 
 // ClientConfig holds client configuration
 type ClientConfig struct {
-	LocalAddr  string // Local UDP listen address
-	ServerURL  string // WebSocket server URL
-	Insecure   bool   // Skip TLS verification (for self-signed certs)
+	LocalAddr string // Local UDP listen address
+	ServerURL string // WebSocket server URL
+	Insecure  bool   // Skip TLS verification (for self-signed certs)
+	Token     string // Login token; lets the server identify the connection and apply limits
+
+	// TLS settings for wss URLs, e.g. to verify against a custom CA
+	// (default: verify against the system roots)
+	TLSConfig *tls.Config
+
+	// TLSConfigFor returns the TLS settings for bonding URLs whose host
+	// differs from the server URL's (default: TLSConfig)
+	TLSConfigFor func(host string) *tls.Config
+
+	// Shaping of the datagrams sent to the server when the framed
+	// subprotocol is negotiated; servers that don't support it get one
+	// message per datagram.
+	Shaping framing.Shaping
+
+	// Keepalive pings (default: every PingInterval)
+	Keepalive framing.Keepalive
+
+	// Upstream proxy (default: from the HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY environment variables)
+	Proxy proxy.Config
+
+	// Connect address, SNI, Host and extra headers, e.g. to reach the
+	// server through a CDN
+	Fronting Fronting
+
+	// Every local UDP source gets its own session (WebSocket connection).
+	// Datagrams from new sources are dropped while MaxSessions are open
+	// (default: DefaultMaxSessions); sessions are closed when no datagrams
+	// passed either way for IdleTimeout (default: DefaultIdleTimeout).
+	MaxSessions int
+	IdleTimeout time.Duration
+
+	// Carry the sessions as streams of one WebSocket connection, if the
+	// server supports it (see package mux)
+	Multiplex bool
+
+	// Spread the datagrams of each session over several connections;
+	// ignored with Multiplex
+	Bonding Bonding
+
+	// OnNotice is called with notices from the server (optional; must not
+	// block)
+	OnNotice func(text string)
+
+	// OnClose is called once when the tunnel ends for good: the server
+	// closed a session for a reason that rules out reconnecting (e.g. quota
+	// exceeded) or refused to connect it. Lost connections are reconnected.
+	OnClose func(err error)
+}
+
+// refusedError is returned by dialWebSocket when the server refused the
+// upgrade
+type refusedError struct {
+	msg string
+}
+
+func (e *refusedError) Error() string {
+	return e.msg
 }
 
 // NewClient creates a new WebSocket tunnel client
 func NewClient(cfg ClientConfig) *Client {
-	return &Client{
+	c := &Client{
 		localAddr: cfg.LocalAddr,
 		serverURL: cfg.ServerURL,
 		insecure:  cfg.Insecure,
+		tlsConfig: cfg.TLSConfig,
+		tlsFor:    cfg.TLSConfigFor,
+		token:     cfg.Token,
+		shaping:   cfg.Shaping,
+		keepalive: withPingInterval(cfg.Keepalive),
+		proxy:     cfg.Proxy,
+		fronting:  cfg.Fronting,
+		onNotice:  cfg.OnNotice,
+		onClose:   cfg.OnClose,
 		stopChan:  make(chan struct{}),
+
+		maxSessions: cfg.MaxSessions,
+		idleTimeout: cfg.IdleTimeout,
+		multiplex:   cfg.Multiplex,
+	}
+	if !c.multiplex {
+		c.bonding = cfg.Bonding // Streams can't be bonded
+	}
+	if c.maxSessions <= 0 {
+		c.maxSessions = DefaultMaxSessions
 	}
+	if c.idleTimeout <= 0 {
+		c.idleTimeout = DefaultIdleTimeout
+	}
+	return c
 }
 
 // Start starts the WebSocket tunnel client
@@ -255,42 +453,146 @@ func (c *Client) Start() error {
 	// Listen on local UDP
 	udpAddr, err := net.ResolveUDPAddr("udp", c.localAddr)
 	if err != nil {
+		c.stopped()
 		return fmt.Errorf("failed to resolve local UDP address: %w", err)
 	}
 
 	c.udpConn, err = net.ListenUDP("udp", udpAddr)
 	if err != nil {
+		c.stopped()
 		return fmt.Errorf("failed to listen on UDP %s: %w", c.localAddr, err)
 	}
 
-	// Connect to WebSocket server
-	dialer := websocket.Dialer{
-		HandshakeTimeout: DefaultTimeout,
-	}
-
-	if c.insecure {
-		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
+	// Store the actual port (useful when binding to port 0)
+	c.actualPort = c.udpConn.LocalAddr().(*net.UDPAddr).Port
 
-	c.conn, _, err = dialer.Dial(c.serverURL, nil)
+	// Connect to WebSocket server. The connection is kept for the first
+	// local source, or for the streams of all sources if the server
+	// multiplexes, so that failing to connect fails Start.
+	spare := c.newSession()
+	conn, err := c.dialWebSocket(spare.own.url, spare.own.iface, c.multiplex)
 	if err != nil {
 		c.udpConn.Close()
+		c.stopped()
 		return fmt.Errorf("failed to connect to WebSocket server %s: %w", c.serverURL, err)
 	}
+	var muxSess *mux.Session
+	if conn.Multiplexed() {
+		muxSess = mux.NewSession(conn, mux.Config{})
+		spare = nil
+	} else {
+		conn.SetControlHandler(spare.handleControl)
+		spare.conn = conn
+	}
 
-	log.Printf("Tunnel client started: UDP %s <-> WS %s", c.localAddr, c.serverURL)
+	c.mu.Lock()
+	c.sessions = make(map[string]*session)
+	c.spare = spare
+	c.multiplexed = muxSess != nil
+	c.mux = muxSess
+	c.limitLogged = false
+	c.ended = false
+	stop := c.stopChan
+	c.mu.Unlock()
 
-	// Track client addresses for responses
-	clientMap := make(map[string]*net.UDPAddr)
-	var clientMu sync.Mutex
+	log.Printf("Tunnel client started: UDP %s <-> WS %s", c.localAddr, c.serverURL)
 
 	// Start forwarding goroutines
-	go c.udpToWS(clientMap, &clientMu)
-	go c.wsToUDP(clientMap, &clientMu)
+	if muxSess != nil {
+		go c.runMux(muxSess, stop)
+	} else {
+		go spare.run(conn)
+	}
+	go c.udpToWS()
+	go c.expireSessions(stop)
 
 	return nil
 }
 
+// stopped marks the client stopped after Start failed
+func (c *Client) stopped() {
+	c.mu.Lock()
+	c.running = false
+	c.mu.Unlock()
+}
+
+// dialWebSocket connects to the tunnel at serverURL, from the network
+// interface or local address iface unless it is empty, offering the
+// multiplexed subprotocol if multiplex is set
+func (c *Client) dialWebSocket(serverURL, iface string, multiplex bool) (*framing.Conn, error) {
+	proxyCfg := c.proxy
+	if iface != "" {
+		local, err := localAddr(iface)
+		if err != nil {
+			return nil, err
+		}
+		proxyCfg.LocalAddr = local
+	}
+	dial, err := proxyCfg.Dialer(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: DefaultTimeout,
+		NetDialContext:   c.fronting.Dialer(dial),
+		Subprotocols:     framing.Subprotocols,
+	}
+	if multiplex {
+		dialer.Subprotocols = framing.MuxSubprotocols
+	}
+
+	if c.tlsConfig != nil {
+		dialer.TLSClientConfig = c.tlsConfig
+		if host := urlHost(serverURL); c.tlsFor != nil && host != urlHost(c.serverURL) {
+			dialer.TLSClientConfig = c.tlsFor(host)
+		}
+	} else if c.insecure {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if c.fronting.SNI != "" {
+		if dialer.TLSClientConfig == nil {
+			dialer.TLSClientConfig = &tls.Config{}
+		}
+		dialer.TLSClientConfig = dialer.TLSClientConfig.Clone()
+		dialer.TLSClientConfig.ServerName = c.fronting.SNI
+	}
+
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+	c.fronting.SetHeaders(header)
+
+	ws, resp, err := dialer.Dial(serverURL, header)
+	if err != nil {
+		// The server explains refusals (e.g. quota exceeded) in the body
+		if resp != nil && resp.StatusCode >= 400 {
+			msg := resp.Status
+			if body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)); len(body) > 0 {
+				msg = strings.TrimSpace(string(body))
+			}
+			if resp.StatusCode < 500 {
+				return nil, &refusedError{msg: msg}
+			}
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+
+	conn := framing.NewConn(ws, c.shaping)
+	conn.StartKeepalive(c.keepalive)
+	return conn, nil
+}
+
+// urlHost returns the host name of a URL
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 // Stop stops the client
 func (c *Client) Stop() error {
 	c.mu.Lock()
@@ -303,8 +605,15 @@ func (c *Client) Stop() error {
 	c.running = false
 	close(c.stopChan)
 
-	if c.conn != nil {
-		c.conn.Close()
+	for _, s := range c.sessions {
+		s.close("client disconnected")
+	}
+	if c.spare != nil {
+		c.spare.close("client disconnected")
+	}
+	if c.mux != nil {
+		c.mux.Close()
+		c.mux = nil
 	}
 	if c.udpConn != nil {
 		c.udpConn.Close()
@@ -321,8 +630,39 @@ func (c *Client) IsRunning() bool {
 	return c.running
 }
 
-// udpToWS forwards data from local UDP to WebSocket
-func (c *Client) udpToWS(clientMap map[string]*net.UDPAddr, mu *sync.Mutex) {
+// LocalPort returns the actual local UDP port the client is listening on.
+// This is useful when the client was configured with port 0 (dynamic port).
+func (c *Client) LocalPort() int {
+	return c.actualPort
+}
+
+// Latency returns the round-trip time of the last keepalive ping of the most
+// recently active session, or 0 if none was answered yet
+func (c *Client) Latency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	latest := c.spare
+	for _, s := range c.sessions {
+		if latest == nil || s.lastActive.Load() > latest.lastActive.Load() {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return 0
+	}
+	return latest.latency()
+}
+
+// withPingInterval applies PingInterval to a keepalive without an interval
+func withPingInterval(k framing.Keepalive) framing.Keepalive {
+	if k.Interval == 0 {
+		k.Interval = PingInterval
+	}
+	return k
+}
+
+// udpToWS forwards data from local UDP to the session of each source
+func (c *Client) udpToWS() {
 	buf := make([]byte, DefaultBufferSize)
 	for {
 		select {
@@ -346,51 +686,19 @@ func (c *Client) udpToWS(clientMap map[string]*net.UDPAddr, mu *sync.Mutex) {
 			}
 		}
 
-		// Store client address for response routing
-		mu.Lock()
-		clientMap["last"] = addr
-		mu.Unlock()
-
-		err = c.conn.WriteMessage(websocket.BinaryMessage, buf[:n])
-		if err != nil {
-			log.Printf("WebSocket write error: %v", err)
-			return
+		if sess := c.session(addr); sess != nil {
+			sess.send(buf[:n])
 		}
 	}
 }
 
-// wsToUDP forwards data from WebSocket to local UDP clients
-func (c *Client) wsToUDP(clientMap map[string]*net.UDPAddr, mu *sync.Mutex) {
-	for {
-		select {
-		case <-c.stopChan:
-			return
-		default:
-		}
-
-		_, data, err := c.conn.ReadMessage()
-		if err != nil {
-			select {
-			case <-c.stopChan:
-				return
-			default:
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket read error: %v", err)
-				}
-				return
-			}
-		}
-
-		// Send response to last known client
-		mu.Lock()
-		clientAddr := clientMap["last"]
-		mu.Unlock()
-
-		if clientAddr != nil {
-			_, err = c.udpConn.WriteToUDP(data, clientAddr)
-			if err != nil {
-				log.Printf("UDP write error: %v", err)
-			}
-		}
+// closed reports the end of the tunnel, once, unless the client was stopped
+func (c *Client) closed(err error) {
+	c.mu.Lock()
+	report := c.running && !c.ended && c.onClose != nil
+	c.ended = true
+	c.mu.Unlock()
+	if report {
+		c.onClose(err)
 	}
 }
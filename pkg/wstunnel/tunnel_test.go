@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
 )
 
 // TestServerCreation tests server creation
@@ -237,6 +238,64 @@ func TestEndToEndTunnel(t *testing.T) {
 	t.Logf("End-to-end tunnel test passed! Sent and received: %q", testData)
 }
 
+// TestEndToEndTunnelFramed tests the tunnel with shaping on both ends, so the
+// framed subprotocol is negotiated
+func TestEndToEndTunnelFramed(t *testing.T) {
+	echoConn, stopEcho := createUDPEchoServer(t, "127.0.0.1:0")
+	defer stopEcho()
+
+	shaping := framing.Shaping{PaddingMin: 16, PaddingMax: 128, CoalesceDelay: 5 * time.Millisecond}
+	tunnelServer := NewServer(ServerConfig{
+		TargetAddr: echoConn.LocalAddr().String(),
+		Shaping:    shaping,
+	})
+	testServer := httptest.NewServer(http.HandlerFunc(tunnelServer.handleWebSocket))
+	defer testServer.Close()
+
+	tunnelClient := NewClient(ClientConfig{
+		LocalAddr: "127.0.0.1:0",
+		ServerURL: "ws" + strings.TrimPrefix(testServer.URL, "http"),
+		Shaping:   shaping,
+	})
+	if err := tunnelClient.Start(); err != nil {
+		t.Fatalf("Failed to start tunnel client: %v", err)
+	}
+	defer tunnelClient.Stop()
+
+	tunnelClient.mu.Lock()
+	conn := tunnelClient.spare.conn.(*framing.Conn)
+	tunnelClient.mu.Unlock()
+	if !conn.Framed() {
+		t.Fatalf("Expected the framed subprotocol, got %q", conn.Subprotocol())
+	}
+
+	testConn, err := net.DialUDP("udp", nil, tunnelClient.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to create test UDP connection: %v", err)
+	}
+	defer testConn.Close()
+
+	buf := make([]byte, DefaultBufferSize)
+	for _, size := range []int{148, 92, 32, 1400} {
+		testData := make([]byte, size)
+		for i := range testData {
+			testData[i] = byte(i)
+		}
+		if _, err := testConn.Write(testData); err != nil {
+			t.Fatalf("Failed to send test data: %v", err)
+		}
+
+		testConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := testConn.Read(buf)
+		if err != nil {
+			t.Fatalf("Failed to receive echo of %d bytes: %v", size, err)
+		}
+		if string(buf[:n]) != string(testData) {
+			t.Errorf("Echo of %d bytes corrupted (got %d bytes)", size, n)
+		}
+	}
+}
+
 // TestServerStopStart tests server stop and restart
 func TestServerStopStart(t *testing.T) {
 	cfg := ServerConfig{
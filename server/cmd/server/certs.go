@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"wire-socket-server/internal/certs"
+	"wire-socket-server/internal/serverconfig"
+)
+
+// listenerCerts holds the certificate sources of the API and tunnel listeners
+type listenerCerts struct {
+	acme      *certs.ACME   // nil unless a listener uses ACME
+	challenge *http.Server  // HTTP-01 challenge listener, nil if not used
+	files     []*certs.File // Reloaded when they change and on SIGHUP
+	stopWatch context.CancelFunc
+
+	api    *tls.Config // nil if the API is served over plain HTTP
+	tunnel *tls.Config // nil if the tunnel is served over plain WS
+}
+
+// setupCerts loads the certificate files and starts ACME and the file
+// watchers for the listeners that use TLS
+func setupCerts(config *serverconfig.Config) (*listenerCerts, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := &listenerCerts{stopWatch: cancel}
+
+	apiTLS := config.Server.TLS
+	tunnelTLS := config.Tunnel.Enabled && config.Tunnel.ListenAddr != "" &&
+		(config.Tunnel.TLSACME || config.Tunnel.TLSCert != "")
+
+	if (apiTLS != nil && apiTLS.ACME) || (tunnelTLS && config.Tunnel.TLSACME) {
+		acme, err := certs.NewACME(config.ACME)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		lc.acme = acme
+
+		if lc.challenge = acme.HTTPServer(); lc.challenge != nil {
+			ln, err := net.Listen("tcp", lc.challenge.Addr)
+			if err != nil {
+				cancel()
+				return nil, fmt.Errorf("failed to listen for ACME HTTP-01 challenges: %w", err)
+			}
+			go func() {
+				if err := lc.challenge.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+					slog.Error("ACME challenge server failed", "error", err)
+				}
+			}()
+		}
+		slog.Info("ACME certificates enabled", "domains", config.ACME.Domains, "http_challenge_addr", config.ACME.HTTPAddr)
+	}
+
+	loadFile := func(certFile, keyFile string) (*tls.Config, error) {
+		file, err := certs.LoadFile(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		lc.files = append(lc.files, file)
+		go file.Watch(ctx, certs.DefaultWatchInterval)
+		return file.TLSConfig(), nil
+	}
+
+	var err error
+	switch {
+	case apiTLS == nil:
+	case apiTLS.ACME:
+		lc.api = lc.acme.TLSConfig("h2", "http/1.1")
+	default:
+		if lc.api, err = loadFile(apiTLS.CertFile, apiTLS.KeyFile); err != nil {
+			lc.stop(context.Background())
+			return nil, err
+		}
+	}
+
+	switch {
+	case !tunnelTLS:
+	case config.Tunnel.TLSACME:
+		// WebSocket upgrades need HTTP/1.1
+		lc.tunnel = lc.acme.TLSConfig("http/1.1")
+	default:
+		if lc.tunnel, err = loadFile(config.Tunnel.TLSCert, config.Tunnel.TLSKey); err != nil {
+			lc.stop(context.Background())
+			return nil, err
+		}
+	}
+
+	return lc, nil
+}
+
+// reload re-reads the certificate files that changed. ACME certificates are
+// renewed in the background and need no reload.
+func (lc *listenerCerts) reload() {
+	for _, file := range lc.files {
+		if _, err := file.Reload(); err != nil {
+			slog.Warn("failed to reload TLS certificate, keeping the current one", "error", err)
+		}
+	}
+}
+
+// stop stops the file watchers and the ACME challenge listener
+func (lc *listenerCerts) stop(ctx context.Context) {
+	lc.stopWatch()
+	if lc.challenge != nil {
+		if err := lc.challenge.Shutdown(ctx); err != nil {
+			lc.challenge.Close()
+		}
+	}
+}
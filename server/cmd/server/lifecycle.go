@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+	"wire-socket-server/internal/api"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/nat"
+	"wire-socket-server/internal/serverconfig"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/usage"
+	"wire-socket-server/internal/webhook"
+	"wire-socket-server/internal/wireguard"
+)
+
+// defaultShutdownTimeout bounds how long shutdown waits for requests and
+// tunnel connections to finish
+const defaultShutdownTimeout = 15 * time.Second
+
+// process holds the components of a running server for shutdown and reload
+type process struct {
+	configPath string
+	dev        bool // -dev flag, kept for validating reloads
+	config     *serverconfig.Config
+	db         *database.DB
+
+	httpServer        *http.Server
+	certs             *listenerCerts
+	tunnelServer      *tunnel.Server // nil if the built-in tunnel is disabled
+	apiRouter         *api.Router
+	adminHandler      *api.AdminHandler
+	natManager        *nat.Manager
+	natConfig         nat.Config
+	usageCollector    *usage.Collector
+	peerWatcher       *events.PeerWatcher
+	webhookDispatcher *webhook.Dispatcher
+	wgManager         *wireguard.Manager
+}
+
+// run serves HTTP until SIGINT or SIGTERM, reloading the configuration on
+// SIGHUP, then shuts down. It returns an error if the HTTP server failed.
+func (p *process) run() error {
+	serveErr := make(chan error, 1)
+	go func() {
+		var err error
+		if p.httpServer.TLSConfig != nil {
+			err = p.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = p.httpServer.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	slog.Info("server is ready")
+
+	var err error
+	for err == nil {
+		select {
+		case err = <-serveErr:
+			slog.Error("HTTP server failed", "error", err)
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				p.reload()
+				continue
+			}
+			slog.Info("shutting down", "signal", sig.String())
+			p.shutdown()
+			return nil
+		}
+	}
+
+	p.shutdown()
+	return err
+}
+
+// shutdown stops accepting connections, drains HTTP requests and tunnel
+// connections, stops the background workers, removes NAT rules and routes
+// and finally closes the WireGuard device
+func (p *process) shutdown() {
+	timeout := p.config.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := p.httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP server did not shut down cleanly", "error", err)
+			p.httpServer.Close()
+		}
+	}()
+	if p.tunnelServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.tunnelServer.Shutdown(ctx); err != nil {
+				slog.Warn("tunnel server did not shut down cleanly", "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	p.certs.stop(ctx)
+
+	p.usageCollector.Stop()
+	p.peerWatcher.Stop()
+	p.webhookDispatcher.Stop()
+
+	p.natManager.Cleanup()
+	p.adminHandler.CleanupRoutes()
+
+	if err := p.wgManager.Close(); err != nil {
+		slog.Warn("failed to close WireGuard device", "error", err)
+	}
+	slog.Info("shutdown complete")
+}
+
+// reload re-reads the config file and applies the settings that can change
+// without dropping peers: logging, the shutdown timeout, DNS, client routes
+// and the config.yaml NAT rules. Changed TLS certificate files are reloaded
+// as well. Other changes are reported and need a restart.
+func (p *process) reload() {
+	slog.Info("reloading configuration", "path", p.configPath)
+
+	config, err := serverconfig.Load(p.configPath)
+	if err == nil {
+		err = serverconfig.Validate(config, p.dev)
+	}
+	if err != nil {
+		slog.Error("reload failed, keeping the current configuration", "error", err)
+		return
+	}
+
+	if err := setupLogging(config.Server.LogLevel, config.Server.LogFormat); err != nil {
+		slog.Error("invalid logging configuration, keeping the current one", "error", err)
+	}
+
+	if err := syncServerDNS(p.db, config); err != nil {
+		slog.Warn("failed to update DNS", "error", err)
+	}
+
+	p.apiRouter.SetRoutes(config.WireGuard.Routes)
+	p.certs.reload()
+
+	natConfig := loadNATConfig(p.db, config)
+	if !reflect.DeepEqual(natConfig, p.natConfig) {
+		p.natManager.Cleanup()
+		newManager := nat.NewManager(natConfig)
+		if err := newManager.Apply(); err != nil {
+			slog.Warn("failed to apply NAT rules", "error", err)
+		}
+		*p.natManager = *newManager
+		p.natConfig = natConfig
+	}
+
+	if changed := restartRequired(p.config, config); len(changed) > 0 {
+		slog.Warn("some settings changed that only take effect after a restart", "settings", changed)
+	}
+
+	p.config = reloaded(p.config, config)
+
+	slog.Info("configuration reloaded")
+}
+
+// reloaded returns the running configuration with the settings reload
+// applies taken from config. The restart-only settings of the running process
+// are kept, so they are reported again until the server is restarted.
+func reloaded(running, config *serverconfig.Config) *serverconfig.Config {
+	c := *running
+	c.Server.LogLevel = config.Server.LogLevel
+	c.Server.LogFormat = config.Server.LogFormat
+	c.Server.ShutdownTimeout = config.Server.ShutdownTimeout
+	c.WireGuard.DNS = config.WireGuard.DNS
+	c.WireGuard.Routes = config.WireGuard.Routes
+	c.NAT = config.NAT
+	return &c
+}
+
+// restartRequired lists the settings that differ between old and new and
+// cannot be applied while running
+func restartRequired(old, new *serverconfig.Config) []string {
+	checks := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"server.address", old.Server.Address, new.Server.Address},
+		{"server.tls", old.Server.TLS, new.Server.TLS},
+		{"server.tls_pins", old.Server.TLSPins, new.Server.TLSPins},
+		{"server.trusted_proxies", old.Server.TrustedProxies, new.Server.TrustedProxies},
+		{"database", old.Database, new.Database},
+		{"wireguard.device_name", old.WireGuard.DeviceName, new.WireGuard.DeviceName},
+		{"wireguard.listen_port", old.WireGuard.ListenPort, new.WireGuard.ListenPort},
+		{"wireguard.subnet", old.WireGuard.Subnet, new.WireGuard.Subnet},
+		{"wireguard.endpoint", old.WireGuard.Endpoint, new.WireGuard.Endpoint},
+		{"wireguard.private_key", old.WireGuard.PrivateKey, new.WireGuard.PrivateKey},
+		{"wireguard.public_key", old.WireGuard.PublicKey, new.WireGuard.PublicKey},
+		{"wireguard.mode", old.WireGuard.Mode, new.WireGuard.Mode},
+		{"auth", old.Auth, new.Auth},
+		{"tunnel", old.Tunnel, new.Tunnel},
+		{"usage", old.Usage, new.Usage},
+		{"metrics", old.Metrics, new.Metrics},
+		{"webhooks", old.Webhooks, new.Webhooks},
+		{"acme", old.ACME, new.ACME},
+	}
+
+	var changed []string
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.old, c.new) {
+			changed = append(changed, c.name)
+		}
+	}
+	return changed
+}
+
+// newHTTPServer creates the API server. Request contexts derive from a
+// context that is cancelled when shutdown begins, so event streams end
+// instead of holding up the drain.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	streams, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return streams },
+	}
+	srv.RegisterOnShutdown(cancel)
+	return srv
+}
+
+// syncServerDNS updates the DNS servers handed to clients in the server
+// record. An empty wireguard.dns leaves the record unchanged.
+func syncServerDNS(db *database.DB, config *serverconfig.Config) error {
+	if config.WireGuard.DNS == "" {
+		return nil
+	}
+
+	var server database.Server
+	if err := db.First(&server).Error; err != nil {
+		return fmt.Errorf("no server record found: %w", err)
+	}
+	if server.DNS == config.WireGuard.DNS {
+		return nil
+	}
+
+	if err := db.Model(&server).Update("dns", config.WireGuard.DNS).Error; err != nil {
+		return fmt.Errorf("failed to update server: %w", err)
+	}
+	slog.Info("server DNS updated", "dns", config.WireGuard.DNS)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"wire-socket-server/internal/serverconfig"
+)
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*serverconfig.Config)
+		want   []string
+	}{
+		{"unchanged", func(*serverconfig.Config) {}, nil},
+		{"log level", func(c *serverconfig.Config) { c.Server.LogLevel = "debug" }, nil},
+		{"routes", func(c *serverconfig.Config) { c.WireGuard.Routes = []string{"10.1.0.0/16"} }, nil},
+		{"NAT", func(c *serverconfig.Config) { c.NAT.Enabled = true }, nil},
+		{"subnet", func(c *serverconfig.Config) { c.WireGuard.Subnet = "10.1.0.0/24" }, []string{"wireguard.subnet"}},
+		{"nested", func(c *serverconfig.Config) { c.Auth.LoginProtection.MaxAttempts = 3 }, []string{"auth"}},
+		{"trusted proxies", func(c *serverconfig.Config) { c.Server.TrustedProxies = []string{"10.0.0.1"} }, []string{"server.trusted_proxies"}},
+		{"several", func(c *serverconfig.Config) {
+			c.Server.Address = ":9090"
+			c.Server.LogLevel = "debug"
+			c.Tunnel.Path = "/ws"
+		}, []string{"server.address", "tunnel"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := &serverconfig.Config{}, &serverconfig.Config{}
+			tt.modify(new)
+			if got := restartRequired(old, new); !slices.Equal(got, tt.want) {
+				t.Errorf("restartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReloadCoversEveryField changes each setting in turn and checks that a
+// reload either applies it or reports that it needs a restart, so new
+// settings can't be silently ignored
+func TestReloadCoversEveryField(t *testing.T) {
+	for n := 0; ; n++ {
+		old, new := &serverconfig.Config{}, &serverconfig.Config{}
+		remaining := n
+		path, ok := setLeaf(reflect.ValueOf(new).Elem(), "config", &remaining)
+		if !ok {
+			if n < 50 {
+				t.Fatalf("only %d settings found", n)
+			}
+			return
+		}
+
+		applied := reflect.DeepEqual(reloaded(old, new), new)
+		restart := restartRequired(old, new)
+		if !applied && len(restart) == 0 {
+			t.Errorf("%s is neither applied on reload nor reported as needing a restart", path)
+		}
+		if applied && len(restart) > 0 {
+			t.Errorf("%s is applied on reload but reported as needing a restart (%v)", path, restart)
+		}
+	}
+}
+
+// setLeaf changes the n-th setting in v, counting pointers, slices and maps
+// as single settings, and returns its path. It returns false if v has fewer
+// settings.
+func setLeaf(v reflect.Value, path string, n *int) (string, bool) {
+	if v.Kind() == reflect.Struct {
+		for i := 0; i < v.NumField(); i++ {
+			if p, ok := setLeaf(v.Field(i), path+"."+v.Type().Field(i).Name, n); ok {
+				return p, true
+			}
+		}
+		return "", false
+	}
+
+	if *n > 0 {
+		*n--
+		return "", false
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("changed")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		m.SetMapIndex(reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem())
+		v.Set(m)
+	default:
+		panic("unsupported setting type " + v.Type().String() + " at " + path)
+	}
+	return path, true
+}
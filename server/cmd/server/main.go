@@ -3,121 +3,59 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
-	"os/signal"
-	"strings"
-	"syscall"
 	"wire-socket-server/internal/admin"
 	"wire-socket-server/internal/api"
 	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/logging"
+	"wire-socket-server/internal/loginguard"
+	"wire-socket-server/internal/metrics"
 	"wire-socket-server/internal/nat"
+	"wire-socket-server/internal/quota"
+	"wire-socket-server/internal/serverconfig"
 	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/usage"
+	"wire-socket-server/internal/webhook"
 	"wire-socket-server/internal/wireguard"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
-	"gopkg.in/yaml.v3"
 )
 
 // Version is set at build time via -ldflags
 var Version = "dev"
 
-// Log level constants
-const (
-	LogLevelDebug = iota
-	LogLevelInfo
-	LogLevelWarn
-	LogLevelError
-)
-
-var currentLogLevel = LogLevelInfo
-
-// setupLogging configures log level based on config
-func setupLogging(level string) {
-	switch strings.ToLower(level) {
-	case "debug":
-		currentLogLevel = LogLevelDebug
+// setupLogging installs the slog default logger; gin runs in debug mode at debug level
+func setupLogging(level, format string) error {
+	if err := logging.Setup(level, format); err != nil {
+		return err
+	}
+	if logging.Level() <= slog.LevelDebug {
 		gin.SetMode(gin.DebugMode)
-	case "warn", "warning":
-		currentLogLevel = LogLevelWarn
-		gin.SetMode(gin.ReleaseMode)
-	case "error":
-		currentLogLevel = LogLevelError
-		gin.SetMode(gin.ReleaseMode)
-		log.SetOutput(io.Discard) // Suppress all but fatal logs
-	default: // "info" or unset
-		currentLogLevel = LogLevelInfo
+	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
+	return nil
 }
 
-// Config represents the server configuration
-type Config struct {
-	Server struct {
-		Address  string `yaml:"address"`
-		LogLevel string `yaml:"log_level"` // debug, info, warn, error (default: info)
-		TLS      *struct {
-			CertFile string `yaml:"cert_file"`
-			KeyFile  string `yaml:"key_file"`
-		} `yaml:"tls"`
-	} `yaml:"server"`
-	Database struct {
-		Path   string `yaml:"path"`
-		Driver string `yaml:"driver"`
-		DSN    string `yaml:"dsn"`
-	} `yaml:"database"`
-	WireGuard struct {
-		DeviceName string   `yaml:"device_name"`
-		ListenPort int      `yaml:"listen_port"`
-		Subnet     string   `yaml:"subnet"`
-		DNS        string   `yaml:"dns"`
-		Endpoint   string   `yaml:"endpoint"`
-		PrivateKey string   `yaml:"private_key"`
-		PublicKey  string   `yaml:"public_key"`
-		Mode       string   `yaml:"mode"`   // "kernel" or "userspace"
-		Routes     []string `yaml:"routes"` // Routes to push to clients
-	} `yaml:"wireguard"`
-	Auth struct {
-		JWTSecret           string `yaml:"jwt_secret"`
-		AllowRegistration   bool   `yaml:"allow_registration"` // Default: false (disabled)
-	} `yaml:"auth"`
-	Tunnel struct {
-		Enabled    bool   `yaml:"enabled"`
-		ListenAddr string `yaml:"listen_addr"`
-		Path       string `yaml:"path"`
-		PublicHost string `yaml:"public_host"` // Public hostname for clients (e.g., vpn.example.com)
-		TLSCert    string `yaml:"tls_cert"`
-		TLSKey     string `yaml:"tls_key"`
-	} `yaml:"tunnel"`
-	NAT struct {
-		Enabled    bool `yaml:"enabled"`
-		Masquerade []struct {
-			Interface string `yaml:"interface"`
-		} `yaml:"masquerade"`
-		SNAT []struct {
-			Source      string `yaml:"source"`
-			Destination string `yaml:"destination"`
-			Interface   string `yaml:"interface"`
-			ToSource    string `yaml:"to_source"`
-		} `yaml:"snat"`
-		DNAT []struct {
-			Interface     string `yaml:"interface"`
-			Protocol      string `yaml:"protocol"`
-			Port          int    `yaml:"port"`
-			ToDestination string `yaml:"to_destination"`
-		} `yaml:"dnat"`
-	} `yaml:"nat"`
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
 	initDB := flag.Bool("init-db", false, "Initialize database with default data")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration and exit")
+	dev := flag.Bool("dev", false, "Development mode: accept the example JWT secret")
 	flag.Parse()
 
 	if *showVersion {
@@ -125,24 +63,39 @@ func main() {
 		return
 	}
 
-	log.Printf("WireSocket Server %s", Version)
-
 	// Load configuration
-	config, err := loadConfig(*configPath)
+	config, err := serverconfig.Load(*configPath)
+	if err == nil {
+		err = serverconfig.Validate(config, *dev)
+	}
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: invalid configuration: %v\n", *configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: configuration OK\n", *configPath)
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("invalid configuration", "path", *configPath, "error", err)
 	}
 
 	// Setup logging based on config
-	setupLogging(config.Server.LogLevel)
+	if err := setupLogging(config.Server.LogLevel, config.Server.LogFormat); err != nil {
+		fatal("invalid logging configuration", "error", err)
+	}
+	slog.Info("WireSocket Server starting", "version", Version)
+	if *dev {
+		slog.Warn("running in development mode, do not use in production")
+	}
 
 	// Initialize database
 	db, err := database.NewDB(config.Database.Path)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("failed to initialize database", "error", err)
 	}
 
-	log.Println("Database initialized successfully")
+	slog.Info("database initialized", "path", config.Database.Path)
 
 	// Determine WireGuard mode
 	wgMode := wireguard.Mode(config.WireGuard.Mode)
@@ -156,11 +109,10 @@ func main() {
 		Mode:       wgMode,
 	})
 	if err != nil {
-		log.Fatalf("Failed to initialize WireGuard manager: %v", err)
+		fatal("failed to initialize WireGuard manager", "error", err)
 	}
-	defer wgManager.Close()
 
-	log.Printf("WireGuard manager initialized successfully (mode: %s)", wgMode)
+	slog.Info("WireGuard manager initialized", "mode", wgMode)
 
 	// Generate or load WireGuard server keys
 	// Priority: 1. config.yaml, 2. existing wg config file, 3. generate new
@@ -169,27 +121,26 @@ func main() {
 		// Try to load from existing WireGuard config file
 		existingConfig, err := wgManager.LoadConfigFile()
 		if err == nil && existingConfig != nil && existingConfig.PrivateKey != "" {
-			log.Println("Loading WireGuard keys from existing config file...")
+			slog.Info("loading WireGuard keys from existing config file")
 			privateKey = existingConfig.PrivateKey
 			// Derive public key from private key
 			publicKey, err = derivePublicKey(privateKey)
 			if err != nil {
-				log.Printf("Warning: failed to derive public key: %v, generating new keys", err)
+				slog.Warn("failed to derive public key, generating new keys", "error", err)
 				privateKey, publicKey, err = wireguard.GenerateKeyPair()
 				if err != nil {
-					log.Fatalf("Failed to generate key pair: %v", err)
+					fatal("failed to generate key pair", "error", err)
 				}
 			} else {
-				log.Printf("Loaded existing keys - Public: %s", publicKey)
+				slog.Info("loaded existing keys", "public_key", publicKey)
 			}
 		} else {
-			log.Println("Generating new WireGuard key pair...")
+			slog.Info("generating new WireGuard key pair")
 			privateKey, publicKey, err = wireguard.GenerateKeyPair()
 			if err != nil {
-				log.Fatalf("Failed to generate key pair: %v", err)
+				fatal("failed to generate key pair", "error", err)
 			}
-			log.Printf("Generated keys - Public: %s", publicKey)
-			log.Println("TIP: Save these keys in config.yaml to persist across restarts")
+			slog.Info("generated keys; save them in config.yaml to persist across restarts", "public_key", publicKey)
 		}
 	}
 
@@ -197,48 +148,52 @@ func main() {
 	// (first usable IP in subnet, e.g., 10.250.2.0/24 -> 10.250.2.1/24)
 	serverAddr, err := getServerAddress(config.WireGuard.Subnet)
 	if err != nil {
-		log.Fatalf("Failed to calculate server address: %v", err)
+		fatal("failed to calculate server address", "error", err)
 	}
 	wgManager.SetAddress(serverAddr)
-	log.Printf("WireGuard server address: %s", serverAddr)
+	slog.Info("WireGuard server address", "address", serverAddr)
 
 	// Configure WireGuard device (must be after SetAddress so TUN gets the IP)
 	if err := wgManager.ConfigureDevice(privateKey, config.WireGuard.ListenPort); err != nil {
-		log.Fatalf("Failed to configure WireGuard device: %v", err)
+		fatal("failed to configure WireGuard device", "error", err)
 	}
 
-	log.Printf("WireGuard device %s configured successfully", config.WireGuard.DeviceName)
+	slog.Info("WireGuard device configured", "device", config.WireGuard.DeviceName)
 
 	// Load existing peers from config file (if any)
 	if err := wgManager.LoadPeersFromConfig(); err != nil {
-		log.Printf("Warning: failed to load peers from config file: %v", err)
+		slog.Warn("failed to load peers from config file", "error", err)
 	} else {
 		existingConfig, _ := wgManager.LoadConfigFile()
 		if existingConfig != nil && len(existingConfig.Peers) > 0 {
-			log.Printf("Loaded %d peers from %s", len(existingConfig.Peers), wgManager.GetConfigPath())
+			slog.Info("loaded peers from config file", "peers", len(existingConfig.Peers), "path", wgManager.GetConfigPath())
 		}
 	}
 
 	// Save initial config (creates file if not exists)
 	if err := wgManager.SaveConfigFile(privateKey, config.WireGuard.Subnet, config.WireGuard.ListenPort); err != nil {
-		log.Printf("Warning: failed to save initial config: %v", err)
+		slog.Warn("failed to save initial config", "error", err)
 	} else {
-		log.Printf("WireGuard config persisted to %s", wgManager.GetConfigPath())
+		slog.Info("WireGuard config persisted", "path", wgManager.GetConfigPath())
 	}
 
 	// Create default server entry in database
 	if *initDB {
 		if err := initializeDatabase(db, config, publicKey); err != nil {
-			log.Fatalf("Failed to initialize database: %v", err)
+			fatal("failed to initialize database", "error", err)
 		}
-		log.Println("Database initialized with default data")
+		slog.Info("database initialized with default data")
+		wgManager.Close()
 		return
 	}
 
 	// Ensure server record in database has the correct public key
 	// This handles the case where keys are generated on startup
 	if err := syncServerPublicKey(db, config, publicKey); err != nil {
-		log.Printf("Warning: failed to sync server public key: %v", err)
+		slog.Warn("failed to sync server public key", "error", err)
+	}
+	if err := syncServerDNS(db, config); err != nil {
+		slog.Warn("failed to sync server DNS", "error", err)
 	}
 
 	// Initialize config generator
@@ -246,15 +201,34 @@ func main() {
 
 	// Initialize auth handler
 	authHandler := auth.NewHandler(db, config.Auth.JWTSecret, config.Auth.AllowRegistration)
+	loginGuard, err := loginguard.New(config.Auth.LoginProtection, db.DB)
+	if err != nil {
+		fatal("failed to initialize login protection", "error", err)
+	}
+	authHandler.SetLoginGuard(loginGuard)
+
+	// Event bus: login, peer, quota and admin change events, delivered to webhooks
+	bus := events.NewBus()
+	events.PublishAuditEntries(bus)
+	authHandler.SetEvents(bus)
+	webhookDispatcher := webhook.NewDispatcher(db.DB, config.Webhooks)
+	webhookDispatcher.Start(bus)
 
 	// Set up Gin router
-	engine := gin.Default()
+	engine := gin.New()
+	engine.Use(logging.Middleware(), gin.Recovery())
+	// Client addresses feed login throttling and the audit log, so only
+	// configured proxies may set them
+	if err := engine.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		fatal("invalid trusted proxies", "error", err)
+	}
 
 	// Enable CORS
 	engine.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -279,22 +253,44 @@ func main() {
 	natConfig := loadNATConfig(db, config)
 	natManager := nat.NewManager(natConfig)
 	if err := natManager.Apply(); err != nil {
-		log.Printf("Warning: failed to apply NAT rules: %v", err)
+		slog.Warn("failed to apply NAT rules", "error", err)
 	}
 
 	// Initialize admin handler
 	adminHandler := api.NewAdminHandler(db, natManager, config.WireGuard.DeviceName)
+	adminHandler.SetLoginGuard(loginGuard)
+	adminHandler.SetWireGuardManager(wgManager)
+	adminHandler.SetEvents(bus)
+
+	// Prometheus metrics; the tunnel and traffic metrics are added below
+	serverMetrics := metrics.New(config.Metrics)
+	serverMetrics.WatchPeers(wgManager)
+	serverMetrics.WatchIPPool(config.WireGuard.Subnet, func() (int64, error) {
+		var n int64
+		err := db.Model(&database.AllocatedIP{}).Count(&n).Error
+		return n, err
+	})
 
 	apiRouter := api.NewRouter(authHandler, adminHandler, db, configGen, tunnelURL, config.WireGuard.Subnet)
+	apiRouter.SetRoutes(config.WireGuard.Routes)
+	apiRouter.SetTunnelShaping(config.Tunnel.Shaping)
+	apiRouter.SetTunnelKeepalive(config.Tunnel.Keepalive)
+	apiRouter.SetTLSPins(config.Server.TLSPins)
+	apiRouter.SetMetrics(serverMetrics)
+	apiRouter.SetWebhooks(webhook.NewHandler(db.DB, webhookDispatcher))
 	apiRouter.SetupRoutes(engine)
 
 	// Setup admin UI routes
 	admin.SetupRoutes(engine)
 
 	// Start server
-	log.Printf("Starting VPN server on %s", config.Server.Address)
-	log.Printf("WireGuard endpoint: %s", config.WireGuard.Endpoint)
-	log.Printf("VPN subnet: %s", config.WireGuard.Subnet)
+	slog.Info("starting VPN server", "address", config.Server.Address, "endpoint", config.WireGuard.Endpoint, "subnet", config.WireGuard.Subnet)
+
+	// TLS certificates from files (reloaded when they change) or ACME
+	listenerCerts, err := setupCerts(config)
+	if err != nil {
+		fatal("failed to set up TLS certificates", "error", err)
+	}
 
 	// Start built-in tunnel server if enabled
 	var tunnelServer *tunnel.Server
@@ -304,70 +300,96 @@ func main() {
 			ListenAddr: config.Tunnel.ListenAddr,
 			TargetAddr: targetAddr,
 			PathPrefix: config.Tunnel.Path,
-			TLSCert:    config.Tunnel.TLSCert,
-			TLSKey:     config.Tunnel.TLSKey,
+			TLSConfig:  listenerCerts.tunnel,
+			Shaping:    config.Tunnel.Shaping,
+			Keepalive:  config.Tunnel.Keepalive,
+
+			ResumeTimeout:   config.Tunnel.ResumeTimeout,
+			RequiredHeaders: config.Tunnel.RequiredHeaders,
+			MaxStreams:      config.Tunnel.MaxStreams,
+			TrustedProxies:  config.Server.TrustedProxies,
 		})
 
-		if err := tunnelServer.StartAsync(); err != nil {
-			log.Fatalf("Failed to start tunnel server: %v", err)
+		requireAuth := config.Tunnel.RequireAuth != nil && *config.Tunnel.RequireAuth
+		if config.Tunnel.RequireAuth == nil {
+			slog.Warn("tunnel.require_auth is not set: tunnel connections without a login token are accepted " +
+				"without a quota or rate limit, so any client can skip them; set require_auth: true once all " +
+				"clients send their token, or require_auth: false to keep accepting older clients")
 		}
-		defer tunnelServer.Stop()
-
-		protocol := "WS"
-		if config.Tunnel.TLSCert != "" {
-			protocol = "WSS"
+		tunnelServer.SetAuthenticator(quota.TunnelAuthenticator(db, authHandler.ValidateToken, requireAuth))
+		if fallback, err := tunnelFallback(config); err != nil {
+			fatal("failed to set up tunnel fallback", "error", err)
+		} else if fallback != nil {
+			tunnelServer.SetFallback(fallback)
 		}
-		log.Printf("Built-in tunnel server started on %s (%s -> %s)", config.Tunnel.ListenAddr, protocol, targetAddr)
-	} else {
-		log.Println("")
-		log.Println("Built-in tunnel disabled. Make sure wstunnel server is running:")
-		log.Println("  wstunnel server wss://0.0.0.0:443 --restrict-to 127.0.0.1:51820")
-	}
-
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		<-sigChan
-		log.Println("\nShutting down...")
-		natManager.Cleanup()
-		if tunnelServer != nil {
-			tunnelServer.Stop()
-		}
-		wgManager.Close()
-		os.Exit(0)
-	}()
+		adminHandler.SetTunnelServer(tunnelServer)
+		serverMetrics.WatchTunnel(tunnelServer)
 
-	log.Println("")
-	log.Println("Server is ready!")
+		if config.Tunnel.ListenAddr == "" {
+			path := mountTunnel(engine, config.Tunnel.Path, tunnelServer)
+			slog.Info("built-in tunnel served on the API listener", "address", config.Server.Address, "path", path, "target", targetAddr)
+		} else {
+			if err := tunnelServer.StartAsync(); err != nil {
+				fatal("failed to start tunnel server", "error", err)
+			}
 
-	if config.Server.TLS != nil {
-		if err := engine.RunTLS(config.Server.Address, config.Server.TLS.CertFile, config.Server.TLS.KeyFile); err != nil {
-			log.Fatalf("Failed to start server: %v", err)
+			protocol := "WS"
+			if listenerCerts.tunnel != nil {
+				protocol = "WSS"
+			}
+			slog.Info("built-in tunnel server started", "address", config.Tunnel.ListenAddr, "protocol", protocol, "target", targetAddr)
 		}
 	} else {
-		if err := engine.Run(config.Server.Address); err != nil {
-			log.Fatalf("Failed to start server: %v", err)
-		}
-	}
-}
-
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+		slog.Info("built-in tunnel disabled, make sure wstunnel server is running",
+			"example", "wstunnel server wss://0.0.0.0:443 --restrict-to 127.0.0.1:51820")
+	}
+
+	// Start traffic accounting
+	usageCollector := usage.NewCollector(db, wgManager, config.Usage)
+
+	// Disconnect users over their data quota after every usage sample
+	quotaEnforcer := quota.NewEnforcer(db, wgManager)
+	if tunnelServer != nil {
+		quotaEnforcer.SetTunnelServer(tunnelServer)
+	}
+	quotaEnforcer.SetEvents(bus)
+	usageCollector.OnSample(func([]usage.Delta) { quotaEnforcer.Enforce() })
+	serverMetrics.WatchTraffic(usageCollector)
+	if config.Usage.Disabled {
+		slog.Warn("usage accounting is disabled, data quotas are not enforced")
+	}
+	usageCollector.Start()
+
+	// Publish peer.connected/peer.disconnected from handshake state
+	peerWatcher := events.NewPeerWatcher(bus, db, wgManager, 0)
+	peerWatcher.Start()
+
+	httpServer := newHTTPServer(config.Server.Address, engine)
+	httpServer.TLSConfig = listenerCerts.api
+
+	proc := &process{
+		configPath:        *configPath,
+		dev:               *dev,
+		config:            config,
+		db:                db,
+		httpServer:        httpServer,
+		certs:             listenerCerts,
+		tunnelServer:      tunnelServer,
+		apiRouter:         apiRouter,
+		adminHandler:      adminHandler,
+		natManager:        natManager,
+		natConfig:         natConfig,
+		usageCollector:    usageCollector,
+		peerWatcher:       peerWatcher,
+		webhookDispatcher: webhookDispatcher,
+		wgManager:         wgManager,
+	}
+	if err := proc.run(); err != nil {
+		os.Exit(1)
 	}
-
-	return &config, nil
 }
 
-func initializeDatabase(db *database.DB, config *Config, publicKey string) error {
+func initializeDatabase(db *database.DB, config *serverconfig.Config, publicKey string) error {
 	// Create default server
 	server := &database.Server{
 		Name:       "Default Server",
@@ -401,31 +423,27 @@ func initializeDatabase(db *database.DB, config *Config, publicKey string) error
 		return fmt.Errorf("failed to create admin user: %w", err)
 	}
 
-	log.Println("Created default admin user:")
-	log.Println("  Username: admin")
-	log.Println("  Password: admin123")
-	log.Println("  *** Please change this password immediately! ***")
+	slog.Warn("created default admin user, change this password immediately", "username", "admin", "password", "admin123")
 
 	return nil
 }
 
 // syncServerPublicKey updates the server record in the database with the current public key
 // This ensures the database always has the key that matches the running WireGuard instance
-func syncServerPublicKey(db *database.DB, config *Config, publicKey string) error {
+func syncServerPublicKey(db *database.DB, config *serverconfig.Config, publicKey string) error {
 	var server database.Server
 	if err := db.First(&server).Error; err != nil {
 		return fmt.Errorf("no server record found: %w", err)
 	}
 
 	if server.PublicKey != publicKey {
-		log.Printf("Updating server public key in database (was: %s..., now: %s...)",
-			server.PublicKey[:8], publicKey[:8])
+		slog.Info("updating server public key in database", "old", server.PublicKey, "new", publicKey)
 		server.PublicKey = publicKey
 		server.PrivateKey = config.WireGuard.PrivateKey
 		if err := db.Save(&server).Error; err != nil {
 			return fmt.Errorf("failed to update server: %w", err)
 		}
-		log.Println("Server public key updated in database")
+		slog.Info("server public key updated in database")
 	}
 
 	return nil
@@ -466,8 +484,33 @@ func getServerAddress(subnet string) (string, error) {
 	return fmt.Sprintf("%s/%d", ip4.String(), ones), nil
 }
 
+// mountTunnel serves the tunnel at path (default "/") on the API engine, so
+// one port (and one TLS certificate) serves the API, admin UI and tunnel. It
+// returns the path used.
+func mountTunnel(engine *gin.Engine, path string, tunnelServer *tunnel.Server) string {
+	if path == "" {
+		path = "/"
+	}
+	engine.GET(path, gin.WrapH(tunnelServer.Handler()))
+	return path
+}
+
+// tunnelFallback returns the decoy handler configured in tunnel.fallback, or
+// nil if none is configured
+func tunnelFallback(config *serverconfig.Config) (http.Handler, error) {
+	switch fallback := config.Tunnel.Fallback; {
+	case fallback.Proxy != "":
+		slog.Info("tunnel fallback proxies to upstream site", "upstream", fallback.Proxy)
+		return tunnel.ProxyFallback(fallback.Proxy)
+	case fallback.StaticDir != "":
+		slog.Info("tunnel fallback serves static site", "dir", fallback.StaticDir)
+		return tunnel.StaticFallback(fallback.StaticDir)
+	}
+	return nil, nil
+}
+
 // loadNATConfig loads NAT configuration from database, falling back to config.yaml if database is empty
-func loadNATConfig(db *database.DB, config *Config) nat.Config {
+func loadNATConfig(db *database.DB, config *serverconfig.Config) nat.Config {
 	natConfig := nat.Config{
 		Enabled: config.NAT.Enabled,
 	}
@@ -475,7 +518,7 @@ func loadNATConfig(db *database.DB, config *Config) nat.Config {
 	// Try to load from database first
 	var rules []database.NATRule
 	if err := db.Where("enabled = ?", true).Find(&rules).Error; err == nil && len(rules) > 0 {
-		log.Printf("Loading NAT rules from database (%d rules)", len(rules))
+		slog.Info("loading NAT rules from database", "rules", len(rules))
 		for _, rule := range rules {
 			switch rule.Type {
 			case database.NATTypeMasquerade:
@@ -508,7 +551,7 @@ func loadNATConfig(db *database.DB, config *Config) nat.Config {
 	}
 
 	// Fall back to config.yaml
-	log.Println("Loading NAT rules from config.yaml (no rules in database)")
+	slog.Info("loading NAT rules from config.yaml (no rules in database)")
 	for _, m := range config.NAT.Masquerade {
 		natConfig.Masquerade = append(natConfig.Masquerade, nat.MasqueradeRule{
 			Interface: m.Interface,
@@ -530,6 +573,13 @@ func loadNATConfig(db *database.DB, config *Config) nat.Config {
 			ToDestination: d.ToDestination,
 		})
 	}
+	for _, t := range config.NAT.TCPMSS {
+		natConfig.TCPMSS = append(natConfig.TCPMSS, nat.TCPMSSRule{
+			Interface: t.Interface,
+			Source:    t.Source,
+			MSS:       t.MSS,
+		})
+	}
 
 	return natConfig
 }
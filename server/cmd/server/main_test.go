@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wire-socket-server/internal/admin"
+	"wire-socket-server/internal/api"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/tunnel"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// udpEcho returns the address of a UDP socket that echoes datagrams, standing
+// in for the WireGuard port
+func udpEcho(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// apiServer serves the API routes and admin UI with the tunnel mounted at
+// tunnelPath, like the server does when tunnel.listen_addr is empty
+func apiServer(t *testing.T, tunnelPath string) (*httptest.Server, *tunnel.Server) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	router := api.NewRouter(auth.NewHandler(db, "test-secret", false), api.NewAdminHandler(db, nil, ""), db, nil, "", "10.0.0.0/24")
+	router.SetupRoutes(engine)
+	admin.SetupRoutes(engine)
+
+	tunnelServer := tunnel.NewServer(tunnel.Config{TargetAddr: udpEcho(t), PathPrefix: tunnelPath})
+	mountTunnel(engine, tunnelPath, tunnelServer)
+
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv, tunnelServer
+}
+
+// echoThroughTunnel opens a tunnel connection at path and checks that a
+// datagram comes back from the target
+func echoThroughTunnel(srv *httptest.Server, path string) (int, error) {
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if err != nil {
+		if resp != nil {
+			return resp.StatusCode, err
+		}
+		return 0, err
+	}
+	defer ws.Close()
+
+	ping := []byte("datagram")
+	if err := ws.WriteMessage(websocket.BinaryMessage, ping); err != nil {
+		return resp.StatusCode, err
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := ws.ReadMessage()
+	if err == nil && !bytes.Equal(reply, ping) {
+		err = fmt.Errorf("unexpected reply %q", reply)
+	}
+	return resp.StatusCode, err
+}
+
+func TestMountTunnel(t *testing.T) {
+	for _, tunnelPath := range []string{"/tunnel", ""} {
+		t.Run("path "+tunnelPath, func(t *testing.T) {
+			srv, tunnelServer := apiServer(t, tunnelPath)
+			mounted := tunnelPath
+			if mounted == "" {
+				mounted = "/"
+			}
+
+			if status, err := echoThroughTunnel(srv, mounted); err != nil {
+				t.Fatalf("tunnel at %s: HTTP %d: %v", mounted, status, err)
+			}
+			if status, err := echoThroughTunnel(srv, "/health"); err == nil {
+				t.Errorf("tunnel connection accepted at /health (HTTP %d)", status)
+			}
+
+			// The API and admin UI are still served next to the tunnel
+			for _, path := range []string{"/health", "/admin"} {
+				resp, err := http.Get(srv.URL + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, http.StatusOK)
+				}
+			}
+
+			// Without a fallback, a plain request to the tunnel path is refused
+			resp, err := http.Get(srv.URL + mounted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("GET %s = %d, want %d", mounted, resp.StatusCode, http.StatusBadRequest)
+			}
+
+			// Shutting the tunnel down leaves the API listener serving
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tunnelServer.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown: %v", err)
+			}
+			if status, _ := echoThroughTunnel(srv, mounted); status != http.StatusServiceUnavailable {
+				t.Errorf("tunnel after shutdown: HTTP %d, want %d", status, http.StatusServiceUnavailable)
+			}
+			resp, err = http.Get(srv.URL + "/health")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET /health after tunnel shutdown = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
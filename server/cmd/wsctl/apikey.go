@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+)
+
+// ============ API Key Commands ============
+
+func handleAPIKeyCommand(db *database.DB, args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		listAPIKeys(db)
+	case "create", "add":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl apikey create <name> --scopes=<scope,...> [--expires=<duration>]")
+			os.Exit(1)
+		}
+		createAPIKey(db, args[1], args[2:])
+	case "revoke", "delete", "rm":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl apikey revoke <id>")
+			os.Exit(1)
+		}
+		revokeAPIKey(db, args[1])
+	case "logs":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl apikey logs <id>")
+			os.Exit(1)
+		}
+		listAPIKeyLogs(db, args[1])
+	case "scopes":
+		for _, s := range auth.AllScopes {
+			fmt.Println(s)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown apikey subcommand: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func listAPIKeys(db *database.DB) {
+	var keys []database.APIKey
+	if err := db.Order("id ASC").Find(&keys).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(keys) == 0 {
+		fmt.Println("No API keys configured")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tEXPIRES\tLAST_USED\tSTATUS")
+	for _, k := range keys {
+		status := "active"
+		if k.RevokedAt != nil {
+			status = "revoked"
+		} else if k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt) {
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Prefix,
+			strings.Join(k.Scopes, ","), formatOptionalTime(k.ExpiresAt), formatOptionalTime(k.LastUsedAt), status)
+	}
+	w.Flush()
+}
+
+func createAPIKey(db *database.DB, name string, opts []string) {
+	var scopes []string
+	var expiresAt *time.Time
+
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--scopes=") {
+			for _, s := range strings.Split(strings.TrimPrefix(opt, "--scopes="), ",") {
+				s = strings.TrimSpace(s)
+				if s == "" {
+					continue
+				}
+				if !auth.ValidScope(s) {
+					fmt.Fprintf(os.Stderr, "Invalid scope: %s (valid: %s)\n", s, strings.Join(auth.AllScopes, ", "))
+					os.Exit(1)
+				}
+				scopes = append(scopes, s)
+			}
+		} else if strings.HasPrefix(opt, "--expires=") {
+			d, err := parseDurationDays(strings.TrimPrefix(opt, "--expires="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid expiry: %v\n", err)
+				os.Exit(1)
+			}
+			t := time.Now().Add(d)
+			expiresAt = &t
+		}
+	}
+
+	if len(scopes) == 0 {
+		fmt.Fprintln(os.Stderr, "At least one scope is required (--scopes=users:read,...)")
+		os.Exit(1)
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating API key: %v\n", err)
+		os.Exit(1)
+	}
+
+	apiKey := database.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := db.Create(&apiKey).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating API key: %v\n", err)
+		os.Exit(1)
+	}
+
+	recordAudit(db.DB, "apikey.create", "api_key", apiKey.ID, nil, apiKey)
+
+	fmt.Printf("API key created: ID=%d, Name=%s\n", apiKey.ID, apiKey.Name)
+	fmt.Printf("Key: %s\n", key)
+	fmt.Println("Store this key now, it will not be shown again.")
+}
+
+func revokeAPIKey(db *database.DB, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", idStr)
+		os.Exit(1)
+	}
+
+	var apiKey database.APIKey
+	if err := db.First(&apiKey, id).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "API key not found: %v\n", err)
+		os.Exit(1)
+	}
+
+	if apiKey.RevokedAt != nil {
+		fmt.Printf("API key already revoked: ID=%d\n", apiKey.ID)
+		return
+	}
+
+	before := apiKey
+	if err := db.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error revoking API key: %v\n", err)
+		os.Exit(1)
+	}
+
+	recordAudit(db.DB, "apikey.revoke", "api_key", apiKey.ID, before, apiKey)
+
+	fmt.Printf("API key revoked: ID=%d, Name=%s\n", apiKey.ID, apiKey.Name)
+}
+
+func listAPIKeyLogs(db *database.DB, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", idStr)
+		os.Exit(1)
+	}
+
+	var logs []database.APIKeyLog
+	if err := db.Where("api_key_id = ?", id).Order("id DESC").Limit(100).Find(&logs).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(logs) == 0 {
+		fmt.Println("No requests logged for this API key")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tMETHOD\tPATH\tSTATUS\tCLIENT_IP")
+	for _, l := range logs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", l.CreatedAt.Format("2006-01-02 15:04:05"), l.Method, l.Path, l.Status, l.ClientIP)
+	}
+	w.Flush()
+}
+
+// parseDurationDays parses a Go duration, additionally accepting a "d" suffix for days (e.g., "90d")
+func parseDurationDays(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid number of days: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive: %s", s)
+	}
+	return d, nil
+}
+
+// formatOptionalTime formats a nullable timestamp for table output
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// ============ Audit Commands ============
+// wsctl writes straight to the database, so it records its own changes
+
+// recordAudit appends an audit entry for a change made with wsctl. The change
+// has already been applied, so a failure only prints a warning.
+func recordAudit(db *gorm.DB, action, targetType string, targetID, before, after interface{}) {
+	if err := audit.Record(db, audit.WSCTL(), "", action, targetType, targetID, before, after); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+	}
+}
+
+func listAuditLog(db *gorm.DB, args []string) {
+	limit := 50
+	query := db.Order("id DESC")
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--limit=") {
+			if l, err := strconv.Atoi(strings.TrimPrefix(arg, "--limit=")); err == nil && l > 0 {
+				limit = l
+			}
+		} else if strings.HasPrefix(arg, "--actor=") {
+			query = query.Where("actor = ?", strings.TrimPrefix(arg, "--actor="))
+		} else if strings.HasPrefix(arg, "--action=") {
+			action := strings.TrimPrefix(arg, "--action=")
+			if strings.HasSuffix(action, "*") {
+				query = query.Where("action LIKE ?", strings.TrimSuffix(action, "*")+"%")
+			} else {
+				query = query.Where("action = ?", action)
+			}
+		} else if strings.HasPrefix(arg, "--target=") {
+			// --target=<type>[:<id>]
+			parts := strings.SplitN(strings.TrimPrefix(arg, "--target="), ":", 2)
+			query = query.Where("target_type = ?", parts[0])
+			if len(parts) == 2 {
+				query = query.Where("target_id = ?", parts[1])
+			}
+		}
+	}
+
+	var entries []database.AuditLog
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No audit entries recorded")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tACTION\tTARGET\tSOURCE_IP\tCHANGES")
+	for _, e := range entries {
+		target := e.TargetType
+		if e.TargetID != "" {
+			target += ":" + e.TargetID
+		}
+		sourceIP := e.SourceIP
+		if sourceIP == "" {
+			sourceIP = "-"
+		}
+		changes := e.Changes
+		if changes == "" {
+			changes = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format("2006-01-02 15:04:05"), e.Actor, e.Action, target, sourceIP, changes)
+	}
+	w.Flush()
+}
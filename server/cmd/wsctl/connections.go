@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ============ Peer Commands (server mode) ============
+// The WireGuard device lives inside the server process, so live data comes
+// from the running server's admin API rather than the database
+
+func handleServerPeerCommand(db *database.DB, config *Config, args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		if contains(args[1:], "--live") {
+			listLivePeers(db, config, contains(args[1:], "--all"))
+		} else {
+			listAllocatedPeers(db)
+		}
+	case "kick", "disconnect":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl peer kick <id>")
+			os.Exit(1)
+		}
+		kickPeer(db, config, args[1])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown peer subcommand: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func listAllocatedPeers(db *database.DB) {
+	var peers []database.AllocatedIP
+	if err := db.Preload("User").Order("id ASC").Find(&peers).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(peers) == 0 {
+		fmt.Println("No peers allocated")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER_ID\tUSERNAME\tIP\tPUBLIC_KEY\tLAST_SEEN")
+	for _, p := range peers {
+		lastSeen := "-"
+		if p.LastSeen != nil {
+			lastSeen = p.LastSeen.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", p.ID, p.UserID, p.User.Username, p.IPAddress, shortKey(p.PublicKey), lastSeen)
+	}
+	w.Flush()
+}
+
+// liveConnection mirrors api.Connection
+type liveConnection struct {
+	ID             uint       `json:"id"`
+	UserID         uint       `json:"user_id"`
+	Username       string     `json:"username"`
+	DeviceIP       string     `json:"device_ip"`
+	PublicKey      string     `json:"public_key"`
+	SourceAddr     string     `json:"source_addr"`
+	LastHandshake  *time.Time `json:"last_handshake"`
+	RxBytes        int64      `json:"rx_bytes"`
+	TxBytes        int64      `json:"tx_bytes"`
+	ConnectedSince *time.Time `json:"connected_since"`
+	LatencyMs      float64    `json:"latency_ms"`
+	Live           bool       `json:"live"`
+}
+
+func listLivePeers(db *database.DB, config *Config, all bool) {
+	path := "/api/admin/connections"
+	if all {
+		path += "?all=true"
+	}
+
+	var resp struct {
+		Connections []liveConnection `json:"connections"`
+	}
+	if err := serverRequest(db, config, http.MethodGet, path, &resp); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(resp.Connections) == 0 {
+		fmt.Println("No connected peers")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tDEVICE_IP\tSOURCE\tLAST_HANDSHAKE\tRX\tTX\tLATENCY\tCONNECTED_SINCE")
+	for _, conn := range resp.Connections {
+		source := conn.SourceAddr
+		if source == "" {
+			source = "-"
+		}
+		latency := "-"
+		if conn.LatencyMs > 0 {
+			latency = fmt.Sprintf("%.1f ms", conn.LatencyMs)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", conn.ID, conn.Username, conn.DeviceIP, source,
+			formatAgo(conn.LastHandshake), formatBytes(conn.RxBytes), formatBytes(conn.TxBytes), latency, formatOptionalTime(conn.ConnectedSince))
+	}
+	w.Flush()
+}
+
+func kickPeer(db *database.DB, config *Config, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", idStr)
+		os.Exit(1)
+	}
+
+	var resp struct {
+		Username     string `json:"username"`
+		DeviceIP     string `json:"device_ip"`
+		TunnelClosed bool   `json:"tunnel_closed"`
+	}
+	if err := serverRequest(db, config, http.MethodDelete, "/api/admin/connections/"+idStr, &resp); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The server logs the request under the admin serverRequest signs in as;
+	// this entry records who actually ran wsctl
+	recordAudit(db.DB, "connection.disconnect", "connection", uint(id), map[string]interface{}{"username": resp.Username, "device_ip": resp.DeviceIP}, nil)
+
+	fmt.Printf("Peer disconnected: ID=%s, Username=%s, IP=%s, TunnelClosed=%v\n", idStr, resp.Username, resp.DeviceIP, resp.TunnelClosed)
+}
+
+// serverRequest calls the running server's admin API. It authenticates with
+// a short-lived token signed with auth.jwt_secret for the first active
+// superadmin, so the server attributes the change to that admin; callers
+// that change anything record their own wsctl audit entry as well.
+func serverRequest(db *database.DB, config *Config, method, path string, out interface{}) error {
+	if config.Auth.JWTSecret == "" {
+		return fmt.Errorf("auth.jwt_secret is not set in config")
+	}
+
+	var admins []database.User
+	if err := db.Where("is_active = ? AND ((is_admin = ? AND role = '') OR role = ?)", true, true, auth.RoleSuperadmin).Order("id ASC").Limit(1).Find(&admins).Error; err != nil {
+		return err
+	}
+	if len(admins) == 0 {
+		return fmt.Errorf("no active superadmin user to authenticate as")
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": admins[0].ID,
+		"exp":     time.Now().Add(time.Minute).Unix(),
+		"iat":     time.Now().Unix(),
+	}).SignedString([]byte(config.Auth.JWTSecret))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, serverURL(config)+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// Local connection to our own server; its certificate usually names the public host
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("server not reachable (is it running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (HTTP %d)", apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// serverURL returns WSCTL_SERVER_URL or a local URL built from server.address
+func serverURL(config *Config) string {
+	if u := os.Getenv("WSCTL_SERVER_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+
+	scheme := "http"
+	if config.Server.TLS != nil {
+		scheme = "https"
+	}
+
+	addr := config.Server.Address
+	if addr == "" {
+		addr = ":8080"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return scheme + "://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+func shortKey(key string) string {
+	if key == "" {
+		return "-"
+	}
+	if len(key) > 20 {
+		return key[:20] + "..."
+	}
+	return key
+}
+
+func formatAgo(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return time.Since(*t).Round(time.Second).String() + " ago"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
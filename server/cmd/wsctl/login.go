@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/loginguard"
+
+	"gorm.io/gorm"
+)
+
+// ============ Login Protection Commands ============
+// Shared by server and auth modes (both keep users in the "users" table)
+
+func unlockUser(db *gorm.DB, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", idStr)
+		os.Exit(1)
+	}
+
+	var user struct {
+		ID       uint
+		Username string
+	}
+	if err := db.Table("users").Select("id, username").Where("id = ?", id).Take(&user).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "User not found: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := db.Table("users").Where("id = ?", user.ID).Update("locked_until", nil).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error unlocking user: %v\n", err)
+		os.Exit(1)
+	}
+
+	recordAudit(db, "user.unlock", "user", user.ID, nil, nil)
+
+	// Clears the shared counter; a server using the memory store forgets
+	// failures once its window expires
+	if err := loginguard.ResetUser(db, user.Username); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to reset login attempts: %v\n", err)
+	}
+
+	fmt.Printf("User unlocked: ID=%d, Username=%s\n", user.ID, user.Username)
+}
+
+func listLoginFailures(db *gorm.DB, args []string) {
+	limit := 50
+	query := db.Order("id DESC")
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--limit=") {
+			if l, err := strconv.Atoi(strings.TrimPrefix(arg, "--limit=")); err == nil && l > 0 {
+				limit = l
+			}
+		} else if strings.HasPrefix(arg, "--user=") {
+			query = query.Where("username = ?", strings.TrimPrefix(arg, "--user="))
+		} else if strings.HasPrefix(arg, "--ip=") {
+			query = query.Where("client_ip = ?", strings.TrimPrefix(arg, "--ip="))
+		}
+	}
+
+	var failures []database.LoginFailure
+	if err := query.Limit(limit).Find(&failures).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(failures) == 0 {
+		fmt.Println("No failed logins recorded")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tUSERNAME\tCLIENT_IP\tREASON\tUSER_AGENT")
+	for _, f := range failures {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.CreatedAt.Format("2006-01-02 15:04:05"), f.Username, f.ClientIP, f.Reason, f.UserAgent)
+	}
+	w.Flush()
+}
@@ -6,12 +6,15 @@ import (
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
 	"wire-socket-server/internal/nat"
+	"wire-socket-server/internal/quota"
 	"wire-socket-server/internal/route"
+	"wire-socket-server/internal/serverconfig"
 
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/yaml.v3"
 )
 
 // ServiceMode represents which service wsctl is managing
@@ -25,6 +28,10 @@ const (
 
 // Config represents the combined configuration (for mode detection)
 type Config struct {
+	Server struct {
+		Address string    `yaml:"address"`
+		TLS     *struct{} `yaml:"tls"` // Only checked for presence
+	} `yaml:"server"`
 	Database struct {
 		Path string `yaml:"path"`
 	} `yaml:"database"`
@@ -109,6 +116,14 @@ func runServerMode(config *Config, cmd string, args []string) {
 		handleNATCommand(db, config, args)
 	case "group", "groups":
 		handleGroupCommand(db, args)
+	case "apikey", "apikeys":
+		handleAPIKeyCommand(db, args)
+	case "peer", "peers":
+		handleServerPeerCommand(db, config, args)
+	case "usage":
+		handleUsageCommand(db, args)
+	case "audit":
+		listAuditLog(db.DB, args)
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -132,6 +147,8 @@ func runAuthMode(config *Config, cmd string, args []string) {
 		handleAuthUserCommand(db, args)
 	case "tunnel", "tunnels":
 		handleTunnelCommand(db, args)
+	case "audit":
+		listAuditLog(db.DB, args)
 	case "help", "-h", "--help":
 		printAuthUsage()
 	default:
@@ -157,6 +174,8 @@ func runTunnelMode(config *Config, cmd string, args []string) {
 		handleTunnelNATCommand(db, config, args)
 	case "peer", "peers":
 		handlePeerCommand(db, args)
+	case "audit":
+		listAuditLog(db.DB, args)
 	case "help", "-h", "--help":
 		printTunnelUsage()
 	default:
@@ -166,17 +185,26 @@ func runTunnelMode(config *Config, cmd string, args []string) {
 	}
 }
 
+// loadConfig reads the config file with the overrides the services apply
+// (WIRESOCKET_* variables and *_file secrets), so that wsctl opens the same
+// database and signs with the same JWT secret. Server configs must also pass
+// the server's validation; the example JWT secret is accepted, as the server
+// refuses it itself outside development.
 func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+	if err := serverconfig.LoadInto(path, &config, false); err != nil {
+		return nil, err
 	}
 
+	if detectMode(&config) == ModeServer {
+		server, err := serverconfig.Load(path)
+		if err == nil {
+			err = serverconfig.Validate(server, true)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid server configuration: %w", err)
+		}
+	}
 	return &config, nil
 }
 
@@ -189,7 +217,7 @@ Commands:
   user list [--sort=<field>]    List all users
     --sort=id|username|email|created_at  Sort by field (prefix with - for desc)
   user get <id>                 Get user details
-  user create <username> <email> <password> [--admin]
+  user create <username> <email> <password> [--admin] [--role=<role>]
                                 Create a new user
   user update <id> [options]    Update user
     --username=<name>           Set username
@@ -197,7 +225,14 @@ Commands:
     --password=<pwd>            Set password
     --active=true|false         Set active status
     --admin=true|false          Set admin status
+    --role=<role>               Set admin role (viewer|user-manager|network-admin|superadmin, empty to clear)
+    --quota=<size>              Monthly data quota, e.g. 50G (0 = inherit from groups)
+    --rate-limit=<kbps>         Tunnel bandwidth limit in kbit/s (0 = inherit from groups)
   user delete <id>              Delete a user
+  user scope <id> [group_ids]   Limit a user-manager to members of groups (comma-separated, empty to clear)
+  user unlock <id>              Clear a lockout after too many failed logins
+  user failures [options]       Show recent failed logins
+    --user=<name> --ip=<addr> --limit=<n>
 
   route list [--sort=<field>]   List all routes
     --sort=id|cidr|enabled|created_at    Sort by field (prefix with - for desc)
@@ -239,10 +274,14 @@ Commands:
     --sort=id|name|created_at            Sort by field (prefix with - for desc)
   group create <name> [options] Create a new group
     --description=<text>        Group description
+    --quota=<size>              Monthly data quota per member, e.g. 50G (0 = unlimited)
+    --rate-limit=<kbps>         Tunnel bandwidth limit per member in kbit/s (0 = unlimited)
   group get <id>                Get group details (with users/routes)
   group update <id> [options]   Update group
     --name=<name>               Set name
     --description=<text>        Set description
+    --quota=<size>              Set monthly data quota per member
+    --rate-limit=<kbps>         Set bandwidth limit per member
   group delete <id>             Delete a group
   group add-user <group_id> <user_id>
                                 Add user to group
@@ -253,18 +292,45 @@ Commands:
   group remove-route <group_id> <route_id>
                                 Remove route from group
 
+  apikey list                   List all API keys
+  apikey create <name> --scopes=<scope,...> [--expires=<duration>]
+                                Create an API key (e.g., --expires=90d)
+  apikey revoke <id>            Revoke an API key
+  apikey logs <id>              Show recent requests made with an API key
+  apikey scopes                 List available scopes
+
+  peer list [--live] [--all]    List WireGuard peers (--live asks the running server
+                                for connected peers; --all includes idle peers)
+  peer kick <id>                Disconnect a peer and close its tunnel (asks the running server)
+
+  usage report [options]        Show traffic history (default: last 30 days, per user and day)
+    --user=<id|name>            Only this user
+    --from=<date> --to=<date>   Period (YYYY-MM-DD or RFC 3339)
+    --bucket=hour|day|month     Aggregation
+    --by-device                 One row per device instead of per user
+    --csv                       Output CSV
+
+  audit [options]               Show recent administrative changes
+    --actor=<name> --action=<action|prefix*> --target=<type[:id]> --limit=<n>
+
 Environment:
   WSCTL_CONFIG                  Config file path (default: config.yaml)
+  WSCTL_SERVER_URL              Running server URL for live commands (default: from server.address)
 
 Examples:
   wsctl user list
   wsctl user create alice alice@example.com secret123 --admin
+  wsctl user create bob bob@example.com secret123 --role=user-manager
+  wsctl user scope 2 1,3
   wsctl route create 192.168.1.0/24 "Internal network"
   wsctl nat create masquerade --interface=eth0
   wsctl nat apply
   wsctl group create developers --description="Dev team"
   wsctl group add-user 1 2
-  wsctl group add-route 1 3`)
+  wsctl group add-route 1 3
+  wsctl group create contractors --quota=50G --rate-limit=20000
+  wsctl usage report --bucket=month --user=alice
+  wsctl apikey create ci-bot --scopes=routes:read,routes:write,routes:apply --expires=90d`)
 }
 
 // ============ User Commands ============
@@ -285,11 +351,11 @@ func handleUserCommand(db *database.DB, args []string) {
 		getUser(db, args[1])
 	case "create", "add":
 		if len(args) < 4 {
-			fmt.Fprintln(os.Stderr, "Usage: wsctl user create <username> <email> <password> [--admin]")
+			fmt.Fprintln(os.Stderr, "Usage: wsctl user create <username> <email> <password> [--admin] [--role=<role>]")
 			os.Exit(1)
 		}
 		isAdmin := contains(args, "--admin")
-		createUser(db, args[1], args[2], args[3], isAdmin)
+		createUser(db, args[1], args[2], args[3], isAdmin, parseRoleOption(args))
 	case "update", "edit":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "Usage: wsctl user update <id> [options]")
@@ -302,6 +368,24 @@ func handleUserCommand(db *database.DB, args []string) {
 			os.Exit(1)
 		}
 		deleteUser(db, args[1])
+	case "scope":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl user scope <id> [group_ids]")
+			os.Exit(1)
+		}
+		groupIDs := ""
+		if len(args) > 2 {
+			groupIDs = args[2]
+		}
+		setUserScope(db, args[1], groupIDs)
+	case "unlock":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl user unlock <id>")
+			os.Exit(1)
+		}
+		unlockUser(db.DB, args[1])
+	case "failures":
+		listLoginFailures(db.DB, args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown user subcommand: %s\n", args[0])
 		os.Exit(1)
@@ -319,9 +403,9 @@ func listUsers(db *database.DB, args []string) {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tACTIVE\tADMIN")
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tACTIVE\tADMIN\tROLE")
 	for _, u := range users {
-		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\n", u.ID, u.Username, u.Email, u.IsActive, u.IsAdmin)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\t%s\n", u.ID, u.Username, u.Email, u.IsActive, u.IsAdmin, auth.EffectiveRole(u.IsAdmin, u.Role))
 	}
 	w.Flush()
 }
@@ -344,11 +428,16 @@ func getUser(db *database.DB, idStr string) {
 	fmt.Printf("Email:    %s\n", user.Email)
 	fmt.Printf("Active:   %v\n", user.IsActive)
 	fmt.Printf("Admin:    %v\n", user.IsAdmin)
+	fmt.Printf("Role:     %s\n", auth.EffectiveRole(user.IsAdmin, user.Role))
+	if status, err := quota.Check(db.DB, user.ID, time.Now()); err == nil {
+		fmt.Printf("Quota:    %s (used %s, resets %s)\n", formatQuota(status.QuotaBytes), formatBytes(status.UsedBytes), status.ResetsAt.Format("2006-01-02"))
+		fmt.Printf("Rate:     %s\n", formatRateLimit(status.RateLimitKbps))
+	}
 	fmt.Printf("Created:  %s\n", user.CreatedAt.Format("2006-01-02 15:04:05"))
 	fmt.Printf("Updated:  %s\n", user.UpdatedAt.Format("2006-01-02 15:04:05"))
 }
 
-func createUser(db *database.DB, username, email, password string, isAdmin bool) {
+func createUser(db *database.DB, username, email, password string, isAdmin bool, role string) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error hashing password: %v\n", err)
@@ -361,6 +450,7 @@ func createUser(db *database.DB, username, email, password string, isAdmin bool)
 		PasswordHash: string(hashedPassword),
 		IsActive:     true,
 		IsAdmin:      isAdmin,
+		Role:         role,
 	}
 
 	if err := db.Create(&user).Error; err != nil {
@@ -368,6 +458,8 @@ func createUser(db *database.DB, username, email, password string, isAdmin bool)
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.create", "user", user.ID, nil, user)
+
 	fmt.Printf("User created: ID=%d, Username=%s\n", user.ID, user.Username)
 }
 
@@ -383,6 +475,7 @@ func updateUser(db *database.DB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "User not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := user
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--username=") {
@@ -401,6 +494,10 @@ func updateUser(db *database.DB, idStr string, opts []string) {
 			user.IsActive = strings.TrimPrefix(opt, "--active=") == "true"
 		} else if strings.HasPrefix(opt, "--admin=") {
 			user.IsAdmin = strings.TrimPrefix(opt, "--admin=") == "true"
+		} else if strings.HasPrefix(opt, "--role=") {
+			user.Role = parseRoleOption([]string{opt})
+		} else {
+			parseLimitOption(opt, &user.QuotaBytes, &user.RateLimitKbps)
 		}
 	}
 
@@ -409,6 +506,8 @@ func updateUser(db *database.DB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.update", "user", user.ID, before, user)
+
 	fmt.Printf("User updated: ID=%d\n", user.ID)
 }
 
@@ -434,9 +533,78 @@ func deleteUser(db *database.DB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.delete", "user", user.ID, user, nil)
+
 	fmt.Printf("User deleted: ID=%d\n", user.ID)
 }
 
+// parseRoleOption returns the value of a --role= option, exiting on an unknown role
+func parseRoleOption(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--role=") {
+			role := strings.TrimPrefix(arg, "--role=")
+			if role != "" && !auth.ValidRole(role) {
+				fmt.Fprintf(os.Stderr, "Invalid role: %s (valid: %s)\n", role, strings.Join(auth.AllRoles, ", "))
+				os.Exit(1)
+			}
+			return role
+		}
+	}
+	return ""
+}
+
+func setUserScope(db *database.DB, idStr, groupIDsStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", idStr)
+		os.Exit(1)
+	}
+
+	var user database.User
+	if err := db.First(&user, id).Error; err != nil {
+		fmt.Fprintf(os.Stderr, "User not found: %v\n", err)
+		os.Exit(1)
+	}
+
+	var groupIDs []uint
+	for _, s := range strings.Split(groupIDsStr, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		gid, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid group ID: %s\n", s)
+			os.Exit(1)
+		}
+		var group database.Group
+		if err := db.First(&group, gid).Error; err != nil {
+			fmt.Fprintf(os.Stderr, "Group not found: %d\n", gid)
+			os.Exit(1)
+		}
+		groupIDs = append(groupIDs, uint(gid))
+	}
+
+	var before []uint
+	db.Model(&database.AdminGroupScope{}).Where("user_id = ?", user.ID).Pluck("group_id", &before)
+
+	db.Where("user_id = ?", user.ID).Delete(&database.AdminGroupScope{})
+	for _, gid := range groupIDs {
+		if err := db.Create(&database.AdminGroupScope{UserID: user.ID, GroupID: gid}).Error; err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting scope: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	recordAudit(db.DB, "user.set_admin_groups", "user", user.ID, map[string]interface{}{"group_ids": before}, map[string]interface{}{"group_ids": groupIDs})
+
+	if len(groupIDs) == 0 {
+		fmt.Printf("User %d may manage all users\n", user.ID)
+	} else {
+		fmt.Printf("User %d may manage members of %d group(s)\n", user.ID, len(groupIDs))
+	}
+}
+
 // ============ Route Commands ============
 
 func handleRouteCommand(db *database.DB, config *Config, args []string) {
@@ -546,6 +714,8 @@ func createRoute(db *database.DB, cidr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.create", "route", route.ID, nil, route)
+
 	fmt.Printf("Route created: ID=%d, CIDR=%s, PushToClient=%v, ApplyOnServer=%v\n", route.ID, route.CIDR, route.PushToClient, route.ApplyOnServer)
 }
 
@@ -561,6 +731,7 @@ func updateRoute(db *database.DB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "Route not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := route
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--cidr=") {
@@ -588,6 +759,8 @@ func updateRoute(db *database.DB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.update", "route", route.ID, before, route)
+
 	fmt.Printf("Route updated: ID=%d\n", route.ID)
 }
 
@@ -609,6 +782,8 @@ func deleteRoute(db *database.DB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.delete", "route", route.ID, route, nil)
+
 	fmt.Printf("Route deleted: ID=%d\n", route.ID)
 }
 
@@ -647,6 +822,8 @@ func applyRoutes(db *database.DB, config *Config) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.apply", "route", nil, nil, map[string]interface{}{"routes_count": len(routes)})
+
 	fmt.Printf("Routes applied: %d routes\n", len(routes))
 }
 
@@ -784,6 +961,8 @@ func createNATRule(db *database.DB, ruleType string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.create", "nat_rule", rule.ID, nil, rule)
+
 	fmt.Printf("NAT rule created: ID=%d, Type=%s\n", rule.ID, rule.Type)
 }
 
@@ -799,6 +978,7 @@ func updateNATRule(db *database.DB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "NAT rule not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := rule
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--interface=") {
@@ -831,6 +1011,8 @@ func updateNATRule(db *database.DB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.update", "nat_rule", rule.ID, before, rule)
+
 	fmt.Printf("NAT rule updated: ID=%d\n", rule.ID)
 }
 
@@ -852,6 +1034,8 @@ func deleteNATRule(db *database.DB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.delete", "nat_rule", rule.ID, rule, nil)
+
 	fmt.Printf("NAT rule deleted: ID=%d\n", rule.ID)
 }
 
@@ -908,6 +1092,8 @@ func applyNATRules(db *database.DB, config *Config) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.apply", "nat_rule", nil, nil, map[string]interface{}{"rules_count": len(rules)})
+
 	fmt.Printf("NAT rules applied: %d masquerade, %d SNAT, %d DNAT, %d TCPMSS\n",
 		len(natConfig.Masquerade), len(natConfig.SNAT), len(natConfig.DNAT), len(natConfig.TCPMSS))
 }
@@ -1065,6 +1251,8 @@ func getGroup(db *database.DB, idStr string) {
 	fmt.Printf("ID:          %d\n", group.ID)
 	fmt.Printf("Name:        %s\n", group.Name)
 	fmt.Printf("Description: %s\n", group.Description)
+	fmt.Printf("Quota:       %s\n", formatQuota(group.QuotaBytes))
+	fmt.Printf("Rate limit:  %s\n", formatRateLimit(group.RateLimitKbps))
 	fmt.Printf("Created:     %s\n", group.CreatedAt.Format("2006-01-02 15:04:05"))
 	fmt.Printf("Updated:     %s\n", group.UpdatedAt.Format("2006-01-02 15:04:05"))
 
@@ -1105,6 +1293,8 @@ func createGroup(db *database.DB, name string, opts []string) {
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--description=") {
 			group.Description = strings.TrimPrefix(opt, "--description=")
+		} else {
+			parseLimitOption(opt, &group.QuotaBytes, &group.RateLimitKbps)
 		}
 	}
 
@@ -1113,6 +1303,8 @@ func createGroup(db *database.DB, name string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.create", "group", group.ID, nil, group)
+
 	fmt.Printf("Group created: ID=%d, Name=%s\n", group.ID, group.Name)
 }
 
@@ -1128,12 +1320,15 @@ func updateGroup(db *database.DB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "Group not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := group
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--name=") {
 			group.Name = strings.TrimPrefix(opt, "--name=")
 		} else if strings.HasPrefix(opt, "--description=") {
 			group.Description = strings.TrimPrefix(opt, "--description=")
+		} else {
+			parseLimitOption(opt, &group.QuotaBytes, &group.RateLimitKbps)
 		}
 	}
 
@@ -1142,6 +1337,8 @@ func updateGroup(db *database.DB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.update", "group", group.ID, before, group)
+
 	fmt.Printf("Group updated: ID=%d\n", group.ID)
 }
 
@@ -1167,6 +1364,8 @@ func deleteGroup(db *database.DB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.delete", "group", group.ID, group, nil)
+
 	fmt.Printf("Group deleted: ID=%d\n", group.ID)
 }
 
@@ -1206,6 +1405,8 @@ func addUserToGroup(db *database.DB, groupIDStr, userIDStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.add_user", "group", group.ID, nil, map[string]interface{}{"user_id": user.ID})
+
 	fmt.Printf("User %s added to group %s\n", user.Username, group.Name)
 }
 
@@ -1232,6 +1433,8 @@ func removeUserFromGroup(db *database.DB, groupIDStr, userIDStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.remove_user", "group", groupID, map[string]interface{}{"user_id": userID}, nil)
+
 	fmt.Printf("User removed from group\n")
 }
 
@@ -1271,6 +1474,8 @@ func addRouteToGroup(db *database.DB, groupIDStr, routeIDStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.add_route", "group", group.ID, nil, map[string]interface{}{"route_id": route.ID})
+
 	fmt.Printf("Route %s added to group %s\n", route.CIDR, group.Name)
 }
 
@@ -1297,6 +1502,8 @@ func removeRouteFromGroup(db *database.DB, groupIDStr, routeIDStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "group.remove_route", "group", groupID, map[string]interface{}{"route_id": routeID}, nil)
+
 	fmt.Printf("Route removed from group\n")
 }
 
@@ -1312,13 +1519,16 @@ Commands:
 
   user list                     List all users
   user get <id>                 Get user details
-  user create <username> <email> <password> [--admin]
+  user create <username> <email> <password> [--admin] [--role=<role>]
                                 Create a new user
-  user update <id> [options]    Update user
+  user update <id> [options]    Update user (--role=<role> sets admin role)
   user delete <id>              Delete a user
   user tunnels <id>             List user's tunnel access
   user set-tunnels <id> <tunnel_ids>
                                 Set user's tunnel access (comma-separated)
+  user unlock <id>              Clear a lockout after too many failed logins
+  user failures [--user=<name>] [--ip=<addr>] [--limit=<n>]
+                                Show recent failed logins
 
   tunnel list                   List all registered tunnels
   tunnel get <id>               Get tunnel details
@@ -1326,6 +1536,9 @@ Commands:
   tunnel set-active <id> <true|false>
                                 Enable/disable a tunnel
 
+  audit [--actor=<name>] [--action=<action|prefix*>] [--target=<type[:id]>] [--limit=<n>]
+                                Show recent administrative changes
+
 Environment:
   WSCTL_CONFIG                  Config file path (default: config.yaml)`)
 }
@@ -1346,11 +1559,11 @@ func handleAuthUserCommand(db *database.AuthDB, args []string) {
 		getAuthUser(db, args[1])
 	case "create", "add":
 		if len(args) < 4 {
-			fmt.Fprintln(os.Stderr, "Usage: wsctl user create <username> <email> <password> [--admin]")
+			fmt.Fprintln(os.Stderr, "Usage: wsctl user create <username> <email> <password> [--admin] [--role=<role>]")
 			os.Exit(1)
 		}
 		isAdmin := contains(args, "--admin")
-		createAuthUser(db, args[1], args[2], args[3], isAdmin)
+		createAuthUser(db, args[1], args[2], args[3], isAdmin, parseRoleOption(args))
 	case "update", "edit":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "Usage: wsctl user update <id> [options]")
@@ -1375,6 +1588,14 @@ func handleAuthUserCommand(db *database.AuthDB, args []string) {
 			os.Exit(1)
 		}
 		setUserTunnels(db, args[1], args[2])
+	case "unlock":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: wsctl user unlock <id>")
+			os.Exit(1)
+		}
+		unlockUser(db.DB, args[1])
+	case "failures":
+		listLoginFailures(db.DB, args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown user subcommand: %s\n", args[0])
 		os.Exit(1)
@@ -1389,9 +1610,9 @@ func listAuthUsers(db *database.AuthDB) {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tACTIVE\tADMIN")
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tACTIVE\tADMIN\tROLE")
 	for _, u := range users {
-		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\n", u.ID, u.Username, u.Email, u.IsActive, u.IsAdmin)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\t%s\n", u.ID, u.Username, u.Email, u.IsActive, u.IsAdmin, auth.EffectiveRole(u.IsAdmin, u.Role))
 	}
 	w.Flush()
 }
@@ -1414,10 +1635,11 @@ func getAuthUser(db *database.AuthDB, idStr string) {
 	fmt.Printf("Email:    %s\n", user.Email)
 	fmt.Printf("Active:   %v\n", user.IsActive)
 	fmt.Printf("Admin:    %v\n", user.IsAdmin)
+	fmt.Printf("Role:     %s\n", auth.EffectiveRole(user.IsAdmin, user.Role))
 	fmt.Printf("Created:  %s\n", user.CreatedAt.Format("2006-01-02 15:04:05"))
 }
 
-func createAuthUser(db *database.AuthDB, username, email, password string, isAdmin bool) {
+func createAuthUser(db *database.AuthDB, username, email, password string, isAdmin bool, role string) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error hashing password: %v\n", err)
@@ -1430,6 +1652,7 @@ func createAuthUser(db *database.AuthDB, username, email, password string, isAdm
 		PasswordHash: string(hashedPassword),
 		IsActive:     true,
 		IsAdmin:      isAdmin,
+		Role:         role,
 	}
 
 	if err := db.Create(&user).Error; err != nil {
@@ -1437,6 +1660,8 @@ func createAuthUser(db *database.AuthDB, username, email, password string, isAdm
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.create", "user", user.ID, nil, user)
+
 	fmt.Printf("User created: ID=%d, Username=%s\n", user.ID, user.Username)
 }
 
@@ -1452,6 +1677,7 @@ func updateAuthUser(db *database.AuthDB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "User not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := user
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--username=") {
@@ -1470,6 +1696,8 @@ func updateAuthUser(db *database.AuthDB, idStr string, opts []string) {
 			user.IsActive = strings.TrimPrefix(opt, "--active=") == "true"
 		} else if strings.HasPrefix(opt, "--admin=") {
 			user.IsAdmin = strings.TrimPrefix(opt, "--admin=") == "true"
+		} else if strings.HasPrefix(opt, "--role=") {
+			user.Role = parseRoleOption([]string{opt})
 		}
 	}
 
@@ -1478,6 +1706,8 @@ func updateAuthUser(db *database.AuthDB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.update", "user", user.ID, before, user)
+
 	fmt.Printf("User updated: ID=%d\n", user.ID)
 }
 
@@ -1503,6 +1733,8 @@ func deleteAuthUser(db *database.AuthDB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "user.delete", "user", user.ID, user, nil)
+
 	fmt.Printf("User deleted: ID=%d\n", user.ID)
 }
 
@@ -1537,6 +1769,8 @@ func setUserTunnels(db *database.AuthDB, idStr, tunnelIDs string) {
 		os.Exit(1)
 	}
 
+	before, _ := db.GetUserAllowedTunnels(uint(id))
+
 	// Delete existing access
 	db.Where("user_id = ?", id).Delete(&database.UserTunnelAccess{})
 
@@ -1556,6 +1790,9 @@ func setUserTunnels(db *database.AuthDB, idStr, tunnelIDs string) {
 		}
 	}
 
+	after, _ := db.GetUserAllowedTunnels(uint(id))
+	recordAudit(db.DB, "user.set_tunnels", "user", id, map[string]interface{}{"tunnel_ids": before}, map[string]interface{}{"tunnel_ids": after})
+
 	fmt.Printf("User tunnel access updated\n")
 }
 
@@ -1647,6 +1884,8 @@ func deleteTunnel(db *database.AuthDB, id string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "tunnel.delete", "tunnel", tunnel.ID, tunnel, nil)
+
 	fmt.Printf("Tunnel deleted: ID=%s\n", id)
 }
 
@@ -1656,6 +1895,7 @@ func setTunnelActive(db *database.AuthDB, id, activeStr string) {
 		fmt.Fprintf(os.Stderr, "Tunnel not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := tunnel
 
 	tunnel.IsActive = activeStr == "true"
 	if err := db.Save(&tunnel).Error; err != nil {
@@ -1663,6 +1903,8 @@ func setTunnelActive(db *database.AuthDB, id, activeStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "tunnel.update", "tunnel", tunnel.ID, before, tunnel)
+
 	fmt.Printf("Tunnel %s active=%v\n", id, tunnel.IsActive)
 }
 
@@ -1691,6 +1933,8 @@ Commands:
   peer list                     List allocated IPs/peers
   peer delete <id>              Delete a peer allocation
 
+  audit [options]               Show recent administrative changes
+
 Environment:
   WSCTL_CONFIG                  Config file path (default: config.yaml)`)
 }
@@ -1785,6 +2029,8 @@ func createTunnelRoute(db *database.TunnelDB, cidr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.create", "route", route.ID, nil, route)
+
 	fmt.Printf("Route created: ID=%d, CIDR=%s\n", route.ID, route.CIDR)
 }
 
@@ -1800,6 +2046,7 @@ func updateTunnelRoute(db *database.TunnelDB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "Route not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := r
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--cidr=") {
@@ -1827,6 +2074,8 @@ func updateTunnelRoute(db *database.TunnelDB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.update", "route", r.ID, before, r)
+
 	fmt.Printf("Route updated: ID=%d\n", r.ID)
 }
 
@@ -1848,6 +2097,8 @@ func deleteTunnelRoute(db *database.TunnelDB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.delete", "route", r.ID, r, nil)
+
 	fmt.Printf("Route deleted: ID=%d\n", r.ID)
 }
 
@@ -1884,6 +2135,8 @@ func applyTunnelRoutes(db *database.TunnelDB, config *Config) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "route.apply", "route", nil, nil, map[string]interface{}{"routes_count": len(routes)})
+
 	fmt.Printf("Routes applied: %d routes\n", len(routes))
 }
 
@@ -1990,6 +2243,8 @@ func createTunnelNATRule(db *database.TunnelDB, ruleType string, opts []string)
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.create", "nat_rule", rule.ID, nil, rule)
+
 	fmt.Printf("NAT rule created: ID=%d, Type=%s\n", rule.ID, rule.Type)
 }
 
@@ -2005,6 +2260,7 @@ func updateTunnelNATRule(db *database.TunnelDB, idStr string, opts []string) {
 		fmt.Fprintf(os.Stderr, "NAT rule not found: %v\n", err)
 		os.Exit(1)
 	}
+	before := rule
 
 	for _, opt := range opts {
 		if strings.HasPrefix(opt, "--interface=") {
@@ -2035,6 +2291,8 @@ func updateTunnelNATRule(db *database.TunnelDB, idStr string, opts []string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.update", "nat_rule", rule.ID, before, rule)
+
 	fmt.Printf("NAT rule updated: ID=%d\n", rule.ID)
 }
 
@@ -2056,6 +2314,8 @@ func deleteTunnelNATRule(db *database.TunnelDB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.delete", "nat_rule", rule.ID, rule, nil)
+
 	fmt.Printf("NAT rule deleted: ID=%d\n", rule.ID)
 }
 
@@ -2103,6 +2363,8 @@ func applyTunnelNATRules(db *database.TunnelDB) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "nat.apply", "nat_rule", nil, nil, map[string]interface{}{"rules_count": len(rules)})
+
 	fmt.Printf("NAT rules applied: %d masquerade, %d SNAT, %d DNAT, %d TCPMSS\n",
 		len(natConfig.Masquerade), len(natConfig.SNAT), len(natConfig.DNAT), len(natConfig.TCPMSS))
 }
@@ -2172,6 +2434,8 @@ func deletePeer(db *database.TunnelDB, idStr string) {
 		os.Exit(1)
 	}
 
+	recordAudit(db.DB, "peer.delete", "peer", peer.ID, peer, nil)
+
 	fmt.Printf("Peer deleted: ID=%d, IP=%s\n", peer.ID, peer.IP)
 }
 
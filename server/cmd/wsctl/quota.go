@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the suffixes accepted by parseByteSize (powers of 1024)
+var byteUnits = map[string]int64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// parseByteSize parses sizes like 500M, 10G or 1.5T; 0 means unlimited
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	unit := ""
+	if s != "" {
+		if last := s[len(s)-1:]; byteUnits[last] > 0 {
+			unit = last
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 500M, 10G, 0 for unlimited)", s+unit)
+	}
+	return int64(n * float64(byteUnits[unit])), nil
+}
+
+// parseLimitOption handles --quota= and --rate-limit= and reports whether
+// opt was one of them. Invalid values exit.
+func parseLimitOption(opt string, quotaBytes *int64, rateLimitKbps *int) bool {
+	if strings.HasPrefix(opt, "--quota=") {
+		v, err := parseByteSize(strings.TrimPrefix(opt, "--quota="))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --quota: %v\n", err)
+			os.Exit(1)
+		}
+		*quotaBytes = v
+		return true
+	}
+	if strings.HasPrefix(opt, "--rate-limit=") {
+		v, err := strconv.Atoi(strings.TrimPrefix(opt, "--rate-limit="))
+		if err != nil || v < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid --rate-limit: %s (kbit/s, 0 for unlimited)\n", strings.TrimPrefix(opt, "--rate-limit="))
+			os.Exit(1)
+		}
+		*rateLimitKbps = v
+		return true
+	}
+	return false
+}
+
+// formatQuota formats a quota for display
+func formatQuota(quotaBytes int64) string {
+	if quotaBytes <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(quotaBytes)
+}
+
+// formatRateLimit formats a rate limit for display
+func formatRateLimit(kbps int) string {
+	if kbps <= 0 {
+		return "unlimited"
+	}
+	if kbps >= 1000 && kbps%1000 == 0 {
+		return fmt.Sprintf("%d Mbit/s", kbps/1000)
+	}
+	return fmt.Sprintf("%d kbit/s", kbps)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/usage"
+)
+
+// ============ Usage Commands ============
+
+func handleUsageCommand(db *database.DB, args []string) {
+	if len(args) == 0 {
+		args = []string{"report"}
+	}
+
+	switch args[0] {
+	case "report":
+		usageReport(db, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown usage subcommand: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func usageReport(db *database.DB, opts []string) {
+	f := usage.Filter{
+		From:   time.Now().AddDate(0, 0, -30),
+		Bucket: usage.BucketDay,
+	}
+	csvOutput := false
+
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--user=") {
+			v := strings.TrimPrefix(opt, "--user=")
+			var user database.User
+			query := db.Where("username = ?", v)
+			if id, err := strconv.ParseUint(v, 10, 32); err == nil {
+				query = db.Where("id = ?", id)
+			}
+			if err := query.First(&user).Error; err != nil {
+				fmt.Fprintf(os.Stderr, "User not found: %s\n", v)
+				os.Exit(1)
+			}
+			f.UserIDs = []uint{user.ID}
+		} else if strings.HasPrefix(opt, "--from=") || strings.HasPrefix(opt, "--to=") {
+			name, value, _ := strings.Cut(strings.TrimPrefix(opt, "--"), "=")
+			t, err := usage.ParseTime(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --%s: %v\n", name, err)
+				os.Exit(1)
+			}
+			if name == "from" {
+				f.From = t
+			} else {
+				f.To = t
+			}
+		} else if strings.HasPrefix(opt, "--bucket=") {
+			f.Bucket = strings.TrimPrefix(opt, "--bucket=")
+			if !usage.ValidBucket(f.Bucket) {
+				fmt.Fprintf(os.Stderr, "Invalid bucket: %s (use hour, day or month)\n", f.Bucket)
+				os.Exit(1)
+			}
+		} else if opt == "--by-device" {
+			f.ByDevice = true
+		} else if opt == "--csv" {
+			csvOutput = true
+		}
+	}
+
+	rows, err := usage.Query(db.DB, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if csvOutput {
+		if err := usage.WriteCSV(os.Stdout, rows, f.ByDevice); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No usage recorded for this period")
+		return
+	}
+
+	layout := "2006-01-02"
+	switch f.Bucket {
+	case usage.BucketHour:
+		layout = "2006-01-02 15:00"
+	case usage.BucketMonth:
+		layout = "2006-01"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if f.ByDevice {
+		fmt.Fprintln(w, "PERIOD\tUSERNAME\tDEVICE_IP\tRX (UPLOAD)\tTX (DOWNLOAD)")
+	} else {
+		fmt.Fprintln(w, "PERIOD\tUSERNAME\tRX (UPLOAD)\tTX (DOWNLOAD)")
+	}
+	for _, r := range rows {
+		username := r.Username
+		if username == "" {
+			username = fmt.Sprintf("(deleted #%d)", r.UserID)
+		}
+		if f.ByDevice {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Bucket.UTC().Format(layout), username, r.DeviceIP, formatBytes(r.RxBytes), formatBytes(r.TxBytes))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Bucket.UTC().Format(layout), username, formatBytes(r.RxBytes), formatBytes(r.TxBytes))
+		}
+	}
+	rx, tx := usage.Totals(rows)
+	if f.ByDevice {
+		fmt.Fprintf(w, "TOTAL\t\t\t%s\t%s\n", formatBytes(rx), formatBytes(tx))
+	} else {
+		fmt.Fprintf(w, "TOTAL\t\t%s\t%s\n", formatBytes(rx), formatBytes(tx))
+	}
+	w.Flush()
+}
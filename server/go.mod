@@ -8,11 +8,18 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/gorilla/websocket v1.5.1
 	golang.org/x/crypto v0.46.0
+	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20241231184526-a9ab2273dd10
 	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/gorm v1.31.1
+	github.com/k0ngk0ng/wire-socket/pkg/wstunnel v0.0.0
+	wire-socket/pkg/metrics v0.0.0
 	wire-socket/pkg/wireguard v0.0.0
 )
 
+replace github.com/k0ngk0ng/wire-socket/pkg/wstunnel => ../pkg/wstunnel
+
+replace wire-socket/pkg/metrics => ../pkg/metrics
+
 replace wire-socket/pkg/wireguard => ../pkg/wireguard
 
 require (
@@ -58,7 +65,6 @@ require (
 	golang.org/x/tools v0.39.0 // indirect
 	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
 	golang.zx2c4.com/wireguard v0.0.0-20231211153847-12269c276173 // indirect
-	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20241231184526-a9ab2273dd10 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
 	modernc.org/libc v1.22.5 // indirect
 	modernc.org/mathutil v1.5.0 // indirect
@@ -3,9 +3,17 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"time"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/loginguard"
 	"wire-socket-server/internal/nat"
+	"wire-socket-server/internal/quota"
 	"wire-socket-server/internal/route"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/wireguard"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -16,6 +24,10 @@ type AdminHandler struct {
 	db            *database.DB
 	natManager    *nat.Manager
 	routeManager  *route.Manager
+	loginGuard    *loginguard.Guard
+	wgManager     *wireguard.Manager
+	tunnelServer  *tunnel.Server
+	events        *events.Bus
 	defaultDevice string
 }
 
@@ -33,12 +45,50 @@ func (h *AdminHandler) SetNATManager(natManager *nat.Manager) {
 	h.natManager = natManager
 }
 
+// SetLoginGuard sets the login guard cleared when unlocking users
+func (h *AdminHandler) SetLoginGuard(guard *loginguard.Guard) {
+	h.loginGuard = guard
+}
+
 // ============ User Management ============
 
-// ListUsers returns all users
+// canManageUser reports whether the current admin may manage the given user.
+// Group-scoped admins may only manage members of their groups.
+func (h *AdminHandler) canManageUser(c *gin.Context, userID uint) bool {
+	groupIDs, scoped := auth.ManagedGroupIDs(c)
+	if !scoped {
+		return true
+	}
+
+	var count int64
+	h.db.Model(&database.UserGroup{}).Where("user_id = ? AND group_id IN ?", userID, groupIDs).Count(&count)
+	return count > 0
+}
+
+// canManageGroupMembers reports whether the current admin may change the members of a group
+func (h *AdminHandler) canManageGroupMembers(c *gin.Context, groupID uint) bool {
+	groupIDs, scoped := auth.ManagedGroupIDs(c)
+	if !scoped {
+		return true
+	}
+
+	for _, id := range groupIDs {
+		if id == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListUsers returns all users (or, for group-scoped admins, members of their groups)
 func (h *AdminHandler) ListUsers(c *gin.Context) {
+	query := h.db.DB
+	if groupIDs, scoped := auth.ManagedGroupIDs(c); scoped {
+		query = query.Where("id IN (?)", h.db.Model(&database.UserGroup{}).Select("user_id").Where("group_id IN ?", groupIDs))
+	}
+
 	var users []database.User
-	if err := h.db.Find(&users).Error; err != nil {
+	if err := query.Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
 		return
 	}
@@ -60,7 +110,18 @@ func (h *AdminHandler) GetUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	if !h.canManageUser(c, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
+	quotaStatus, err := quota.Check(h.db.DB, user.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "quota": quotaStatus})
 }
 
 // UpdateUser updates a user
@@ -77,12 +138,21 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if !h.canManageUser(c, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+	before := user
+
 	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		IsActive *bool  `json:"is_active"`
-		IsAdmin  *bool  `json:"is_admin"`
+		Username      string  `json:"username"`
+		Email         string  `json:"email"`
+		Password      string  `json:"password"`
+		IsActive      *bool   `json:"is_active"`
+		IsAdmin       *bool   `json:"is_admin"`
+		Role          *string `json:"role"`
+		QuotaBytes    *int64  `json:"quota_bytes"`     // Monthly data quota (0 = inherit from groups)
+		RateLimitKbps *int    `json:"rate_limit_kbps"` // Tunnel bandwidth limit (0 = inherit from groups)
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -90,6 +160,24 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Changing privileges requires the roles:write permission
+	if (req.IsAdmin != nil || req.Role != nil) && !auth.HasPermission(c, auth.PermRolesWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite})
+		return
+	}
+	if !auth.CanManageAccount(c, user.IsAdmin, user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite + " is required to change an admin"})
+		return
+	}
+	if (req.QuotaBytes != nil && *req.QuotaBytes < 0) || (req.RateLimitKbps != nil && *req.RateLimitKbps < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quota_bytes and rate_limit_kbps must not be negative"})
+		return
+	}
+	if req.Role != nil && *req.Role != "" && !auth.ValidRole(*req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role: " + *req.Role})
+		return
+	}
+
 	// Update fields if provided
 	if req.Username != "" {
 		user.Username = req.Username
@@ -111,12 +199,23 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	if req.IsAdmin != nil {
 		user.IsAdmin = *req.IsAdmin
 	}
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
+	if req.QuotaBytes != nil {
+		user.QuotaBytes = *req.QuotaBytes
+	}
+	if req.RateLimitKbps != nil {
+		user.RateLimitKbps = *req.RateLimitKbps
+	}
 
 	if err := h.db.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.update", "user", user.ID, before, user)
+
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
@@ -134,6 +233,15 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if !h.canManageUser(c, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+	if !auth.CanManageAccount(c, user.IsAdmin, user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite + " is required to delete an admin"})
+		return
+	}
+
 	// Delete user's IP allocations
 	h.db.Where("user_id = ?", user.ID).Delete(&database.AllocatedIP{})
 
@@ -146,9 +254,67 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.delete", "user", user.ID, user, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "user deleted successfully"})
 }
 
+// UnlockUser clears a user's lockout and failed-login counter
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var user database.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if !h.canManageUser(c, user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
+	if err := h.db.Model(&user).Update("locked_until", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+		return
+	}
+	if h.loginGuard != nil {
+		h.loginGuard.Unlock(user.Username)
+	}
+
+	audit.Log(c, h.db.DB, "user.unlock", "user", user.ID, gin.H{"locked_until": user.LockedUntil}, gin.H{"locked_until": nil})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+}
+
+// ListLoginFailures returns recent failed logins, optionally filtered by username or IP
+func (h *AdminHandler) ListLoginFailures(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	query := h.db.Order("id DESC").Limit(limit)
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("client_ip = ?", ip)
+	}
+
+	var failures []database.LoginFailure
+	if err := query.Find(&failures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch login failures"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"failures": failures})
+}
+
 // ============ Route Management ============
 
 // ListRoutes returns all routes
@@ -211,6 +377,8 @@ func (h *AdminHandler) CreateRoute(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.create", "route", dbRoute.ID, nil, dbRoute)
+
 	c.JSON(http.StatusCreated, gin.H{"route": dbRoute})
 }
 
@@ -227,6 +395,7 @@ func (h *AdminHandler) UpdateRoute(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 		return
 	}
+	before := dbRoute
 
 	var req struct {
 		CIDR          string `json:"cidr"`
@@ -274,6 +443,8 @@ func (h *AdminHandler) UpdateRoute(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.update", "route", dbRoute.ID, before, dbRoute)
+
 	c.JSON(http.StatusOK, gin.H{"route": dbRoute})
 }
 
@@ -296,6 +467,8 @@ func (h *AdminHandler) DeleteRoute(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.delete", "route", route.ID, route, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "route deleted successfully"})
 }
 
@@ -359,12 +532,22 @@ func (h *AdminHandler) ApplyRoutes(c *gin.Context) {
 	// Update the manager reference
 	h.routeManager = newManager
 
+	audit.Log(c, h.db.DB, "route.apply", "route", nil, nil, gin.H{"routes_count": len(routes)})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "Routes applied successfully",
 		"routes_count": len(routes),
 	})
 }
 
+// CleanupRoutes removes the routes applied through ApplyRoutes (on shutdown)
+func (h *AdminHandler) CleanupRoutes() {
+	if h.routeManager != nil {
+		h.routeManager.Cleanup()
+		h.routeManager = nil
+	}
+}
+
 // ============ NAT Rule Management ============
 
 // ListNATRules returns all NAT rules
@@ -450,6 +633,8 @@ func (h *AdminHandler) CreateNATRule(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.create", "nat_rule", rule.ID, nil, rule)
+
 	c.JSON(http.StatusCreated, gin.H{"nat_rule": rule})
 }
 
@@ -466,6 +651,7 @@ func (h *AdminHandler) UpdateNATRule(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "NAT rule not found"})
 		return
 	}
+	before := rule
 
 	var req struct {
 		Comment       string `json:"comment"`
@@ -521,6 +707,8 @@ func (h *AdminHandler) UpdateNATRule(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.update", "nat_rule", rule.ID, before, rule)
+
 	c.JSON(http.StatusOK, gin.H{"nat_rule": rule})
 }
 
@@ -543,6 +731,8 @@ func (h *AdminHandler) DeleteNATRule(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.delete", "nat_rule", rule.ID, rule, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "NAT rule deleted successfully"})
 }
 
@@ -605,6 +795,8 @@ func (h *AdminHandler) ApplyNATRules(c *gin.Context) {
 	// Update the manager reference
 	*h.natManager = *newManager
 
+	audit.Log(c, h.db.DB, "nat.apply", "nat_rule", nil, nil, gin.H{"rules_count": len(rules)})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "NAT rules applied successfully",
 		"masquerade": len(config.Masquerade),
@@ -630,18 +822,26 @@ func (h *AdminHandler) ListGroups(c *gin.Context) {
 // CreateGroup creates a new group
 func (h *AdminHandler) CreateGroup(c *gin.Context) {
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		Description string `json:"description"`
+		Name          string `json:"name" binding:"required"`
+		Description   string `json:"description"`
+		QuotaBytes    int64  `json:"quota_bytes"`     // Monthly data quota for members (0 = unlimited)
+		RateLimitKbps int    `json:"rate_limit_kbps"` // Tunnel bandwidth limit for members (0 = unlimited)
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	if req.QuotaBytes < 0 || req.RateLimitKbps < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quota_bytes and rate_limit_kbps must not be negative"})
+		return
+	}
 
 	group := database.Group{
-		Name:        req.Name,
-		Description: req.Description,
+		Name:          req.Name,
+		Description:   req.Description,
+		QuotaBytes:    req.QuotaBytes,
+		RateLimitKbps: req.RateLimitKbps,
 	}
 
 	if err := h.db.Create(&group).Error; err != nil {
@@ -649,6 +849,8 @@ func (h *AdminHandler) CreateGroup(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.create", "group", group.ID, nil, group)
+
 	c.JSON(http.StatusCreated, gin.H{"group": group})
 }
 
@@ -702,16 +904,23 @@ func (h *AdminHandler) UpdateGroup(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
 		return
 	}
+	before := group
 
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name          string `json:"name"`
+		Description   string `json:"description"`
+		QuotaBytes    *int64 `json:"quota_bytes"`
+		RateLimitKbps *int   `json:"rate_limit_kbps"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	if (req.QuotaBytes != nil && *req.QuotaBytes < 0) || (req.RateLimitKbps != nil && *req.RateLimitKbps < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quota_bytes and rate_limit_kbps must not be negative"})
+		return
+	}
 
 	if req.Name != "" {
 		group.Name = req.Name
@@ -719,12 +928,20 @@ func (h *AdminHandler) UpdateGroup(c *gin.Context) {
 	if req.Description != "" {
 		group.Description = req.Description
 	}
+	if req.QuotaBytes != nil {
+		group.QuotaBytes = *req.QuotaBytes
+	}
+	if req.RateLimitKbps != nil {
+		group.RateLimitKbps = *req.RateLimitKbps
+	}
 
 	if err := h.db.Save(&group).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update group"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.update", "group", group.ID, before, group)
+
 	c.JSON(http.StatusOK, gin.H{"group": group})
 }
 
@@ -754,6 +971,8 @@ func (h *AdminHandler) DeleteGroup(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.delete", "group", group.ID, group, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "group deleted successfully"})
 }
 
@@ -774,6 +993,16 @@ func (h *AdminHandler) AddUserToGroup(c *gin.Context) {
 		return
 	}
 
+	if !h.canManageGroupMembers(c, uint(groupID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "group is outside your managed groups"})
+		return
+	}
+	// Group-scoped admins may only add users they already manage
+	if !h.canManageUser(c, req.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
 	// Verify group exists
 	var group database.Group
 	if err := h.db.First(&group, groupID).Error; err != nil {
@@ -798,6 +1027,8 @@ func (h *AdminHandler) AddUserToGroup(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.add_user", "group", groupID, nil, gin.H{"user_id": req.UserID})
+
 	c.JSON(http.StatusCreated, gin.H{"message": "user added to group"})
 }
 
@@ -815,12 +1046,23 @@ func (h *AdminHandler) RemoveUserFromGroup(c *gin.Context) {
 		return
 	}
 
+	if !h.canManageGroupMembers(c, uint(groupID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "group is outside your managed groups"})
+		return
+	}
+	if !h.canManageUser(c, uint(userID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
 	result := h.db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&database.UserGroup{})
 	if result.RowsAffected == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not in group"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.remove_user", "group", groupID, gin.H{"user_id": userID}, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "user removed from group"})
 }
 
@@ -865,6 +1107,8 @@ func (h *AdminHandler) AddRouteToGroup(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.add_route", "group", groupID, nil, gin.H{"route_id": req.RouteID})
+
 	c.JSON(http.StatusCreated, gin.H{"message": "route added to group"})
 }
 
@@ -888,6 +1132,8 @@ func (h *AdminHandler) RemoveRouteFromGroup(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "group.remove_route", "group", groupID, gin.H{"route_id": routeID}, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "route removed from group"})
 }
 
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ============ API Key Management ============
+
+// ListAPIKeys returns all API keys (without secrets)
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	var keys []database.APIKey
+	if err := h.db.Order("id ASC").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKey creates a new API key. The full key is only returned in this response.
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope: " + scope})
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate API key"})
+		return
+	}
+
+	apiKey := database.APIKey{
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if userID, ok := c.Get("user_id"); ok {
+		apiKey.CreatedBy = userID.(uint)
+	}
+
+	if err := h.db.Create(&apiKey).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key name already exists"})
+		return
+	}
+
+	audit.Log(c, h.db.DB, "apikey.create", "api_key", apiKey.ID, nil, apiKey)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": apiKey,
+		"key":     key,
+		"message": "store this key now, it will not be shown again",
+	})
+}
+
+// RevokeAPIKey revokes an API key
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key id"})
+		return
+	}
+
+	var apiKey database.APIKey
+	if err := h.db.First(&apiKey, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if apiKey.RevokedAt == nil {
+		before := apiKey
+		now := time.Now()
+		apiKey.RevokedAt = &now
+		if err := h.db.Save(&apiKey).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+			return
+		}
+		audit.Log(c, h.db.DB, "apikey.revoke", "api_key", apiKey.ID, before, apiKey)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "api_key": apiKey})
+}
+
+// ListAPIKeyLogs returns the most recent requests made with an API key
+func (h *AdminHandler) ListAPIKeyLogs(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key id"})
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var logs []database.APIKeyLog
+	if err := h.db.Where("api_key_id = ?", id).Order("id DESC").Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API key logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"logs": logs})
+}
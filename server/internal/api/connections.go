@@ -0,0 +1,272 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/logging"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/wireguard"
+
+	"github.com/gin-gonic/gin"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// liveHandshakeWindow is how recent a handshake must be for a peer to count
+// as connected (WireGuard re-handshakes every 2 minutes while traffic flows)
+const liveHandshakeWindow = 3 * time.Minute
+
+// Connection is a WireGuard peer joined with its user and tunnel connection
+type Connection struct {
+	ID             uint       `json:"id"` // Allocated IP ID
+	UserID         uint       `json:"user_id"`
+	Username       string     `json:"username"`
+	DeviceIP       string     `json:"device_ip"`
+	PublicKey      string     `json:"public_key"`
+	SourceAddr     string     `json:"source_addr"` // Client address of the WebSocket tunnel, or the UDP endpoint
+	LastHandshake  *time.Time `json:"last_handshake"`
+	RxBytes        int64      `json:"rx_bytes"`
+	TxBytes        int64      `json:"tx_bytes"`
+	ConnectedSince *time.Time `json:"connected_since"` // When the tunnel connection was established (nil without the built-in tunnel)
+	LatencyMs      float64    `json:"latency_ms"`      // Round-trip time of the tunnel connection (0 until measured)
+	Live           bool       `json:"live"`
+}
+
+// SetWireGuardManager sets the WireGuard manager used for live connections
+func (h *AdminHandler) SetWireGuardManager(wgManager *wireguard.Manager) {
+	h.wgManager = wgManager
+}
+
+// SetTunnelServer sets the built-in tunnel server whose connections are
+// listed and closed with their peers
+func (h *AdminHandler) SetTunnelServer(tunnelServer *tunnel.Server) {
+	h.tunnelServer = tunnelServer
+}
+
+// connections joins peer statistics with IP allocations and tunnel sessions.
+// With all=false only peers with a recent handshake are returned.
+func (h *AdminHandler) connections(all bool) ([]Connection, error) {
+	stats, err := h.wgManager.GetPeerStats()
+	if err != nil {
+		return nil, err
+	}
+	statsByKey := make(map[string]wireguard.PeerStat, len(stats))
+	for _, s := range stats {
+		statsByKey[s.PublicKey] = s
+	}
+
+	var allocations []database.AllocatedIP
+	if err := h.db.Preload("User").Where("public_key <> ''").Order("id ASC").Find(&allocations).Error; err != nil {
+		return nil, err
+	}
+
+	connections := make([]Connection, 0, len(allocations))
+	for _, a := range allocations {
+		stat, hasPeer := statsByKey[a.PublicKey]
+		live := hasPeer && !stat.LastHandshake.IsZero() && time.Since(stat.LastHandshake) < liveHandshakeWindow
+		if !all && !live {
+			continue
+		}
+
+		conn := Connection{
+			ID:         a.ID,
+			UserID:     a.UserID,
+			Username:   a.User.Username,
+			DeviceIP:   a.IPAddress,
+			PublicKey:  a.PublicKey,
+			SourceAddr: stat.Endpoint,
+			RxBytes:    stat.RxBytes,
+			TxBytes:    stat.TxBytes,
+			Live:       live,
+		}
+		if !stat.LastHandshake.IsZero() {
+			t := stat.LastHandshake
+			conn.LastHandshake = &t
+		}
+		if h.tunnelServer != nil && stat.Endpoint != "" {
+			if sess, ok := h.tunnelServer.SessionByEndpoint(stat.Endpoint); ok {
+				conn.SourceAddr = sess.RemoteAddr
+				t := sess.ConnectedAt
+				conn.ConnectedSince = &t
+				conn.LatencyMs = sess.LatencyMs
+			}
+		}
+		connections = append(connections, conn)
+	}
+
+	return connections, nil
+}
+
+// ListConnections returns connected peers. Use ?all=true to include idle allocations.
+func (h *AdminHandler) ListConnections(c *gin.Context) {
+	if h.wgManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WireGuard manager not available"})
+		return
+	}
+
+	connections, err := h.connections(c.Query("all") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch connections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"connections": h.visibleConnections(c, connections)})
+}
+
+// visibleConnections filters connections down to those the current admin may
+// see. Group-scoped admins only see their users.
+func (h *AdminHandler) visibleConnections(c *gin.Context, connections []Connection) []Connection {
+	if _, scoped := auth.ManagedGroupIDs(c); !scoped {
+		return connections
+	}
+
+	filtered := connections[:0]
+	for _, conn := range connections {
+		if h.canManageUser(c, conn.UserID) {
+			filtered = append(filtered, conn)
+		}
+	}
+	return filtered
+}
+
+// DisconnectConnection removes a peer from WireGuard and closes its tunnel
+// connection. The IP allocation is kept, so the user may reconnect; deactivate
+// the user to keep them out.
+func (h *AdminHandler) DisconnectConnection(c *gin.Context) {
+	if h.wgManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WireGuard manager not available"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+
+	var alloc database.AllocatedIP
+	if err := h.db.Preload("User").First(&alloc, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	if !h.canManageUser(c, alloc.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
+	// Find the peer endpoint before removing the peer
+	endpoint := h.peerEndpoint(alloc.PublicKey)
+
+	if err := h.wgManager.RemovePeer(alloc.PublicKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove peer: " + err.Error()})
+		return
+	}
+
+	tunnelClosed := false
+	if h.tunnelServer != nil && endpoint != "" {
+		tunnelClosed = h.tunnelServer.CloseSession(endpoint, framing.CloseAdmin, "disconnected by an administrator")
+	}
+
+	logging.Request(c).Info("peer disconnected by admin", "user", alloc.User.Username, "user_id", alloc.UserID,
+		"peer", alloc.PublicKey, "device_ip", alloc.IPAddress, "endpoint", endpoint, "tunnel_closed", tunnelClosed)
+	audit.Log(c, h.db.DB, "connection.disconnect", "connection", alloc.ID, gin.H{"user_id": alloc.UserID, "device_ip": alloc.IPAddress, "source_addr": endpoint}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "connection closed",
+		"username":      alloc.User.Username,
+		"device_ip":     alloc.IPAddress,
+		"tunnel_closed": tunnelClosed,
+	})
+}
+
+// peerEndpoint returns the endpoint of the WireGuard peer with publicKey, or
+// "" if it has none
+func (h *AdminHandler) peerEndpoint(publicKey string) string {
+	stats, err := h.wgManager.GetPeerStats()
+	if err != nil {
+		return ""
+	}
+	for _, s := range stats {
+		if s.PublicKey == publicKey {
+			return s.Endpoint
+		}
+	}
+	return ""
+}
+
+// ConnectionLatency returns the latency history of a connection's tunnel,
+// measured with keepalive pings
+func (h *AdminHandler) ConnectionLatency(c *gin.Context) {
+	if h.wgManager == nil || h.tunnelServer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "built-in tunnel not enabled"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid connection id"})
+		return
+	}
+
+	var alloc database.AllocatedIP
+	if err := h.db.First(&alloc, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	if !h.canManageUser(c, alloc.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
+	samples, ok := h.tunnelServer.LatencyHistory(h.peerEndpoint(alloc.PublicKey))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection has no tunnel connection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": alloc.ID, "samples": samples})
+}
+
+// NotifyConnectionsRequest is the body of NotifyConnections
+type NotifyConnectionsRequest struct {
+	Message string `json:"message" binding:"required,max=1000"`
+	UserID  uint   `json:"user_id"` // 0 notifies every connection
+}
+
+// NotifyConnections sends a notice to the clients of open tunnel connections,
+// e.g. about upcoming maintenance. Clients too old for the framed
+// subprotocol don't receive it.
+func (h *AdminHandler) NotifyConnections(c *gin.Context) {
+	if h.tunnelServer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "built-in tunnel not enabled"})
+		return
+	}
+
+	var req NotifyConnectionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if req.UserID == 0 {
+		if _, scoped := auth.ManagedGroupIDs(c); scoped {
+			c.JSON(http.StatusForbidden, gin.H{"error": "group-scoped admins must name a user_id"})
+			return
+		}
+	} else if !h.canManageUser(c, req.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+		return
+	}
+
+	notified := h.tunnelServer.Notify(req.UserID, req.Message)
+
+	logging.Request(c).Info("notice sent to tunnel connections", "user_id", req.UserID, "notified", notified)
+	audit.Log(c, h.db.DB, "connection.notify", "user", req.UserID, nil, gin.H{"message": req.Message, "notified": notified})
+
+	c.JSON(http.StatusOK, gin.H{"notified": notified})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/wireguard"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testHandler returns a handler over a database with alice in group 1 and
+// bob in group 2, each with a connection of the same ID as the user
+func testHandler(t *testing.T) *AdminHandler {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"alice", "bob"} {
+		id := uint(i + 1)
+		records := []interface{}{
+			&database.User{ID: id, Username: name, Email: name + "@example.com", PasswordHash: "x"},
+			&database.Group{ID: id, Name: name + "-team"},
+			&database.UserGroup{UserID: id, GroupID: id},
+			&database.AllocatedIP{ID: id, UserID: id, ServerID: 1, IPAddress: fmt.Sprintf("10.0.0.%d", id+1), PublicKey: name + "-key"},
+		}
+		for _, record := range records {
+			if err := db.Create(record).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return NewAdminHandler(db, nil, "")
+}
+
+// serve runs one request as an admin limited to groupIDs, or unscoped if nil
+func serve(h *AdminHandler, groupIDs []uint, method, path, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if groupIDs != nil {
+			c.Set("admin_group_ids", groupIDs)
+		}
+	})
+	router.DELETE("/connections/:id", h.DisconnectConnection)
+	router.GET("/connections/:id/latency", h.ConnectionLatency)
+	router.POST("/connections/notify", h.NotifyConnections)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestConnectionScope(t *testing.T) {
+	h := testHandler(t)
+	// The scope is checked before the WireGuard device is used, so a manager
+	// without a device is enough as long as the request is refused
+	h.SetWireGuardManager(&wireguard.Manager{})
+	h.SetTunnelServer(tunnel.NewServer(tunnel.Config{}))
+
+	tests := []struct {
+		name     string
+		groupIDs []uint
+		method   string
+		path     string
+		want     int
+	}{
+		{"disconnect invalid id", []uint{1}, http.MethodDelete, "/connections/x", http.StatusBadRequest},
+		{"disconnect unknown", []uint{1}, http.MethodDelete, "/connections/9", http.StatusNotFound},
+		{"disconnect other group", []uint{1}, http.MethodDelete, "/connections/2", http.StatusForbidden},
+		{"disconnect no groups", []uint{}, http.MethodDelete, "/connections/1", http.StatusForbidden},
+		{"latency other group", []uint{1}, http.MethodGet, "/connections/2/latency", http.StatusForbidden},
+		{"latency unknown", nil, http.MethodGet, "/connections/9/latency", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, tt.groupIDs, tt.method, tt.path, ""); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestNotifyConnections(t *testing.T) {
+	h := testHandler(t)
+
+	if w := serve(h, nil, http.MethodPost, "/connections/notify", `{"message":"maintenance"}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without a tunnel server = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	h.SetTunnelServer(tunnel.NewServer(tunnel.Config{}))
+
+	tests := []struct {
+		name     string
+		groupIDs []uint
+		body     string
+		want     int
+	}{
+		{"everyone", nil, `{"message":"maintenance"}`, http.StatusOK},
+		{"user", nil, `{"message":"maintenance","user_id":2}`, http.StatusOK},
+		{"scoped user in group", []uint{1}, `{"message":"maintenance","user_id":1}`, http.StatusOK},
+		{"scoped everyone", []uint{1}, `{"message":"maintenance"}`, http.StatusForbidden},
+		{"scoped user in other group", []uint{1}, `{"message":"maintenance","user_id":2}`, http.StatusForbidden},
+		{"scoped unknown user", []uint{1}, `{"message":"maintenance","user_id":9}`, http.StatusForbidden},
+		{"no message", nil, `{"user_id":1}`, http.StatusBadRequest},
+		{"long message", nil, `{"message":"` + strings.Repeat("x", 1001) + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, tt.groupIDs, http.MethodPost, "/connections/notify", tt.body); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
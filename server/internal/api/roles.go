@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ============ Roles & Admin Scopes ============
+
+// ListRoles returns the available admin roles and their permissions
+func (h *AdminHandler) ListRoles(c *gin.Context) {
+	roles := make([]gin.H, 0, len(auth.AllRoles))
+	for _, role := range auth.AllRoles {
+		roles = append(roles, gin.H{"name": role, "permissions": auth.RolePermissions(role)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetAdminGroups returns the groups a user's user management is limited to
+func (h *AdminHandler) GetAdminGroups(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var groups []database.Group
+	err = h.db.Where("id IN (?)", h.db.Model(&database.AdminGroupScope{}).Select("group_id").Where("user_id = ?", id)).
+		Find(&groups).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch admin groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// SetAdminGroups replaces the groups a user's user management is limited to.
+// An empty list removes the restriction.
+func (h *AdminHandler) SetAdminGroups(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var req struct {
+		GroupIDs []uint `json:"group_ids"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var user database.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var before []uint
+	h.db.Model(&database.AdminGroupScope{}).Where("user_id = ?", user.ID).Pluck("group_id", &before)
+
+	if len(req.GroupIDs) > 0 {
+		var count int64
+		h.db.Model(&database.Group{}).Where("id IN ?", req.GroupIDs).Count(&count)
+		if int(count) != len(req.GroupIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "one or more groups not found"})
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&database.AdminGroupScope{}).Error; err != nil {
+			return err
+		}
+		for _, groupID := range req.GroupIDs {
+			if err := tx.Create(&database.AdminGroupScope{UserID: user.ID, GroupID: groupID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update admin groups"})
+		return
+	}
+
+	audit.Log(c, h.db.DB, "user.set_admin_groups", "user", user.ID, gin.H{"group_ids": before}, gin.H{"group_ids": req.GroupIDs})
+
+	c.JSON(http.StatusOK, gin.H{"message": "admin groups updated", "group_ids": req.GroupIDs})
+}
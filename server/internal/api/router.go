@@ -3,11 +3,18 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
+	"wire-socket-server/internal/audit"
 	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/metrics"
+	"wire-socket-server/internal/quota"
+	"wire-socket-server/internal/webhook"
 	"wire-socket-server/internal/wireguard"
 
 	"github.com/gin-gonic/gin"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
 )
 
 // Router sets up the API routes
@@ -17,7 +24,15 @@ type Router struct {
 	db           *database.DB
 	configGen    *wireguard.ConfigGenerator
 	tunnelURL    string
-	subnet       string // VPN subnet (automatically included in routes)
+	shaping      framing.Shaping   // Tunnel traffic shaping suggested to clients
+	keepalive    framing.Keepalive // Tunnel keepalive suggested to clients
+	tlsPins      []string          // SPKI pins clients require
+	subnet       string            // VPN subnet (automatically included in routes)
+	metrics      *metrics.Metrics
+	webhooks     *webhook.Handler
+
+	routesMu sync.RWMutex
+	routes   []string // Extra routes pushed to every client (wireguard.routes)
 }
 
 // NewRouter creates a new API router
@@ -32,6 +47,42 @@ func NewRouter(authHandler *auth.Handler, adminHandler *AdminHandler, db *databa
 	}
 }
 
+// SetTunnelShaping sets the traffic shaping clients apply to the datagrams
+// they send through the tunnel; it is part of the config response
+func (r *Router) SetTunnelShaping(shaping framing.Shaping) {
+	r.shaping = shaping
+}
+
+// SetTunnelKeepalive sets the keepalive pings clients send through the
+// tunnel; it is part of the config response unless it is the default
+func (r *Router) SetTunnelKeepalive(keepalive framing.Keepalive) {
+	r.keepalive = keepalive
+}
+
+// SetTLSPins sets the SPKI pins clients require in the server's certificate
+// chains; they are part of the config response
+func (r *Router) SetTLSPins(pins []string) {
+	r.tlsPins = pins
+}
+
+// SetMetrics enables /metrics and login instrumentation. Call before SetupRoutes.
+func (r *Router) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
+// SetWebhooks enables the webhook admin API. Call before SetupRoutes.
+func (r *Router) SetWebhooks(h *webhook.Handler) {
+	r.webhooks = h
+}
+
+// SetRoutes sets the routes pushed to every client in addition to the subnet
+// and group routes. Safe to call while serving (config reload).
+func (r *Router) SetRoutes(routes []string) {
+	r.routesMu.Lock()
+	defer r.routesMu.Unlock()
+	r.routes = append([]string(nil), routes...)
+}
+
 // SetupRoutes configures all API routes
 func (r *Router) SetupRoutes(engine *gin.Engine) {
 	// Health check
@@ -39,14 +90,17 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// Prometheus metrics
+	r.metrics.Mount(engine)
+
 	// API v1 routes
 	v1 := engine.Group("/api")
 	{
 		// Public routes (no authentication required)
-		auth := v1.Group("/auth")
+		authRoutes := v1.Group("/auth")
 		{
-			auth.POST("/login", r.authHandler.Login)
-			auth.POST("/register", r.authHandler.Register)
+			authRoutes.POST("/login", r.metrics.LoginMiddleware(), r.authHandler.Login)
+			authRoutes.POST("/register", r.authHandler.Register)
 		}
 
 		// Protected routes (authentication required)
@@ -58,46 +112,91 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 			protected.GET("/config", r.GetConfig)
 			protected.GET("/servers", r.ListServers)
 			protected.GET("/status", r.GetStatus)
+			protected.GET("/usage", r.GetMyUsage)
 		}
 
-		// Admin routes (requires authentication + admin privileges)
+		// Admin routes (requires authentication + an admin role)
+		// Each route requires a permission, granted by the admin's role or an API key's scopes
 		admin := v1.Group("/admin")
 		admin.Use(r.authHandler.AuthMiddleware())
 		admin.Use(r.authHandler.AdminMiddleware())
+		perm := auth.RequirePermission
 		{
 			// User management
-			admin.GET("/users", r.adminHandler.ListUsers)
-			admin.POST("/users", r.authHandler.CreateUserByAdmin)
-			admin.GET("/users/:id", r.adminHandler.GetUser)
-			admin.PUT("/users/:id", r.adminHandler.UpdateUser)
-			admin.DELETE("/users/:id", r.adminHandler.DeleteUser)
+			admin.GET("/users", perm(auth.ScopeUsersRead), r.adminHandler.ListUsers)
+			admin.POST("/users", perm(auth.ScopeUsersWrite), r.authHandler.CreateUserByAdmin)
+			admin.GET("/users/:id", perm(auth.ScopeUsersRead), r.adminHandler.GetUser)
+			admin.PUT("/users/:id", perm(auth.ScopeUsersWrite), r.adminHandler.UpdateUser)
+			admin.DELETE("/users/:id", perm(auth.ScopeUsersWrite), r.adminHandler.DeleteUser)
+			admin.POST("/users/:id/unlock", perm(auth.ScopeUsersWrite), r.adminHandler.UnlockUser)
+			admin.GET("/login-failures", perm(auth.ScopeUsersRead), r.adminHandler.ListLoginFailures)
+
+			// Roles and group-scoped administration
+			admin.GET("/roles", r.adminHandler.ListRoles)
+			admin.GET("/users/:id/admin-groups", perm(auth.ScopeUsersRead), r.adminHandler.GetAdminGroups)
+			admin.PUT("/users/:id/admin-groups", perm(auth.PermRolesWrite), r.adminHandler.SetAdminGroups)
 
 			// Route management
-			admin.GET("/routes", r.adminHandler.ListRoutes)
-			admin.POST("/routes", r.adminHandler.CreateRoute)
-			admin.PUT("/routes/:id", r.adminHandler.UpdateRoute)
-			admin.DELETE("/routes/:id", r.adminHandler.DeleteRoute)
-			admin.POST("/routes/apply", r.adminHandler.ApplyRoutes)
+			admin.GET("/routes", perm(auth.ScopeRoutesRead), r.adminHandler.ListRoutes)
+			admin.POST("/routes", perm(auth.ScopeRoutesWrite), r.adminHandler.CreateRoute)
+			admin.PUT("/routes/:id", perm(auth.ScopeRoutesWrite), r.adminHandler.UpdateRoute)
+			admin.DELETE("/routes/:id", perm(auth.ScopeRoutesWrite), r.adminHandler.DeleteRoute)
+			admin.POST("/routes/apply", perm(auth.ScopeRoutesApply), r.adminHandler.ApplyRoutes)
 
 			// NAT rule management
-			admin.GET("/nat", r.adminHandler.ListNATRules)
-			admin.POST("/nat", r.adminHandler.CreateNATRule)
-			admin.PUT("/nat/:id", r.adminHandler.UpdateNATRule)
-			admin.DELETE("/nat/:id", r.adminHandler.DeleteNATRule)
-			admin.POST("/nat/apply", r.adminHandler.ApplyNATRules)
+			admin.GET("/nat", perm(auth.ScopeNATRead), r.adminHandler.ListNATRules)
+			admin.POST("/nat", perm(auth.ScopeNATWrite), r.adminHandler.CreateNATRule)
+			admin.PUT("/nat/:id", perm(auth.ScopeNATWrite), r.adminHandler.UpdateNATRule)
+			admin.DELETE("/nat/:id", perm(auth.ScopeNATWrite), r.adminHandler.DeleteNATRule)
+			admin.POST("/nat/apply", perm(auth.ScopeNATApply), r.adminHandler.ApplyNATRules)
 
 			// Group management
-			admin.GET("/groups", r.adminHandler.ListGroups)
-			admin.POST("/groups", r.adminHandler.CreateGroup)
-			admin.GET("/groups/:id", r.adminHandler.GetGroup)
-			admin.PUT("/groups/:id", r.adminHandler.UpdateGroup)
-			admin.DELETE("/groups/:id", r.adminHandler.DeleteGroup)
+			admin.GET("/groups", perm(auth.ScopeGroupsRead), r.adminHandler.ListGroups)
+			admin.POST("/groups", perm(auth.ScopeGroupsWrite), r.adminHandler.CreateGroup)
+			admin.GET("/groups/:id", perm(auth.ScopeGroupsRead), r.adminHandler.GetGroup)
+			admin.PUT("/groups/:id", perm(auth.ScopeGroupsWrite), r.adminHandler.UpdateGroup)
+			admin.DELETE("/groups/:id", perm(auth.ScopeGroupsWrite), r.adminHandler.DeleteGroup)
 
 			// Group membership management
-			admin.POST("/groups/:id/users", r.adminHandler.AddUserToGroup)
-			admin.DELETE("/groups/:id/users/:user_id", r.adminHandler.RemoveUserFromGroup)
-			admin.POST("/groups/:id/routes", r.adminHandler.AddRouteToGroup)
-			admin.DELETE("/groups/:id/routes/:route_id", r.adminHandler.RemoveRouteFromGroup)
+			admin.POST("/groups/:id/users", perm(auth.ScopeUsersWrite), r.adminHandler.AddUserToGroup)
+			admin.DELETE("/groups/:id/users/:user_id", perm(auth.ScopeUsersWrite), r.adminHandler.RemoveUserFromGroup)
+			admin.POST("/groups/:id/routes", perm(auth.ScopeGroupsWrite), r.adminHandler.AddRouteToGroup)
+			admin.DELETE("/groups/:id/routes/:route_id", perm(auth.ScopeGroupsWrite), r.adminHandler.RemoveRouteFromGroup)
+
+			// Live connections
+			admin.GET("/connections", perm(auth.ScopeConnectionsRead), r.adminHandler.ListConnections)
+			admin.DELETE("/connections/:id", perm(auth.ScopeConnectionsWrite), r.adminHandler.DisconnectConnection)
+			admin.POST("/connections/notice", perm(auth.ScopeConnectionsWrite), r.adminHandler.NotifyConnections)
+			admin.GET("/connections/:id/latency", perm(auth.ScopeConnectionsRead), r.adminHandler.ConnectionLatency)
+			admin.GET("/events", perm(auth.ScopeConnectionsRead), r.adminHandler.StreamEvents)
+
+			// Traffic history
+			admin.GET("/usage", perm(auth.ScopeUsageRead), r.adminHandler.ListUsage)
+
+			// Audit log
+			admin.GET("/audit", perm(auth.ScopeAuditRead), audit.ListHandler(r.db.DB))
+
+			// Webhooks
+			if r.webhooks != nil {
+				admin.GET("/webhooks", perm(auth.ScopeWebhooksRead), r.webhooks.List)
+				admin.POST("/webhooks", perm(auth.ScopeWebhooksWrite), r.webhooks.Create)
+				admin.GET("/webhooks/:id", perm(auth.ScopeWebhooksRead), r.webhooks.Get)
+				admin.PUT("/webhooks/:id", perm(auth.ScopeWebhooksWrite), r.webhooks.Update)
+				admin.DELETE("/webhooks/:id", perm(auth.ScopeWebhooksWrite), r.webhooks.Delete)
+				admin.POST("/webhooks/:id/test", perm(auth.ScopeWebhooksWrite), r.webhooks.Test)
+				admin.GET("/webhooks/:id/dead-letters", perm(auth.ScopeWebhooksRead), r.webhooks.ListDeadLetters)
+				admin.POST("/webhooks/:id/dead-letters/:letter_id/redeliver", perm(auth.ScopeWebhooksWrite), r.webhooks.RedeliverDeadLetter)
+				admin.DELETE("/webhooks/:id/dead-letters/:letter_id", perm(auth.ScopeWebhooksWrite), r.webhooks.DeleteDeadLetter)
+			}
+
+			// API key management (superadmins only, not available to API keys)
+			apiKeys := admin.Group("/apikeys", r.authHandler.RequireUser(), perm(auth.PermAPIKeys))
+			{
+				apiKeys.GET("", r.adminHandler.ListAPIKeys)
+				apiKeys.POST("", r.adminHandler.CreateAPIKey)
+				apiKeys.DELETE("/:id", r.adminHandler.RevokeAPIKey)
+				apiKeys.GET("/:id/logs", r.adminHandler.ListAPIKeyLogs)
+			}
 		}
 	}
 }
@@ -119,6 +218,21 @@ func (r *Router) GetConfig(c *gin.Context) {
 		}
 	}
 
+	// Refuse configs once the monthly quota has run out, so the peer stays
+	// removed until the period resets
+	quotaStatus, err := quota.Check(r.db.DB, userID.(uint), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return
+	}
+	if quotaStatus.Exceeded() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("%s (resets %s)", quota.ErrExceeded, quotaStatus.ResetsAt.Format("2006-01-02")),
+			"quota": quotaStatus,
+		})
+		return
+	}
+
 	// Generate WireGuard config
 	config, err := r.configGen.GenerateForUser(userID.(uint), serverID)
 	if err != nil {
@@ -126,19 +240,33 @@ func (r *Router) GetConfig(c *gin.Context) {
 		return
 	}
 
-	// Build routes: subnet + user-specific routes based on groups
+	// Build routes: subnet + configured routes + user-specific routes based on groups
 	allRoutes := []string{r.subnet}
+	r.routesMu.RLock()
+	allRoutes = append(allRoutes, r.routes...)
+	r.routesMu.RUnlock()
 	dbRoutes, err := r.adminHandler.GetRoutesForUser(userID.(uint))
 	if err == nil {
 		allRoutes = append(allRoutes, dbRoutes...)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"config":     config,
 		"ini_format": config.ToINIFormat(),
 		"tunnel_url": r.tunnelURL,
 		"routes":     allRoutes,
-	})
+		"quota":      quotaStatus,
+	}
+	if r.shaping.Enabled() {
+		response["tunnel_shaping"] = r.shaping
+	}
+	if r.keepalive != (framing.Keepalive{}) {
+		response["tunnel_keepalive"] = r.keepalive
+	}
+	if len(r.tlsPins) > 0 {
+		response["tls_pins"] = r.tlsPins
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // ListServers returns available VPN servers
@@ -167,7 +295,14 @@ func (r *Router) GetStatus(c *gin.Context) {
 		return
 	}
 
+	quotaStatus, err := quota.Check(r.db.DB, userID.(uint), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"allocations": allocations,
+		"quota":       quotaStatus,
 	})
 }
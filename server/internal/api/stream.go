@@ -0,0 +1,106 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamStatsInterval is how often the admin event stream sends a "stats" event
+const streamStatsInterval = 5 * time.Second
+
+// streamBuffer is how many events a slow stream may fall behind before
+// further events are dropped for it
+const streamBuffer = 64
+
+// streamStats is the payload of "stats" events on the admin event stream
+type streamStats struct {
+	Connected   int          `json:"connected"`
+	RxBytes     int64        `json:"rx_bytes"`
+	TxBytes     int64        `json:"tx_bytes"`
+	Connections []Connection `json:"connections"`
+}
+
+// SetEvents sets the event bus whose peer events are streamed to admins
+func (h *AdminHandler) SetEvents(bus *events.Bus) {
+	h.events = bus
+}
+
+// StreamEvents serves a Server-Sent Events stream for the admin UI:
+// peer.connected and peer.disconnected as they are detected, and a "stats"
+// event with the live connections every 5 seconds. Group-scoped admins only
+// receive events for their users.
+func (h *AdminHandler) StreamEvents(c *gin.Context) {
+	if h.wgManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WireGuard manager not available"})
+		return
+	}
+
+	ch := make(chan events.Event, streamBuffer)
+	if h.events != nil {
+		unsubscribe := h.events.Subscribe(func(e events.Event) {
+			if e.Type != events.PeerConnected && e.Type != events.PeerDisconnected {
+				return
+			}
+			select {
+			case ch <- e:
+			default:
+			}
+		})
+		defer unsubscribe()
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	sendStats := func() {
+		connections, err := h.connections(false)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "failed to fetch connections"})
+			return
+		}
+
+		stats := streamStats{Connections: h.visibleConnections(c, connections)}
+		stats.Connected = len(stats.Connections)
+		for _, conn := range stats.Connections {
+			stats.RxBytes += conn.RxBytes
+			stats.TxBytes += conn.TxBytes
+		}
+		c.SSEvent("stats", stats)
+	}
+
+	sendStats()
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(streamStatsInterval)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e := <-ch:
+			if h.visibleEvent(c, e) {
+				c.SSEvent(e.Type, e)
+			}
+		case <-ticker.C:
+			sendStats()
+		}
+		return true
+	})
+}
+
+// visibleEvent reports whether a peer event may be streamed to the current
+// admin. Group-scoped admins only receive events for their users, so peers
+// without a user are only shown to unscoped admins.
+func (h *AdminHandler) visibleEvent(c *gin.Context, e events.Event) bool {
+	if _, scoped := auth.ManagedGroupIDs(c); !scoped {
+		return true
+	}
+	data, ok := e.Data.(events.PeerData)
+	return ok && data.UserID != 0 && h.canManageUser(c, data.UserID)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"wire-socket-server/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminContext returns a request context for an admin limited to groupIDs,
+// or unscoped if nil
+func adminContext(groupIDs []uint) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if groupIDs != nil {
+		c.Set("admin_group_ids", groupIDs)
+	}
+	return c
+}
+
+func TestVisibleEvent(t *testing.T) {
+	h := testHandler(t)
+
+	alice := events.New(events.PeerConnected, events.PeerData{UserID: 1, PublicKey: "alice-key"})
+	bob := events.New(events.PeerDisconnected, events.PeerData{UserID: 2, PublicKey: "bob-key"})
+	unknown := events.New(events.PeerConnected, events.PeerData{PublicKey: "unallocated-key"})
+	tests := []struct {
+		name     string
+		groupIDs []uint
+		event    events.Event
+		want     bool
+	}{
+		{"unscoped", nil, bob, true},
+		{"unscoped without user", nil, unknown, true},
+		{"own group", []uint{1}, alice, true},
+		{"other group", []uint{1}, bob, false},
+		{"both groups", []uint{1, 2}, bob, true},
+		{"no groups", []uint{}, alice, false},
+		{"scoped without user", []uint{1, 2}, unknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.visibleEvent(adminContext(tt.groupIDs), tt.event); got != tt.want {
+				t.Errorf("visibleEvent = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisibleConnections(t *testing.T) {
+	h := testHandler(t)
+
+	tests := []struct {
+		name     string
+		groupIDs []uint
+		want     []uint // Connection IDs
+	}{
+		{"unscoped", nil, []uint{1, 2, 3}},
+		{"own group", []uint{1}, []uint{1}},
+		{"both groups", []uint{1, 2}, []uint{1, 2}},
+		{"no groups", []uint{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Connection 3 belongs to a user that no longer exists
+			connections := []Connection{{ID: 1, UserID: 1}, {ID: 2, UserID: 2}, {ID: 3, UserID: 3}}
+			got := h.visibleConnections(adminContext(tt.groupIDs), connections)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d connections, want %v: %+v", len(got), tt.want, got)
+			}
+			for i, id := range tt.want {
+				if got[i].ID != id {
+					t.Errorf("connection %d has ID %d, want %d", i, got[i].ID, id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"wire-socket-server/internal/auth"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/usage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseUsageFilter reads from, to, bucket and by from the query string.
+// Defaults: the last 30 days in daily buckets, one row per user.
+func parseUsageFilter(c *gin.Context) (usage.Filter, bool) {
+	f := usage.Filter{
+		From:     time.Now().AddDate(0, 0, -30),
+		Bucket:   c.DefaultQuery("bucket", usage.BucketDay),
+		ByDevice: c.Query("by") == "device",
+	}
+
+	if !usage.ValidBucket(f.Bucket) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucket (use hour, day or month)"})
+		return f, false
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := usage.ParseTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return f, false
+		}
+		f.From = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := usage.ParseTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return f, false
+		}
+		f.To = t
+	}
+
+	return f, true
+}
+
+// writeUsage responds with usage rows as JSON, or as CSV with ?format=csv
+func writeUsage(c *gin.Context, db *database.DB, f usage.Filter) {
+	rows, err := usage.Query(db.DB, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch usage"})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename=usage.csv")
+		c.Status(http.StatusOK)
+		usage.WriteCSV(c.Writer, rows, f.ByDevice)
+		return
+	}
+
+	if rows == nil {
+		rows = []usage.Row{}
+	}
+	rx, tx := usage.Totals(rows)
+	c.JSON(http.StatusOK, gin.H{
+		"bucket": f.Bucket,
+		"from":   f.From,
+		"to":     f.To,
+		"usage":  rows,
+		"totals": gin.H{"rx_bytes": rx, "tx_bytes": tx},
+	})
+}
+
+// ListUsage returns traffic history (?user=<id|username>&from=&to=&bucket=hour|day|month&by=device&format=csv).
+// Group-scoped admins only see members of their groups.
+func (h *AdminHandler) ListUsage(c *gin.Context) {
+	f, ok := parseUsageFilter(c)
+	if !ok {
+		return
+	}
+
+	if v := c.Query("user"); v != "" {
+		var user database.User
+		query := h.db.Where("username = ?", v)
+		if id, err := strconv.ParseUint(v, 10, 32); err == nil {
+			query = h.db.Where("id = ?", id)
+		}
+		if err := query.First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if !h.canManageUser(c, user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "user is outside your managed groups"})
+			return
+		}
+		f.UserIDs = []uint{user.ID}
+	} else if groupIDs, scoped := auth.ManagedGroupIDs(c); scoped {
+		f.UserIDs = []uint{}
+		h.db.Model(&database.UserGroup{}).Where("group_id IN ?", groupIDs).Distinct().Pluck("user_id", &f.UserIDs)
+	}
+
+	writeUsage(c, h.db, f)
+}
+
+// GetMyUsage returns the authenticated user's own traffic history
+func (r *Router) GetMyUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	f, ok := parseUsageFilter(c)
+	if !ok {
+		return
+	}
+	f.UserIDs = []uint{userID.(uint)}
+
+	writeUsage(c, r.db, f)
+}
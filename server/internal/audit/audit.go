@@ -0,0 +1,222 @@
+// Package audit records administrative changes in an append-only audit table
+// shared by the monolith, auth service and tunnel service.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"reflect"
+	"sync"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Actor types
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+	ActorWSCTL  = "wsctl"
+)
+
+// ignoredFields are bookkeeping fields left out of change sets
+var ignoredFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+}
+
+// secretFields are struct fields hidden from JSON whose changes are recorded
+// as a flag under the given key, without their values
+var secretFields = map[string]string{
+	"PasswordHash": "password_changed",
+}
+
+// listeners are called with every recorded entry
+var (
+	listenersMu sync.RWMutex
+	listeners   []func(database.AuditLog)
+)
+
+// OnRecord registers a function called with every entry after it is stored
+// by this process (e.g., to publish admin.change events)
+func OnRecord(fn func(database.AuditLog)) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Actor identifies who made a change
+type Actor struct {
+	Type string
+	ID   uint
+	Name string
+}
+
+// FromContext returns the actor of an authenticated admin request.
+// Handlers rely on "user_id"/"username" or "api_key_id"/"api_key_name" set by auth middleware.
+func FromContext(c *gin.Context) Actor {
+	if id, ok := c.Get("api_key_id"); ok {
+		return Actor{Type: ActorAPIKey, ID: id.(uint), Name: "apikey:" + c.GetString("api_key_name")}
+	}
+
+	actor := Actor{Type: ActorUser, Name: c.GetString("username")}
+	if id, ok := c.Get("user_id"); ok {
+		actor.ID = id.(uint)
+	}
+	if actor.Name == "" {
+		actor.Name = fmt.Sprintf("user:%d", actor.ID)
+	}
+	return actor
+}
+
+// WSCTL returns the actor for changes made with wsctl, named after the OS user
+func WSCTL() Actor {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		} else {
+			name = os.Getenv("USER")
+		}
+	}
+	return Actor{Type: ActorWSCTL, Name: "wsctl:" + name}
+}
+
+// Record appends an audit entry. before and after are the target's state
+// (nil for creations and deletions); only changed fields are stored.
+func Record(db *gorm.DB, actor Actor, sourceIP, action, targetType string, targetID interface{}, before, after interface{}) error {
+	entry := database.AuditLog{
+		ActorType:  actor.Type,
+		ActorID:    actor.ID,
+		Actor:      actor.Name,
+		Action:     action,
+		TargetType: targetType,
+		SourceIP:   sourceIP,
+	}
+	if targetID != nil {
+		entry.TargetID = fmt.Sprint(targetID)
+	}
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		data, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		entry.Changes = string(data)
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		return err
+	}
+
+	listenersMu.RLock()
+	fns := listeners
+	listenersMu.RUnlock()
+	for _, fn := range fns {
+		fn(entry)
+	}
+	return nil
+}
+
+// Log records a change made through an admin API request. Failures are logged
+// but do not fail the request, since the change has already been applied.
+func Log(c *gin.Context, db *gorm.DB, action, targetType string, targetID interface{}, before, after interface{}) {
+	if err := Record(db, FromContext(c), c.ClientIP(), action, targetType, targetID, before, after); err != nil {
+		logging.Request(c).Warn("failed to write audit log", "action", action, "error", err)
+	}
+}
+
+// Diff compares the JSON representations of before and after and returns the
+// changed fields as {"field": [before, after]}. Fields hidden from JSON never
+// appear; a changed password hash is recorded as
+// {"password_changed": [false, true]}.
+func Diff(before, after interface{}) (map[string][2]interface{}, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string][2]interface{})
+	for k, bv := range b {
+		if ignoredFields[k] {
+			continue
+		}
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(av, bv) {
+			changes[k] = [2]interface{}{bv, a[k]}
+		}
+	}
+	for k, av := range a {
+		if ignoredFields[k] {
+			continue
+		}
+		if _, ok := b[k]; !ok {
+			changes[k] = [2]interface{}{nil, av}
+		}
+	}
+	for field, key := range secretFields {
+		bv, bok := stringField(before, field)
+		av, aok := stringField(after, field)
+		if bok && aok && bv != av {
+			changes[key] = [2]interface{}{false, true}
+		}
+	}
+	return changes, nil
+}
+
+// stringField returns the string field name of a struct or struct pointer
+func stringField(v interface{}, name string) (string, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "", false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return "", false
+	}
+	field := rv.FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return "", false
+	}
+	return field.String(), true
+}
+
+// toMap converts a value to a generic JSON object. Non-object values are
+// wrapped as {"value": v}.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err == nil {
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+		return m, nil
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"value": raw}, nil
+}
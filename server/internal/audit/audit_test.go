@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"wire-socket-server/internal/database"
+)
+
+func TestDiff(t *testing.T) {
+	user := database.User{Username: "alice", Email: "a@example.com", PasswordHash: "old"}
+	renamed := user
+	renamed.Email = "b@example.com"
+	reset := user
+	reset.PasswordHash = "new"
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          map[string][2]interface{}
+	}{
+		{"unchanged", user, user, map[string][2]interface{}{}},
+		{"field", user, renamed, map[string][2]interface{}{"email": {"a@example.com", "b@example.com"}}},
+		{"password", user, &reset, map[string][2]interface{}{"password_changed": {false, true}}},
+		{"creation", nil, map[string]interface{}{"user_id": 7}, map[string][2]interface{}{"user_id": {nil, float64(7)}}},
+		{"deletion", map[string]interface{}{"user_id": 7}, nil, map[string][2]interface{}{"user_id": {float64(7), nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
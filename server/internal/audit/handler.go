@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wire-socket-server/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListHandler serves GET /api/admin/audit.
+//
+// Query parameters:
+//
+//	actor, actor_type, target_type, target_id  exact match
+//	action                                     exact match, or prefix with a trailing "*" (e.g., "nat.*")
+//	since, until                               RFC 3339 timestamps
+//	limit                                      max entries (default 100, max 1000; unlimited for JSONL unless set)
+//	format=jsonl                               stream entries as JSON Lines for export
+func ListHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Model(&database.AuditLog{}).Order("id DESC")
+
+		for _, field := range []string{"actor", "actor_type", "target_type", "target_id"} {
+			if v := c.Query(field); v != "" {
+				query = query.Where(field+" = ?", v)
+			}
+		}
+		if action := c.Query("action"); action != "" {
+			if strings.HasSuffix(action, "*") {
+				query = query.Where("action LIKE ?", strings.TrimSuffix(action, "*")+"%")
+			} else {
+				query = query.Where("action = ?", action)
+			}
+		}
+		for param, op := range map[string]string{"since": ">=", "until": "<="} {
+			v := c.Query(param)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + " (expected RFC 3339)"})
+				return
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+
+		jsonl := c.Query("format") == "jsonl"
+
+		limit := 100
+		if jsonl {
+			limit = -1
+		}
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && (jsonl || l <= 1000) {
+			limit = l
+		}
+		query = query.Limit(limit)
+
+		if jsonl {
+			streamJSONL(c, db, query)
+			return
+		}
+
+		var entries []database.AuditLog
+		if err := query.Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch audit log"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	}
+}
+
+// streamJSONL writes matching entries one JSON object per line
+func streamJSONL(c *gin.Context, db *gorm.DB, query *gorm.DB) {
+	rows, err := query.Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch audit log"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename=audit.jsonl")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	for rows.Next() {
+		var entry database.AuditLog
+		if err := db.ScanRows(rows, &entry); err != nil {
+			return
+		}
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"wire-socket-server/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyPrefix marks a bearer token as an API key rather than a JWT
+const APIKeyPrefix = "wsk_"
+
+// apiKeyLookupLen is the length of the public key prefix stored for lookup
+// ("wsk_" + 8 hex characters)
+const apiKeyLookupLen = len(APIKeyPrefix) + 8
+
+// Scopes that can be granted to API keys
+const (
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopeGroupsRead  = "groups:read"
+	ScopeGroupsWrite = "groups:write"
+	ScopeRoutesRead  = "routes:read"
+	ScopeRoutesWrite = "routes:write"
+	ScopeRoutesApply = "routes:apply"
+	ScopeNATRead     = "nat:read"
+	ScopeNATWrite    = "nat:write"
+	ScopeNATApply    = "nat:apply"
+	ScopeAuditRead   = "audit:read"
+
+	ScopeConnectionsRead  = "connections:read"
+	ScopeConnectionsWrite = "connections:write"
+	ScopeUsageRead        = "usage:read"
+	ScopeWebhooksRead     = "webhooks:read"
+	ScopeWebhooksWrite    = "webhooks:write"
+)
+
+// AllScopes lists every scope that can be granted to an API key
+var AllScopes = []string{
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeGroupsRead,
+	ScopeGroupsWrite,
+	ScopeRoutesRead,
+	ScopeRoutesWrite,
+	ScopeRoutesApply,
+	ScopeNATRead,
+	ScopeNATWrite,
+	ScopeNATApply,
+	ScopeAuditRead,
+	ScopeConnectionsRead,
+	ScopeConnectionsWrite,
+	ScopeUsageRead,
+	ScopeWebhooksRead,
+	ScopeWebhooksWrite,
+}
+
+var (
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key expired")
+	ErrAPIKeyRevoked = errors.New("API key revoked")
+)
+
+// ValidScope reports whether scope is a known scope
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAPIKey creates a new random API key.
+// It returns the full key (shown to the user once), the lookup prefix and the hash to store.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	key = APIKeyPrefix + hex.EncodeToString(buf)
+	return key, key[:apiKeyLookupLen], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of an API key.
+// Keys carry enough entropy that a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAPIKey looks up an API key and checks that it is active
+func (h *Handler) ValidateAPIKey(key string) (*database.APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) <= apiKeyLookupLen {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	var apiKey database.APIKey
+	if err := h.db.Where("prefix = ?", key[:apiKeyLookupLen]).First(&apiKey).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(HashAPIKey(key))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	return &apiKey, nil
+}
+
+// authenticateAPIKey validates an API key, runs the rest of the chain and records the request
+func (h *Handler) authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := h.ValidateAPIKey(key)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	h.db.Model(apiKey).Update("last_used_at", now)
+
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("api_key_name", apiKey.Name)
+	c.Set("api_key_scopes", apiKey.Scopes)
+	c.Next()
+
+	h.db.Create(&database.APIKeyLog{
+		APIKeyID: apiKey.ID,
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Status:   c.Writer.Status(),
+		ClientIP: c.ClientIP(),
+	})
+}
+
+// RequireUser is a middleware that rejects API keys, for endpoints that
+// must only be used by a logged-in admin (e.g., managing API keys themselves)
+func (h *Handler) RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIKey := c.Get("api_key_id"); isAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this endpoint is not available to API keys"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -2,28 +2,54 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 	"time"
+	"wire-socket-server/internal/audit"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/logging"
+	"wire-socket-server/internal/loginguard"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
 	db                *database.DB
 	jwtSecret         []byte
 	allowRegistration bool
+	loginGuard        *loginguard.Guard
+	events            *events.Bus
 }
 
 func NewHandler(db *database.DB, jwtSecret string, allowRegistration bool) *Handler {
+	// Default in-memory brute-force protection; replaced via SetLoginGuard
+	guard, _ := loginguard.New(loginguard.Config{}, db.DB)
 	return &Handler{
 		db:                db,
 		jwtSecret:         []byte(jwtSecret),
 		allowRegistration: allowRegistration,
+		loginGuard:        guard,
 	}
 }
 
+// SetLoginGuard sets the login brute-force protection
+func (h *Handler) SetLoginGuard(guard *loginguard.Guard) {
+	h.loginGuard = guard
+}
+
+// SetEvents sets the bus that receives user.login and user.login_failed
+func (h *Handler) SetEvents(bus *events.Bus) {
+	h.events = bus
+}
+
+// LoginGuard returns the login brute-force protection
+func (h *Handler) LoginGuard() *loginguard.Guard {
+	return h.loginGuard
+}
+
 // LoginRequest represents the login request body
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
@@ -60,21 +86,51 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	clientIP, userAgent := c.ClientIP(), c.Request.UserAgent()
+
+	// Throttle repeated failures from this IP or against this username
+	if wait := h.loginGuard.Check(clientIP, req.Username); wait > 0 {
+		h.loginGuard.LogFailure(clientIP, req.Username, userAgent, "rate limited")
+		h.publishLoginFailed(c, 0, req.Username, clientIP, userAgent, "rate limited")
+		loginguard.AbortTooManyRequests(c, wait)
+		return
+	}
+
 	// Find user by username
 	var user database.User
 	if err := h.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		h.loginGuard.Fail(clientIP, req.Username, userAgent, "unknown user")
+		h.publishLoginFailed(c, 0, req.Username, clientIP, userAgent, "unknown user")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	// Reject locked accounts before checking the password
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.loginGuard.LogFailure(clientIP, req.Username, userAgent, "locked")
+		h.publishLoginFailed(c, user.ID, user.Username, clientIP, userAgent, "locked")
+		loginguard.AbortLocked(c, *user.LockedUntil)
+		return
+	}
+
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		if h.loginGuard.Fail(clientIP, req.Username, userAgent, "invalid password") {
+			h.db.Model(&user).Update("locked_until", time.Now().Add(h.loginGuard.LockoutDuration()))
+		}
+		h.publishLoginFailed(c, user.ID, user.Username, clientIP, userAgent, "invalid password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	h.loginGuard.Succeed(req.Username)
+	if user.LockedUntil != nil {
+		h.db.Model(&user).Update("locked_until", nil)
+	}
+
 	// Check if user is active
 	if !user.IsActive {
+		h.publishLoginFailed(c, user.ID, user.Username, clientIP, userAgent, "inactive")
 		c.JSON(http.StatusForbidden, gin.H{"error": "account is inactive"})
 		return
 	}
@@ -86,6 +142,14 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	logging.Request(c).Info("user logged in", "user", user.Username, "user_id", user.ID, "client_ip", clientIP)
+	h.events.Publish(events.UserLogin, events.LoginData{
+		UserID:    user.ID,
+		Username:  user.Username,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+	})
+
 	c.JSON(http.StatusOK, TokenResponse{
 		Token:     token,
 		ExpiresAt: expiresAt,
@@ -97,6 +161,19 @@ func (h *Handler) Login(c *gin.Context) {
 	})
 }
 
+// publishLoginFailed logs a failed login and publishes user.login_failed.
+// userID is 0 when the user is unknown.
+func (h *Handler) publishLoginFailed(c *gin.Context, userID uint, username, clientIP, userAgent, reason string) {
+	logging.Request(c).Warn("login failed", "user", username, "user_id", userID, "client_ip", clientIP, "reason", reason)
+	h.events.Publish(events.UserLoginFailed, events.LoginData{
+		UserID:    userID,
+		Username:  username,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+		Reason:    reason,
+	})
+}
+
 // Register handles user registration
 func (h *Handler) Register(c *gin.Context) {
 	// Check if registration is allowed
@@ -217,6 +294,12 @@ func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 			tokenString = authHeader[7:]
 		}
 
+		// API keys are accepted alongside JWTs
+		if strings.HasPrefix(tokenString, APIKeyPrefix) {
+			h.authenticateAPIKey(c, tokenString)
+			return
+		}
+
 		userID, err := h.ValidateToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
@@ -230,10 +313,17 @@ func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AdminMiddleware is a middleware that checks if the user is an admin
+// AdminMiddleware is a middleware that checks if the user has an admin role.
+// It sets "role" and, for group-scoped admins, "admin_group_ids".
+// API keys are let through; their access is limited per route by RequirePermission.
 // Must be used AFTER AuthMiddleware
 func (h *Handler) AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if _, isAPIKey := c.Get("api_key_id"); isAPIKey {
+			c.Next()
+			return
+		}
+
 		userID, exists := c.Get("user_id")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -241,7 +331,7 @@ func (h *Handler) AdminMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Check if user is admin
+		// Check if user has an admin role
 		var user database.User
 		if err := h.db.First(&user, userID).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
@@ -249,13 +339,26 @@ func (h *Handler) AdminMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if !user.IsAdmin {
+		role := EffectiveRole(user.IsAdmin, user.Role)
+		if !ValidRole(role) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
 		}
 
 		c.Set("is_admin", true)
+		c.Set("role", role)
+		c.Set("username", user.Username)
+
+		// Superadmins always manage all users
+		if role != RoleSuperadmin {
+			var groupIDs []uint
+			h.db.Model(&database.AdminGroupScope{}).Where("user_id = ?", user.ID).Pluck("group_id", &groupIDs)
+			if len(groupIDs) > 0 {
+				c.Set("admin_group_ids", groupIDs)
+			}
+		}
+
 		c.Next()
 	}
 }
@@ -267,6 +370,8 @@ func (h *Handler) CreateUserByAdmin(c *gin.Context) {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required,min=8"`
 		IsAdmin  bool   `json:"is_admin"`
+		Role     string `json:"role"`
+		GroupID  uint   `json:"group_id"` // Add the new user to this group
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -274,6 +379,30 @@ func (h *Handler) CreateUserByAdmin(c *gin.Context) {
 		return
 	}
 
+	if req.Role != "" && !ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role: " + req.Role})
+		return
+	}
+	if (req.IsAdmin || req.Role != "") && !HasPermission(c, PermRolesWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + PermRolesWrite})
+		return
+	}
+
+	// Group-scoped admins may only create users inside their groups
+	if groupIDs, scoped := ManagedGroupIDs(c); scoped {
+		if req.GroupID == 0 || !containsID(groupIDs, req.GroupID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "group_id must be one of your managed groups"})
+			return
+		}
+	}
+	if req.GroupID != 0 {
+		var group database.Group
+		if err := h.db.First(&group, req.GroupID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+			return
+		}
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -288,13 +417,28 @@ func (h *Handler) CreateUserByAdmin(c *gin.Context) {
 		PasswordHash: string(hashedPassword),
 		IsActive:     true,
 		IsAdmin:      req.IsAdmin,
+		Role:         req.Role,
 	}
 
-	if err := h.db.Create(&user).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if req.GroupID != 0 {
+			return tx.Create(&database.UserGroup{UserID: user.ID, GroupID: req.GroupID}).Error
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "username or email already exists"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.create", "user", user.ID, nil, user)
+	if req.GroupID != 0 {
+		audit.Log(c, h.db.DB, "group.add_user", "group", req.GroupID, nil, gin.H{"user_id": user.ID})
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "user created successfully",
 		"user": gin.H{
@@ -302,10 +446,21 @@ func (h *Handler) CreateUserByAdmin(c *gin.Context) {
 			"username": user.Username,
 			"email":    user.Email,
 			"is_admin": user.IsAdmin,
+			"role":     user.Role,
 		},
 	})
 }
 
+// containsID reports whether ids contains id
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
 // ChangePasswordRequest represents the change password request body
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password" binding:"required"`
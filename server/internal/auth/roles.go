@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin roles. Each role is a fixed set of permissions; permissions share
+// their names with API key scopes.
+const (
+	RoleViewer       = "viewer"
+	RoleUserManager  = "user-manager"
+	RoleNetworkAdmin = "network-admin"
+	RoleSuperadmin   = "superadmin"
+)
+
+// Permissions that are only granted through roles, never to API keys
+const (
+	PermTunnelsRead  = "tunnels:read"  // Auth service tunnel registry
+	PermTunnelsWrite = "tunnels:write" // Auth service tunnel registry
+	PermRolesWrite   = "roles:write"   // Assign roles and group scopes
+	PermAPIKeys      = "apikeys:manage"
+)
+
+// AllPermissions lists every permission, including those only granted through roles
+var AllPermissions = append(append([]string{}, AllScopes...), PermTunnelsRead, PermTunnelsWrite, PermRolesWrite, PermAPIKeys)
+
+// AllRoles lists every assignable role, from least to most privileged
+var AllRoles = []string{RoleViewer, RoleUserManager, RoleNetworkAdmin, RoleSuperadmin}
+
+var rolePermissions = map[string][]string{
+	RoleViewer: {
+		ScopeUsersRead, ScopeGroupsRead, ScopeRoutesRead, ScopeNATRead, PermTunnelsRead,
+		ScopeConnectionsRead, ScopeUsageRead,
+	},
+	RoleUserManager: {
+		ScopeUsersRead, ScopeUsersWrite, ScopeGroupsRead, PermTunnelsRead,
+		ScopeConnectionsRead, ScopeConnectionsWrite, ScopeUsageRead,
+	},
+	RoleNetworkAdmin: {
+		ScopeUsersRead, ScopeGroupsRead, ScopeGroupsWrite,
+		ScopeRoutesRead, ScopeRoutesWrite, ScopeRoutesApply,
+		ScopeNATRead, ScopeNATWrite, ScopeNATApply,
+		PermTunnelsRead, PermTunnelsWrite,
+		ScopeConnectionsRead, ScopeConnectionsWrite, ScopeUsageRead,
+	},
+	RoleSuperadmin: nil, // All permissions
+}
+
+// ValidRole reports whether role is a known admin role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// EffectiveRole returns the admin role of a user. Users that predate roles
+// (IsAdmin set, no role) are treated as superadmins. An empty result means
+// the user has no admin access.
+func EffectiveRole(isAdmin bool, role string) string {
+	if role != "" {
+		return role
+	}
+	if isAdmin {
+		return RoleSuperadmin
+	}
+	return ""
+}
+
+// RolePermissions returns the permissions granted by role
+func RolePermissions(role string) []string {
+	if role == RoleSuperadmin {
+		return AllPermissions
+	}
+	return rolePermissions[role]
+}
+
+// RoleHasPermission reports whether role grants perm
+func RoleHasPermission(role, perm string) bool {
+	perms, ok := rolePermissions[role]
+	if !ok {
+		return false
+	}
+	if role == RoleSuperadmin {
+		return true
+	}
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the current request may use perm.
+// API keys are checked against their scopes, users against their role.
+func HasPermission(c *gin.Context, perm string) bool {
+	if scopes, isAPIKey := c.Get("api_key_scopes"); isAPIKey {
+		for _, s := range scopes.([]string) {
+			if s == perm {
+				return true
+			}
+		}
+		return false
+	}
+	return RoleHasPermission(c.GetString("role"), perm)
+}
+
+// CanManageAccount reports whether the current request may change a user
+// with the given admin flag and role, or delete it. Admin accounts require
+// PermRolesWrite, so that lesser admins can't take them over or lock them
+// out (e.g. by deactivating them or setting a tiny quota).
+func CanManageAccount(c *gin.Context, isAdmin bool, role string) bool {
+	return EffectiveRole(isAdmin, role) == "" || HasPermission(c, PermRolesWrite)
+}
+
+// RequirePermission returns a middleware that checks the caller's role
+// (or API key scopes) grants perm.
+// Must be used AFTER an admin middleware that sets "role"
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ManagedGroupIDs returns the groups the current admin's user management is
+// limited to. ok is false when the admin is not group-scoped.
+func ManagedGroupIDs(c *gin.Context) (groupIDs []uint, ok bool) {
+	v, exists := c.Get("admin_group_ids")
+	if !exists {
+		return nil, false
+	}
+	return v.([]uint), true
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEffectiveRole(t *testing.T) {
+	tests := []struct {
+		isAdmin bool
+		role    string
+		want    string
+	}{
+		{false, "", ""},
+		{true, "", RoleSuperadmin},
+		{true, RoleViewer, RoleViewer},
+		{false, RoleUserManager, RoleUserManager},
+	}
+	for _, tt := range tests {
+		if got := EffectiveRole(tt.isAdmin, tt.role); got != tt.want {
+			t.Errorf("EffectiveRole(%v, %q) = %q, want %q", tt.isAdmin, tt.role, got, tt.want)
+		}
+	}
+}
+
+// testContext returns a request context carrying role, or API key scopes
+// unless they are nil
+func testContext(role string, scopes []string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if scopes != nil {
+		c.Set("api_key_scopes", scopes)
+	} else if role != "" {
+		c.Set("role", role)
+	}
+	return c
+}
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		scopes []string
+		perm   string
+		want   bool
+	}{
+		{"viewer reads", RoleViewer, nil, ScopeUsersRead, true},
+		{"viewer writes", RoleViewer, nil, ScopeUsersWrite, false},
+		{"user manager", RoleUserManager, nil, ScopeUsersWrite, true},
+		{"user manager roles", RoleUserManager, nil, PermRolesWrite, false},
+		{"network admin", RoleNetworkAdmin, nil, ScopeRoutesApply, true},
+		{"superadmin", RoleSuperadmin, nil, PermRolesWrite, true},
+		{"unknown role", "root", nil, ScopeUsersRead, false},
+		{"no role", "", nil, ScopeUsersRead, false},
+		{"key scope", "", []string{ScopeUsersRead}, ScopeUsersRead, true},
+		{"key without scope", "", []string{ScopeUsersRead}, ScopeUsersWrite, false},
+		{"key without scopes", "", []string{}, ScopeUsersRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPermission(testContext(tt.role, tt.scopes), tt.perm); got != tt.want {
+				t.Errorf("HasPermission(%q) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanManageAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		callerRole string
+		isAdmin    bool
+		targetRole string
+		want       bool
+	}{
+		{"user", RoleUserManager, false, "", true},
+		{"admin by role", RoleUserManager, false, RoleViewer, false},
+		{"legacy admin", RoleUserManager, true, "", false},
+		{"superadmin target", RoleUserManager, false, RoleSuperadmin, false}, // e.g. deactivating it or setting its quota
+		{"superadmin caller", RoleSuperadmin, false, RoleNetworkAdmin, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanManageAccount(testContext(tt.callerRole, nil), tt.isAdmin, tt.targetRole); got != tt.want {
+				t.Errorf("CanManageAccount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ package authservice
 import (
 	"net/http"
 	"strconv"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/loginguard"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -11,7 +14,8 @@ import (
 
 // AdminHandler handles admin API endpoints
 type AdminHandler struct {
-	db *database.AuthDB
+	db         *database.AuthDB
+	loginGuard *loginguard.Guard
 }
 
 // NewAdminHandler creates a new AdminHandler
@@ -54,6 +58,7 @@ type CreateUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password" binding:"required,min=6"`
 	IsAdmin  bool   `json:"is_admin"`
+	Role     string `json:"role"`
 }
 
 // CreateUser creates a new user
@@ -64,6 +69,10 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	if !checkRoleChange(c, req.IsAdmin || req.Role != "", req.Role) {
+		return
+	}
+
 	// Hash password
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -77,6 +86,7 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 		PasswordHash: string(passwordHash),
 		IsActive:     true,
 		IsAdmin:      req.IsAdmin,
+		Role:         req.Role,
 	}
 
 	if err := h.db.Create(&user).Error; err != nil {
@@ -84,6 +94,8 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.create", "user", user.ID, nil, user)
+
 	c.JSON(http.StatusCreated, gin.H{"user": user})
 }
 
@@ -94,6 +106,7 @@ type UpdateUserRequest struct {
 	Password *string `json:"password"`
 	IsActive *bool   `json:"is_active"`
 	IsAdmin  *bool   `json:"is_admin"`
+	Role     *string `json:"role"`
 }
 
 // UpdateUser updates a user
@@ -109,6 +122,7 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	before := user
 
 	var req UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -116,6 +130,18 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	role := ""
+	if req.Role != nil {
+		role = *req.Role
+	}
+	if !checkRoleChange(c, req.IsAdmin != nil || req.Role != nil, role) {
+		return
+	}
+	if !auth.CanManageAccount(c, user.IsAdmin, user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite + " is required to change an admin"})
+		return
+	}
+
 	if req.Username != nil {
 		user.Username = *req.Username
 	}
@@ -136,15 +162,34 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	if req.IsAdmin != nil {
 		user.IsAdmin = *req.IsAdmin
 	}
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
 
 	if err := h.db.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.update", "user", user.ID, before, user)
+
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
+// checkRoleChange validates a requested role and checks the caller may change
+// privileges. It writes the error response and returns false if not.
+func checkRoleChange(c *gin.Context, changing bool, role string) bool {
+	if role != "" && !auth.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role: " + role})
+		return false
+	}
+	if changing && !auth.HasPermission(c, auth.PermRolesWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite})
+		return false
+	}
+	return true
+}
+
 // DeleteUser deletes a user
 func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -158,15 +203,72 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+	if !auth.CanManageAccount(c, user.IsAdmin, user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + auth.PermRolesWrite + " is required to delete an admin"})
+		return
+	}
 
 	if err := h.db.Delete(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "user.delete", "user", user.ID, user, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "user deleted successfully"})
 }
 
+// UnlockUser clears a user's lockout and failed-login counter
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var user database.AuthUser
+	if err := h.db.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if err := h.db.Model(&user).Update("locked_until", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+		return
+	}
+	if h.loginGuard != nil {
+		h.loginGuard.Unlock(user.Username)
+	}
+
+	audit.Log(c, h.db.DB, "user.unlock", "user", user.ID, gin.H{"locked_until": user.LockedUntil}, gin.H{"locked_until": nil})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+}
+
+// ListLoginFailures returns recent failed logins, optionally filtered by username or IP
+func (h *AdminHandler) ListLoginFailures(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	query := h.db.Order("id DESC").Limit(limit)
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("client_ip = ?", ip)
+	}
+
+	var failures []database.LoginFailure
+	if err := query.Find(&failures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch login failures"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"failures": failures})
+}
+
 // ============ Tunnel Management ============
 
 // ListTunnels returns all tunnels (admin view)
@@ -209,6 +311,7 @@ func (h *AdminHandler) UpdateTunnel(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "tunnel not found"})
 		return
 	}
+	before := tunnel
 
 	var req UpdateTunnelRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -234,6 +337,8 @@ func (h *AdminHandler) UpdateTunnel(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "tunnel.update", "tunnel", tunnel.ID, before, tunnel)
+
 	c.JSON(http.StatusOK, gin.H{"tunnel": tunnel})
 }
 
@@ -255,6 +360,8 @@ func (h *AdminHandler) DeleteTunnel(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "tunnel.delete", "tunnel", tunnel.ID, tunnel, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "tunnel deleted successfully"})
 }
 
@@ -296,6 +403,8 @@ func (h *AdminHandler) SetUserTunnelAccess(c *gin.Context) {
 		return
 	}
 
+	before, _ := h.db.GetUserAllowedTunnels(uint(id))
+
 	// Delete existing access
 	h.db.Where("user_id = ?", id).Delete(&database.UserTunnelAccess{})
 
@@ -308,5 +417,7 @@ func (h *AdminHandler) SetUserTunnelAccess(c *gin.Context) {
 		h.db.Create(&access)
 	}
 
+	audit.Log(c, h.db.DB, "user.set_tunnels", "user", id, gin.H{"tunnel_ids": before}, gin.H{"tunnel_ids": req.TunnelIDs})
+
 	c.JSON(http.StatusOK, gin.H{"message": "access updated"})
 }
@@ -3,7 +3,11 @@ package authservice
 import (
 	"net/http"
 	"time"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/logging"
+	"wire-socket-server/internal/loginguard"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -12,8 +16,10 @@ import (
 
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
-	db        *database.AuthDB
-	jwtSecret string
+	db         *database.AuthDB
+	jwtSecret  string
+	loginGuard *loginguard.Guard
+	events     *events.Bus
 }
 
 // NewAuthHandler creates a new AuthHandler
@@ -46,6 +52,7 @@ type LoginResponse struct {
 	UserID   uint         `json:"user_id"`
 	Username string       `json:"username"`
 	IsAdmin  bool         `json:"is_admin"`
+	Role     string       `json:"role,omitempty"`
 	Tunnels  []TunnelInfo `json:"tunnels"` // Accessible tunnels with connection info
 }
 
@@ -57,24 +64,54 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	clientIP, userAgent := c.ClientIP(), c.Request.UserAgent()
+
+	// Throttle repeated failures from this IP or against this username
+	if wait := h.loginGuard.Check(clientIP, req.Username); wait > 0 {
+		h.loginGuard.LogFailure(clientIP, req.Username, userAgent, "rate limited")
+		publishLoginFailed(c, h.events, 0, req.Username, clientIP, userAgent, "rate limited")
+		loginguard.AbortTooManyRequests(c, wait)
+		return
+	}
+
 	// Find user (allow both admin and regular users)
 	var user database.AuthUser
 	if err := h.db.Where("username = ? AND is_active = ?", req.Username, true).First(&user).Error; err != nil {
+		h.loginGuard.Fail(clientIP, req.Username, userAgent, "user not found or inactive")
+		publishLoginFailed(c, h.events, 0, req.Username, clientIP, userAgent, "user not found or inactive")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	// Reject locked accounts before checking the password
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.loginGuard.LogFailure(clientIP, req.Username, userAgent, "locked")
+		publishLoginFailed(c, h.events, user.ID, user.Username, clientIP, userAgent, "locked")
+		loginguard.AbortLocked(c, *user.LockedUntil)
+		return
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		if h.loginGuard.Fail(clientIP, req.Username, userAgent, "invalid password") {
+			h.db.Model(&user).Update("locked_until", time.Now().Add(h.loginGuard.LockoutDuration()))
+		}
+		publishLoginFailed(c, h.events, user.ID, user.Username, clientIP, userAgent, "invalid password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	h.loginGuard.Succeed(req.Username)
+	if user.LockedUntil != nil {
+		h.db.Model(&user).Update("locked_until", nil)
+	}
+
 	// Generate JWT token
 	expires := time.Now().Add(24 * time.Hour)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id":  user.ID,
 		"username": user.Username,
 		"is_admin": user.IsAdmin,
+		"role":     auth.EffectiveRole(user.IsAdmin, user.Role),
 		"exp":      expires.Unix(),
 	})
 
@@ -91,12 +128,21 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	logging.Request(c).Info("user logged in", "user", user.Username, "user_id", user.ID, "client_ip", clientIP)
+	h.events.Publish(events.UserLogin, events.LoginData{
+		UserID:    user.ID,
+		Username:  user.Username,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+	})
+
 	c.JSON(http.StatusOK, LoginResponse{
 		Token:    tokenString,
 		Expires:  expires.Unix(),
 		UserID:   user.ID,
 		Username: user.Username,
 		IsAdmin:  user.IsAdmin,
+		Role:     auth.EffectiveRole(user.IsAdmin, user.Role),
 		Tunnels:  tunnels,
 	})
 }
@@ -168,15 +214,17 @@ func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 		c.Set("user_id", uint(claims["user_id"].(float64)))
 		c.Set("username", claims["username"].(string))
 		c.Set("is_admin", claims["is_admin"].(bool))
+		// Tokens issued before roles existed carry no role claim
+		role, _ := claims["role"].(string)
+		c.Set("role", auth.EffectiveRole(claims["is_admin"].(bool), role))
 		c.Next()
 	}
 }
 
-// AdminMiddleware ensures the user is an admin
+// AdminMiddleware ensures the user has an admin role
 func (h *AuthHandler) AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		isAdmin, exists := c.Get("is_admin")
-		if !exists || !isAdmin.(bool) {
+		if !auth.ValidRole(c.GetString("role")) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
@@ -184,3 +232,16 @@ func (h *AuthHandler) AdminMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// publishLoginFailed logs a failed login and publishes user.login_failed.
+// userID is 0 when the user is unknown.
+func publishLoginFailed(c *gin.Context, bus *events.Bus, userID uint, username, clientIP, userAgent, reason string) {
+	logging.Request(c).Warn("login failed", "user", username, "user_id", userID, "client_ip", clientIP, "reason", reason)
+	bus.Publish(events.UserLoginFailed, events.LoginData{
+		UserID:    userID,
+		Username:  username,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,95 @@
+package authservice
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+)
+
+// TunnelMonitor publishes tunnel.offline when an active tunnel node has not
+// contacted the auth service (heartbeat, verify) within the timeout. Each
+// outage is reported once; the tunnel is reported again after it comes back.
+type TunnelMonitor struct {
+	db      *database.AuthDB
+	bus     *events.Bus
+	timeout time.Duration
+
+	offline map[string]bool // Tunnel IDs already reported
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewTunnelMonitor creates a TunnelMonitor (timeout default: 3m)
+func NewTunnelMonitor(db *database.AuthDB, bus *events.Bus, timeout time.Duration) *TunnelMonitor {
+	if timeout <= 0 {
+		timeout = 3 * time.Minute
+	}
+	return &TunnelMonitor{
+		db:      db,
+		bus:     bus,
+		timeout: timeout,
+		offline: make(map[string]bool),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+}
+
+// Start begins checking in the background
+func (m *TunnelMonitor) Start() {
+	go func() {
+		defer close(m.doneCh)
+
+		ticker := time.NewTicker(m.timeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.Check()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking
+func (m *TunnelMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+	<-m.doneCh
+}
+
+// Check compares every active tunnel's last contact with the timeout
+func (m *TunnelMonitor) Check() {
+	var tunnels []database.Tunnel
+	if err := m.db.Where("is_active = ?", true).Find(&tunnels).Error; err != nil {
+		slog.Warn("tunnel monitor check failed", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, t := range tunnels {
+		down := now.Sub(t.LastSeen) > m.timeout
+		if !down {
+			delete(m.offline, t.ID)
+			continue
+		}
+		if m.offline[t.ID] {
+			continue
+		}
+		m.offline[t.ID] = true
+
+		slog.Warn("tunnel is offline", "tunnel_id", t.ID, "tunnel", t.Name, "last_seen", t.LastSeen.Format(time.RFC3339))
+		m.bus.Publish(events.TunnelOffline, events.TunnelData{
+			TunnelID: t.ID,
+			Name:     t.Name,
+			URL:      t.URL,
+			Region:   t.Region,
+			LastSeen: t.LastSeen,
+		})
+	}
+}
@@ -1,7 +1,13 @@
 package authservice
 
 import (
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/loginguard"
+	"wire-socket-server/internal/metrics"
+	"wire-socket-server/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,37 +18,75 @@ type Router struct {
 	authHandler   *AuthHandler
 	adminHandler  *AdminHandler
 	tunnelHandler *TunnelHandler
+	metrics       *metrics.Metrics
+	webhooks      *webhook.Handler
 }
 
-// NewRouter creates a new Router
+// NewRouter creates a new Router with in-memory login protection
 func NewRouter(db *database.AuthDB, jwtSecret string) *Router {
-	return &Router{
+	r := &Router{
 		db:            db,
 		authHandler:   NewAuthHandler(db, jwtSecret),
 		adminHandler:  NewAdminHandler(db),
 		tunnelHandler: NewTunnelHandler(db),
 	}
+	guard, _ := loginguard.New(loginguard.Config{}, db.DB)
+	r.SetLoginGuard(guard)
+	return r
 }
 
-// SetupRoutes configures all routes
+// SetLoginGuard sets the login brute-force protection used by admin login,
+// tunnel verification and user unlock. Use a "db" store when several auth
+// service instances share the database.
+func (r *Router) SetLoginGuard(guard *loginguard.Guard) {
+	r.authHandler.loginGuard = guard
+	r.adminHandler.loginGuard = guard
+	r.tunnelHandler.loginGuard = guard
+}
+
+// SetMetrics enables /metrics and login instrumentation. Call before SetupRoutes.
+func (r *Router) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
+// SetEvents sets the bus that receives login events from admin login and
+// tunnel verification
+func (r *Router) SetEvents(bus *events.Bus) {
+	r.authHandler.events = bus
+	r.tunnelHandler.events = bus
+}
+
+// SetWebhooks enables the webhook admin API. Call before SetupRoutes.
+func (r *Router) SetWebhooks(h *webhook.Handler) {
+	r.webhooks = h
+}
+
+// SetupRoutes configures all routes. The engine trusts no reverse proxy, so
+// login throttling uses the connection's address rather than a
+// client-supplied X-Forwarded-For header.
 func (r *Router) SetupRoutes(engine *gin.Engine) {
+	engine.SetTrustedProxies(nil)
+
 	// Health check
 	engine.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Prometheus metrics
+	r.metrics.Mount(engine)
+
 	api := engine.Group("/api")
 	{
 		// Auth endpoints (for admin login)
-		auth := api.Group("/auth")
+		authRoutes := api.Group("/auth")
 		{
-			auth.POST("/login", r.authHandler.Login)
+			authRoutes.POST("/login", r.metrics.LoginMiddleware(), r.authHandler.Login)
 		}
 
 		// Tunnel endpoints (for tunnel nodes)
 		tunnel := api.Group("/tunnel")
 		{
-			tunnel.POST("/verify", r.tunnelHandler.Verify)
+			tunnel.POST("/verify", r.metrics.LoginMiddleware(), r.tunnelHandler.Verify)
 			tunnel.POST("/register", r.tunnelHandler.Register)
 			tunnel.POST("/heartbeat", r.tunnelHandler.Heartbeat)
 		}
@@ -50,24 +94,43 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 		// Public endpoints
 		api.GET("/tunnels", r.tunnelHandler.ListTunnels)
 
-		// Admin endpoints (require auth)
+		// Admin endpoints (require auth + a permission granted by the admin's role)
 		admin := api.Group("/admin")
 		admin.Use(r.authHandler.AuthMiddleware(), r.authHandler.AdminMiddleware())
+		perm := auth.RequirePermission
 		{
 			// User management
-			admin.GET("/users", r.adminHandler.ListUsers)
-			admin.POST("/users", r.adminHandler.CreateUser)
-			admin.GET("/users/:id", r.adminHandler.GetUser)
-			admin.PUT("/users/:id", r.adminHandler.UpdateUser)
-			admin.DELETE("/users/:id", r.adminHandler.DeleteUser)
-			admin.GET("/users/:id/tunnels", r.adminHandler.GetUserTunnelAccess)
-			admin.PUT("/users/:id/tunnels", r.adminHandler.SetUserTunnelAccess)
+			admin.GET("/users", perm(auth.ScopeUsersRead), r.adminHandler.ListUsers)
+			admin.POST("/users", perm(auth.ScopeUsersWrite), r.adminHandler.CreateUser)
+			admin.GET("/users/:id", perm(auth.ScopeUsersRead), r.adminHandler.GetUser)
+			admin.PUT("/users/:id", perm(auth.ScopeUsersWrite), r.adminHandler.UpdateUser)
+			admin.DELETE("/users/:id", perm(auth.ScopeUsersWrite), r.adminHandler.DeleteUser)
+			admin.POST("/users/:id/unlock", perm(auth.ScopeUsersWrite), r.adminHandler.UnlockUser)
+			admin.GET("/login-failures", perm(auth.ScopeUsersRead), r.adminHandler.ListLoginFailures)
+			admin.GET("/users/:id/tunnels", perm(auth.ScopeUsersRead), r.adminHandler.GetUserTunnelAccess)
+			admin.PUT("/users/:id/tunnels", perm(auth.ScopeUsersWrite), r.adminHandler.SetUserTunnelAccess)
 
 			// Tunnel management
-			admin.GET("/tunnels", r.adminHandler.ListTunnels)
-			admin.GET("/tunnels/:id", r.adminHandler.GetTunnel)
-			admin.PUT("/tunnels/:id", r.adminHandler.UpdateTunnel)
-			admin.DELETE("/tunnels/:id", r.adminHandler.DeleteTunnel)
+			admin.GET("/tunnels", perm(auth.PermTunnelsRead), r.adminHandler.ListTunnels)
+			admin.GET("/tunnels/:id", perm(auth.PermTunnelsRead), r.adminHandler.GetTunnel)
+			admin.PUT("/tunnels/:id", perm(auth.PermTunnelsWrite), r.adminHandler.UpdateTunnel)
+			admin.DELETE("/tunnels/:id", perm(auth.PermTunnelsWrite), r.adminHandler.DeleteTunnel)
+
+			// Audit log
+			admin.GET("/audit", perm(auth.ScopeAuditRead), audit.ListHandler(r.db.DB))
+
+			// Webhooks
+			if r.webhooks != nil {
+				admin.GET("/webhooks", perm(auth.ScopeWebhooksRead), r.webhooks.List)
+				admin.POST("/webhooks", perm(auth.ScopeWebhooksWrite), r.webhooks.Create)
+				admin.GET("/webhooks/:id", perm(auth.ScopeWebhooksRead), r.webhooks.Get)
+				admin.PUT("/webhooks/:id", perm(auth.ScopeWebhooksWrite), r.webhooks.Update)
+				admin.DELETE("/webhooks/:id", perm(auth.ScopeWebhooksWrite), r.webhooks.Delete)
+				admin.POST("/webhooks/:id/test", perm(auth.ScopeWebhooksWrite), r.webhooks.Test)
+				admin.GET("/webhooks/:id/dead-letters", perm(auth.ScopeWebhooksRead), r.webhooks.ListDeadLetters)
+				admin.POST("/webhooks/:id/dead-letters/:letter_id/redeliver", perm(auth.ScopeWebhooksWrite), r.webhooks.RedeliverDeadLetter)
+				admin.DELETE("/webhooks/:id/dead-letters/:letter_id", perm(auth.ScopeWebhooksWrite), r.webhooks.DeleteDeadLetter)
+			}
 		}
 	}
 }
@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"time"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/logging"
+	"wire-socket-server/internal/loginguard"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -11,7 +14,9 @@ import (
 
 // TunnelHandler handles tunnel-related API endpoints
 type TunnelHandler struct {
-	db *database.AuthDB
+	db         *database.AuthDB
+	loginGuard *loginguard.Guard
+	events     *events.Bus
 }
 
 // NewTunnelHandler creates a new TunnelHandler
@@ -24,6 +29,10 @@ type VerifyRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	TunnelID string `json:"tunnel_id" binding:"required"`
+
+	// End-user details forwarded by the tunnel node for brute-force protection
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
 }
 
 // VerifyResponse to tunnel node
@@ -33,6 +42,7 @@ type VerifyResponse struct {
 	Username       string   `json:"username,omitempty"`
 	AllowedTunnels []string `json:"allowed_tunnels,omitempty"`
 	Error          string   `json:"error,omitempty"`
+	RetryAfter     int      `json:"retry_after,omitempty"` // Seconds, set when rate limited (429) or locked (423)
 }
 
 // Verify handles POST /api/tunnel/verify - called by tunnel nodes
@@ -56,9 +66,28 @@ func (h *TunnelHandler) Verify(c *gin.Context) {
 		return
 	}
 
+	clientIP := req.ClientIP
+	if clientIP == "" {
+		clientIP = c.ClientIP()
+	}
+
+	// Throttle repeated failures from this IP or against this username
+	if wait := h.loginGuard.Check(clientIP, req.Username); wait > 0 {
+		h.loginGuard.LogFailure(clientIP, req.Username, req.UserAgent, "rate limited")
+		publishLoginFailed(c, h.events, 0, req.Username, clientIP, req.UserAgent, "rate limited")
+		c.JSON(http.StatusTooManyRequests, VerifyResponse{
+			Valid:      false,
+			Error:      "too many failed login attempts, try again later",
+			RetryAfter: loginguard.RetryAfterSeconds(wait),
+		})
+		return
+	}
+
 	// Find user
 	var user database.AuthUser
 	if err := h.db.Where("username = ? AND is_active = ?", req.Username, true).First(&user).Error; err != nil {
+		h.loginGuard.Fail(clientIP, req.Username, req.UserAgent, "user not found or inactive")
+		publishLoginFailed(c, h.events, 0, req.Username, clientIP, req.UserAgent, "user not found or inactive")
 		c.JSON(http.StatusOK, VerifyResponse{
 			Valid: false,
 			Error: "user not found or inactive",
@@ -66,8 +95,24 @@ func (h *TunnelHandler) Verify(c *gin.Context) {
 		return
 	}
 
+	// Reject locked accounts before checking the password
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.loginGuard.LogFailure(clientIP, req.Username, req.UserAgent, "locked")
+		publishLoginFailed(c, h.events, user.ID, user.Username, clientIP, req.UserAgent, "locked")
+		c.JSON(http.StatusLocked, VerifyResponse{
+			Valid:      false,
+			Error:      "account is temporarily locked",
+			RetryAfter: loginguard.RetryAfterSeconds(time.Until(*user.LockedUntil)),
+		})
+		return
+	}
+
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		if h.loginGuard.Fail(clientIP, req.Username, req.UserAgent, "invalid password") {
+			h.db.Model(&user).Update("locked_until", time.Now().Add(h.loginGuard.LockoutDuration()))
+		}
+		publishLoginFailed(c, h.events, user.ID, user.Username, clientIP, req.UserAgent, "invalid password")
 		c.JSON(http.StatusOK, VerifyResponse{
 			Valid: false,
 			Error: "invalid password",
@@ -75,6 +120,11 @@ func (h *TunnelHandler) Verify(c *gin.Context) {
 		return
 	}
 
+	h.loginGuard.Succeed(req.Username)
+	if user.LockedUntil != nil {
+		h.db.Model(&user).Update("locked_until", nil)
+	}
+
 	// Get allowed tunnels
 	allowedTunnels, err := h.db.GetUserAllowedTunnels(user.ID)
 	if err != nil {
@@ -102,6 +152,14 @@ func (h *TunnelHandler) Verify(c *gin.Context) {
 		return
 	}
 
+	logging.Request(c).Info("user logged in", "user", user.Username, "user_id", user.ID, "client_ip", clientIP)
+	h.events.Publish(events.UserLogin, events.LoginData{
+		UserID:    user.ID,
+		Username:  user.Username,
+		ClientIP:  clientIP,
+		UserAgent: req.UserAgent,
+	})
+
 	c.JSON(http.StatusOK, VerifyResponse{
 		Valid:          true,
 		UserID:         user.ID,
@@ -0,0 +1,111 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig configures certificates from an ACME CA
+type ACMEConfig struct {
+	Domains      []string      `yaml:"domains"`       // Hostnames to request certificates for
+	Email        string        `yaml:"email"`         // Contact address for expiry notices (optional)
+	CacheDir     string        `yaml:"cache_dir"`     // Where account keys and certificates are kept
+	DirectoryURL string        `yaml:"directory_url"` // ACME directory (default: Let's Encrypt production)
+	CAFile       string        `yaml:"ca_file"`       // PEM roots trusted for the directory, e.g. for pebble (optional)
+	HTTPAddr     string        `yaml:"http_addr"`     // Listener for HTTP-01 challenges, e.g. ":80"; empty uses TLS-ALPN-01 only
+	RenewBefore  time.Duration `yaml:"renew_before"`  // Renew this long before expiry (default: 720h = 30 days)
+}
+
+// ACME obtains and renews certificates for the configured domains. Both
+// TLS-ALPN-01 (answered on the TLS listeners) and, with an HTTP listener,
+// HTTP-01 challenges are supported.
+type ACME struct {
+	manager  *autocert.Manager
+	httpAddr string
+}
+
+// NewACME creates an ACME certificate manager. Certificates are requested on
+// the first handshake for a domain and renewed in the background.
+func NewACME(cfg ACMEConfig) (*ACME, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, fmt.Errorf("no ACME domains configured")
+	}
+	if cfg.CacheDir == "" {
+		return nil, fmt.Errorf("no ACME cache directory configured")
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ACME CA file %s", cfg.CAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	domains := make([]string, len(cfg.Domains))
+	for i, domain := range cfg.Domains {
+		domains[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cfg.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(domains...),
+		RenewBefore: cfg.RenewBefore,
+		Email:       cfg.Email,
+		Client:      client,
+	}
+	return &ACME{manager: manager, httpAddr: cfg.HTTPAddr}, nil
+}
+
+// GetCertificate returns the certificate for the requested domain, obtaining
+// it first if needed, and answers TLS-ALPN-01 challenges
+func (a *ACME) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return a.manager.GetCertificate(hello)
+}
+
+// TLSConfig returns a TLS configuration serving ACME certificates. nextProtos
+// are the application protocols of the listener; the TLS-ALPN-01 protocol is
+// added.
+func (a *ACME) TLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: a.GetCertificate,
+		NextProtos:     append(nextProtos, acme.ALPNProto),
+	}
+}
+
+// HTTPServer returns a server answering HTTP-01 challenges on the configured
+// address and redirecting other requests to HTTPS, or nil if no address is
+// configured
+func (a *ACME) HTTPServer() *http.Server {
+	if a.httpAddr == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr:              a.httpAddr,
+		Handler:           a.manager.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
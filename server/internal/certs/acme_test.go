@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+)
+
+// TestACMEPebble obtains a certificate from a pebble test CA. It runs only
+// when PEBBLE_DIRECTORY_URL (e.g. https://localhost:14000/dir) and
+// PEBBLE_CA_FILE (pebble's test/certs/pebble.minica.pem) are set; start
+// pebble with PEBBLE_VA_ALWAYS_VALID=1 so it skips challenge validation.
+func TestACMEPebble(t *testing.T) {
+	directoryURL, caFile := os.Getenv("PEBBLE_DIRECTORY_URL"), os.Getenv("PEBBLE_CA_FILE")
+	if directoryURL == "" || caFile == "" {
+		t.Skip("PEBBLE_DIRECTORY_URL and PEBBLE_CA_FILE not set")
+	}
+
+	const domain = "vpn.example.com"
+	a, err := NewACME(ACMEConfig{
+		Domains:      []string{domain},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: directoryURL,
+		CAFile:       caFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.VerifyHostname(domain) != nil {
+		t.Fatalf("certificate does not cover %s", domain)
+	}
+
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("GetCertificate issued a certificate for an unconfigured domain")
+	}
+}
+
+func TestNewACME(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ACMEConfig
+		wantErr bool
+	}{
+		{"valid", ACMEConfig{Domains: []string{"vpn.example.com"}, CacheDir: t.TempDir()}, false},
+		{"no domains", ACMEConfig{CacheDir: t.TempDir()}, true},
+		{"no cache directory", ACMEConfig{Domains: []string{"vpn.example.com"}}, true},
+		{"missing CA file", ACMEConfig{Domains: []string{"vpn.example.com"}, CacheDir: t.TempDir(), CAFile: "/nonexistent/ca.pem"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewACME(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("NewACME error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package certs provides the TLS certificates of the API and tunnel
+// listeners: from certificate files that are reloaded when they change, or
+// obtained and renewed from an ACME CA such as Let's Encrypt. Certificates are
+// served through tls.Config.GetCertificate, so a new certificate applies to
+// new handshakes while established connections and tunnels stay up.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often File.Watch checks the files for changes
+const DefaultWatchInterval = time.Minute
+
+// expiryWarning is how long before expiry a loaded certificate is logged as
+// about to expire
+const expiryWarning = 7 * 24 * time.Hour
+
+// File is a certificate loaded from a PEM certificate and key file pair
+type File struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certStat fileStamp
+	keyStat  fileStamp
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// LoadFile loads a certificate and its key
+func LoadFile(certFile, keyFile string) (*File, error) {
+	f := &File{certFile: certFile, keyFile: keyFile}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the files if either changed since they were last loaded and
+// reports whether the certificate was replaced. On error the current
+// certificate is kept.
+func (f *File) Reload() (bool, error) {
+	certStat, err := stampOf(f.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyStat, err := stampOf(f.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	f.mu.RLock()
+	unchanged := f.cert != nil && certStat == f.certStat && keyStat == f.keyStat
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate %s: %w", f.certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, fmt.Errorf("failed to parse certificate %s: %w", f.certFile, err)
+		}
+	}
+
+	f.mu.Lock()
+	f.cert = &cert
+	f.certStat = certStat
+	f.keyStat = keyStat
+	f.mu.Unlock()
+
+	logLoaded(f.certFile, cert.Leaf)
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate
+func (f *File) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cert, nil
+}
+
+// TLSConfig returns a TLS configuration serving the current certificate
+func (f *File) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: f.GetCertificate,
+	}
+}
+
+// Watch reloads the certificate whenever the files change, e.g. after an
+// external renewal by certbot, until ctx is done. Failed reloads are logged
+// and retried on the next change.
+func (f *File) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := f.Reload(); err != nil {
+				slog.Warn("failed to reload TLS certificate, keeping the current one", "cert_file", f.certFile, "error", err)
+			}
+		}
+	}
+}
+
+// logLoaded logs a newly loaded certificate, as a warning if it expires soon
+func logLoaded(source string, leaf *x509.Certificate) {
+	attrs := []any{
+		"source", source,
+		"subject", leaf.Subject.CommonName,
+		"dns_names", leaf.DNSNames,
+		"not_after", leaf.NotAfter,
+		"pin", Pin(leaf),
+	}
+	if time.Until(leaf.NotAfter) < expiryWarning {
+		slog.Warn("TLS certificate loaded, expires soon", attrs...)
+		return
+	}
+	slog.Info("TLS certificate loaded", attrs...)
+}
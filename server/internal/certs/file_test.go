@@ -0,0 +1,138 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a new self-signed certificate for name and its key
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of the files forward, so a replacement
+// within the file system's timestamp resolution is still noticed
+func touch(t *testing.T, paths ...string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	for _, path := range paths {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func leafName(t *testing.T, f *File) string {
+	t.Helper()
+	cert, err := f.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestFileWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "old.example.com")
+
+	f, err := LoadFile(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := leafName(t, f); name != "old.example.com" {
+		t.Fatalf("loaded certificate for %s, want old.example.com", name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, 10*time.Millisecond)
+
+	writeCert(t, certFile, keyFile, "new.example.com")
+	touch(t, certFile, keyFile)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leafName(t, f) != "new.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not load the replaced certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "old.example.com")
+
+	f, err := LoadFile(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := f.Reload(); changed || err != nil {
+		t.Fatalf("Reload of unchanged files = %v, %v, want false, nil", changed, err)
+	}
+
+	// A half-written renewal keeps the current certificate
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, keyFile)
+	if _, err := f.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid key")
+	}
+	if name := leafName(t, f); name != "old.example.com" {
+		t.Fatalf("serving certificate for %s after a failed reload, want old.example.com", name)
+	}
+
+	writeCert(t, certFile, keyFile, "new.example.com")
+	touch(t, certFile, keyFile)
+	if changed, err := f.Reload(); !changed || err != nil {
+		t.Fatalf("Reload of replaced files = %v, %v, want true, nil", changed, err)
+	}
+	if name := leafName(t, f); name != "new.example.com" {
+		t.Fatalf("serving certificate for %s, want new.example.com", name)
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFile(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Fatal("LoadFile succeeded without files")
+	}
+}
@@ -0,0 +1,29 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// pinPrefix starts SPKI pins
+const pinPrefix = "sha256/"
+
+// Pin returns the SPKI pin of a certificate: "sha256/" followed by the base64
+// SHA-256 hash of its public key. Clients can require pinned keys in the
+// certificate chain.
+func Pin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ValidatePin checks the format of an SPKI pin
+func ValidatePin(pin string) error {
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("invalid pin %q (want %s<base64 SHA-256 of the public key>)", pin, pinPrefix)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditImmutable is returned when something tries to modify or delete an audit entry
+var ErrAuditImmutable = errors.New("audit log entries cannot be modified")
+
+// AuditLog records an administrative change. Shared by the monolith, auth service
+// and tunnel service databases. Entries are append-only.
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ActorType  string    `gorm:"column:actor_type;index" json:"actor_type"` // user, api_key, wsctl
+	ActorID    uint      `gorm:"column:actor_id" json:"actor_id,omitempty"` // User or API key ID
+	Actor      string    `gorm:"column:actor;index" json:"actor"`           // e.g., "alice", "apikey:ci-bot", "wsctl:root"
+	Action     string    `gorm:"column:action;index" json:"action"`         // e.g., "user.update", "nat.apply"
+	TargetType string    `gorm:"column:target_type;index" json:"target_type"`
+	TargetID   string    `gorm:"column:target_id;index" json:"target_id,omitempty"`
+	Changes    string    `gorm:"column:changes;type:text" json:"changes,omitempty"` // JSON object: {"field": [before, after]}
+	SourceIP   string    `gorm:"column:source_ip" json:"source_ip,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// BeforeUpdate keeps audit entries append-only
+func (AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditImmutable
+}
+
+// BeforeDelete keeps audit entries append-only
+func (AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditImmutable
+}
@@ -1,7 +1,7 @@
 package database
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -13,14 +13,16 @@ import (
 
 // AuthUser represents a user account (managed by auth service)
 type AuthUser struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	Username     string    `gorm:"column:username;uniqueIndex;not null" json:"username"`
-	Email        string    `gorm:"column:email;uniqueIndex" json:"email"`
-	PasswordHash string    `gorm:"column:password_hash;not null" json:"-"`
-	IsActive     bool      `gorm:"column:is_active;default:true" json:"is_active"`
-	IsAdmin      bool      `gorm:"column:is_admin;default:false" json:"is_admin"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"column:username;uniqueIndex;not null" json:"username"`
+	Email        string     `gorm:"column:email;uniqueIndex" json:"email"`
+	PasswordHash string     `gorm:"column:password_hash;not null" json:"-"`
+	IsActive     bool       `gorm:"column:is_active;default:true" json:"is_active"`
+	IsAdmin      bool       `gorm:"column:is_admin;default:false" json:"is_admin"`
+	Role         string     `gorm:"column:role" json:"role,omitempty"`                 // Admin role (viewer, user-manager, network-admin, superadmin)
+	LockedUntil  *time.Time `gorm:"column:locked_until" json:"locked_until,omitempty"` // Set after too many failed logins
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
 // TableName overrides the table name for AuthUser
@@ -93,6 +95,11 @@ func (db *AuthDB) AutoMigrate() error {
 		&Tunnel{},
 		&UserTunnelAccess{},
 		&AuthSession{},
+		&LoginAttempt{},
+		&LoginFailure{},
+		&AuditLog{},
+		&Webhook{},
+		&WebhookDeadLetter{},
 	)
 }
 
@@ -101,7 +108,7 @@ func (db *AuthDB) InitAdmin(passwordHash string) error {
 	var count int64
 	db.Model(&AuthUser{}).Where("is_admin = ?", true).Count(&count)
 	if count > 0 {
-		log.Println("Admin user already exists")
+		slog.Debug("admin user already exists")
 		return nil
 	}
 
@@ -117,7 +124,7 @@ func (db *AuthDB) InitAdmin(passwordHash string) error {
 		return err
 	}
 
-	log.Println("Created default admin user: admin")
+	slog.Warn("created default admin user, change its password immediately", "username", "admin")
 	return nil
 }
 
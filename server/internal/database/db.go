@@ -10,14 +10,18 @@ import (
 
 // User represents a VPN user
 type User struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	Username     string    `gorm:"column:username;unique;not null" json:"username"`
-	Email        string    `gorm:"column:email;unique;not null" json:"email"`
-	PasswordHash string    `gorm:"column:password_hash;not null" json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	IsActive     bool      `gorm:"column:is_active;default:true" json:"is_active"`
-	IsAdmin      bool      `gorm:"column:is_admin;default:false" json:"is_admin"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Username      string     `gorm:"column:username;unique;not null" json:"username"`
+	Email         string     `gorm:"column:email;unique;not null" json:"email"`
+	PasswordHash  string     `gorm:"column:password_hash;not null" json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	IsActive      bool       `gorm:"column:is_active;default:true" json:"is_active"`
+	IsAdmin       bool       `gorm:"column:is_admin;default:false" json:"is_admin"`
+	Role          string     `gorm:"column:role" json:"role,omitempty"`                       // Admin role (viewer, user-manager, network-admin, superadmin)
+	LockedUntil   *time.Time `gorm:"column:locked_until" json:"locked_until,omitempty"`       // Set after too many failed logins
+	QuotaBytes    int64      `gorm:"column:quota_bytes;default:0" json:"quota_bytes"`         // Monthly data quota (0 = inherit from groups)
+	RateLimitKbps int        `gorm:"column:rate_limit_kbps;default:0" json:"rate_limit_kbps"` // Tunnel bandwidth limit per direction (0 = inherit from groups)
 }
 
 // Server represents a VPN server configuration
@@ -112,11 +116,13 @@ type NATRule struct {
 
 // Group represents a user group for route assignment
 type Group struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	Name        string    `gorm:"column:name;unique;not null" json:"name"`
-	Description string    `gorm:"column:description" json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"column:name;unique;not null" json:"name"`
+	Description   string    `gorm:"column:description" json:"description"`
+	QuotaBytes    int64     `gorm:"column:quota_bytes;default:0" json:"quota_bytes"`         // Monthly data quota for members (0 = unlimited)
+	RateLimitKbps int       `gorm:"column:rate_limit_kbps;default:0" json:"rate_limit_kbps"` // Tunnel bandwidth limit for members (0 = unlimited)
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // UserGroup is a many-to-many join table between users and groups
@@ -141,6 +147,44 @@ type RouteGroup struct {
 	Group Group `gorm:"foreignKey:GroupID" json:"-"`
 }
 
+// AdminGroupScope limits a user-manager's user administration to members of a group.
+// An admin with no scope rows may manage all users.
+type AdminGroupScope struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"column:user_id;not null;uniqueIndex:idx_admin_group_scope" json:"user_id"`
+	GroupID   uint      `gorm:"column:group_id;not null;uniqueIndex:idx_admin_group_scope" json:"group_id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	User  User  `gorm:"foreignKey:UserID" json:"-"`
+	Group Group `gorm:"foreignKey:GroupID" json:"-"`
+}
+
+// APIKey represents a service-account API key used by automation.
+// Only a hash of the secret is stored; the full key is shown once on creation.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"column:name;unique;not null" json:"name"`
+	Prefix     string     `gorm:"column:prefix;uniqueIndex;not null" json:"prefix"` // Public part of the key, used for lookup
+	KeyHash    string     `gorm:"column:key_hash;not null" json:"-"`                // SHA-256 of the full key
+	Scopes     []string   `gorm:"column:scopes;serializer:json" json:"scopes"`      // e.g., ["users:read", "nat:apply"]
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	CreatedBy  uint       `gorm:"column:created_by" json:"created_by"` // Admin user ID (0 = created via wsctl)
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyLog records a request made with an API key
+type APIKeyLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID  uint      `gorm:"column:api_key_id;not null;index" json:"api_key_id"`
+	Method    string    `gorm:"column:method" json:"method"`
+	Path      string    `gorm:"column:path" json:"path"`
+	Status    int       `gorm:"column:status" json:"status"`
+	ClientIP  string    `gorm:"column:client_ip" json:"client_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // DB holds the database connection
 type DB struct {
 	*gorm.DB
@@ -159,7 +203,7 @@ func NewDB(dbPath string) (*DB, error) {
 	}
 
 	// Auto-migrate schemas
-	if err := db.AutoMigrate(&User{}, &Server{}, &AllocatedIP{}, &Session{}, &Route{}, &NATRule{}, &Group{}, &UserGroup{}, &RouteGroup{}); err != nil {
+	if err := db.AutoMigrate(&User{}, &Server{}, &AllocatedIP{}, &Session{}, &Route{}, &NATRule{}, &Group{}, &UserGroup{}, &RouteGroup{}, &AdminGroupScope{}, &APIKey{}, &APIKeyLog{}, &LoginAttempt{}, &LoginFailure{}, &AuditLog{}, &UsageHourly{}, &UsageDaily{}, &Webhook{}, &WebhookDeadLetter{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
@@ -0,0 +1,24 @@
+package database
+
+import "time"
+
+// ============ Login Protection Models ============
+// Shared by the monolith and auth service databases
+
+// LoginAttempt tracks recent failed logins for a rate-limit key ("ip:<addr>" or "user:<name>").
+// Used by the database-backed limiter store so several auth instances share state.
+type LoginAttempt struct {
+	Key         string    `gorm:"column:attempt_key;primaryKey" json:"key"`
+	Count       int       `gorm:"column:count;not null" json:"count"`
+	LastFailure time.Time `gorm:"column:last_failure;not null" json:"last_failure"`
+}
+
+// LoginFailure records a failed login for auditing
+type LoginFailure struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"column:username;index" json:"username"`
+	ClientIP  string    `gorm:"column:client_ip;index" json:"client_ip"`
+	UserAgent string    `gorm:"column:user_agent" json:"user_agent"`
+	Reason    string    `gorm:"column:reason" json:"reason"` // e.g., "invalid password", "rate limited", "locked"
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -1,7 +1,7 @@
 package database
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -103,6 +103,7 @@ func (db *TunnelDB) AutoMigrate() error {
 		&TunnelAllocatedIP{},
 		&TunnelRoute{},
 		&TunnelNATRule{},
+		&AuditLog{},
 	); err != nil {
 		return err
 	}
@@ -118,13 +119,13 @@ func (db *TunnelDB) migrateColumnNames() {
 	var count int64
 	db.Raw("SELECT COUNT(*) FROM pragma_table_info('routes') WHERE name = 'c_id_r'").Scan(&count)
 	if count > 0 {
-		log.Println("Migrating routes table: c_id_r -> cidr")
+		slog.Info("migrating routes table: c_id_r -> cidr")
 		db.Exec("ALTER TABLE routes RENAME COLUMN c_id_r TO cidr")
 	}
 
 	db.Raw("SELECT COUNT(*) FROM pragma_table_info('nat_rules') WHERE name = 'm_s_s'").Scan(&count)
 	if count > 0 {
-		log.Println("Migrating nat_rules table: m_s_s -> mss")
+		slog.Info("migrating nat_rules table: m_s_s -> mss")
 		db.Exec("ALTER TABLE nat_rules RENAME COLUMN m_s_s TO mss")
 	}
 }
@@ -0,0 +1,39 @@
+package database
+
+import "time"
+
+// ============ Usage Accounting Models ============
+
+// UsageHourly is the traffic of one device (IP allocation) during one hour.
+// Bucket is the start of the hour in UTC; rx is traffic received by the
+// server from the device (upload), tx is traffic sent to it (download).
+type UsageHourly struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"column:user_id;not null;uniqueIndex:idx_usage_hourly_key,priority:2;index" json:"user_id"`
+	AllocationID uint      `gorm:"column:allocation_id;not null;uniqueIndex:idx_usage_hourly_key,priority:3" json:"allocation_id"`
+	DeviceIP     string    `gorm:"column:device_ip" json:"device_ip"`
+	Bucket       time.Time `gorm:"column:bucket;not null;uniqueIndex:idx_usage_hourly_key,priority:1" json:"bucket"`
+	RxBytes      int64     `gorm:"column:rx_bytes;not null;default:0" json:"rx_bytes"`
+	TxBytes      int64     `gorm:"column:tx_bytes;not null;default:0" json:"tx_bytes"`
+}
+
+// TableName specifies the table name for UsageHourly
+func (UsageHourly) TableName() string {
+	return "usage_hourly"
+}
+
+// UsageDaily is the traffic of one device during one UTC day
+type UsageDaily struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"column:user_id;not null;uniqueIndex:idx_usage_daily_key,priority:2;index" json:"user_id"`
+	AllocationID uint      `gorm:"column:allocation_id;not null;uniqueIndex:idx_usage_daily_key,priority:3" json:"allocation_id"`
+	DeviceIP     string    `gorm:"column:device_ip" json:"device_ip"`
+	Bucket       time.Time `gorm:"column:bucket;not null;uniqueIndex:idx_usage_daily_key,priority:1" json:"bucket"`
+	RxBytes      int64     `gorm:"column:rx_bytes;not null;default:0" json:"rx_bytes"`
+	TxBytes      int64     `gorm:"column:tx_bytes;not null;default:0" json:"tx_bytes"`
+}
+
+// TableName specifies the table name for UsageDaily
+func (UsageDaily) TableName() string {
+	return "usage_daily"
+}
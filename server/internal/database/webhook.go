@@ -0,0 +1,45 @@
+package database
+
+import "time"
+
+// ============ Webhook Models ============
+// Shared by the monolith and auth service databases
+
+// Webhook is an HTTP endpoint that receives events as signed JSON
+type Webhook struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;uniqueIndex;not null" json:"name"`
+	URL       string    `gorm:"column:url;not null" json:"url"`
+	Secret    string    `gorm:"column:secret;not null" json:"-"`             // HMAC-SHA256 signing key
+	Events    []string  `gorm:"column:events;serializer:json" json:"events"` // Subscribed event types; empty = all
+	IsActive  bool      `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribed reports whether the webhook receives events of eventType
+func (w Webhook) Subscribed(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeadLetter is an event that could not be delivered to a webhook
+// after all retries. It can be redelivered through the admin API.
+type WebhookDeadLetter struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	WebhookID  uint      `gorm:"column:webhook_id;not null;index" json:"webhook_id"`
+	EventID    string    `gorm:"column:event_id;index" json:"event_id"`
+	EventType  string    `gorm:"column:event_type;index" json:"event_type"`
+	Payload    string    `gorm:"column:payload;type:text" json:"payload"` // The JSON body that was sent
+	Attempts   int       `gorm:"column:attempts" json:"attempts"`
+	LastStatus int       `gorm:"column:last_status" json:"last_status,omitempty"` // HTTP status of the last attempt (0 = no response)
+	LastError  string    `gorm:"column:last_error" json:"last_error"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
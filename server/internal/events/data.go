@@ -0,0 +1,77 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/database"
+)
+
+// LoginData is the payload of user.login and user.login_failed
+type LoginData struct {
+	UserID    uint   `json:"user_id,omitempty"`
+	Username  string `json:"username"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Reason    string `json:"reason,omitempty"` // Failures only, e.g., "invalid password", "locked"
+}
+
+// PeerData is the payload of peer.connected and peer.disconnected
+type PeerData struct {
+	UserID        uint      `json:"user_id,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	PublicKey     string    `json:"public_key"`
+	DeviceIP      string    `json:"device_ip,omitempty"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	LastHandshake time.Time `json:"last_handshake"`
+}
+
+// QuotaData is the payload of quota.exceeded
+type QuotaData struct {
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	QuotaBytes int64     `json:"quota_bytes"`
+	UsedBytes  int64     `json:"used_bytes"`
+	ResetsAt   time.Time `json:"resets_at"`
+}
+
+// TunnelData is the payload of tunnel.offline
+type TunnelData struct {
+	TunnelID string    `json:"tunnel_id"`
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Region   string    `json:"region,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AdminChangeData is the payload of admin.change, taken from the audit entry
+type AdminChangeData struct {
+	AuditID    uint            `json:"audit_id"`
+	Actor      string          `json:"actor"`
+	ActorType  string          `json:"actor_type"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Changes    json.RawMessage `json:"changes,omitempty"` // {"field": [before, after]}
+	SourceIP   string          `json:"source_ip,omitempty"`
+}
+
+// PublishAuditEntries publishes admin.change for every audit entry recorded
+// by this process
+func PublishAuditEntries(bus *Bus) {
+	audit.OnRecord(func(entry database.AuditLog) {
+		data := AdminChangeData{
+			AuditID:    entry.ID,
+			Actor:      entry.Actor,
+			ActorType:  entry.ActorType,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+			SourceIP:   entry.SourceIP,
+		}
+		if entry.Changes != "" {
+			data.Changes = json.RawMessage(entry.Changes)
+		}
+		bus.Publish(AdminChange, data)
+	})
+}
@@ -0,0 +1,114 @@
+// Package events is an in-process event bus. Components publish VPN events
+// (logins, peer state changes, quota and admin changes); subscribers such as
+// the webhook dispatcher receive them.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	UserLogin        = "user.login"
+	UserLoginFailed  = "user.login_failed"
+	PeerConnected    = "peer.connected"
+	PeerDisconnected = "peer.disconnected"
+	QuotaExceeded    = "quota.exceeded"
+	TunnelOffline    = "tunnel.offline"
+	AdminChange      = "admin.change"
+
+	// WebhookTest is only sent by the webhook test endpoint
+	WebhookTest = "webhook.test"
+)
+
+// Types lists the event types that can be subscribed to
+var Types = []string{
+	UserLogin,
+	UserLoginFailed,
+	PeerConnected,
+	PeerDisconnected,
+	QuotaExceeded,
+	TunnelOffline,
+	AdminChange,
+}
+
+// ValidType reports whether t is a known event type
+func ValidType(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something that happened. Data is type-specific and marshalled as JSON.
+type Event struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// New creates an event with a random ID
+func New(eventType string, data interface{}) Event {
+	return Event{ID: newID(), Type: eventType, Time: time.Now().UTC(), Data: data}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Bus delivers published events to subscribers
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[int]func(Event)
+	next int
+}
+
+// NewBus creates a Bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]func(Event))}
+}
+
+// Subscribe registers fn for every published event and returns a function
+// that removes it. fn is called synchronously by the publisher, so it must
+// not block.
+func (b *Bus) Subscribe(fn func(Event)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish sends a new event to all subscribers. Publishing on a nil Bus
+// does nothing, so components work without one.
+func (b *Bus) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	e := New(eventType, data)
+
+	b.mu.RLock()
+	subs := make([]func(Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.RUnlock()
+
+	for _, fn := range subs {
+		fn(e)
+	}
+}
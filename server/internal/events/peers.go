@@ -0,0 +1,140 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/wireguard"
+)
+
+// handshakeTimeout is how long after its latest handshake a peer still counts
+// as connected (WireGuard re-handshakes every 2 minutes while traffic flows)
+const handshakeTimeout = 3 * time.Minute
+
+// PeerWatcher polls the WireGuard device and publishes peer.connected and
+// peer.disconnected when a peer's handshake state changes
+type PeerWatcher struct {
+	bus       *Bus
+	db        *database.DB
+	wgManager *wireguard.Manager
+	interval  time.Duration
+
+	connected map[string]wireguard.PeerStat // Keyed by public key
+	primed    bool
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewPeerWatcher creates a PeerWatcher polling every interval (default: 30s)
+func NewPeerWatcher(bus *Bus, db *database.DB, wgManager *wireguard.Manager, interval time.Duration) *PeerWatcher {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &PeerWatcher{
+		bus:       bus,
+		db:        db,
+		wgManager: wgManager,
+		interval:  interval,
+		connected: make(map[string]wireguard.PeerStat),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+// Start begins polling in the background
+func (w *PeerWatcher) Start() {
+	go func() {
+		defer close(w.doneCh)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		w.Poll()
+		for {
+			select {
+			case <-ticker.C:
+				w.Poll()
+			case <-w.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops polling
+func (w *PeerWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+	<-w.doneCh
+}
+
+// Poll compares the peers' handshake state with the previous poll. The first
+// poll only records the state, so a restart doesn't announce every peer again.
+func (w *PeerWatcher) Poll() {
+	stats, err := w.wgManager.GetPeerStats()
+	if err != nil {
+		slog.Warn("peer event poll failed", "error", err)
+		return
+	}
+
+	now := time.Now()
+	current := make(map[string]wireguard.PeerStat, len(stats))
+	for _, s := range stats {
+		if !s.LastHandshake.IsZero() && now.Sub(s.LastHandshake) < handshakeTimeout {
+			current[s.PublicKey] = s
+		}
+	}
+
+	previous := w.connected
+	w.connected = current
+	if !w.primed {
+		w.primed = true
+		return
+	}
+
+	var changed []string
+	for key := range current {
+		if _, ok := previous[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	// Attribute peers to users; peers without an allocation are still reported
+	var allocations []database.AllocatedIP
+	if err := w.db.Preload("User").Where("public_key IN ?", changed).Find(&allocations).Error; err != nil {
+		slog.Warn("peer event lookup failed", "error", err)
+	}
+	byKey := make(map[string]database.AllocatedIP, len(allocations))
+	for _, a := range allocations {
+		byKey[a.PublicKey] = a
+	}
+
+	for _, key := range changed {
+		s, up := current[key]
+		eventType := PeerConnected
+		if !up {
+			s = previous[key]
+			eventType = PeerDisconnected
+		}
+
+		data := PeerData{PublicKey: key, Endpoint: s.Endpoint, LastHandshake: s.LastHandshake}
+		if a, ok := byKey[key]; ok {
+			data.UserID = a.UserID
+			data.Username = a.User.Username
+			data.DeviceIP = a.IPAddress
+		}
+		slog.Info(eventType, "user", data.Username, "user_id", data.UserID, "peer", key,
+			"device_ip", data.DeviceIP, "endpoint", data.Endpoint)
+		w.bus.Publish(eventType, data)
+	}
+}
@@ -0,0 +1,158 @@
+// Package logging configures the process-wide slog logger and provides gin
+// middleware that tags each request with an ID and logs it once it completes.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID. An incoming value is kept so IDs can
+// be correlated across a reverse proxy; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// level is shared by every handler Setup creates, so SetLevel takes effect
+// immediately
+var level = new(slog.LevelVar)
+
+// ParseLevel parses debug, info, warn (or warning) and error. Empty means info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+}
+
+// Setup installs the default logger writing to stderr. format is "text"
+// (default) or "json". Output of the standard log package is routed through
+// the same handler at info level.
+func Setup(levelName, format string) error {
+	return SetupWriter(os.Stderr, levelName, format)
+}
+
+// SetupWriter is Setup with a custom output
+func SetupWriter(w io.Writer, levelName, format string) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// SetLevel changes the level of the loggers created by Setup
+func SetLevel(levelName string) error {
+	l, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the current level
+func Level() slog.Level {
+	return level.Level()
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored by WithLogger, or the default logger.
+// Within a request it carries the request_id attribute.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Request returns the logger of a gin request
+func Request(c *gin.Context) *slog.Logger {
+	return FromContext(c.Request.Context())
+}
+
+// RequestID returns the ID assigned by Middleware, if any
+func RequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+// Middleware assigns each request an ID (echoed in the X-Request-ID response
+// header), stores a logger carrying it in the request context and logs the
+// request once it completes: 5xx responses at error level, everything else
+// at debug level.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		lvl := slog.LevelDebug
+		if status >= 500 {
+			lvl = slog.LevelError
+		}
+		if !logger.Enabled(c.Request.Context(), lvl) {
+			return
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		logger.Log(c.Request.Context(), lvl, "request", attrs...)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", slog.LevelInfo, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// setupBuffer installs a JSON logger writing to the returned buffer and
+// restores the previous logger when the test ends
+func setupBuffer(t *testing.T, levelName string) *bytes.Buffer {
+	t.Helper()
+	prev, prevLevel := slog.Default(), Level()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		level.Set(prevLevel)
+	})
+
+	var buf bytes.Buffer
+	if err := SetupWriter(&buf, levelName, "json"); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestSetupWriter(t *testing.T) {
+	buf := setupBuffer(t, "warn")
+
+	slog.Info("hidden")
+	slog.Warn("shown", "key", "value")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("output %q is not a single JSON entry: %v", buf, err)
+	}
+	if entry["msg"] != "shown" || entry["key"] != "value" {
+		t.Errorf("logged %v", entry)
+	}
+
+	// The level applies to the installed logger without another Setup
+	buf.Reset()
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("debug")
+	if !strings.Contains(buf.String(), `"msg":"debug"`) {
+		t.Errorf("debug entry not logged after SetLevel: %q", buf)
+	}
+
+	if err := SetupWriter(buf, "info", "xml"); err == nil {
+		t.Error("SetupWriter accepted an unknown format")
+	}
+	if err := SetupWriter(buf, "loud", "text"); err == nil {
+		t.Error("SetupWriter accepted an unknown level")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		requestID string
+		status    int
+		wantID    string // Empty expects a generated ID
+		wantLog   bool
+	}{
+		{"generated ID", "info", "", http.StatusOK, "", false},
+		{"kept ID", "info", "proxy-123", http.StatusOK, "proxy-123", false},
+		{"oversized ID", "info", strings.Repeat("x", 129), http.StatusOK, "", false},
+		{"server error", "info", "req-1", http.StatusInternalServerError, "req-1", true},
+		{"debug", "debug", "req-2", http.StatusNotFound, "req-2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := setupBuffer(t, tt.level)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(Middleware())
+			var handlerID string
+			router.GET("/", func(c *gin.Context) {
+				handlerID = RequestID(c)
+				Request(c).Info("handled")
+				c.Status(tt.status)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if (tt.wantID != "" && id != tt.wantID) || (tt.wantID == "" && len(id) != 16) {
+				t.Errorf("request ID = %q, want %q", id, tt.wantID)
+			}
+			if handlerID != id {
+				t.Errorf("handler saw request ID %q, response has %q", handlerID, id)
+			}
+
+			// The handler's entry carries the ID, then the request is logged
+			// if the level allows
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if !strings.Contains(lines[0], `"request_id":"`+id+`"`) {
+				t.Errorf("handler entry %q lacks the request ID", lines[0])
+			}
+			if logged := len(lines) == 2 && strings.Contains(lines[1], `"msg":"request"`); logged != tt.wantLog {
+				t.Errorf("request logged = %v, want %v: %q", logged, tt.wantLog, buf)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package loginguard protects login endpoints against password guessing with
+// per-IP and per-username rate limiting, progressive delays and account lockout.
+package loginguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wire-socket-server/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Config controls the limiter. Zero values are replaced by defaults.
+type Config struct {
+	Store            string        `yaml:"store"`             // "memory" (single node, default) or "db" (shared by several instances)
+	MaxAttempts      int           `yaml:"max_attempts"`      // Failures allowed per IP/username before delays start (default: 5)
+	Window           time.Duration `yaml:"window"`            // How long failures are remembered (default: 15m)
+	BaseDelay        time.Duration `yaml:"base_delay"`        // First delay, doubled on each further failure (default: 1s)
+	MaxDelay         time.Duration `yaml:"max_delay"`         // Delay cap (default: 5m)
+	LockoutThreshold int           `yaml:"lockout_threshold"` // Username failures before the account is locked (default: 10, -1 disables)
+	LockoutDuration  time.Duration `yaml:"lockout_duration"`  // How long an account stays locked (default: 30m)
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Window <= 0 {
+		c.Window = 15 * time.Minute
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = time.Second
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 5 * time.Minute
+	}
+	if c.LockoutThreshold == 0 {
+		c.LockoutThreshold = 10
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = 30 * time.Minute
+	}
+	return c
+}
+
+// Guard tracks failed logins and decides when to throttle or lock
+type Guard struct {
+	config Config
+	store  Store
+	logDB  *gorm.DB // Failed-login log (optional)
+}
+
+// New creates a Guard. db holds the login_failures log and, with store "db",
+// the shared counters; it may be nil for a memory-only guard without a log.
+func New(config Config, db *gorm.DB) (*Guard, error) {
+	config = config.withDefaults()
+
+	var store Store
+	switch config.Store {
+	case "", "memory":
+		store = NewMemoryStore()
+	case "db", "database":
+		if db == nil {
+			return nil, fmt.Errorf("loginguard: store %q requires a database", config.Store)
+		}
+		store = NewDBStore(db)
+	default:
+		return nil, fmt.Errorf("loginguard: unknown store %q", config.Store)
+	}
+
+	return &Guard{config: config, store: store, logDB: db}, nil
+}
+
+// LockoutDuration returns how long an account is locked after too many failures
+func (g *Guard) LockoutDuration() time.Duration {
+	return g.config.LockoutDuration
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// delay returns how long after a's last failure the next attempt must wait
+func (g *Guard) delay(a Attempt) time.Duration {
+	over := a.Count - g.config.MaxAttempts
+	if over < 0 {
+		return 0
+	}
+	d := g.config.BaseDelay
+	for i := 0; i < over && d < g.config.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.config.MaxDelay {
+		d = g.config.MaxDelay
+	}
+	return d
+}
+
+// Check returns how long the caller must wait before trying again, or 0 if
+// the attempt may proceed. Store errors fail open.
+func (g *Guard) Check(ip, username string) time.Duration {
+	var wait time.Duration
+	for _, key := range []string{ipKey(ip), userKey(username)} {
+		a, err := g.store.Get(key)
+		if err != nil {
+			slog.Warn("login guard lookup failed", "error", err)
+			continue
+		}
+		if a.Count == 0 {
+			continue
+		}
+		if w := time.Until(a.LastFailure.Add(g.delay(a))); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Fail records a failed login and logs it. It returns true when the username
+// has reached the lockout threshold and the account should be locked.
+func (g *Guard) Fail(ip, username, userAgent, reason string) (lock bool) {
+	g.logFailure(ip, username, userAgent, reason)
+
+	if _, err := g.store.Fail(ipKey(ip), g.config.Window); err != nil {
+		slog.Warn("login guard update failed", "error", err)
+	}
+	a, err := g.store.Fail(userKey(username), g.config.Window)
+	if err != nil {
+		slog.Warn("login guard update failed", "error", err)
+		return false
+	}
+
+	return g.config.LockoutThreshold > 0 && a.Count >= g.config.LockoutThreshold
+}
+
+// Succeed clears the username's failure counter after a successful login.
+// The IP counter is kept so one valid account can't be used to reset it.
+func (g *Guard) Succeed(username string) {
+	g.Unlock(username)
+}
+
+// Unlock clears the username's failure counter (used by admin unlock)
+func (g *Guard) Unlock(username string) {
+	if err := g.store.Reset(userKey(username)); err != nil {
+		slog.Warn("login guard reset failed", "error", err)
+	}
+}
+
+// LogFailure records a rejected login without counting it (e.g., throttled or locked attempts)
+func (g *Guard) LogFailure(ip, username, userAgent, reason string) {
+	g.logFailure(ip, username, userAgent, reason)
+}
+
+func (g *Guard) logFailure(ip, username, userAgent, reason string) {
+	if g.logDB == nil {
+		return
+	}
+	g.logDB.Create(&database.LoginFailure{
+		Username:  username,
+		ClientIP:  ip,
+		UserAgent: userAgent,
+		Reason:    reason,
+	})
+}
+
+// RetryAfterSeconds converts a wait into a whole number of seconds for the Retry-After header
+func RetryAfterSeconds(wait time.Duration) int {
+	secs := int((wait + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// AbortTooManyRequests writes a 429 response with a Retry-After header
+func AbortTooManyRequests(c *gin.Context, wait time.Duration) {
+	secs := RetryAfterSeconds(wait)
+	c.Header("Retry-After", fmt.Sprintf("%d", secs))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later", "retry_after": secs})
+}
+
+// AbortLocked writes a 423 response for a locked account
+func AbortLocked(c *gin.Context, until time.Time) {
+	secs := RetryAfterSeconds(time.Until(until))
+	c.Header("Retry-After", fmt.Sprintf("%d", secs))
+	c.JSON(http.StatusLocked, gin.H{"error": "account is temporarily locked", "retry_after": secs})
+}
@@ -0,0 +1,109 @@
+package loginguard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		store   string
+		wantErr bool
+	}{
+		{"", false},
+		{"memory", false},
+		{"db", true}, // Requires a database
+		{"redis", true},
+	}
+	for _, tt := range tests {
+		if _, err := New(Config{Store: tt.store}, nil); (err != nil) != tt.wantErr {
+			t.Errorf("New(store %q) error = %v, want error %v", tt.store, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	g, err := New(Config{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second}, // Capped
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := g.delay(Attempt{Count: tt.count}); got != tt.want {
+			t.Errorf("delay(%d failures) = %v, want %v", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		failures  int
+		succeed   bool   // Log in successfully after the failures
+		checkIP   string // IP of the checked attempt
+		checkUser string // Username of the checked attempt
+		wantWait  bool
+		wantLock  bool // The last failure locks the account
+	}{
+		{"below limit", Config{MaxAttempts: 3}, 2, false, "1.1.1.1", "alice", false, false},
+		{"throttled", Config{MaxAttempts: 3}, 3, false, "1.1.1.1", "alice", true, false},
+		{"throttled by IP", Config{MaxAttempts: 3}, 3, false, "1.1.1.1", "bob", true, false},
+		{"throttled by username", Config{MaxAttempts: 3}, 3, false, "2.2.2.2", "ALICE", true, false},
+		{"other caller", Config{MaxAttempts: 3}, 3, false, "2.2.2.2", "bob", false, false},
+		{"success keeps IP", Config{MaxAttempts: 3}, 3, true, "1.1.1.1", "bob", true, false},
+		{"success clears username", Config{MaxAttempts: 3}, 3, true, "2.2.2.2", "alice", false, false},
+		{"locked", Config{MaxAttempts: 3, LockoutThreshold: 4}, 4, false, "1.1.1.1", "alice", true, true},
+		{"lockout disabled", Config{MaxAttempts: 3, LockoutThreshold: -1}, 20, false, "1.1.1.1", "alice", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(tt.config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lock bool
+			for i := 0; i < tt.failures; i++ {
+				lock = g.Fail("1.1.1.1", "alice", "test", "invalid password")
+			}
+			if lock != tt.wantLock {
+				t.Errorf("Fail locked = %v, want %v", lock, tt.wantLock)
+			}
+			if tt.succeed {
+				g.Succeed("alice")
+			}
+			if wait := g.Check(tt.checkIP, tt.checkUser); (wait > 0) != tt.wantWait {
+				t.Errorf("Check(%s, %s) = %v, want wait %v", tt.checkIP, tt.checkUser, wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, tt := range tests {
+		if got := RetryAfterSeconds(tt.wait); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%v) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package loginguard
+
+import (
+	"sync"
+	"time"
+	"wire-socket-server/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Attempt is the failure state of a rate-limit key
+type Attempt struct {
+	Count       int
+	LastFailure time.Time
+}
+
+// Store keeps failed-login counters
+type Store interface {
+	// Get returns the current state of key (zero value if none)
+	Get(key string) (Attempt, error)
+	// Fail records a failure for key. Failures older than window are forgotten first.
+	Fail(key string, window time.Duration) (Attempt, error)
+	// Reset clears key
+	Reset(key string) error
+}
+
+// ============ Memory Store ============
+
+// MemoryStore keeps counters in process memory (single node)
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempt
+	lastGC   time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]Attempt)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+// Fail implements Store
+func (s *MemoryStore) Fail(key string, window time.Duration) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.gc(now, window)
+
+	a := s.attempts[key]
+	if now.Sub(a.LastFailure) > window {
+		a.Count = 0
+	}
+	a.Count++
+	a.LastFailure = now
+	s.attempts[key] = a
+	return a, nil
+}
+
+// Reset implements Store
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// gc drops expired keys at most once per window. Caller must hold mu.
+func (s *MemoryStore) gc(now time.Time, window time.Duration) {
+	if now.Sub(s.lastGC) < window {
+		return
+	}
+	s.lastGC = now
+	for key, a := range s.attempts {
+		if now.Sub(a.LastFailure) > window {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// ============ Database Store ============
+
+// DBStore keeps counters in the login_attempts table so several auth
+// instances sharing a database enforce the same limits
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore creates a DBStore. The login_attempts table is created by the
+// database package migrations.
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+// Get implements Store
+func (s *DBStore) Get(key string) (Attempt, error) {
+	var row database.LoginAttempt
+	result := s.db.Where("attempt_key = ?", key).Limit(1).Find(&row)
+	if result.Error != nil {
+		return Attempt{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Attempt{}, nil
+	}
+	return Attempt{Count: row.Count, LastFailure: row.LastFailure}, nil
+}
+
+// Fail implements Store
+func (s *DBStore) Fail(key string, window time.Duration) (Attempt, error) {
+	var a Attempt
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var row database.LoginAttempt
+		result := tx.Where("attempt_key = ?", key).Limit(1).Find(&row)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			row = database.LoginAttempt{Key: key, Count: 1, LastFailure: now}
+			a = Attempt{Count: row.Count, LastFailure: now}
+			return tx.Create(&row).Error
+		}
+
+		if now.Sub(row.LastFailure) > window {
+			row.Count = 0
+		}
+		row.Count++
+		row.LastFailure = now
+		a = Attempt{Count: row.Count, LastFailure: now}
+		return tx.Save(&row).Error
+	})
+	return a, err
+}
+
+// Reset implements Store
+func (s *DBStore) Reset(key string) error {
+	return s.db.Where("attempt_key = ?", key).Delete(&database.LoginAttempt{}).Error
+}
+
+// ResetUser clears the database-backed counter for username. Used by wsctl,
+// which has no access to a running server's in-memory store.
+func ResetUser(db *gorm.DB, username string) error {
+	return NewDBStore(db).Reset(userKey(username))
+}
@@ -0,0 +1,281 @@
+// Package metrics exposes server metrics in the Prometheus text format on
+// /metrics. Gauges are read from the WireGuard device, the tunnel server and
+// the database at scrape time; counters are updated as events happen.
+package metrics
+
+import (
+	"crypto/subtle"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"wire-socket-server/internal/nat"
+	"wire-socket-server/internal/route"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/usage"
+	"wire-socket-server/internal/wireguard"
+
+	"github.com/gin-gonic/gin"
+	pm "wire-socket/pkg/metrics"
+)
+
+// liveHandshakeWindow is how recent a handshake must be for a peer to count
+// as connected (WireGuard re-handshakes every 2 minutes while traffic flows)
+const liveHandshakeWindow = 3 * time.Minute
+
+// handshakeAgeBuckets are the bounds of the handshake age histogram (seconds)
+var handshakeAgeBuckets = []float64{15, 30, 60, 120, 180, 300, 600, 1800, 3600}
+
+// Config controls the metrics endpoint. Zero values are replaced by defaults.
+type Config struct {
+	Disabled bool   `yaml:"disabled"`  // Turn off /metrics
+	Path     string `yaml:"path"`      // Endpoint path (default: /metrics)
+	Token    string `yaml:"token"`     // Bearer token required to scrape (optional)
+	PerUser  bool   `yaml:"per_user"`  // Add per-user traffic series
+	MaxUsers int    `yaml:"max_users"` // Cap on per-user series; the rest are reported as "other" (default: 50)
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.Path == "" {
+		c.Path = "/metrics"
+	}
+	if c.MaxUsers <= 0 {
+		c.MaxUsers = 50
+	}
+	return c
+}
+
+// Metrics is the registry of one server process
+type Metrics struct {
+	config   Config
+	registry *pm.Registry
+
+	logins        *pm.CounterVec
+	loginDuration *pm.HistogramVec
+	traffic       *pm.CounterVec
+	userTraffic   *pm.CounterVec
+	users         *pm.LabelLimiter
+}
+
+// New creates the registry with the metrics every mode shares: logins and
+// NAT/route apply failures
+func New(config Config) *Metrics {
+	config = config.withDefaults()
+	r := pm.NewRegistry()
+
+	m := &Metrics{
+		config:   config,
+		registry: r,
+		logins: r.NewCounter("wiresocket_logins_total",
+			"Login attempts by result (success, invalid_credentials, inactive, locked, rate_limited, bad_request, error).", "result"),
+		loginDuration: r.NewHistogram("wiresocket_login_duration_seconds",
+			"Time taken to handle login requests.", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5}),
+	}
+
+	r.NewCounterFunc("wiresocket_apply_failures_total", "NAT rules and routes that failed to apply.",
+		func(emit func(float64, ...string)) {
+			emit(float64(nat.ApplyFailures()), "nat")
+			emit(float64(route.ApplyFailures()), "route")
+		}, "kind")
+
+	return m
+}
+
+// Enabled reports whether the endpoint should be mounted
+func (m *Metrics) Enabled() bool {
+	return !m.config.Disabled
+}
+
+// Mount serves the registry on the configured path. A nil Metrics mounts nothing.
+func (m *Metrics) Mount(engine *gin.Engine) {
+	if m == nil || !m.Enabled() {
+		return
+	}
+	engine.GET(m.config.Path, m.Handler())
+}
+
+// Handler serves the registry, requiring the configured bearer token if set
+func (m *Metrics) Handler() gin.HandlerFunc {
+	handler := m.registry.Handler()
+	return func(c *gin.Context) {
+		if m.config.Token != "" {
+			want := "Bearer " + m.config.Token
+			if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(want)) != 1 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// LoginMiddleware counts login attempts by result and times them. Results are
+// derived from the response status so every login handler can share it.
+// A nil Metrics returns a pass-through middleware.
+func (m *Metrics) LoginMiddleware() gin.HandlerFunc {
+	if m == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		m.loginDuration.With().Observe(time.Since(start).Seconds())
+		m.logins.With(loginResult(c.Writer.Status())).Inc()
+	}
+}
+
+// loginResult maps a login response status to a result label
+func loginResult(status int) string {
+	switch status {
+	case http.StatusOK:
+		return "success"
+	case http.StatusUnauthorized:
+		return "invalid_credentials"
+	case http.StatusForbidden:
+		return "inactive"
+	case http.StatusLocked:
+		return "locked"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusBadRequest:
+		return "bad_request"
+	default:
+		return "error"
+	}
+}
+
+// WatchPeers adds peer gauges and the handshake age histogram, read from the
+// WireGuard device on every scrape
+func (m *Metrics) WatchPeers(wgManager *wireguard.Manager) {
+	m.registry.NewGaugeFunc("wiresocket_peers", "WireGuard peers by state (connected = handshake within 3 minutes).",
+		func(emit func(float64, ...string)) {
+			stats, err := wgManager.GetPeerStats()
+			if err != nil {
+				return
+			}
+			connected := 0
+			for _, s := range stats {
+				if !s.LastHandshake.IsZero() && time.Since(s.LastHandshake) < liveHandshakeWindow {
+					connected++
+				}
+			}
+			emit(float64(connected), "connected")
+			emit(float64(len(stats)-connected), "idle")
+		}, "state")
+
+	m.registry.NewHistogramFunc("wiresocket_peer_handshake_age_seconds",
+		"Time since the latest handshake of each peer that has completed one.", handshakeAgeBuckets,
+		func(observe func(float64)) {
+			stats, err := wgManager.GetPeerStats()
+			if err != nil {
+				return
+			}
+			for _, s := range stats {
+				if !s.LastHandshake.IsZero() {
+					observe(time.Since(s.LastHandshake).Seconds())
+				}
+			}
+		})
+}
+
+// WatchTunnel adds the built-in tunnel server's connection gauge and counters
+func (m *Metrics) WatchTunnel(tunnelServer *tunnel.Server) {
+	m.registry.NewGaugeFunc("wiresocket_tunnel_connections", "Active WebSocket tunnel connections.",
+		func(emit func(float64, ...string)) {
+			emit(float64(len(tunnelServer.Sessions())))
+		})
+
+	m.registry.NewCounterFunc("wiresocket_tunnel_upgrades_total",
+		"WebSocket tunnel upgrade attempts by result.",
+		func(emit func(float64, ...string)) {
+			s := tunnelServer.Stats()
+			emit(float64(s.UpgradesAccepted), "accepted")
+			emit(float64(s.UpgradesUnauthorized), "unauthorized")
+			emit(float64(s.UpgradesForbidden), "forbidden")
+			emit(float64(s.UpgradesFailed), "failed")
+		}, "result")
+
+	m.registry.NewCounterFunc("wiresocket_tunnel_bytes_total",
+		"Bytes forwarded by the WebSocket tunnel (rx = from clients, tx = to clients).",
+		func(emit func(float64, ...string)) {
+			s := tunnelServer.Stats()
+			emit(float64(s.BytesFromClients), "rx")
+			emit(float64(s.BytesToClients), "tx")
+		}, "direction")
+}
+
+// WatchIPPool adds gauges for the size and use of the client address pool.
+// allocated returns the number of allocated addresses.
+func (m *Metrics) WatchIPPool(subnet string, allocated func() (int64, error)) {
+	size := poolSize(subnet)
+
+	m.registry.NewGaugeFunc("wiresocket_ip_pool_addresses", "Client addresses in the pool by state.",
+		func(emit func(float64, ...string)) {
+			n, err := allocated()
+			if err != nil {
+				return
+			}
+			emit(float64(n), "allocated")
+			emit(float64(size-n), "free")
+		}, "state")
+
+	m.registry.NewGaugeFunc("wiresocket_ip_pool_utilization_ratio", "Fraction of the client address pool allocated.",
+		func(emit func(float64, ...string)) {
+			n, err := allocated()
+			if err != nil || size <= 0 {
+				return
+			}
+			emit(float64(n) / float64(size))
+		})
+}
+
+// poolSize returns the number of assignable addresses in subnet, excluding
+// the network, gateway (first host) and, for IPv4, broadcast addresses
+func poolSize(subnet string) int64 {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return 0
+	}
+	ones, bits := ipNet.Mask.Size()
+	total := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	reserved := int64(2)
+	if bits == 32 {
+		reserved = 3
+	}
+	total.Sub(total, big.NewInt(reserved))
+	if !total.IsInt64() {
+		return 1<<63 - 1
+	}
+	if total.Sign() < 0 {
+		return 0
+	}
+	return total.Int64()
+}
+
+// WatchTraffic adds WireGuard traffic counters fed by the usage collector,
+// with per-user series if enabled
+func (m *Metrics) WatchTraffic(collector *usage.Collector) {
+	m.traffic = m.registry.NewCounter("wiresocket_traffic_bytes_total",
+		"WireGuard traffic of all peers (rx = from clients, tx = to clients).", "direction")
+	if m.config.PerUser {
+		m.userTraffic = m.registry.NewCounter("wiresocket_user_traffic_bytes_total",
+			"WireGuard traffic per user ID; users beyond metrics.max_users are summed as \"other\".", "user_id", "direction")
+		m.users = pm.NewLabelLimiter(m.config.MaxUsers)
+	}
+	collector.OnSample(m.observeTraffic)
+}
+
+// observeTraffic adds the deltas of one usage sample
+func (m *Metrics) observeTraffic(deltas []usage.Delta) {
+	for _, d := range deltas {
+		m.traffic.With("rx").Add(float64(d.RxBytes))
+		m.traffic.With("tx").Add(float64(d.TxBytes))
+		if m.userTraffic != nil {
+			user := m.users.Value(strconv.FormatUint(uint64(d.UserID), 10))
+			m.userTraffic.With(user, "rx").Add(float64(d.RxBytes))
+			m.userTraffic.With(user, "tx").Add(float64(d.TxBytes))
+		}
+	}
+}
@@ -3,9 +3,10 @@ package nat
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 )
 
 // MasqueradeRule represents a MASQUERADE rule
@@ -45,6 +46,14 @@ type Config struct {
 	TCPMSS     []TCPMSSRule
 }
 
+// applyFailures counts NAT rules that failed to apply, across all managers
+var applyFailures atomic.Uint64
+
+// ApplyFailures returns the number of NAT rules that failed to apply since startup
+func ApplyFailures() uint64 {
+	return applyFailures.Load()
+}
+
 // Manager manages iptables NAT rules
 type Manager struct {
 	config       Config
@@ -62,45 +71,50 @@ func NewManager(cfg Config) *Manager {
 // Apply enables IP forwarding and applies all NAT rules
 func (m *Manager) Apply() error {
 	if !m.config.Enabled {
-		log.Println("NAT is disabled, skipping")
+		slog.Info("NAT is disabled, skipping")
 		return nil
 	}
 
 	// Enable IP forwarding
 	if err := m.enableIPForwarding(); err != nil {
+		applyFailures.Add(1)
 		return fmt.Errorf("failed to enable IP forwarding: %w", err)
 	}
 
 	// Apply MASQUERADE rules
 	for _, rule := range m.config.Masquerade {
 		if err := m.applyMasquerade(rule); err != nil {
-			log.Printf("Warning: failed to apply masquerade rule for %s: %v", rule.Interface, err)
+			applyFailures.Add(1)
+			slog.Warn("failed to apply masquerade rule", "interface", rule.Interface, "error", err)
 		}
 	}
 
 	// Apply SNAT rules
 	for _, rule := range m.config.SNAT {
 		if err := m.applySNAT(rule); err != nil {
-			log.Printf("Warning: failed to apply SNAT rule: %v", err)
+			applyFailures.Add(1)
+			slog.Warn("failed to apply SNAT rule", "error", err)
 		}
 	}
 
 	// Apply DNAT rules
 	for _, rule := range m.config.DNAT {
 		if err := m.applyDNAT(rule); err != nil {
-			log.Printf("Warning: failed to apply DNAT rule: %v", err)
+			applyFailures.Add(1)
+			slog.Warn("failed to apply DNAT rule", "error", err)
 		}
 	}
 
 	// Apply TCPMSS rules (mangle table for MSS clamping)
 	for _, rule := range m.config.TCPMSS {
 		if err := m.applyTCPMSS(rule); err != nil {
-			log.Printf("Warning: failed to apply TCPMSS rule: %v", err)
+			applyFailures.Add(1)
+			slog.Warn("failed to apply TCPMSS rule", "error", err)
 		}
 	}
 
-	log.Printf("NAT rules applied: %d masquerade, %d SNAT, %d DNAT, %d TCPMSS",
-		len(m.config.Masquerade), len(m.config.SNAT), len(m.config.DNAT), len(m.config.TCPMSS))
+	slog.Info("NAT rules applied", "masquerade", len(m.config.Masquerade), "snat", len(m.config.SNAT),
+		"dnat", len(m.config.DNAT), "tcpmss", len(m.config.TCPMSS))
 
 	return nil
 }
@@ -111,7 +125,7 @@ func (m *Manager) Cleanup() {
 		return
 	}
 
-	log.Println("Cleaning up NAT rules...")
+	slog.Info("cleaning up NAT rules")
 
 	// Remove rules in reverse order
 	for i := len(m.appliedRules) - 1; i >= 0; i-- {
@@ -124,13 +138,13 @@ func (m *Manager) Cleanup() {
 		if len(args) > 0 {
 			cmd := exec.Command("iptables", args...)
 			if output, err := cmd.CombinedOutput(); err != nil {
-				log.Printf("Warning: failed to remove rule: %s: %v", strings.TrimSpace(string(output)), err)
+				slog.Warn("failed to remove NAT rule", "rule", rule, "output", strings.TrimSpace(string(output)), "error", err)
 			}
 		}
 	}
 
 	m.appliedRules = []string{}
-	log.Println("NAT rules cleaned up")
+	slog.Info("NAT rules cleaned up")
 }
 
 // enableIPForwarding enables IPv4 forwarding via sysctl
@@ -139,7 +153,7 @@ func (m *Manager) enableIPForwarding() error {
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
 	}
-	log.Println("IP forwarding enabled")
+	slog.Info("IP forwarding enabled")
 	return nil
 }
 
@@ -152,7 +166,7 @@ func (m *Manager) applyMasquerade(rule MasqueradeRule) error {
 	checkArgs := strings.Replace(ruleStr, " -A ", " -C ", 1)
 	checkCmd := exec.Command("iptables", strings.Fields(checkArgs)...)
 	if checkCmd.Run() == nil {
-		log.Printf("Masquerade rule for %s already exists", rule.Interface)
+		slog.Debug("masquerade rule already exists", "interface", rule.Interface)
 		return nil
 	}
 
@@ -163,7 +177,7 @@ func (m *Manager) applyMasquerade(rule MasqueradeRule) error {
 	}
 
 	m.appliedRules = append(m.appliedRules, ruleStr)
-	log.Printf("Applied MASQUERADE rule: -o %s", rule.Interface)
+	slog.Info("applied MASQUERADE rule", "interface", rule.Interface)
 	return nil
 }
 
@@ -177,7 +191,7 @@ func (m *Manager) applySNAT(rule SNATRule) error {
 	checkArgs := strings.Replace(ruleStr, " -A ", " -C ", 1)
 	checkCmd := exec.Command("iptables", strings.Fields(checkArgs)...)
 	if checkCmd.Run() == nil {
-		log.Printf("SNAT rule already exists: %s -> %s via %s", rule.Source, rule.Destination, rule.Interface)
+		slog.Debug("SNAT rule already exists", "source", rule.Source, "destination", rule.Destination, "interface", rule.Interface)
 		return nil
 	}
 
@@ -188,8 +202,8 @@ func (m *Manager) applySNAT(rule SNATRule) error {
 	}
 
 	m.appliedRules = append(m.appliedRules, ruleStr)
-	log.Printf("Applied SNAT rule: %s -> %s via %s (source: %s)",
-		rule.Source, rule.Destination, rule.Interface, rule.ToSource)
+	slog.Info("applied SNAT rule", "source", rule.Source, "destination", rule.Destination,
+		"interface", rule.Interface, "to_source", rule.ToSource)
 	return nil
 }
 
@@ -203,7 +217,7 @@ func (m *Manager) applyDNAT(rule DNATRule) error {
 	checkArgs := strings.Replace(ruleStr, " -A ", " -C ", 1)
 	checkCmd := exec.Command("iptables", strings.Fields(checkArgs)...)
 	if checkCmd.Run() == nil {
-		log.Printf("DNAT rule already exists: %s:%d -> %s", rule.Interface, rule.Port, rule.ToDestination)
+		slog.Debug("DNAT rule already exists", "interface", rule.Interface, "port", rule.Port, "to_destination", rule.ToDestination)
 		return nil
 	}
 
@@ -214,8 +228,8 @@ func (m *Manager) applyDNAT(rule DNATRule) error {
 	}
 
 	m.appliedRules = append(m.appliedRules, ruleStr)
-	log.Printf("Applied DNAT rule: %s %s:%d -> %s",
-		rule.Protocol, rule.Interface, rule.Port, rule.ToDestination)
+	slog.Info("applied DNAT rule", "protocol", rule.Protocol, "interface", rule.Interface,
+		"port", rule.Port, "to_destination", rule.ToDestination)
 	return nil
 }
 
@@ -230,7 +244,7 @@ func (m *Manager) applyTCPMSS(rule TCPMSSRule) error {
 	checkArgs := strings.Replace(ruleStr, " -A ", " -C ", 1)
 	checkCmd := exec.Command("iptables", strings.Fields(checkArgs)...)
 	if checkCmd.Run() == nil {
-		log.Printf("TCPMSS rule already exists: -o %s -s %s --set-mss %d", rule.Interface, rule.Source, rule.MSS)
+		slog.Debug("TCPMSS rule already exists", "interface", rule.Interface, "source", rule.Source, "mss", rule.MSS)
 		return nil
 	}
 
@@ -241,7 +255,6 @@ func (m *Manager) applyTCPMSS(rule TCPMSSRule) error {
 	}
 
 	m.appliedRules = append(m.appliedRules, ruleStr)
-	log.Printf("Applied TCPMSS rule: -o %s -s %s --set-mss %d",
-		rule.Interface, rule.Source, rule.MSS)
+	slog.Info("applied TCPMSS rule", "interface", rule.Interface, "source", rule.Source, "mss", rule.MSS)
 	return nil
 }
@@ -0,0 +1,103 @@
+package quota
+
+import (
+	"log/slog"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+	"wire-socket-server/internal/tunnel"
+	"wire-socket-server/internal/wireguard"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// Enforcer removes the peers of users whose quota has run out. Call Enforce
+// after every usage sample; the peer comes back when the client fetches its
+// config again, which is refused until the period resets.
+type Enforcer struct {
+	db           *database.DB
+	wgManager    *wireguard.Manager
+	tunnelServer *tunnel.Server
+	events       *events.Bus
+}
+
+// NewEnforcer creates an Enforcer
+func NewEnforcer(db *database.DB, wgManager *wireguard.Manager) *Enforcer {
+	return &Enforcer{db: db, wgManager: wgManager}
+}
+
+// SetTunnelServer sets the built-in tunnel server whose connections are
+// closed along with removed peers
+func (e *Enforcer) SetTunnelServer(tunnelServer *tunnel.Server) {
+	e.tunnelServer = tunnelServer
+}
+
+// SetEvents sets the bus that receives quota.exceeded
+func (e *Enforcer) SetEvents(bus *events.Bus) {
+	e.events = bus
+}
+
+// Enforce checks every connected peer's user and removes the peers of users
+// over quota. Peers are checked rather than the last sample's deltas so that
+// peers restored from the config file at startup are caught too.
+func (e *Enforcer) Enforce() {
+	stats, err := e.wgManager.GetPeerStats()
+	if err != nil {
+		slog.Warn("quota check failed", "error", err)
+		return
+	}
+	if len(stats) == 0 {
+		return
+	}
+
+	endpoints := make(map[string]string, len(stats))
+	keys := make([]string, 0, len(stats))
+	for _, s := range stats {
+		endpoints[s.PublicKey] = s.Endpoint
+		keys = append(keys, s.PublicKey)
+	}
+
+	var allocations []database.AllocatedIP
+	if err := e.db.Preload("User").Where("public_key IN ?", keys).Find(&allocations).Error; err != nil {
+		slog.Warn("quota check failed", "error", err)
+		return
+	}
+
+	now := time.Now()
+	exceeded := make(map[uint]bool)
+	for _, a := range allocations {
+		over, checked := exceeded[a.UserID]
+		if !checked {
+			status, err := Check(e.db.DB, a.UserID, now)
+			if err != nil {
+				slog.Warn("quota check failed", "user_id", a.UserID, "error", err)
+				continue
+			}
+			over = status.Exceeded()
+			exceeded[a.UserID] = over
+			if over {
+				slog.Info("user exceeded monthly quota, disconnecting", "user", a.User.Username, "user_id", a.UserID,
+					"used_bytes", status.UsedBytes, "quota_bytes", status.QuotaBytes, "resets_at", status.ResetsAt.Format("2006-01-02"))
+				e.events.Publish(events.QuotaExceeded, events.QuotaData{
+					UserID:     a.UserID,
+					Username:   a.User.Username,
+					QuotaBytes: status.QuotaBytes,
+					UsedBytes:  status.UsedBytes,
+					ResetsAt:   status.ResetsAt,
+				})
+			}
+		}
+		if !over {
+			continue
+		}
+
+		if err := e.wgManager.RemovePeer(a.PublicKey); err != nil {
+			slog.Warn("failed to remove peer over quota", "user", a.User.Username, "peer", a.PublicKey, "error", err)
+			continue
+		}
+		slog.Info("peer disconnected: quota exceeded", "user", a.User.Username, "user_id", a.UserID, "peer", a.PublicKey, "device_ip", a.IPAddress)
+		if e.tunnelServer != nil && endpoints[a.PublicKey] != "" {
+			e.tunnelServer.CloseSession(endpoints[a.PublicKey], framing.CloseQuota, "monthly data quota exceeded")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package quota applies monthly data quotas and bandwidth limits. Limits are
+// set on users or inherited from their groups; usage is read from the daily
+// usage aggregates recorded by the usage collector.
+package quota
+
+import (
+	"time"
+	"wire-socket-server/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// Limits are the effective limits of a user. Zero means unlimited.
+type Limits struct {
+	QuotaBytes    int64 // Monthly data quota (rx + tx)
+	RateLimitKbps int   // Tunnel bandwidth per direction
+}
+
+// Status is a user's quota position in the current period
+type Status struct {
+	QuotaBytes     int64     `json:"quota_bytes"`     // 0 = unlimited
+	UsedBytes      int64     `json:"used_bytes"`      // Traffic in the current period
+	RemainingBytes int64     `json:"remaining_bytes"` // Only meaningful when quota_bytes > 0
+	RateLimitKbps  int       `json:"rate_limit_kbps"` // 0 = unlimited
+	PeriodStart    time.Time `json:"period_start"`
+	ResetsAt       time.Time `json:"resets_at"`
+}
+
+// Exceeded reports whether the quota has run out
+func (s Status) Exceeded() bool {
+	return s.QuotaBytes > 0 && s.UsedBytes >= s.QuotaBytes
+}
+
+// RateLimitBytes returns the rate limit in bytes per second
+func (s Status) RateLimitBytes() int64 {
+	return int64(s.RateLimitKbps) * 1000 / 8
+}
+
+// Period returns the quota period containing t: the calendar month in UTC
+func Period(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// LimitsFor returns the effective limits of a user. A non-zero value on the
+// user wins; otherwise the most restrictive non-zero value of its groups applies.
+func LimitsFor(db *gorm.DB, userID uint) (Limits, error) {
+	var user database.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return Limits{}, err
+	}
+	limits := Limits{QuotaBytes: user.QuotaBytes, RateLimitKbps: user.RateLimitKbps}
+	if limits.QuotaBytes > 0 && limits.RateLimitKbps > 0 {
+		return limits, nil
+	}
+
+	var groups []database.Group
+	if err := db.Where("id IN (?)", db.Model(&database.UserGroup{}).Select("group_id").Where("user_id = ?", userID)).
+		Find(&groups).Error; err != nil {
+		return Limits{}, err
+	}
+
+	inheritQuota := limits.QuotaBytes <= 0
+	inheritRate := limits.RateLimitKbps <= 0
+	for _, g := range groups {
+		if inheritQuota && g.QuotaBytes > 0 && (limits.QuotaBytes <= 0 || g.QuotaBytes < limits.QuotaBytes) {
+			limits.QuotaBytes = g.QuotaBytes
+		}
+		if inheritRate && g.RateLimitKbps > 0 && (limits.RateLimitKbps <= 0 || g.RateLimitKbps < limits.RateLimitKbps) {
+			limits.RateLimitKbps = g.RateLimitKbps
+		}
+	}
+	return limits, nil
+}
+
+// Used returns the traffic of a user since start
+func Used(db *gorm.DB, userID uint, start time.Time) (int64, error) {
+	var used int64
+	err := db.Model(&database.UsageDaily{}).
+		Select("COALESCE(SUM(rx_bytes + tx_bytes), 0)").
+		Where("user_id = ? AND bucket >= ?", userID, start.UTC()).
+		Scan(&used).Error
+	return used, err
+}
+
+// Check returns the quota status of a user at now
+func Check(db *gorm.DB, userID uint, now time.Time) (Status, error) {
+	limits, err := LimitsFor(db, userID)
+	if err != nil {
+		return Status{}, err
+	}
+
+	start, end := Period(now)
+	status := Status{
+		QuotaBytes:    limits.QuotaBytes,
+		RateLimitKbps: limits.RateLimitKbps,
+		PeriodStart:   start,
+		ResetsAt:      end,
+	}
+
+	if status.UsedBytes, err = Used(db, userID, start); err != nil {
+		return Status{}, err
+	}
+	if status.QuotaBytes > 0 {
+		status.RemainingBytes = status.QuotaBytes - status.UsedBytes
+		if status.RemainingBytes < 0 {
+			status.RemainingBytes = 0
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,110 @@
+package quota
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+	"wire-socket-server/internal/database"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	thisMonth := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	lastMonth := time.Date(2026, 2, 27, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		user          Limits
+		groups        []Limits
+		usage         map[time.Time]int64 // Bytes per day
+		want          Limits
+		wantUsed      int64
+		wantRemaining int64
+		wantExceeded  bool
+	}{
+		{name: "unlimited", usage: map[time.Time]int64{thisMonth: 500}, wantUsed: 500},
+		{
+			name:          "user quota",
+			user:          Limits{QuotaBytes: 1000, RateLimitKbps: 800},
+			groups:        []Limits{{QuotaBytes: 100, RateLimitKbps: 80}},
+			usage:         map[time.Time]int64{thisMonth: 400},
+			want:          Limits{QuotaBytes: 1000, RateLimitKbps: 800},
+			wantUsed:      400,
+			wantRemaining: 600,
+		},
+		{
+			name:          "most restrictive group",
+			groups:        []Limits{{QuotaBytes: 2000}, {QuotaBytes: 1000, RateLimitKbps: 800}, {}},
+			want:          Limits{QuotaBytes: 1000, RateLimitKbps: 800},
+			wantRemaining: 1000,
+		},
+		{
+			name:          "user rate, group quota",
+			user:          Limits{RateLimitKbps: 800},
+			groups:        []Limits{{QuotaBytes: 1000, RateLimitKbps: 80}},
+			want:          Limits{QuotaBytes: 1000, RateLimitKbps: 800},
+			wantRemaining: 1000,
+		},
+		{
+			name:          "previous period ignored",
+			user:          Limits{QuotaBytes: 1000},
+			usage:         map[time.Time]int64{lastMonth: 5000, thisMonth: 300},
+			want:          Limits{QuotaBytes: 1000},
+			wantUsed:      300,
+			wantRemaining: 700,
+		},
+		{
+			name:         "exceeded",
+			user:         Limits{QuotaBytes: 1000},
+			usage:        map[time.Time]int64{thisMonth: 1500},
+			want:         Limits{QuotaBytes: 1000},
+			wantUsed:     1500,
+			wantExceeded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := database.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x",
+				QuotaBytes: tt.user.QuotaBytes, RateLimitKbps: tt.user.RateLimitKbps}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			for i, l := range tt.groups {
+				group := database.Group{Name: fmt.Sprintf("group%d", i), QuotaBytes: l.QuotaBytes, RateLimitKbps: l.RateLimitKbps}
+				if err := db.Create(&group).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Create(&database.UserGroup{UserID: user.ID, GroupID: group.ID}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			for day, bytes := range tt.usage {
+				if err := db.Create(&database.UsageDaily{UserID: user.ID, AllocationID: 1, Bucket: day, RxBytes: bytes / 2, TxBytes: bytes - bytes/2}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			status, err := Check(db.DB, user.ID, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := (Limits{QuotaBytes: status.QuotaBytes, RateLimitKbps: status.RateLimitKbps}); got != tt.want {
+				t.Errorf("limits = %+v, want %+v", got, tt.want)
+			}
+			if status.UsedBytes != tt.wantUsed || status.RemainingBytes != tt.wantRemaining {
+				t.Errorf("used %d, remaining %d, want %d, %d", status.UsedBytes, status.RemainingBytes, tt.wantUsed, tt.wantRemaining)
+			}
+			if status.Exceeded() != tt.wantExceeded {
+				t.Errorf("Exceeded() = %v, want %v", status.Exceeded(), tt.wantExceeded)
+			}
+			if !status.PeriodStart.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !status.ResetsAt.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("period %v - %v, want March 2026", status.PeriodStart, status.ResetsAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/tunnel"
+)
+
+// ErrExceeded is the error of requests refused because the quota has run out
+var ErrExceeded = errors.New("monthly data quota exceeded")
+
+// TunnelAuthenticator identifies tunnel connections by the client's JWT,
+// refuses users over quota and applies their rate limit. Connections without
+// a token are accepted without a quota or limit unless requireAuth is set.
+func TunnelAuthenticator(db *database.DB, validateToken func(string) (uint, error), requireAuth bool) tunnel.Authenticator {
+	return func(r *http.Request) (tunnel.Identity, error) {
+		token := tunnel.BearerToken(r)
+		if token == "" {
+			if requireAuth {
+				return tunnel.Identity{}, tunnel.ErrUnauthorized
+			}
+			return tunnel.Identity{}, nil
+		}
+
+		userID, err := validateToken(token)
+		if err != nil {
+			return tunnel.Identity{}, tunnel.ErrUnauthorized
+		}
+
+		var user database.User
+		if err := db.First(&user, userID).Error; err != nil || !user.IsActive {
+			return tunnel.Identity{}, tunnel.ErrUnauthorized
+		}
+
+		status, err := Check(db.DB, userID, time.Now())
+		if err != nil {
+			return tunnel.Identity{}, fmt.Errorf("quota check failed: %w", err)
+		}
+		if status.Exceeded() {
+			return tunnel.Identity{}, ErrExceeded
+		}
+
+		return tunnel.Identity{
+			UserID:    user.ID,
+			Username:  user.Username,
+			RateLimit: status.RateLimitBytes(),
+		}, nil
+	}
+}
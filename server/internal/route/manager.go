@@ -3,10 +3,11 @@ package route
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync/atomic"
 )
 
 // Route represents a routing rule
@@ -23,6 +24,14 @@ type Config struct {
 	Routes        []Route // Routes to apply
 }
 
+// applyFailures counts routes that failed to apply, across all managers
+var applyFailures atomic.Uint64
+
+// ApplyFailures returns the number of routes that failed to apply since startup
+func ApplyFailures() uint64 {
+	return applyFailures.Load()
+}
+
 // Manager manages IP routes
 type Manager struct {
 	config       Config
@@ -41,17 +50,18 @@ func NewManager(cfg Config) *Manager {
 func (m *Manager) Apply() error {
 	for _, route := range m.config.Routes {
 		if err := m.addRoute(route); err != nil {
-			log.Printf("Warning: failed to add route %s: %v", route.CIDR, err)
+			applyFailures.Add(1)
+			slog.Warn("failed to add route", "cidr", route.CIDR, "error", err)
 		}
 	}
 
-	log.Printf("Routes applied: %d routes", len(m.config.Routes))
+	slog.Info("routes applied", "routes", len(m.config.Routes))
 	return nil
 }
 
 // Cleanup removes all applied routes
 func (m *Manager) Cleanup() {
-	log.Println("Cleaning up routes...")
+	slog.Info("cleaning up routes")
 
 	for i := len(m.appliedRules) - 1; i >= 0; i-- {
 		rule := m.appliedRules[i]
@@ -62,7 +72,7 @@ func (m *Manager) Cleanup() {
 			if len(args) > 0 {
 				cmd := exec.Command("route", args...)
 				if output, err := cmd.CombinedOutput(); err != nil {
-					log.Printf("Warning: failed to remove route: %s: %v", strings.TrimSpace(string(output)), err)
+					slog.Warn("failed to remove route", "output", strings.TrimSpace(string(output)), "error", err)
 				}
 			}
 		} else {
@@ -72,14 +82,14 @@ func (m *Manager) Cleanup() {
 			if len(args) > 0 {
 				cmd := exec.Command("ip", args...)
 				if output, err := cmd.CombinedOutput(); err != nil {
-					log.Printf("Warning: failed to remove route: %s: %v", strings.TrimSpace(string(output)), err)
+					slog.Warn("failed to remove route", "output", strings.TrimSpace(string(output)), "error", err)
 				}
 			}
 		}
 	}
 
 	m.appliedRules = []string{}
-	log.Println("Routes cleaned up")
+	slog.Info("routes cleaned up")
 }
 
 // addRoute adds a single route
@@ -119,7 +129,7 @@ func (m *Manager) addRouteLinux(route Route, device string) error {
 	// Check if route already exists
 	checkCmd := exec.Command("ip", "route", "show", route.CIDR)
 	if output, _ := checkCmd.Output(); len(strings.TrimSpace(string(output))) > 0 {
-		log.Printf("Route %s already exists, skipping", route.CIDR)
+		slog.Debug("route already exists, skipping", "cidr", route.CIDR)
 		return nil
 	}
 
@@ -154,7 +164,7 @@ func (m *Manager) addRouteDarwin(route Route, device string) error {
 	checkCmd := exec.Command("netstat", "-rn")
 	if output, err := checkCmd.Output(); err == nil {
 		if strings.Contains(string(output), route.CIDR) {
-			log.Printf("Route %s already exists, skipping", route.CIDR)
+			slog.Debug("route already exists, skipping", "cidr", route.CIDR)
 			return nil
 		}
 	}
@@ -183,7 +193,7 @@ func (m *Manager) logAppliedRoute(route Route, device string) {
 	if route.Metric > 0 {
 		parts = append(parts, fmt.Sprintf("metric %d", route.Metric))
 	}
-	log.Printf("Applied route: %s", strings.Join(parts, " "))
+	slog.Info("applied route", "route", strings.Join(parts, " "))
 }
 
 // GetAppliedCount returns the number of applied routes
@@ -0,0 +1,116 @@
+// Package serverconfig loads and validates the configuration of the server,
+// for the server itself and for wsctl, which must open the same database and
+// sign with the same JWT secret.
+package serverconfig
+
+import (
+	"time"
+	"wire-socket-server/internal/certs"
+	"wire-socket-server/internal/loginguard"
+	"wire-socket-server/internal/metrics"
+	"wire-socket-server/internal/usage"
+	"wire-socket-server/internal/webhook"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// Config represents the server configuration
+type Config struct {
+	Server struct {
+		Address   string `yaml:"address"`
+		LogLevel  string `yaml:"log_level"`  // debug, info, warn, error (default: info)
+		LogFormat string `yaml:"log_format"` // text or json (default: text)
+		// How long shutdown waits for requests and tunnel connections (default: 15s)
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		TLS             *struct {
+			CertFile string `yaml:"cert_file"`
+			KeyFile  string `yaml:"key_file"`
+			ACME     bool   `yaml:"acme"` // Use ACME certificates instead of the files
+		} `yaml:"tls"`
+		// SPKI pins clients require in the certificate chains of the API and
+		// tunnel, added to the pins they learned on first use
+		TLSPins []string `yaml:"tls_pins"`
+		// Reverse proxies (IPs or CIDRs) whose X-Forwarded-For header gives
+		// the client address (default: none)
+		TrustedProxies []string `yaml:"trusted_proxies"`
+	} `yaml:"server"`
+	Database struct {
+		Path   string `yaml:"path"`
+		Driver string `yaml:"driver"`
+		DSN    string `yaml:"dsn"`
+	} `yaml:"database"`
+	WireGuard struct {
+		DeviceName string   `yaml:"device_name"`
+		ListenPort int      `yaml:"listen_port"`
+		Subnet     string   `yaml:"subnet"`
+		DNS        string   `yaml:"dns"`
+		Endpoint   string   `yaml:"endpoint"`
+		PrivateKey string   `yaml:"private_key"`
+		PublicKey  string   `yaml:"public_key"`
+		Mode       string   `yaml:"mode"`   // "kernel" or "userspace"
+		Routes     []string `yaml:"routes"` // Routes to push to clients
+	} `yaml:"wireguard"`
+	Auth struct {
+		JWTSecret         string            `yaml:"jwt_secret"`
+		AllowRegistration bool              `yaml:"allow_registration"` // Default: false (disabled)
+		LoginProtection   loginguard.Config `yaml:"login_protection"`
+	} `yaml:"auth"`
+	Tunnel struct {
+		Enabled    bool   `yaml:"enabled"`
+		ListenAddr string `yaml:"listen_addr"`
+		Path       string `yaml:"path"`
+		PublicHost string `yaml:"public_host"` // Public hostname for clients (e.g., vpn.example.com)
+		TLSCert    string `yaml:"tls_cert"`
+		TLSKey     string `yaml:"tls_key"`
+		TLSACME    bool   `yaml:"tls_acme"` // Use ACME certificates instead of tls_cert/tls_key
+		// Padding, coalescing, jitter and cover traffic against traffic
+		// analysis, for clients that support the framed subprotocol
+		Shaping framing.Shaping `yaml:"shaping"`
+		// Keepalive pings through the tunnel, also suggested to clients
+		Keepalive framing.Keepalive `yaml:"keepalive"`
+		// How long the session of a lost connection is kept for the client
+		// to resume it (default: 30s; negative disables resuming)
+		ResumeTimeout time.Duration `yaml:"resume_timeout"`
+		// Decoy website for requests that yield no tunnel (set one of them)
+		Fallback struct {
+			Proxy     string `yaml:"proxy"`      // Reverse-proxy to this site
+			StaticDir string `yaml:"static_dir"` // Serve files from this directory
+		} `yaml:"fallback"`
+		// Require a valid login token on tunnel connections. When false,
+		// clients that send no token are accepted without a quota or rate
+		// limit. Unset keeps accepting them, for older clients, but warns.
+		RequireAuth *bool `yaml:"require_auth"`
+		// Headers upgrade requests must carry, e.g. a secret added by a CDN
+		// (an empty value accepts any)
+		RequiredHeaders map[string]string `yaml:"required_headers"`
+		// Streams a multiplexed connection may have open at once (default: 256)
+		MaxStreams int `yaml:"max_streams"`
+	} `yaml:"tunnel"`
+	NAT struct {
+		Enabled    bool `yaml:"enabled"`
+		Masquerade []struct {
+			Interface string `yaml:"interface"`
+		} `yaml:"masquerade"`
+		SNAT []struct {
+			Source      string `yaml:"source"`
+			Destination string `yaml:"destination"`
+			Interface   string `yaml:"interface"`
+			ToSource    string `yaml:"to_source"`
+		} `yaml:"snat"`
+		DNAT []struct {
+			Interface     string `yaml:"interface"`
+			Protocol      string `yaml:"protocol"`
+			Port          int    `yaml:"port"`
+			ToDestination string `yaml:"to_destination"`
+		} `yaml:"dnat"`
+		TCPMSS []struct {
+			Interface string `yaml:"interface"`
+			Source    string `yaml:"source"`
+			MSS       int    `yaml:"mss"`
+		} `yaml:"tcpmss"`
+	} `yaml:"nat"`
+	Usage    usage.Config     `yaml:"usage"`
+	Metrics  metrics.Config   `yaml:"metrics"`
+	Webhooks webhook.Config   `yaml:"webhooks"`
+	ACME     certs.ACMEConfig `yaml:"acme"`
+}
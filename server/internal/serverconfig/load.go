@@ -0,0 +1,265 @@
+package serverconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables that override config keys,
+// e.g. WIRESOCKET_WIREGUARD_LISTEN_PORT for wireguard.listen_port
+const envPrefix = "WIRESOCKET"
+
+// configErrors lists every problem found in a configuration
+type configErrors []string
+
+func (e configErrors) Error() string {
+	if len(e) == 1 {
+		return e[0]
+	}
+	return fmt.Sprintf("%d problems:\n  %s", len(e), strings.Join(e, "\n  "))
+}
+
+func (e *configErrors) add(key, format string, args ...any) {
+	*e = append(*e, key+": "+fmt.Sprintf(format, args...))
+}
+
+// Load reads the server config file at path and applies the overrides, in
+// order:
+//  1. any string key "x" can be given as "x_file" holding the path of a file
+//     with the value (Docker and Kubernetes secrets);
+//  2. environment variables WIRESOCKET_<SECTION>_<KEY> replace the value of
+//     any key, and WIRESOCKET_<SECTION>_<KEY>_FILE read a string key from a
+//     file.
+//
+// Unknown keys are rejected. The result still has to pass Validate.
+func Load(path string) (*Config, error) {
+	var config Config
+	if err := LoadInto(path, &config, true); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// LoadInto reads the config file at path into out, a pointer to a struct,
+// with the overrides of Load. With strict, unknown keys are rejected;
+// otherwise they are ignored, to read part of a config.
+func LoadInto(path string, out any, strict bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	v := reflect.ValueOf(out).Elem()
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(root.Content) > 0 {
+		var errs configErrors
+		resolveNode(root.Content[0], v.Type(), "", strict, &errs)
+		if len(errs) > 0 {
+			return errs
+		}
+		if err := root.Content[0].Decode(out); err != nil {
+			return fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
+
+	var errs configErrors
+	applyEnv(v, envPrefix, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// resolveNode checks the keys of a YAML mapping against the struct type t,
+// recording unknown keys if strict, and replaces "x_file" keys by "x" with
+// the file contents
+func resolveNode(node *yaml.Node, t reflect.Type, path string, strict bool, errs *configErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		present := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			present[node.Content[i].Value] = true
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := keyNode.Value
+			if field, ok := fields[key]; ok {
+				resolveNode(valueNode, field.Type, joinKey(path, key), strict, errs)
+				continue
+			}
+
+			base := strings.TrimSuffix(key, "_file")
+			field, ok := fields[base]
+			if base == key || !ok || field.Type.Kind() != reflect.String {
+				if strict {
+					errs.add(joinKey(path, key), "unknown key")
+				}
+				continue
+			}
+			if present[base] {
+				errs.add(joinKey(path, key), "%s is also set; use only one of them", base)
+				continue
+			}
+
+			value, err := readSecretFile(valueNode.Value)
+			if err != nil {
+				errs.add(joinKey(path, key), "%v", err)
+				continue
+			}
+			keyNode.Value = base
+			*valueNode = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			resolveNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), strict, errs)
+		}
+	}
+}
+
+// applyEnv overrides the fields of v from the environment. Strings are taken
+// as they are, string lists may be comma separated and anything else is
+// parsed as YAML (e.g. WIRESOCKET_NAT_MASQUERADE='[{interface: eth0}]').
+func applyEnv(v reflect.Value, prefix string, errs *configErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := yamlName(t.Field(i))
+		if !ok {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		field := v.Field(i)
+
+		// Sections are walked; a nil pointer section is only allocated if a
+		// variable sets one of its keys
+		if field.Kind() == reflect.Struct {
+			applyEnv(field, key, errs)
+			continue
+		}
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			section := reflect.New(field.Type().Elem())
+			if !field.IsNil() {
+				section.Elem().Set(field.Elem())
+			}
+			before := len(*errs)
+			if applyEnv(section.Elem(), key, errs); len(*errs) == before && envSet(key) {
+				field.Set(section)
+			}
+			continue
+		}
+
+		value, hasValue := os.LookupEnv(key)
+		file, hasFile := os.LookupEnv(key + "_FILE")
+		if hasFile && field.Kind() == reflect.String {
+			if hasValue {
+				errs.add(key, "%s_FILE is also set; use only one of them", key)
+				continue
+			}
+			secret, err := readSecretFile(file)
+			if err != nil {
+				errs.add(key+"_FILE", "%v", err)
+				continue
+			}
+			field.SetString(secret)
+			continue
+		}
+		if !hasValue {
+			continue
+		}
+
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(value)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String &&
+			!strings.HasPrefix(strings.TrimSpace(value), "["):
+			list := reflect.MakeSlice(field.Type(), 0, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = reflect.Append(list, reflect.ValueOf(item))
+				}
+			}
+			field.Set(list)
+		default:
+			target := reflect.New(field.Type())
+			if err := yaml.Unmarshal([]byte(value), target.Interface()); err != nil {
+				var typeErr *yaml.TypeError
+				if errors.As(err, &typeErr) {
+					err = errors.New(strings.Join(typeErr.Errors, "; "))
+				}
+				errs.add(key, "invalid value %q: %v", value, err)
+				continue
+			}
+			field.Set(target.Elem())
+		}
+	}
+}
+
+// envSet reports whether any environment variable starts with prefix_
+func envSet(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// readSecretFile returns the contents of a secret file without the trailing
+// newline most editors and "kubectl create secret" leave
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty file path")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// yamlName returns the key of a struct field as yaml.v3 decodes it
+func yamlName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return name, true
+}
+
+// yamlFields maps the YAML keys of a struct type to its fields
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := yamlName(t.Field(i)); ok {
+			fields[name] = t.Field(i)
+		}
+	}
+	return fields
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
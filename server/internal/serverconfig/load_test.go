@@ -0,0 +1,199 @@
+package serverconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validYAML is a minimal config that passes Validate
+const validYAML = `
+server:
+  address: "0.0.0.0:8080"
+database:
+  path: "vpn.db"
+wireguard:
+  listen_port: 51820
+  subnet: "10.0.0.0/24"
+  endpoint: "vpn.example.com:51820"
+auth:
+  jwt_secret: "test-secret"
+`
+
+// writeConfig writes a config file and the given secret files to a
+// temporary directory. "{dir}" in the config is replaced by the directory.
+func writeConfig(t *testing.T, config string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(config, "{dir}", dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string // "{dir}" is replaced by the config's directory
+		files   map[string]string
+		check   func(*Config) any // Returns the value to compare with want
+		want    any
+		wantErr string
+	}{
+		{
+			name:   "file",
+			config: validYAML,
+			check:  func(c *Config) any { return c.Auth.JWTSecret },
+			want:   "test-secret",
+		},
+		{
+			name:   "env string",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_AUTH_JWT_SECRET": "from-env"},
+			check:  func(c *Config) any { return c.Auth.JWTSecret },
+			want:   "from-env",
+		},
+		{
+			name:   "env int",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_WIREGUARD_LISTEN_PORT": "51821"},
+			check:  func(c *Config) any { return c.WireGuard.ListenPort },
+			want:   51821,
+		},
+		{
+			name:   "env nested section",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_AUTH_LOGIN_PROTECTION_MAX_ATTEMPTS": "3"},
+			check:  func(c *Config) any { return c.Auth.LoginProtection.MaxAttempts },
+			want:   3,
+		},
+		{
+			name:   "env list",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_WIREGUARD_ROUTES": "10.1.0.0/16, 192.168.0.0/24"},
+			check:  func(c *Config) any { return c.WireGuard.Routes },
+			want:   []string{"10.1.0.0/16", "192.168.0.0/24"},
+		},
+		{
+			name:   "env pointer section",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_SERVER_TLS_CERT_FILE": "/etc/cert.pem"},
+			check:  func(c *Config) any { return c.Server.TLS != nil && c.Server.TLS.CertFile == "/etc/cert.pem" },
+			want:   true,
+		},
+		{
+			name:   "unset pointer section",
+			config: validYAML,
+			check:  func(c *Config) any { return c.Server.TLS == nil },
+			want:   true,
+		},
+		{
+			name:    "env invalid value",
+			config:  validYAML,
+			env:     map[string]string{"WIRESOCKET_WIREGUARD_LISTEN_PORT": "high"},
+			wantErr: `WIRESOCKET_WIREGUARD_LISTEN_PORT: invalid value "high"`,
+		},
+		{
+			name:   "file key",
+			config: strings.Replace(validYAML, `jwt_secret: "test-secret"`, `jwt_secret_file: "{dir}/secret"`, 1),
+			files:  map[string]string{"secret": "from-file\n"},
+			check:  func(c *Config) any { return c.Auth.JWTSecret },
+			want:   "from-file",
+		},
+		{
+			name:   "env file",
+			config: validYAML,
+			env:    map[string]string{"WIRESOCKET_AUTH_JWT_SECRET_FILE": "{dir}/secret"},
+			files:  map[string]string{"secret": "from-env-file\r\n"},
+			check:  func(c *Config) any { return c.Auth.JWTSecret },
+			want:   "from-env-file",
+		},
+		{
+			name:    "key and file key",
+			config:  validYAML + `  jwt_secret_file: "{dir}/secret"` + "\n",
+			files:   map[string]string{"secret": "from-file"},
+			wantErr: "auth.jwt_secret_file: jwt_secret is also set",
+		},
+		{
+			name:    "env and env file",
+			config:  validYAML,
+			env:     map[string]string{"WIRESOCKET_AUTH_JWT_SECRET": "a", "WIRESOCKET_AUTH_JWT_SECRET_FILE": "{dir}/secret"},
+			files:   map[string]string{"secret": "b"},
+			wantErr: "WIRESOCKET_AUTH_JWT_SECRET: WIRESOCKET_AUTH_JWT_SECRET_FILE is also set",
+		},
+		{
+			name:    "missing file",
+			config:  strings.Replace(validYAML, `jwt_secret: "test-secret"`, `jwt_secret_file: "{dir}/missing"`, 1),
+			wantErr: "auth.jwt_secret_file: failed to read secret file",
+		},
+		{
+			name:    "file key of a number",
+			config:  strings.Replace(validYAML, "listen_port: 51820", `listen_port_file: "{dir}/port"`, 1),
+			wantErr: "wireguard.listen_port_file: unknown key",
+		},
+		{
+			name:    "unknown key",
+			config:  strings.Replace(validYAML, "jwt_secret:", "jwt_secrte:", 1),
+			wantErr: "auth.jwt_secrte: unknown key",
+		},
+		{
+			name:    "unknown section",
+			config:  validYAML + "wireguad:\n  mode: kernel\n",
+			wantErr: "wireguad: unknown key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.config, tt.files)
+			for key, value := range tt.env {
+				t.Setenv(key, strings.ReplaceAll(value, "{dir}", filepath.Dir(path)))
+			}
+
+			config, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if got := tt.check(config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadIntoLenient(t *testing.T) {
+	path := writeConfig(t, validYAML+"  master_token_file: \"{dir}/token\"\n", map[string]string{"token": "master\n"})
+	t.Setenv("WIRESOCKET_DATABASE_PATH", "/var/lib/vpn.db")
+
+	var partial struct {
+		Database struct {
+			Path string `yaml:"path"`
+		} `yaml:"database"`
+		Auth struct {
+			MasterToken string `yaml:"master_token"`
+		} `yaml:"auth"`
+	}
+	if err := LoadInto(path, &partial, false); err != nil {
+		t.Fatal(err)
+	}
+	if partial.Database.Path != "/var/lib/vpn.db" || partial.Auth.MasterToken != "master" {
+		t.Errorf("got %+v", partial)
+	}
+	if err := LoadInto(path, &partial, true); err == nil {
+		t.Error("strict LoadInto accepted unknown keys")
+	}
+}
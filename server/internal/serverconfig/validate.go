@@ -0,0 +1,343 @@
+package serverconfig
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wire-socket-server/internal/certs"
+	"wire-socket-server/internal/logging"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultJWTSecret is the placeholder shipped in the example configs. The
+// server refuses to start with it unless -dev is given.
+const DefaultJWTSecret = "change-this-secret-in-production"
+
+// Validate checks the configuration and returns every problem found, each
+// prefixed with its key. With dev set, the example JWT secret is accepted.
+func Validate(config *Config, dev bool) error {
+	var errs configErrors
+
+	// server
+	if config.Server.Address == "" {
+		errs.add("server.address", "required")
+	} else {
+		validateHostPort(&errs, "server.address", config.Server.Address)
+	}
+	if _, err := logging.ParseLevel(config.Server.LogLevel); err != nil {
+		errs.add("server.log_level", "%v", err)
+	}
+	switch strings.ToLower(config.Server.LogFormat) {
+	case "", "text", "json":
+	default:
+		errs.add("server.log_format", "invalid log format %q (want text or json)", config.Server.LogFormat)
+	}
+	validateNonNegative(&errs, "server.shutdown_timeout", config.Server.ShutdownTimeout)
+	for i, pin := range config.Server.TLSPins {
+		if err := certs.ValidatePin(pin); err != nil {
+			errs.add(fmt.Sprintf("server.tls_pins[%d]", i), "%v", err)
+		}
+	}
+	for i, proxy := range config.Server.TrustedProxies {
+		validateIPOrCIDR(&errs, fmt.Sprintf("server.trusted_proxies[%d]", i), proxy)
+	}
+	if tls := config.Server.TLS; tls != nil {
+		if tls.ACME {
+			if tls.CertFile != "" || tls.KeyFile != "" {
+				errs.add("server.tls", "cert_file and key_file cannot be used with acme")
+			}
+		} else {
+			validateFile(&errs, "server.tls.cert_file", tls.CertFile, true)
+			validateFile(&errs, "server.tls.key_file", tls.KeyFile, true)
+		}
+	}
+
+	// database
+	if config.Database.Path == "" {
+		errs.add("database.path", "required")
+	}
+
+	// wireguard
+	wg := config.WireGuard
+	if wg.Subnet == "" {
+		errs.add("wireguard.subnet", "required")
+	} else if ip, ipNet, err := net.ParseCIDR(wg.Subnet); err != nil {
+		errs.add("wireguard.subnet", "invalid CIDR %q", wg.Subnet)
+	} else if ip.To4() == nil {
+		errs.add("wireguard.subnet", "only IPv4 subnets are supported")
+	} else if ones, _ := ipNet.Mask.Size(); ones > 30 {
+		errs.add("wireguard.subnet", "%s leaves no room for clients (use /30 or larger)", wg.Subnet)
+	}
+	if wg.Endpoint == "" {
+		errs.add("wireguard.endpoint", "required: the public host:port clients connect to")
+	} else {
+		validateHostPort(&errs, "wireguard.endpoint", wg.Endpoint)
+	}
+	if wg.ListenPort < 1 || wg.ListenPort > 65535 {
+		errs.add("wireguard.listen_port", "must be between 1 and 65535, got %d", wg.ListenPort)
+	}
+	switch wg.Mode {
+	case "", "kernel", "userspace":
+	default:
+		errs.add("wireguard.mode", "invalid mode %q (want kernel or userspace)", wg.Mode)
+	}
+	if wg.DNS != "" {
+		for _, dns := range strings.Split(wg.DNS, ",") {
+			if net.ParseIP(strings.TrimSpace(dns)) == nil {
+				errs.add("wireguard.dns", "invalid IP address %q", strings.TrimSpace(dns))
+			}
+		}
+	}
+	for i, route := range wg.Routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			errs.add(fmt.Sprintf("wireguard.routes[%d]", i), "invalid CIDR %q", route)
+		}
+	}
+	if wg.PrivateKey != "" {
+		if privateKey, err := wgtypes.ParseKey(wg.PrivateKey); err != nil {
+			errs.add("wireguard.private_key", "invalid private key: %v", err)
+		} else if publicKey := privateKey.PublicKey().String(); wg.PublicKey != "" && wg.PublicKey != publicKey {
+			errs.add("wireguard.public_key", "does not match private_key (expected %s)", publicKey)
+		}
+	}
+
+	// auth
+	switch config.Auth.JWTSecret {
+	case "":
+		errs.add("auth.jwt_secret", "required")
+	case DefaultJWTSecret:
+		if !dev {
+			errs.add("auth.jwt_secret", "still set to the example value; generate one with \"openssl rand -base64 32\" (or start with -dev)")
+		}
+	}
+	lp := config.Auth.LoginProtection
+	switch lp.Store {
+	case "", "memory", "db", "database":
+	default:
+		errs.add("auth.login_protection.store", "invalid store %q (want memory or db)", lp.Store)
+	}
+	validateNonNegative(&errs, "auth.login_protection.window", lp.Window)
+	validateNonNegative(&errs, "auth.login_protection.base_delay", lp.BaseDelay)
+	validateNonNegative(&errs, "auth.login_protection.max_delay", lp.MaxDelay)
+	validateNonNegative(&errs, "auth.login_protection.lockout_duration", lp.LockoutDuration)
+
+	// tunnel
+	if t := config.Tunnel; t.Enabled {
+		if t.ListenAddr != "" {
+			validateHostPort(&errs, "tunnel.listen_addr", t.ListenAddr)
+		} else if t.TLSACME || t.TLSCert != "" || t.TLSKey != "" {
+			errs.add("tunnel", "tls_cert, tls_key and tls_acme need listen_addr; without it the tunnel uses server.tls")
+		} else {
+			validateMountPath(&errs, config)
+		}
+		if t.TLSACME && (t.TLSCert != "" || t.TLSKey != "") {
+			errs.add("tunnel", "tls_cert and tls_key cannot be used with tls_acme")
+		} else if (t.TLSCert == "") != (t.TLSKey == "") {
+			errs.add("tunnel", "tls_cert and tls_key must be set together")
+		}
+		validateFile(&errs, "tunnel.tls_cert", t.TLSCert, false)
+		validateFile(&errs, "tunnel.tls_key", t.TLSKey, false)
+	}
+	if err := config.Tunnel.Shaping.Validate(); err != nil {
+		errs.add("tunnel.shaping", "%v", err)
+	}
+	if err := config.Tunnel.Keepalive.Validate(); err != nil {
+		errs.add("tunnel.keepalive", "%v", err)
+	}
+	if fallback := config.Tunnel.Fallback; fallback.Proxy != "" && fallback.StaticDir != "" {
+		errs.add("tunnel.fallback", "set either proxy or static_dir")
+	} else if fallback.Proxy != "" {
+		if u, err := url.Parse(fallback.Proxy); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("tunnel.fallback.proxy", "must be an http(s) URL, got %q", fallback.Proxy)
+		}
+	} else if fallback.StaticDir != "" {
+		if info, err := os.Stat(fallback.StaticDir); err != nil {
+			errs.add("tunnel.fallback.static_dir", "%v", err)
+		} else if !info.IsDir() {
+			errs.add("tunnel.fallback.static_dir", "%s is not a directory", fallback.StaticDir)
+		}
+	}
+	for name, value := range config.Tunnel.RequiredHeaders {
+		if name == "" || strings.ContainsAny(name, ": \t\r\n") {
+			errs.add("tunnel.required_headers", "invalid header name %q", name)
+		} else if strings.ContainsAny(value, "\r\n") {
+			errs.add("tunnel.required_headers."+name, "must be a single line")
+		}
+	}
+	if config.Tunnel.MaxStreams < 0 {
+		errs.add("tunnel.max_streams", "must not be negative, got %d", config.Tunnel.MaxStreams)
+	}
+	if config.Tunnel.Path != "" && !strings.HasPrefix(config.Tunnel.Path, "/") {
+		errs.add("tunnel.path", "must start with \"/\", got %q", config.Tunnel.Path)
+	}
+	if host := config.Tunnel.PublicHost; strings.Contains(host, "://") || strings.Contains(host, "/") {
+		errs.add("tunnel.public_host", "must be a hostname (optionally with :port), got %q", host)
+	}
+
+	// nat
+	for i, rule := range config.NAT.Masquerade {
+		key := fmt.Sprintf("nat.masquerade[%d]", i)
+		if rule.Interface == "" {
+			errs.add(key+".interface", "required")
+		}
+	}
+	for i, rule := range config.NAT.SNAT {
+		key := fmt.Sprintf("nat.snat[%d]", i)
+		validateCIDR(&errs, key+".source", rule.Source)
+		validateCIDR(&errs, key+".destination", rule.Destination)
+		if rule.ToSource == "" {
+			errs.add(key+".to_source", "required")
+		} else if net.ParseIP(rule.ToSource) == nil {
+			errs.add(key+".to_source", "invalid IP address %q", rule.ToSource)
+		}
+	}
+	for i, rule := range config.NAT.DNAT {
+		key := fmt.Sprintf("nat.dnat[%d]", i)
+		switch rule.Protocol {
+		case "tcp", "udp":
+		default:
+			errs.add(key+".protocol", "invalid protocol %q (want tcp or udp)", rule.Protocol)
+		}
+		if rule.Port < 1 || rule.Port > 65535 {
+			errs.add(key+".port", "must be between 1 and 65535, got %d", rule.Port)
+		}
+		if rule.ToDestination == "" {
+			errs.add(key+".to_destination", "required")
+		} else if net.ParseIP(rule.ToDestination) == nil {
+			if _, _, err := net.SplitHostPort(rule.ToDestination); err != nil {
+				errs.add(key+".to_destination", "invalid address %q (want ip or ip:port)", rule.ToDestination)
+			}
+		}
+	}
+
+	for i, rule := range config.NAT.TCPMSS {
+		key := fmt.Sprintf("nat.tcpmss[%d]", i)
+		validateCIDR(&errs, key+".source", rule.Source)
+		if rule.MSS < 536 || rule.MSS > 65495 {
+			errs.add(key+".mss", "must be between 536 and 65495, got %d", rule.MSS)
+		}
+	}
+
+	// acme, when a listener uses it
+	if (config.Server.TLS != nil && config.Server.TLS.ACME) || (config.Tunnel.Enabled && config.Tunnel.ListenAddr != "" && config.Tunnel.TLSACME) {
+		validateACME(&errs, config.ACME)
+	}
+
+	// usage, metrics, webhooks
+	validateNonNegative(&errs, "usage.interval", config.Usage.Interval)
+	validateNonNegative(&errs, "usage.hourly_retention", config.Usage.HourlyRetention)
+	validateNonNegative(&errs, "usage.daily_retention", config.Usage.DailyRetention)
+	if p := config.Metrics.Path; p != "" && !strings.HasPrefix(p, "/") {
+		errs.add("metrics.path", "must start with \"/\", got %q", p)
+	}
+	validateNonNegative(&errs, "webhooks.initial_backoff", config.Webhooks.InitialBackoff)
+	validateNonNegative(&errs, "webhooks.max_backoff", config.Webhooks.MaxBackoff)
+	validateNonNegative(&errs, "webhooks.timeout", config.Webhooks.Timeout)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateMountPath checks that the tunnel path doesn't clash with the API
+// routes when the tunnel is served on the API listener
+func validateMountPath(errs *configErrors, config *Config) {
+	path := config.Tunnel.Path
+	if path == "" {
+		path = "/"
+	}
+	metricsPath := config.Metrics.Path
+	if metricsPath == "" {
+		metricsPath = "/metrics"
+	}
+
+	for _, reserved := range []string{"/api", "/admin", "/health", metricsPath} {
+		if path == reserved || strings.HasPrefix(path, reserved+"/") {
+			errs.add("tunnel.path", "%q is used by the API; choose another path to serve the tunnel on the API listener", path)
+			return
+		}
+	}
+}
+
+// validateACME checks the acme section
+func validateACME(errs *configErrors, acme certs.ACMEConfig) {
+	if len(acme.Domains) == 0 {
+		errs.add("acme.domains", "required when a listener uses ACME")
+	}
+	for i, domain := range acme.Domains {
+		if domain == "" || strings.ContainsAny(domain, ":/ ") || net.ParseIP(domain) != nil {
+			errs.add(fmt.Sprintf("acme.domains[%d]", i), "must be a DNS name, got %q", domain)
+		}
+	}
+	if acme.CacheDir == "" {
+		errs.add("acme.cache_dir", "required, certificates would be requested again on every start")
+	}
+	if acme.DirectoryURL != "" {
+		if u, err := url.Parse(acme.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs.add("acme.directory_url", "must be an https URL, got %q", acme.DirectoryURL)
+		}
+	}
+	validateFile(errs, "acme.ca_file", acme.CAFile, false)
+	if acme.HTTPAddr != "" {
+		validateHostPort(errs, "acme.http_addr", acme.HTTPAddr)
+	}
+	validateNonNegative(errs, "acme.renew_before", acme.RenewBefore)
+}
+
+// validateHostPort checks a host:port address with a numeric port
+func validateHostPort(errs *configErrors, key, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		errs.add(key, "invalid address %q (want host:port)", addr)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs.add(key, "invalid port %q in %q", port, addr)
+	}
+}
+
+// validateCIDR checks an optional CIDR
+func validateCIDR(errs *configErrors, key, cidr string) {
+	if cidr == "" {
+		return
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		errs.add(key, "invalid CIDR %q", cidr)
+	}
+}
+
+// validateIPOrCIDR checks an IP address or CIDR range
+func validateIPOrCIDR(errs *configErrors, key, value string) {
+	if net.ParseIP(value) != nil {
+		return
+	}
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		errs.add(key, "invalid IP address or CIDR %q", value)
+	}
+}
+
+// validateFile checks that a file exists
+func validateFile(errs *configErrors, key, path string, required bool) {
+	if path == "" {
+		if required {
+			errs.add(key, "required")
+		}
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		errs.add(key, "%v", err)
+	}
+}
+
+// validateNonNegative rejects negative durations
+func validateNonNegative(errs *configErrors, key string, d time.Duration) {
+	if d < 0 {
+		errs.add(key, "must not be negative, got %s", d)
+	}
+}
@@ -0,0 +1,138 @@
+package serverconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*Config)
+		dev      bool
+		wantErrs []string // Expected problems, each "key: message" prefix
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{
+			name:     "default JWT secret",
+			modify:   func(c *Config) { c.Auth.JWTSecret = DefaultJWTSecret },
+			wantErrs: []string{"auth.jwt_secret: still set to the example value"},
+		},
+		{
+			name:   "default JWT secret in dev mode",
+			modify: func(c *Config) { c.Auth.JWTSecret = DefaultJWTSecret },
+			dev:    true,
+		},
+		{
+			name:     "missing JWT secret",
+			modify:   func(c *Config) { c.Auth.JWTSecret = "" },
+			dev:      true,
+			wantErrs: []string{"auth.jwt_secret: required"},
+		},
+		{
+			name:     "invalid subnet",
+			modify:   func(c *Config) { c.WireGuard.Subnet = "10.0.0.0" },
+			wantErrs: []string{`wireguard.subnet: invalid CIDR "10.0.0.0"`},
+		},
+		{
+			name:     "IPv6 subnet",
+			modify:   func(c *Config) { c.WireGuard.Subnet = "fd00::/64" },
+			wantErrs: []string{"wireguard.subnet: only IPv4 subnets are supported"},
+		},
+		{
+			name:     "subnet too small",
+			modify:   func(c *Config) { c.WireGuard.Subnet = "10.0.0.0/31" },
+			wantErrs: []string{"wireguard.subnet: 10.0.0.0/31 leaves no room for clients"},
+		},
+		{
+			name:     "missing endpoint",
+			modify:   func(c *Config) { c.WireGuard.Endpoint = "" },
+			wantErrs: []string{"wireguard.endpoint: required"},
+		},
+		{
+			name:     "endpoint without port",
+			modify:   func(c *Config) { c.WireGuard.Endpoint = "vpn.example.com" },
+			wantErrs: []string{`wireguard.endpoint: invalid address "vpn.example.com" (want host:port)`},
+		},
+		{
+			name:     "endpoint with bad port",
+			modify:   func(c *Config) { c.WireGuard.Endpoint = "vpn.example.com:70000" },
+			wantErrs: []string{`wireguard.endpoint: invalid port "70000"`},
+		},
+		{
+			name:     "invalid trusted proxy",
+			modify:   func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.1", "proxy"} },
+			wantErrs: []string{`server.trusted_proxies[1]: invalid IP address or CIDR "proxy"`},
+		},
+		{
+			name: "tunnel on the API listener",
+			modify: func(c *Config) {
+				c.Tunnel.Enabled = true
+				c.Tunnel.Path = "/tunnel"
+			},
+		},
+		{
+			name: "tunnel on an API path",
+			modify: func(c *Config) {
+				c.Tunnel.Enabled = true
+				c.Tunnel.Path = "/api/ws"
+			},
+			wantErrs: []string{`tunnel.path: "/api/ws" is used by the API`},
+		},
+		{
+			name: "tunnel on the metrics path",
+			modify: func(c *Config) {
+				c.Tunnel.Enabled = true
+				c.Tunnel.Path = "/stats"
+				c.Metrics.Path = "/stats"
+			},
+			wantErrs: []string{`tunnel.path: "/stats" is used by the API`},
+		},
+		{
+			name: "tunnel on an API path with its own listener",
+			modify: func(c *Config) {
+				c.Tunnel.Enabled = true
+				c.Tunnel.ListenAddr = ":8443"
+				c.Tunnel.Path = "/api"
+			},
+		},
+		{
+			name: "every problem",
+			modify: func(c *Config) {
+				c.WireGuard.Subnet = ""
+				c.WireGuard.Endpoint = ""
+				c.Auth.JWTSecret = DefaultJWTSecret
+			},
+			wantErrs: []string{"wireguard.subnet: required", "wireguard.endpoint: required", "auth.jwt_secret: still set"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Load(writeConfig(t, validYAML, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(config)
+
+			err = Validate(config, tt.dev)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(configErrors)
+			if !ok {
+				t.Fatalf("Validate error = %v, want the problems %q", err, tt.wantErrs)
+			}
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("Validate found %d problems, want %d: %v", len(errs), len(tt.wantErrs), err)
+			}
+			for i, want := range tt.wantErrs {
+				if !strings.HasPrefix(errs[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
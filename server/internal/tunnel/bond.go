@@ -0,0 +1,150 @@
+package tunnel
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/bond"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+)
+
+// Path describes a connection of a bonded session
+type Path struct {
+	RemoteAddr      string    `json:"remote_addr"`       // Client address of the connection
+	ConnectedAt     time.Time `json:"connected_at"`      // When the connection was established
+	LatencyMs       float64   `json:"latency_ms"`        // Round-trip time of the last keepalive ping (0 until measured)
+	BytesFromClient uint64    `json:"bytes_from_client"` // WebSocket -> UDP
+	BytesToClient   uint64    `json:"bytes_to_client"`   // UDP -> WebSocket
+}
+
+// bondPath is a connection carrying the datagrams of a session: the session's own
+// connection, or one joined to it with framing.ControlJoin
+type bondPath struct {
+	conn        *framing.Conn
+	remoteAddr  string
+	connectedAt time.Time
+
+	bytesFromClient atomic.Uint64
+	bytesToClient   atomic.Uint64
+}
+
+// snapshot returns the description of the path
+func (p *bondPath) snapshot() Path {
+	return Path{
+		RemoteAddr:      p.remoteAddr,
+		ConnectedAt:     p.connectedAt,
+		LatencyMs:       durationMs(p.conn.RTT()),
+		BytesFromClient: p.bytesFromClient.Load(),
+		BytesToClient:   p.bytesToClient.Load(),
+	}
+}
+
+// join makes the connection of sess a path of the session with the given
+// resume token: its datagrams go to that session's UDP socket, which sends
+// over all of its paths. sess gives up its own UDP socket.
+func (s *Server) join(logger *slog.Logger, sess *session, token string) {
+	s.sessionsMu.Lock()
+	var target *session
+	for _, other := range s.sessions {
+		if other != sess && other.resumeToken == token {
+			target = other
+			break
+		}
+	}
+	if target == nil || target.UserID != sess.UserID {
+		s.sessionsMu.Unlock()
+		logger.Warn("tunnel session to join not found")
+		sess.conn.SendClose(framing.CloseNormal, "session to join not found", time.Now().Add(time.Second))
+		sess.ws.Close()
+		return
+	}
+	if !target.addPath(sess.path) {
+		s.sessionsMu.Unlock()
+		sess.ws.Close()
+		return
+	}
+	if s.sessions[sess.LocalAddr] == sess {
+		delete(s.sessions, sess.LocalAddr)
+	}
+	sess.joined.Store(target)
+	s.sessionsMu.Unlock()
+
+	// Wakes udpToWS, which leaves sending to the joined session
+	sess.udpConn().Close()
+	if err := sess.conn.SendControl(framing.ControlJoin, nil); err != nil {
+		logger.Debug("failed to confirm join", "error", err)
+	}
+	logger.Info("tunnel path joined", "joined_endpoint", target.LocalAddr, "paths", target.numPaths())
+}
+
+// addPath adds a joined connection to the paths of the session. It returns
+// false if the session's own connection has ended.
+func (sess *session) addPath(p *bondPath) bool {
+	sess.pathsMu.Lock()
+	defer sess.pathsMu.Unlock()
+	if sess.pathsClosed {
+		return false
+	}
+	if sess.scheduler == nil {
+		sess.scheduler = bond.NewScheduler(bond.WeightedRTT)
+	}
+	sess.paths = append(sess.paths, p)
+	return true
+}
+
+// removePath removes a joined connection that ended
+func (sess *session) removePath(p *bondPath) {
+	sess.pathsMu.Lock()
+	defer sess.pathsMu.Unlock()
+	for i, other := range sess.paths {
+		if other == p {
+			sess.paths = append(sess.paths[:i:i], sess.paths[i+1:]...)
+			return
+		}
+	}
+}
+
+// closePaths closes the joined connections once the session's own connection
+// has ended; the client joins them again after resuming
+func (sess *session) closePaths() {
+	sess.pathsMu.Lock()
+	paths := sess.paths
+	sess.paths = nil
+	sess.pathsClosed = true
+	sess.pathsMu.Unlock()
+
+	for _, p := range paths {
+		if p != sess.path {
+			p.conn.Close()
+		}
+	}
+}
+
+// numPaths returns the number of connections of the session
+func (sess *session) numPaths() int {
+	sess.pathsMu.Lock()
+	defer sess.pathsMu.Unlock()
+	return len(sess.paths)
+}
+
+// pickPath returns the connection to send the next datagram on: own, the
+// session's own connection, or a joined one, weighted by round-trip time
+func (sess *session) pickPath(own datagramConn) (datagramConn, *bondPath) {
+	sess.pathsMu.Lock()
+	paths, scheduler := sess.paths, sess.scheduler
+	sess.pathsMu.Unlock()
+	if len(paths) < 2 {
+		return own, sess.path
+	}
+
+	rtts := make([]time.Duration, len(paths))
+	for i, p := range paths {
+		rtts[i] = p.conn.RTT()
+	}
+	p := paths[scheduler.Pick(rtts)]
+	if p == sess.path {
+		return own, p
+	}
+	return p.conn, p
+}
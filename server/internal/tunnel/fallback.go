@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+)
+
+// SetFallback sets the handler for requests that don't yield a tunnel:
+// requests to other paths, requests to the tunnel path that are no WebSocket
+// upgrade, and upgrades refused by the authenticator. A decoy website there
+// makes the listener look like an ordinary web server. Without a fallback
+// these requests get an error.
+func (s *Server) SetFallback(h http.Handler) {
+	s.fallback = h
+}
+
+// ProxyFallback returns a fallback that reverse-proxies requests to the site
+// at upstream (e.g. "https://www.example.com"), with the Host header of the
+// upstream site
+func ProxyFallback(upstream string) (http.Handler, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback URL: %w", err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid fallback URL %q: want http(s)://host", upstream)
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Debug("fallback upstream request failed", "upstream", upstream, "path", r.URL.Path, "error", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// StaticFallback returns a fallback that serves the files in dir. Directories
+// are only served through their index.html, never listed.
+func StaticFallback(dir string) (http.Handler, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("invalid fallback directory %s: not a directory", dir)
+	}
+	return http.FileServer(noListingFS{http.Dir(dir)}), nil
+}
+
+// noListingFS hides directories that have no index.html
+type noListingFS struct {
+	fs http.FileSystem
+}
+
+func (n noListingFS) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := n.fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		index.Close()
+	}
+	return f, nil
+}
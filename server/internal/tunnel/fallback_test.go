@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// decoy stands in for the decoy website
+var decoy = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "decoy site")
+})
+
+// testServer serves a tunnel to a UDP socket that is never read. Tokens
+// "good" are accepted, "banned" is forbidden and anything else unauthorized.
+func testServer(t *testing.T, fallback http.Handler, requiredHeaders map[string]string) *httptest.Server {
+	t.Helper()
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+
+	s := NewServer(Config{TargetAddr: target.LocalAddr().String(), RequiredHeaders: requiredHeaders})
+	s.SetAuthenticator(func(r *http.Request) (Identity, error) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			return Identity{UserID: 1, Username: "alice"}, nil
+		case "Bearer banned":
+			return Identity{}, errors.New("account disabled")
+		}
+		return Identity{}, ErrUnauthorized
+	})
+	if fallback != nil {
+		s.SetFallback(fallback)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback http.Handler
+		required map[string]string
+		upgrade  bool
+		headers  map[string]string
+		want     int // http.StatusSwitchingProtocols for an accepted tunnel
+		wantBody string
+	}{
+		{"accepted", decoy, nil, true, map[string]string{"Authorization": "Bearer good"}, http.StatusSwitchingProtocols, ""},
+		{"plain request", decoy, nil, false, nil, http.StatusOK, "decoy site"},
+		{"unauthorized upgrade", decoy, nil, true, nil, http.StatusOK, "decoy site"},
+		{"forbidden upgrade", decoy, nil, true, map[string]string{"Authorization": "Bearer banned"}, http.StatusOK, "decoy site"},
+		{"missing required header", decoy, map[string]string{"X-CDN-Secret": "s3cret"}, true, map[string]string{"Authorization": "Bearer good"}, http.StatusOK, "decoy site"},
+		{"required header", decoy, map[string]string{"X-CDN-Secret": "s3cret"}, true, map[string]string{"Authorization": "Bearer good", "X-CDN-Secret": "s3cret"}, http.StatusSwitchingProtocols, ""},
+		{"no fallback: plain request", nil, nil, false, nil, http.StatusUnauthorized, "authentication required"},
+		{"no fallback: unauthorized upgrade", nil, nil, true, nil, http.StatusUnauthorized, "authentication required"},
+		{"no fallback: forbidden upgrade", nil, nil, true, map[string]string{"Authorization": "Bearer banned"}, http.StatusForbidden, "account disabled"},
+		{"no fallback: missing required header", nil, map[string]string{"X-CDN-Secret": "s3cret"}, true, nil, http.StatusForbidden, "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testServer(t, tt.fallback, tt.required)
+			header := http.Header{}
+			for name, value := range tt.headers {
+				header.Set(name, value)
+			}
+
+			var resp *http.Response
+			if tt.upgrade {
+				ws, r, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+				if err == nil {
+					ws.Close()
+				} else if r == nil {
+					t.Fatal(err)
+				}
+				resp = r
+			} else {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+				req.Header = header
+				r, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp = r
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("got HTTP %d %q, want HTTP %d %q", resp.StatusCode, body, tt.want, tt.wantBody)
+			}
+			// A refused upgrade must look like any other page of the site
+			if tt.fallback != nil && tt.want != http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Accept") != "" {
+				t.Error("refused upgrade carries a WebSocket handshake header")
+			}
+		})
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":      "home",
+		"docs/index.html": "docs",
+		"assets/app.css":  "body{}",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := StaticFallback(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		want     int
+		wantBody string
+	}{
+		{"/", http.StatusOK, "home"},
+		{"/docs/", http.StatusOK, "docs"},
+		{"/assets/app.css", http.StatusOK, "body{}"},
+		{"/assets/", http.StatusNotFound, ""}, // No index.html, so no listing
+		{"/missing", http.StatusNotFound, ""},
+		{"/../etc/passwd", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil))
+		if w.Code != tt.want || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body, tt.want, tt.wantBody)
+		}
+	}
+
+	if _, err := StaticFallback(filepath.Join(dir, "index.html")); err == nil {
+		t.Error("StaticFallback accepted a file")
+	}
+	if _, err := StaticFallback(filepath.Join(dir, "missing")); err == nil {
+		t.Error("StaticFallback accepted a missing directory")
+	}
+}
+
+func TestProxyFallback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.Host+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	h, err := ProxyFallback(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://vpn.example.com/about", nil))
+	if want := "upstream " + strings.TrimPrefix(upstream.URL, "http://") + "/about"; w.Body.String() != want {
+		t.Errorf("proxied response = %q, want %q", w.Body, want)
+	}
+
+	for _, invalid := range []string{"", "www.example.com", "ftp://example.com", "https://", "://bad"} {
+		if _, err := ProxyFallback(invalid); err == nil {
+			t.Errorf("ProxyFallback accepted %q", invalid)
+		}
+	}
+}
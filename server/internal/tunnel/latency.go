@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyHistorySize is how many latency samples are kept per connection;
+// with the default ping interval this covers the last hour
+const latencyHistorySize = 120
+
+// LatencySample is a round-trip time measured with a keepalive ping
+type LatencySample struct {
+	Time      time.Time `json:"time"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// latencyHistory keeps the latest latency samples of a connection
+type latencyHistory struct {
+	mu   sync.Mutex
+	ring []LatencySample
+	next int // Where the next sample goes once the ring is full
+}
+
+// add records a round-trip time
+func (h *latencyHistory) add(rtt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample := LatencySample{Time: time.Now(), LatencyMs: durationMs(rtt)}
+	if len(h.ring) < latencyHistorySize {
+		h.ring = append(h.ring, sample)
+		return
+	}
+	h.ring[h.next] = sample
+	h.next = (h.next + 1) % latencyHistorySize
+}
+
+// samples returns the samples, oldest first
+func (h *latencyHistory) samples() []LatencySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := make([]LatencySample, 0, len(h.ring))
+	samples = append(samples, h.ring[h.next:]...)
+	return append(samples, h.ring[:h.next]...)
+}
+
+// prepend puts the samples of older before those of h, e.g. when a
+// connection resumes the session of older
+func (h *latencyHistory) prepend(older *latencyHistory) {
+	merged := append(older.samples(), h.samples()...)
+	if len(merged) > latencyHistorySize {
+		merged = merged[len(merged)-latencyHistorySize:]
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ring, h.next = merged, 0
+}
+
+// durationMs converts d to milliseconds with microsecond precision
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
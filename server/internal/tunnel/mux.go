@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/mux"
+)
+
+// serveMux serves the streams of a multiplexed connection until it ends.
+// Every stream is a session with its own UDP socket, so WireGuard sees a peer
+// endpoint per stream; the streams share the connection's rate limit and
+// latency history. Streams can't be resumed: a client that reconnects opens
+// new ones.
+func (s *Server) serveMux(r *http.Request, identity Identity, conn *framing.Conn, ws *websocket.Conn) {
+	s.stats.upgradesAccepted.Add(1)
+
+	logger := slog.With("remote_addr", s.clientAddr(r))
+	if identity.Username != "" {
+		logger = logger.With("user", identity.Username, "user_id", identity.UserID)
+	}
+	logger.Info("multiplexed tunnel connection opened")
+	connectedAt := time.Now()
+
+	upload := newTokenBucket(identity.RateLimit)
+	download := newTokenBucket(identity.RateLimit)
+	latency := &latencyHistory{}
+	conn.SetRTTHandler(latency.add)
+	conn.StartKeepalive(s.keepalive)
+
+	sess := mux.NewSession(conn, mux.Config{MaxStreams: s.maxStreams})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			stream, err := sess.Accept()
+			if err != nil {
+				return
+			}
+			udpConn, err := s.dialTarget()
+			if err != nil {
+				stream.Close()
+				continue
+			}
+
+			streamSess := s.newSession(r, identity, conn, ws, udpConn)
+			streamSess.Stream = stream.ID()
+			streamSess.stream = stream
+			streamSess.upload = upload
+			streamSess.download = download
+			streamSess.latency = latency
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serveStream(logger, streamSess)
+			}()
+		}
+	}()
+
+	err := sess.Run()
+	if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		logger.Debug("WebSocket read error", "error", err)
+	}
+	wg.Wait()
+	logger.Info("multiplexed tunnel connection closed", "duration", time.Since(connectedAt).Round(time.Second))
+}
+
+// serveStream forwards the datagrams of a stream until it is closed
+func (s *Server) serveStream(logger *slog.Logger, sess *session) {
+	logger = logger.With("stream", sess.Stream, "endpoint", sess.LocalAddr)
+	logger.Debug("tunnel stream opened")
+	s.addSession(sess)
+
+	s.forward(logger, sess, sess.stream)
+
+	s.removeSession(sess)
+	sess.udpConn().Close()
+	logger.Debug("tunnel stream closed", "duration", time.Since(sess.ConnectedAt).Round(time.Second))
+}
@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits throughput to rate bytes per second with bursts of up
+// to burst bytes
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket for rate bytes per second, or nil (no
+// limit) when rate is not positive. The burst is one second of traffic but at
+// least one maximum-size packet.
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(rate)
+	if burst < DefaultBufferSize {
+		burst = DefaultBufferSize
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until n bytes may be sent or ctx is done. A nil bucket never blocks.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	// Take the tokens now, going into debt if needed, and sleep off the debt
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -4,14 +4,24 @@ package tunnel
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/bond"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/framing"
+	"github.com/k0ngk0ng/wire-socket/pkg/wstunnel/mux"
 )
 
 const (
@@ -20,6 +30,14 @@ const (
 
 	// DefaultTimeout is the default connection timeout
 	DefaultTimeout = 30 * time.Second
+
+	// DefaultResumeTimeout is how long the session of a dropped connection
+	// is kept for the client to resume it
+	DefaultResumeTimeout = 30 * time.Second
+
+	// takeoverTimeout bounds how long a resuming connection waits for the
+	// connection it replaces to end
+	takeoverTimeout = 5 * time.Second
 )
 
 // Server handles WebSocket connections and forwards data to UDP
@@ -29,10 +47,123 @@ type Server struct {
 	pathPrefix string // Path prefix for WebSocket upgrade (e.g., "/tunnel")
 	tlsCert    string // TLS certificate file path
 	tlsKey     string // TLS key file path
+	tlsConfig  *tls.Config
+	shaping    framing.Shaping
+	keepalive  framing.Keepalive
+	maxStreams int
 	upgrader   websocket.Upgrader
 	server     *http.Server
 	mu         sync.Mutex
 	running    bool
+
+	sessionsMu    sync.Mutex
+	sessions      map[string]*session // Keyed by local UDP address
+	detached      map[string]*session // Dropped sessions awaiting resume, keyed by resume token
+	resumeTimeout time.Duration       // 0 if sessions can't be resumed
+	active        sync.WaitGroup      // Connection handlers still forwarding
+
+	authenticate Authenticator // Optional; nil accepts every connection
+	fallback     http.Handler  // Optional decoy for requests that yield no tunnel
+
+	requiredHeaders map[string]string // Keyed by canonical header name
+	trustedProxies  []*net.IPNet      // Reverse proxies whose X-Forwarded-For is honoured
+
+	stats struct {
+		upgradesAccepted     atomic.Uint64
+		upgradesUnauthorized atomic.Uint64
+		upgradesForbidden    atomic.Uint64
+		upgradesFailed       atomic.Uint64
+		bytesFromClients     atomic.Uint64
+		bytesToClients       atomic.Uint64
+	}
+}
+
+// Stats are cumulative counters of a Server since it was created
+type Stats struct {
+	UpgradesAccepted     uint64 // WebSocket connections established
+	UpgradesUnauthorized uint64 // Refused for missing or invalid credentials
+	UpgradesForbidden    uint64 // Refused by the authenticator (e.g. quota exceeded)
+	UpgradesFailed       uint64 // Failed upgrades or UDP dials
+	BytesFromClients     uint64 // WebSocket -> UDP
+	BytesToClients       uint64 // UDP -> WebSocket
+}
+
+// ErrUnauthorized is returned by an Authenticator when the request carries no
+// valid credentials; the upgrade is refused with 401 instead of 403
+var ErrUnauthorized = errors.New("authentication required")
+
+// Identity is the authenticated user of a tunnel connection
+type Identity struct {
+	UserID    uint   // 0 for anonymous connections
+	Username  string // Username of UserID
+	RateLimit int64  // Bytes per second in each direction (0 = unlimited)
+}
+
+// Authenticator checks a WebSocket upgrade request before it is accepted.
+// Returning an error refuses the connection with the error text.
+type Authenticator func(r *http.Request) (Identity, error)
+
+// Session describes an active tunnel connection
+type Session struct {
+	RemoteAddr  string    `json:"remote_addr"`        // Client address (from X-Forwarded-For behind a trusted proxy)
+	LocalAddr   string    `json:"local_addr"`         // Local UDP address; WireGuard sees it as the peer endpoint
+	ConnectedAt time.Time `json:"connected_at"`       // When the WebSocket connection was established
+	UserID      uint      `json:"user_id,omitempty"`  // Authenticated user (0 if anonymous)
+	Username    string    `json:"username,omitempty"` // Username of UserID
+	RateLimit   int64     `json:"rate_limit"`         // Bytes per second in each direction (0 = unlimited)
+	LatencyMs   float64   `json:"latency_ms"`         // Round-trip time of the last keepalive ping (0 until measured)
+	Stream      uint32    `json:"stream,omitempty"`   // Stream ID on a multiplexed connection (0 if not multiplexed)
+	Paths       []Path    `json:"paths,omitempty"`    // Connections of a bonded session, its own first
+}
+
+type session struct {
+	Session
+	conn     *framing.Conn
+	ws       *websocket.Conn
+	stream   *mux.Stream  // nil unless the session is a stream of a multiplexed connection
+	upload   *tokenBucket // WebSocket -> UDP
+	download *tokenBucket // UDP -> WebSocket
+	latency  *latencyHistory
+	path     *bondPath               // Own connection; nil for streams
+	joined   atomic.Pointer[session] // Session this connection was joined to as a path
+
+	pathsMu     sync.Mutex
+	paths       []*bondPath // Own connection first, then those joined to it
+	pathsClosed bool        // The own connection ended
+	scheduler   *bond.Scheduler
+
+	udpMu sync.Mutex
+	udp   *net.UDPConn // Replaced by the socket of the session it resumes
+
+	resumeToken string        // Empty if the session can't be resumed
+	closing     atomic.Bool   // Closed by the server on purpose; not kept for resume
+	takeover    atomic.Bool   // Replaced by a resuming connection; kept for it
+	detachedCh  chan struct{} // Closed when the session is kept for resume
+	detachedAt  time.Time     // When the connection was lost
+	expire      *time.Timer   // Ends the session if it isn't resumed in time
+}
+
+// snapshot returns the session description with the current latency and
+// paths
+func (sess *session) snapshot() Session {
+	info := sess.Session
+	info.LatencyMs = durationMs(sess.conn.RTT())
+
+	sess.pathsMu.Lock()
+	defer sess.pathsMu.Unlock()
+	if len(sess.paths) > 1 {
+		for _, p := range sess.paths {
+			info.Paths = append(info.Paths, p.snapshot())
+		}
+	}
+	return info
+}
+
+// udpConn returns the session's UDP socket
+func (sess *session) udpConn() *net.UDPConn {
+	sess.udpMu.Lock()
+	defer sess.udpMu.Unlock()
+	return sess.udp
 }
 
 // Config holds server configuration
@@ -42,6 +173,39 @@ type Config struct {
 	PathPrefix string // Path prefix for WebSocket upgrade (default: "/")
 	TLSCert    string // TLS certificate file (optional, for WSS)
 	TLSKey     string // TLS key file (optional, for WSS)
+
+	// TLSConfig enables WSS with certificates from GetCertificate, which
+	// allows replacing them without a restart. It takes precedence over
+	// TLSCert and TLSKey.
+	TLSConfig *tls.Config
+
+	// Shaping of the datagrams sent to clients that negotiate the framed
+	// subprotocol. Older clients get one message per datagram.
+	Shaping framing.Shaping
+
+	// Keepalive pings, which keep idle connections open through proxies,
+	// detect dead clients and measure latency
+	Keepalive framing.Keepalive
+
+	// ResumeTimeout is how long the session of a framed connection that
+	// dropped is kept for the client to resume it, keeping its UDP endpoint
+	// towards WireGuard (default: DefaultResumeTimeout; negative disables
+	// resuming)
+	ResumeTimeout time.Duration
+
+	// RequiredHeaders must be present on upgrade requests with these values
+	// (any value where empty), e.g. a secret a CDN adds or clients are
+	// configured with. Other requests are refused, or get the fallback.
+	RequiredHeaders map[string]string
+
+	// MaxStreams limits the streams a multiplexed connection may have open
+	// at once (default: mux.DefaultMaxStreams)
+	MaxStreams int
+
+	// TrustedProxies are the IP addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header gives the client address (default: none).
+	// An invalid entry disables them all.
+	TrustedProxies []string
 }
 
 // NewServer creates a new WebSocket tunnel server
@@ -55,17 +219,59 @@ func NewServer(cfg Config) *Server {
 		pathPrefix = "/" + pathPrefix
 	}
 
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		slog.Warn("ignoring tunnel trusted proxies", "error", err)
+		trustedProxies = nil
+	}
+
+	resumeTimeout := cfg.ResumeTimeout
+	if resumeTimeout == 0 {
+		resumeTimeout = DefaultResumeTimeout
+	} else if resumeTimeout < 0 {
+		resumeTimeout = 0
+	}
+
 	return &Server{
 		listenAddr: cfg.ListenAddr,
 		targetAddr: cfg.TargetAddr,
 		pathPrefix: pathPrefix,
 		tlsCert:    cfg.TLSCert,
 		tlsKey:     cfg.TLSKey,
+		tlsConfig:  cfg.TLSConfig,
+		shaping:    cfg.Shaping,
+		keepalive:  cfg.Keepalive,
+		maxStreams: cfg.MaxStreams,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  DefaultBufferSize,
 			WriteBufferSize: DefaultBufferSize,
 			CheckOrigin:     func(r *http.Request) bool { return true },
+			Subprotocols:    framing.MuxSubprotocols,
 		},
+		sessions:      make(map[string]*session),
+		detached:      make(map[string]*session),
+		resumeTimeout: resumeTimeout,
+
+		requiredHeaders: canonicalHeaders(cfg.RequiredHeaders),
+		trustedProxies:  trustedProxies,
+	}
+}
+
+// SetAuthenticator sets the function that authenticates new connections
+// and assigns their rate limit
+func (s *Server) SetAuthenticator(fn Authenticator) {
+	s.authenticate = fn
+}
+
+// Stats returns the server's cumulative counters
+func (s *Server) Stats() Stats {
+	return Stats{
+		UpgradesAccepted:     s.stats.upgradesAccepted.Load(),
+		UpgradesUnauthorized: s.stats.upgradesUnauthorized.Load(),
+		UpgradesForbidden:    s.stats.upgradesForbidden.Load(),
+		UpgradesFailed:       s.stats.upgradesFailed.Load(),
+		BytesFromClients:     s.stats.bytesFromClients.Load(),
+		BytesToClients:       s.stats.bytesToClients.Load(),
 	}
 }
 
@@ -81,6 +287,9 @@ func (s *Server) Start() error {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc(s.pathPrefix, s.handleWebSocket)
+	if s.fallback != nil && s.pathPrefix != "/" {
+		mux.Handle("/", s.fallback)
+	}
 
 	s.server = &http.Server{
 		Addr:    s.listenAddr,
@@ -88,11 +297,15 @@ func (s *Server) Start() error {
 	}
 
 	var err error
-	if s.tlsCert != "" && s.tlsKey != "" {
-		log.Printf("Starting WSS tunnel server on %s%s -> %s", s.listenAddr, s.pathPrefix, s.targetAddr)
+	if s.tlsConfig != nil {
+		slog.Info("starting WSS tunnel server", "address", s.listenAddr, "path", s.pathPrefix, "target", s.targetAddr)
+		s.server.TLSConfig = s.tlsConfig
+		err = s.server.ListenAndServeTLS("", "")
+	} else if s.tlsCert != "" && s.tlsKey != "" {
+		slog.Info("starting WSS tunnel server", "address", s.listenAddr, "path", s.pathPrefix, "target", s.targetAddr)
 		err = s.server.ListenAndServeTLS(s.tlsCert, s.tlsKey)
 	} else {
-		log.Printf("Starting WS tunnel server on %s%s -> %s", s.listenAddr, s.pathPrefix, s.targetAddr)
+		slog.Info("starting WS tunnel server", "address", s.listenAddr, "path", s.pathPrefix, "target", s.targetAddr)
 		err = s.server.ListenAndServe()
 	}
 
@@ -120,19 +333,103 @@ func (s *Server) StartAsync() error {
 	}
 }
 
-// Stop stops the server
-func (s *Server) Stop() error {
+// Handler returns the WebSocket upgrade handler for serving the tunnel on
+// another HTTP server (e.g. at a path of the API server) instead of its own
+// listener. The server counts as running from then on; Shutdown closes the
+// tunnel connections and leaves the hosting server alone.
+func (s *Server) Handler() http.Handler {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.running = true
+	s.mu.Unlock()
+
+	return http.HandlerFunc(s.handleWebSocket)
+}
+
+// Stop stops the server, giving connections 5 seconds to close
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
 
+// Shutdown stops accepting connections and sends every tunnel connection a
+// close frame, then waits until they have ended. Connections still open when
+// ctx is done are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
 	if !s.running {
+		s.mu.Unlock()
 		return nil
 	}
-
 	s.running = false
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return s.server.Shutdown(ctx)
+	server := s.server
+	s.mu.Unlock()
+
+	// Hijacked WebSocket connections are not tracked by http.Server, so this
+	// only closes the listener and idle connections
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	// The streams of a multiplexed connection share one close frame
+	conns := make(map[*framing.Conn]bool)
+	for _, sess := range s.activeSessions() {
+		sess.closing.Store(true)
+		if !conns[sess.conn] {
+			conns[sess.conn] = true
+			sess.conn.SendClose(framing.CloseShutdown, "server shutting down", deadline)
+		}
+	}
+	if len(conns) > 0 {
+		slog.Info("waiting for tunnel connections to close", "connections", len(conns))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		for _, sess := range s.activeSessions() {
+			sess.ws.Close()
+			sess.udpConn().Close()
+		}
+		<-done
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	// Handlers no longer detach sessions once the server stopped running
+	s.sessionsMu.Lock()
+	detached := s.detached
+	s.detached = make(map[string]*session)
+	s.sessionsMu.Unlock()
+	for _, sess := range detached {
+		sess.expire.Stop()
+		sess.udpConn().Close()
+	}
+	return err
+}
+
+// activeSessions returns a snapshot of the open connections
+func (s *Server) activeSessions() []*session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
 }
 
 // IsRunning returns whether the server is running
@@ -144,81 +441,572 @@ func (s *Server) IsRunning() bool {
 
 // handleWebSocket handles incoming WebSocket connections
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	// A mounted handler keeps being reachable until its host server stops
+	if !s.IsRunning() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.fallback != nil && !websocket.IsWebSocketUpgrade(r) {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	if name, ok := s.checkHeaders(r); !ok {
+		s.stats.upgradesForbidden.Add(1)
+		slog.Warn("tunnel connection refused", "remote_addr", s.clientAddr(r), "error", "missing or wrong header "+name)
+		if s.fallback != nil {
+			s.fallback.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var identity Identity
+	if s.authenticate != nil {
+		var err error
+		identity, err = s.authenticate(r)
+		if err != nil {
+			status := http.StatusForbidden
+			if errors.Is(err, ErrUnauthorized) {
+				status = http.StatusUnauthorized
+				s.stats.upgradesUnauthorized.Add(1)
+			} else {
+				s.stats.upgradesForbidden.Add(1)
+			}
+			slog.Warn("tunnel connection refused", "remote_addr", s.clientAddr(r), "error", err)
+			// Don't tell probes that there is a tunnel here
+			if s.fallback != nil {
+				s.fallback.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	s.active.Add(1)
+	defer s.active.Done()
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		s.stats.upgradesFailed.Add(1)
+		slog.Warn("WebSocket upgrade failed", "remote_addr", s.clientAddr(r), "error", err)
 		return
 	}
+	conn := framing.NewConn(ws, s.shaping)
 	defer conn.Close()
 
-	// Connect to UDP target
-	udpAddr, err := net.ResolveUDPAddr("udp", s.targetAddr)
+	if conn.Multiplexed() {
+		s.serveMux(r, identity, conn, ws)
+		return
+	}
+
+	udpConn, err := s.dialTarget()
 	if err != nil {
-		log.Printf("Failed to resolve UDP address %s: %v", s.targetAddr, err)
 		return
 	}
 
+	s.stats.upgradesAccepted.Add(1)
+
+	// The local UDP address is the peer endpoint WireGuard sees for this connection
+	logger := slog.With("remote_addr", s.clientAddr(r), "endpoint", udpConn.LocalAddr().String())
+	if identity.Username != "" {
+		logger = logger.With("user", identity.Username, "user_id", identity.UserID)
+	}
+	logger.Info("tunnel connection opened", "framed", conn.Framed())
+
+	sess := s.newSession(r, identity, conn, ws, udpConn)
+	sess.upload = newTokenBucket(identity.RateLimit)
+	sess.download = newTokenBucket(identity.RateLimit)
+	sess.latency = &latencyHistory{}
+	sess.path = &bondPath{conn: conn, remoteAddr: sess.RemoteAddr, connectedAt: sess.ConnectedAt}
+	sess.paths = []*bondPath{sess.path}
+	if conn.Framed() && s.resumeTimeout > 0 {
+		sess.resumeToken = newResumeToken()
+	}
+	s.addSession(sess)
+
+	if sess.resumeToken != "" {
+		conn.SetControlHandler(func(typ byte, payload []byte) {
+			switch typ {
+			case framing.ControlResume:
+				s.resume(logger, sess, string(payload))
+			case framing.ControlJoin:
+				s.join(logger, sess, string(payload))
+			}
+		})
+		if err := conn.SendControl(framing.ControlResume, []byte(sess.resumeToken)); err != nil {
+			logger.Debug("failed to send resume token", "error", err)
+		}
+	}
+
+	conn.SetRTTHandler(sess.latency.add)
+	conn.StartKeepalive(s.keepalive)
+
+	dropped := s.forward(logger, sess, conn)
+	if target := sess.joined.Load(); target != nil {
+		target.removePath(sess.path)
+		logger.Info("tunnel path closed", "joined_endpoint", target.LocalAddr, "duration", time.Since(sess.ConnectedAt).Round(time.Second))
+		return
+	}
+	sess.closePaths()
+	if (dropped || sess.takeover.Load()) && s.detach(sess) {
+		logger.Info("tunnel connection lost, keeping session for resume", "timeout", s.resumeTimeout)
+		return
+	}
+	s.removeSession(sess)
+	sess.udpConn().Close()
+	logger.Info("tunnel connection closed", "duration", time.Since(sess.ConnectedAt).Round(time.Second))
+}
+
+// dialTarget opens a UDP socket to the target; its local address is the peer
+// endpoint WireGuard sees
+func (s *Server) dialTarget() (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", s.targetAddr)
+	if err != nil {
+		s.stats.upgradesFailed.Add(1)
+		slog.Error("failed to resolve UDP address", "target", s.targetAddr, "error", err)
+		return nil, err
+	}
+
 	udpConn, err := net.DialUDP("udp", nil, udpAddr)
 	if err != nil {
-		log.Printf("Failed to connect to UDP %s: %v", s.targetAddr, err)
-		return
+		s.stats.upgradesFailed.Add(1)
+		slog.Error("failed to connect to UDP target", "target", s.targetAddr, "error", err)
+		return nil, err
+	}
+	return udpConn, nil
+}
+
+// newSession returns the session of a connection, or of a stream of one, that
+// forwards to udpConn. The caller sets the rate limiters and latency history.
+func (s *Server) newSession(r *http.Request, identity Identity, conn *framing.Conn, ws *websocket.Conn, udpConn *net.UDPConn) *session {
+	return &session{
+		Session: Session{
+			RemoteAddr:  s.clientAddr(r),
+			LocalAddr:   udpConn.LocalAddr().String(),
+			ConnectedAt: time.Now(),
+			UserID:      identity.UserID,
+			Username:    identity.Username,
+			RateLimit:   identity.RateLimit,
+		},
+		conn:       conn,
+		ws:         ws,
+		udp:        udpConn,
+		detachedCh: make(chan struct{}),
 	}
-	defer udpConn.Close()
+}
 
-	log.Printf("New tunnel connection from %s", r.RemoteAddr)
+// datagramConn is a connection, or a stream of a multiplexed one, carrying
+// the datagrams of a session
+type datagramConn interface {
+	ReadDatagram() ([]byte, error)
+	WriteDatagram(p []byte) error
+	Close() error
+}
 
-	// Bidirectional forwarding
+// forward forwards datagrams both ways between dc and the session's UDP
+// socket until either direction ends, then closes dc. It reports whether the
+// connection was lost rather than closed.
+func (s *Server) forward(logger *slog.Logger, sess *session, dc datagramConn) bool {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	// Unblock the other direction as soon as one ends. The UDP socket is
+	// woken instead of closed, as a dropped session keeps it.
+	go func() {
+		<-ctx.Done()
+		sess.udpConn().SetReadDeadline(time.Now())
+		dc.Close()
+	}()
+
 	// WebSocket -> UDP
+	var dropped bool
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		s.wsToUDP(ctx, conn, udpConn)
+		err := s.wsToUDP(ctx, logger, dc, sess)
+		dropped = ctx.Err() == nil && connectionLost(err)
 	}()
 
 	// UDP -> WebSocket
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		s.udpToWS(ctx, udpConn, conn)
+		s.udpToWS(ctx, logger, sess, dc)
 	}()
 
 	wg.Wait()
-	log.Printf("Tunnel connection closed from %s", r.RemoteAddr)
+	return dropped
+}
+
+// newResumeToken returns a random session resume token
+func newResumeToken() string {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// connectionLost reports whether a WebSocket read error means that the
+// connection was lost rather than closed by the client
+func connectionLost(err error) bool {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code == websocket.CloseAbnormalClosure
+	}
+	var reason *framing.CloseError
+	return err != nil && !errors.As(err, &reason)
+}
+
+// detach keeps the UDP socket of a lost connection's session for a resuming
+// connection, until the resume timeout expires. It returns false if the
+// session can't be resumed.
+func (s *Server) detach(sess *session) bool {
+	if sess.resumeToken == "" || sess.closing.Load() || !s.IsRunning() {
+		return false
+	}
+
+	s.sessionsMu.Lock()
+	if s.sessions[sess.LocalAddr] == sess {
+		delete(s.sessions, sess.LocalAddr)
+	}
+	s.detached[sess.resumeToken] = sess
+	sess.detachedAt = time.Now()
+	sess.expire = time.AfterFunc(s.resumeTimeout, func() { s.expireSession(sess) })
+	s.sessionsMu.Unlock()
+
+	close(sess.detachedCh)
+	return true
+}
+
+// expireSession ends a detached session that wasn't resumed in time
+func (s *Server) expireSession(sess *session) {
+	s.sessionsMu.Lock()
+	if s.detached[sess.resumeToken] != sess {
+		// Resumed or closed meanwhile
+		s.sessionsMu.Unlock()
+		return
+	}
+	delete(s.detached, sess.resumeToken)
+	s.sessionsMu.Unlock()
+
+	sess.udpConn().Close()
+	slog.Info("tunnel session expired", "endpoint", sess.LocalAddr, "user", sess.Username,
+		"duration", time.Since(sess.ConnectedAt).Round(time.Second))
+}
+
+// resume continues the session with the given token on the connection of
+// sess: sess takes over its UDP socket, so WireGuard keeps seeing the same
+// peer endpoint. A session whose connection hasn't noticed yet that it was
+// lost (e.g. after the client changed networks) is closed first.
+func (s *Server) resume(logger *slog.Logger, sess *session, token string) {
+	s.sessionsMu.Lock()
+	old := s.detached[token]
+	var live *session
+	if old == nil {
+		for _, other := range s.sessions {
+			if other != sess && other.resumeToken == token {
+				live = other
+				break
+			}
+		}
+	}
+	s.sessionsMu.Unlock()
+
+	if live != nil {
+		if live.UserID != sess.UserID {
+			logger.Warn("refused to resume the tunnel session of another user", "session_user_id", live.UserID)
+			return
+		}
+		live.takeover.Store(true)
+		live.ws.Close()
+		select {
+		case <-live.detachedCh:
+		case <-time.After(takeoverTimeout):
+			logger.Warn("tunnel session to resume did not end in time", "endpoint", live.LocalAddr)
+			return
+		}
+	}
+
+	s.sessionsMu.Lock()
+	old = s.detached[token]
+	if old == nil {
+		s.sessionsMu.Unlock()
+		logger.Info("tunnel session to resume not found; continuing as a new session")
+		return
+	}
+	if old.UserID != sess.UserID {
+		s.sessionsMu.Unlock()
+		logger.Warn("refused to resume the tunnel session of another user", "session_user_id", old.UserID)
+		return
+	}
+	delete(s.detached, token)
+	old.expire.Stop()
+	sess.latency.prepend(old.latency)
+
+	delete(s.sessions, sess.LocalAddr)
+	sess.LocalAddr = old.LocalAddr
+	s.sessions[sess.LocalAddr] = sess
+	s.sessionsMu.Unlock()
+
+	sess.udpMu.Lock()
+	fresh := sess.udp
+	sess.udp = old.udpConn()
+	sess.udpMu.Unlock()
+	// Wakes udpToWS, which continues with the resumed socket
+	fresh.Close()
+
+	logger.Info("tunnel session resumed", "resumed_endpoint", sess.LocalAddr, "lost_for", time.Since(old.detachedAt).Round(time.Millisecond))
+}
+
+// Sessions returns the active tunnel connections
+func (s *Server) Sessions() []Session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess.snapshot())
+	}
+	return sessions
+}
+
+// SessionByEndpoint returns the tunnel connection whose local UDP address is
+// endpoint (a WireGuard peer endpoint)
+func (s *Server) SessionByEndpoint(endpoint string) (Session, bool) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sess, ok := s.sessions[endpoint]
+	if !ok {
+		return Session{}, false
+	}
+	return sess.snapshot(), true
+}
+
+// LatencyHistory returns the latency samples of the tunnel connection whose
+// local UDP address is endpoint, oldest first
+func (s *Server) LatencyHistory(endpoint string) ([]LatencySample, bool) {
+	s.sessionsMu.Lock()
+	sess, ok := s.sessions[endpoint]
+	s.sessionsMu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return sess.latency.samples(), true
 }
 
-// wsToUDP forwards data from WebSocket to UDP
-func (s *Server) wsToUDP(ctx context.Context, ws *websocket.Conn, udp *net.UDPConn) {
+// CloseSession closes the tunnel connection whose local UDP address is
+// endpoint, telling clients of the framed subprotocol the reason. A session
+// kept for resume is ended. It returns false if there is no such connection.
+func (s *Server) CloseSession(endpoint string, reason framing.CloseReason, text string) bool {
+	s.sessionsMu.Lock()
+	sess, ok := s.sessions[endpoint]
+	if !ok {
+		for token, detached := range s.detached {
+			if detached.LocalAddr == endpoint {
+				delete(s.detached, token)
+				detached.expire.Stop()
+				s.sessionsMu.Unlock()
+
+				slog.Info("ending tunnel session kept for resume", "endpoint", endpoint, "user", detached.Username, "reason", reason)
+				detached.udpConn().Close()
+				return true
+			}
+		}
+	}
+	s.sessionsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	slog.Info("closing tunnel connection", "remote_addr", sess.RemoteAddr, "endpoint", endpoint, "user", sess.Username, "reason", reason)
+	sess.closing.Store(true)
+	if sess.stream != nil {
+		// Only this stream, which ends its forwarding; the connection
+		// carries others
+		sess.stream.CloseWithReason(reason, text)
+		return true
+	}
+	sess.conn.SendClose(reason, text, time.Now().Add(time.Second))
+	sess.ws.Close()
+	sess.udpConn().Close()
+	return true
+}
+
+// Notify sends a notice to the clients of the open tunnel connections, or
+// only to those of userID if it isn't 0. Clients of the v1 format can't
+// receive notices. It returns the number of connections notified.
+func (s *Server) Notify(userID uint, text string) int {
+	notified := 0
+	conns := make(map[*framing.Conn]bool)
+	for _, sess := range s.activeSessions() {
+		if (userID != 0 && sess.UserID != userID) || conns[sess.conn] {
+			continue
+		}
+		conns[sess.conn] = true
+		if err := sess.conn.SendNotice(text); err == nil {
+			notified++
+		}
+	}
+	return notified
+}
+
+func (s *Server) addSession(sess *session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.sessions[sess.LocalAddr] = sess
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if s.sessions[sess.LocalAddr] == sess {
+		delete(s.sessions, sess.LocalAddr)
+	}
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header, or of
+// the token query parameter for clients that cannot set headers
+func BearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// canonicalHeaders returns headers keyed by canonical header name
+func canonicalHeaders(headers map[string]string) map[string]string {
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical
+}
+
+// checkHeaders checks the required headers of an upgrade request. It returns
+// the name of a header that is missing or has the wrong value.
+func (s *Server) checkHeaders(r *http.Request) (string, bool) {
+	for name, want := range s.requiredHeaders {
+		values, ok := r.Header[name]
+		if !ok {
+			return name, false
+		}
+		if want != "" && (len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(want)) != 1) {
+			return name, false
+		}
+	}
+	return "", true
+}
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of reverse
+// proxies
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR range %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR range %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether ip is a trusted reverse proxy
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the client address of r. Behind trusted reverse proxies
+// it is the last X-Forwarded-For entry that isn't one of them; other clients
+// can't forge it.
+func (s *Server) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !s.trustedProxy(net.ParseIP(host)) {
+		return r.RemoteAddr
+	}
+
+	entries := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			break
+		}
+		if i == 0 || !s.trustedProxy(ip) {
+			return entry
+		}
+	}
+	return r.RemoteAddr
+}
+
+// wsToUDP forwards data from WebSocket to the session's UDP socket,
+// throttled by its upload limit. It returns the WebSocket read error that
+// ended forwarding, if any.
+func (s *Server) wsToUDP(ctx context.Context, logger *slog.Logger, ws datagramConn, sess *session) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
 
-		_, data, err := ws.ReadMessage()
+		data, err := ws.ReadDatagram()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket read error: %v", err)
+				logger.Debug("WebSocket read error", "error", err)
 			}
-			return
+			return err
+		}
+
+		// A joined connection feeds the session it was joined to
+		dst := sess
+		if target := sess.joined.Load(); target != nil {
+			dst = target
 		}
 
-		_, err = udp.Write(data)
+		if dst.upload.wait(ctx, len(data)) != nil {
+			return nil
+		}
+
+		_, err = dst.udpConn().Write(data)
 		if err != nil {
-			log.Printf("UDP write error: %v", err)
-			return
+			logger.Warn("UDP write error", "error", err)
+			return nil
+		}
+		s.stats.bytesFromClients.Add(uint64(len(data)))
+		if sess.path != nil {
+			sess.path.bytesFromClient.Add(uint64(len(data)))
 		}
 	}
 }
 
-// udpToWS forwards data from UDP to WebSocket
-func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws *websocket.Conn) {
+// udpToWS forwards data from the session's UDP socket to WebSocket,
+// throttled by its download limit
+func (s *Server) udpToWS(ctx context.Context, logger *slog.Logger, sess *session, ws datagramConn) {
 	buf := make([]byte, DefaultBufferSize)
 	for {
 		select {
@@ -227,20 +1015,56 @@ func (s *Server) udpToWS(ctx context.Context, udp *net.UDPConn, ws *websocket.Co
 		default:
 		}
 
+		udp := sess.udpConn()
 		udp.SetReadDeadline(time.Now().Add(DefaultTimeout))
+		// Checked after setting the deadline, which would otherwise override
+		// the wake-up deadline set when forwarding ends
+		if ctx.Err() != nil {
+			return
+		}
 		n, err := udp.Read(buf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			log.Printf("UDP read error: %v", err)
+			if ctx.Err() != nil {
+				return
+			}
+			if sess.udpConn() != udp {
+				// Replaced by the socket of a resumed session
+				continue
+			}
+			if sess.joined.Load() != nil {
+				// Joined to another session, which sends on this connection
+				<-ctx.Done()
+				return
+			}
+			logger.Warn("UDP read error", "error", err)
+			return
+		}
+
+		if sess.download.wait(ctx, n) != nil {
 			return
 		}
 
-		err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+		out, p := sess.pickPath(ws)
+		err = out.WriteDatagram(buf[:n])
+		if err != nil && out != ws {
+			// The joined connection failed; it leaves the paths when its
+			// handler notices
+			out, p = ws, sess.path
+			err = ws.WriteDatagram(buf[:n])
+		}
+		if errors.Is(err, mux.ErrWindowFull) {
+			continue // The client's reader is behind; dropped like on a congested path
+		}
 		if err != nil {
-			log.Printf("WebSocket write error: %v", err)
+			logger.Debug("WebSocket write error", "error", err)
 			return
 		}
+		s.stats.bytesToClients.Add(uint64(n))
+		if p != nil {
+			p.bytesToClient.Add(uint64(n))
+		}
 	}
 }
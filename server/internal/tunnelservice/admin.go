@@ -3,6 +3,7 @@ package tunnelservice
 import (
 	"net/http"
 	"strconv"
+	"wire-socket-server/internal/audit"
 	"wire-socket-server/internal/database"
 	"wire-socket-server/internal/nat"
 	"wire-socket-server/internal/route"
@@ -89,6 +90,8 @@ func (h *AdminHandler) CreateRoute(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.create", "route", dbRoute.ID, nil, dbRoute)
+
 	c.JSON(http.StatusCreated, gin.H{"route": dbRoute})
 }
 
@@ -105,6 +108,7 @@ func (h *AdminHandler) UpdateRoute(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 		return
 	}
+	before := dbRoute
 
 	var req CreateRouteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -135,6 +139,8 @@ func (h *AdminHandler) UpdateRoute(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.update", "route", dbRoute.ID, before, dbRoute)
+
 	c.JSON(http.StatusOK, gin.H{"route": dbRoute})
 }
 
@@ -146,11 +152,16 @@ func (h *AdminHandler) DeleteRoute(c *gin.Context) {
 		return
 	}
 
+	var before database.TunnelRoute
+	h.db.First(&before, id)
+
 	if err := h.db.Delete(&database.TunnelRoute{}, id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete route"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "route.delete", "route", id, before, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "route deleted"})
 }
 
@@ -194,6 +205,8 @@ func (h *AdminHandler) ApplyRoutes(c *gin.Context) {
 
 	h.routeManager = newManager
 
+	audit.Log(c, h.db.DB, "route.apply", "route", nil, nil, gin.H{"routes_count": len(routes)})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Routes applied", "routes_count": len(routes)})
 }
 
@@ -223,6 +236,8 @@ func (h *AdminHandler) CreateNATRule(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.create", "nat_rule", rule.ID, nil, rule)
+
 	c.JSON(http.StatusCreated, gin.H{"nat_rule": rule})
 }
 
@@ -239,6 +254,7 @@ func (h *AdminHandler) UpdateNATRule(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
 		return
 	}
+	before := rule
 
 	if err := c.ShouldBindJSON(&rule); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -250,6 +266,8 @@ func (h *AdminHandler) UpdateNATRule(c *gin.Context) {
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.update", "nat_rule", rule.ID, before, rule)
+
 	c.JSON(http.StatusOK, gin.H{"nat_rule": rule})
 }
 
@@ -261,11 +279,16 @@ func (h *AdminHandler) DeleteNATRule(c *gin.Context) {
 		return
 	}
 
+	var before database.TunnelNATRule
+	h.db.First(&before, id)
+
 	if err := h.db.Delete(&database.TunnelNATRule{}, id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete rule"})
 		return
 	}
 
+	audit.Log(c, h.db.DB, "nat.delete", "nat_rule", id, before, nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "rule deleted"})
 }
 
@@ -320,6 +343,8 @@ func (h *AdminHandler) ApplyNATRules(c *gin.Context) {
 
 	*h.natManager = *newManager
 
+	audit.Log(c, h.db.DB, "nat.apply", "nat_rule", nil, nil, gin.H{"rules_count": len(rules)})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "NAT rules applied",
 		"masquerade": len(config.Masquerade),
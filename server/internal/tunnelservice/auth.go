@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
 	"wire-socket-server/internal/wireguard"
 
@@ -71,6 +73,10 @@ type VerifyRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	TunnelID string `json:"tunnel_id"`
+
+	// End-user details for brute-force protection in the auth service
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
 }
 
 // VerifyResponse from auth service
@@ -80,6 +86,7 @@ type VerifyResponse struct {
 	Username       string   `json:"username"`
 	AllowedTunnels []string `json:"allowed_tunnels"`
 	Error          string   `json:"error"`
+	RetryAfter     int      `json:"retry_after"`
 }
 
 // Login handles POST /api/auth/login
@@ -91,12 +98,19 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Verify with auth service
-	verifyResp, err := h.verifyWithAuth(req.Username, req.Password)
+	verifyResp, status, err := h.verifyWithAuth(req.Username, req.Password, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "auth service unavailable"})
 		return
 	}
 
+	// Pass through rate limiting and lockout from the auth service
+	if status == http.StatusTooManyRequests || status == http.StatusLocked {
+		c.Header("Retry-After", strconv.Itoa(verifyResp.RetryAfter))
+		c.JSON(status, gin.H{"error": verifyResp.Error, "retry_after": verifyResp.RetryAfter})
+		return
+	}
+
 	if !verifyResp.Valid {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": verifyResp.Error})
 		return
@@ -142,22 +156,25 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
-// verifyWithAuth calls auth service to verify user
-func (h *AuthHandler) verifyWithAuth(username, password string) (*VerifyResponse, error) {
+// verifyWithAuth calls auth service to verify user.
+// It also returns the auth service's HTTP status so throttling can be passed on.
+func (h *AuthHandler) verifyWithAuth(username, password, clientIP, userAgent string) (*VerifyResponse, int, error) {
 	reqBody := VerifyRequest{
-		Username: username,
-		Password: password,
-		TunnelID: h.tunnelID,
+		Username:  username,
+		Password:  password,
+		TunnelID:  h.tunnelID,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	req, err := http.NewRequest("POST", h.authURL+"/api/tunnel/verify", bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -166,16 +183,16 @@ func (h *AuthHandler) verifyWithAuth(username, password string) (*VerifyResponse
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	var verifyResp VerifyResponse
 	if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return &verifyResp, nil
+	return &verifyResp, resp.StatusCode, nil
 }
 
 // RegisterWithAuth registers this tunnel with auth service
@@ -239,6 +256,8 @@ func (h *AuthHandler) AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Skip if no JWT secret configured (backwards compatibility)
 		if h.jwtSecret == "" {
+			c.Set("role", auth.RoleSuperadmin)
+			c.Set("username", "anonymous")
 			c.Next()
 			return
 		}
@@ -281,12 +300,21 @@ func (h *AuthHandler) AdminAuthMiddleware() gin.HandlerFunc {
 		}
 
 		isAdmin, _ := claims["is_admin"].(bool)
-		if !isAdmin {
+		role, _ := claims["role"].(string)
+		role = auth.EffectiveRole(isAdmin, role)
+		if !auth.ValidRole(role) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
 		}
 
+		c.Set("role", role)
+		if userID, ok := claims["user_id"].(float64); ok {
+			c.Set("user_id", uint(userID))
+		}
+		if username, ok := claims["username"].(string); ok {
+			c.Set("username", username)
+		}
 		c.Next()
 	}
 }
@@ -1,7 +1,7 @@
 package tunnelservice
 
 import (
-	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 	"wire-socket-server/internal/database"
@@ -56,14 +56,14 @@ func NewPeerCleanup(db *database.TunnelDB, wgManager *wireguard.Manager, config
 func (c *PeerCleanup) Start() {
 	c.wg.Add(1)
 	go c.run()
-	fmt.Printf("Peer cleanup started (timeout: %v, interval: %v)\n", c.timeout, c.interval)
+	slog.Info("peer cleanup started", "timeout", c.timeout, "interval", c.interval)
 }
 
 // Stop stops the cleanup process
 func (c *PeerCleanup) Stop() {
 	close(c.stopCh)
 	c.wg.Wait()
-	fmt.Println("Peer cleanup stopped")
+	slog.Info("peer cleanup stopped")
 }
 
 // run is the main cleanup loop
@@ -87,7 +87,7 @@ func (c *PeerCleanup) run() {
 func (c *PeerCleanup) cleanup() {
 	stats, err := c.wgManager.GetPeerStats()
 	if err != nil {
-		fmt.Printf("Cleanup: failed to get peer stats: %v\n", err)
+		slog.Warn("peer cleanup: failed to get peer stats", "error", err)
 		return
 	}
 
@@ -101,18 +101,18 @@ func (c *PeerCleanup) cleanup() {
 		// Check if peer is inactive
 		inactive := now.Sub(peer.LastHandshake)
 		if inactive > c.timeout {
-			fmt.Printf("Cleanup: removing inactive peer %s (last handshake: %v ago)\n",
-				peer.PublicKey[:8]+"...", inactive.Round(time.Second))
+			slog.Info("peer cleanup: removing inactive peer", "peer", peer.PublicKey,
+				"endpoint", peer.Endpoint, "inactive", inactive.Round(time.Second))
 
 			// Remove from WireGuard
 			if err := c.wgManager.RemovePeer(peer.PublicKey); err != nil {
-				fmt.Printf("Cleanup: failed to remove peer %s: %v\n", peer.PublicKey[:8]+"...", err)
+				slog.Warn("peer cleanup: failed to remove peer", "peer", peer.PublicKey, "error", err)
 				continue
 			}
 
 			// Mark as disconnected in database
 			if err := c.db.MarkPeerDisconnected(peer.PublicKey); err != nil {
-				fmt.Printf("Cleanup: failed to update DB for peer %s: %v\n", peer.PublicKey[:8]+"...", err)
+				slog.Warn("peer cleanup: failed to mark peer disconnected", "peer", peer.PublicKey, "error", err)
 			}
 		}
 	}
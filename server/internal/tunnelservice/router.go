@@ -1,7 +1,10 @@
 package tunnelservice
 
 import (
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/auth"
 	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/metrics"
 	"wire-socket-server/internal/nat"
 	"wire-socket-server/internal/wireguard"
 
@@ -13,6 +16,9 @@ type Router struct {
 	db           *database.TunnelDB
 	authHandler  *AuthHandler
 	adminHandler *AdminHandler
+	wgManager    *wireguard.Manager
+	subnet       string
+	metrics      *metrics.Metrics
 }
 
 // NewRouter creates a new Router
@@ -21,23 +27,44 @@ func NewRouter(db *database.TunnelDB, wgManager *wireguard.Manager, natManager *
 		db:           db,
 		authHandler:  NewAuthHandler(db, wgManager, authConfig),
 		adminHandler: NewAdminHandler(db, natManager, defaultDevice),
+		wgManager:    wgManager,
+		subnet:       authConfig.Subnet,
 	}
 }
 
-// SetupRoutes configures all routes
+// SetMetrics enables /metrics, login instrumentation and peer and IP pool
+// gauges. Call before SetupRoutes.
+func (r *Router) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+	m.WatchPeers(r.wgManager)
+	m.WatchIPPool(r.subnet, func() (int64, error) {
+		var n int64
+		err := r.db.Model(&database.TunnelAllocatedIP{}).Count(&n).Error
+		return n, err
+	})
+}
+
+// SetupRoutes configures all routes. The engine trusts no reverse proxy, so
+// login throttling uses the connection's address rather than a
+// client-supplied X-Forwarded-For header.
 func (r *Router) SetupRoutes(engine *gin.Engine) {
+	engine.SetTrustedProxies(nil)
+
 	// Health check
 	engine.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Prometheus metrics
+	r.metrics.Mount(engine)
+
 	api := engine.Group("/api")
 	{
 		// Auth endpoints (for clients)
-		auth := api.Group("/auth")
+		authRoutes := api.Group("/auth")
 		{
-			auth.POST("/login", r.authHandler.Login)
-			auth.POST("/change-password", r.authHandler.ChangePassword)
+			authRoutes.POST("/login", r.metrics.LoginMiddleware(), r.authHandler.Login)
+			authRoutes.POST("/change-password", r.authHandler.ChangePassword)
 		}
 
 		// Config endpoint
@@ -46,20 +73,24 @@ func (r *Router) SetupRoutes(engine *gin.Engine) {
 		// Admin endpoints (require JWT auth if configured)
 		admin := api.Group("/admin")
 		admin.Use(r.authHandler.AdminAuthMiddleware())
+		perm := auth.RequirePermission
 		{
 			// Route management
-			admin.GET("/routes", r.adminHandler.ListRoutes)
-			admin.POST("/routes", r.adminHandler.CreateRoute)
-			admin.PUT("/routes/:id", r.adminHandler.UpdateRoute)
-			admin.DELETE("/routes/:id", r.adminHandler.DeleteRoute)
-			admin.POST("/routes/apply", r.adminHandler.ApplyRoutes)
+			admin.GET("/routes", perm(auth.ScopeRoutesRead), r.adminHandler.ListRoutes)
+			admin.POST("/routes", perm(auth.ScopeRoutesWrite), r.adminHandler.CreateRoute)
+			admin.PUT("/routes/:id", perm(auth.ScopeRoutesWrite), r.adminHandler.UpdateRoute)
+			admin.DELETE("/routes/:id", perm(auth.ScopeRoutesWrite), r.adminHandler.DeleteRoute)
+			admin.POST("/routes/apply", perm(auth.ScopeRoutesApply), r.adminHandler.ApplyRoutes)
 
 			// NAT management
-			admin.GET("/nat", r.adminHandler.ListNATRules)
-			admin.POST("/nat", r.adminHandler.CreateNATRule)
-			admin.PUT("/nat/:id", r.adminHandler.UpdateNATRule)
-			admin.DELETE("/nat/:id", r.adminHandler.DeleteNATRule)
-			admin.POST("/nat/apply", r.adminHandler.ApplyNATRules)
+			admin.GET("/nat", perm(auth.ScopeNATRead), r.adminHandler.ListNATRules)
+			admin.POST("/nat", perm(auth.ScopeNATWrite), r.adminHandler.CreateNATRule)
+			admin.PUT("/nat/:id", perm(auth.ScopeNATWrite), r.adminHandler.UpdateNATRule)
+			admin.DELETE("/nat/:id", perm(auth.ScopeNATWrite), r.adminHandler.DeleteNATRule)
+			admin.POST("/nat/apply", perm(auth.ScopeNATApply), r.adminHandler.ApplyNATRules)
+
+			// Audit log
+			admin.GET("/audit", perm(auth.ScopeAuditRead), audit.ListHandler(r.db.DB))
 		}
 	}
 }
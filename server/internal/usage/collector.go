@@ -0,0 +1,259 @@
+// Package usage records per-user traffic history. A collector samples the
+// WireGuard peer counters, attributes the deltas to users and devices and
+// stores hourly and daily aggregates.
+package usage
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/wireguard"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Config controls the collector. Zero values are replaced by defaults.
+type Config struct {
+	Disabled        bool          `yaml:"disabled"`         // Turn off usage accounting
+	Interval        time.Duration `yaml:"interval"`         // How often peer counters are sampled (default: 1m)
+	HourlyRetention time.Duration `yaml:"hourly_retention"` // How long hourly rows are kept (default: 720h = 30 days)
+	DailyRetention  time.Duration `yaml:"daily_retention"`  // How long daily rows are kept (default: 8760h = 1 year)
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = time.Minute
+	}
+	if c.HourlyRetention <= 0 {
+		c.HourlyRetention = 30 * 24 * time.Hour
+	}
+	if c.DailyRetention <= 0 {
+		c.DailyRetention = 365 * 24 * time.Hour
+	}
+	return c
+}
+
+// counters are the cumulative byte counters of a peer at the last sample
+type counters struct {
+	rx, tx int64
+}
+
+// Delta is the traffic of one device since the previous sample
+type Delta struct {
+	UserID       uint
+	AllocationID uint
+	DeviceIP     string
+	RxBytes      int64
+	TxBytes      int64
+}
+
+// Collector periodically samples WireGuard peer counters
+type Collector struct {
+	config    Config
+	db        *database.DB
+	wgManager *wireguard.Manager
+
+	mu        sync.Mutex
+	last      map[string]counters // Keyed by peer public key
+	primed    bool                // Set after the first sample has recorded baselines
+	lastPrune time.Time
+	onSample  []func([]Delta)
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewCollector creates a Collector
+func NewCollector(db *database.DB, wgManager *wireguard.Manager, config Config) *Collector {
+	return &Collector{
+		config:    config.withDefaults(),
+		db:        db,
+		wgManager: wgManager,
+		last:      make(map[string]counters),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+// OnSample registers a function called with the deltas of every sample
+// after they have been stored
+func (c *Collector) OnSample(fn func([]Delta)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSample = append(c.onSample, fn)
+}
+
+// Start begins sampling in the background
+func (c *Collector) Start() {
+	if c.config.Disabled {
+		close(c.doneCh)
+		return
+	}
+
+	go func() {
+		defer close(c.doneCh)
+
+		ticker := time.NewTicker(c.config.Interval)
+		defer ticker.Stop()
+
+		c.Sample()
+		for {
+			select {
+			case <-ticker.C:
+				c.Sample()
+			case <-c.stopCh:
+				// Record traffic since the last tick before exiting
+				c.Sample()
+				return
+			}
+		}
+	}()
+
+	slog.Info("usage accounting started", "interval", c.config.Interval)
+}
+
+// Stop stops sampling and waits for the final sample
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+	<-c.doneCh
+}
+
+// Sample reads the peer counters once and stores the deltas
+func (c *Collector) Sample() {
+	stats, err := c.wgManager.GetPeerStats()
+	if err != nil {
+		slog.Warn("usage sample failed", "error", err)
+		return
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	deltas := c.deltas(stats)
+	callbacks := c.onSample
+	prune := now.Sub(c.lastPrune) >= time.Hour
+	if prune {
+		c.lastPrune = now
+	}
+	c.mu.Unlock()
+
+	if len(deltas) > 0 {
+		if err := c.store(deltas, now); err != nil {
+			slog.Warn("failed to store usage", "error", err)
+		}
+	}
+
+	if prune {
+		c.prune(now)
+	}
+
+	for _, fn := range callbacks {
+		fn(deltas)
+	}
+}
+
+// deltas computes the traffic since the last sample and attributes it to
+// users. Caller must hold mu.
+//
+// The first sample after startup only records baselines, since kernel-mode
+// counters survive a server restart and would otherwise be counted twice.
+// Counters lower than the baseline mean the peer was removed and re-added,
+// so the whole counter is new traffic.
+func (c *Collector) deltas(stats []wireguard.PeerStat) []Delta {
+	current := make(map[string]counters, len(stats))
+	for _, s := range stats {
+		current[s.PublicKey] = counters{rx: s.RxBytes, tx: s.TxBytes}
+	}
+
+	if !c.primed {
+		c.last = current
+		c.primed = true
+		return nil
+	}
+
+	changed := make(map[string]counters)
+	for key, cur := range current {
+		prev, seen := c.last[key]
+		d := counters{rx: cur.rx, tx: cur.tx}
+		if seen && cur.rx >= prev.rx && cur.tx >= prev.tx {
+			d = counters{rx: cur.rx - prev.rx, tx: cur.tx - prev.tx}
+		}
+		if d.rx > 0 || d.tx > 0 {
+			changed[key] = d
+		}
+	}
+	c.last = current
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	var allocations []database.AllocatedIP
+	if err := c.db.Where("public_key IN ?", keys).Find(&allocations).Error; err != nil {
+		slog.Warn("usage lookup failed", "error", err)
+		return nil
+	}
+
+	deltas := make([]Delta, 0, len(allocations))
+	for _, a := range allocations {
+		d := changed[a.PublicKey]
+		deltas = append(deltas, Delta{
+			UserID:       a.UserID,
+			AllocationID: a.ID,
+			DeviceIP:     a.IPAddress,
+			RxBytes:      d.rx,
+			TxBytes:      d.tx,
+		})
+	}
+	return deltas
+}
+
+// store adds deltas to the hourly and daily aggregates
+func (c *Collector) store(deltas []Delta, now time.Time) error {
+	hour := now.UTC().Truncate(time.Hour)
+	day := time.Date(now.UTC().Year(), now.UTC().Month(), now.UTC().Day(), 0, 0, 0, 0, time.UTC)
+
+	return c.db.Transaction(func(tx *gorm.DB) error {
+		for _, d := range deltas {
+			hourly := database.UsageHourly{UserID: d.UserID, AllocationID: d.AllocationID, DeviceIP: d.DeviceIP, Bucket: hour, RxBytes: d.RxBytes, TxBytes: d.TxBytes}
+			if err := tx.Clauses(addOnConflict(d)).Create(&hourly).Error; err != nil {
+				return err
+			}
+			daily := database.UsageDaily{UserID: d.UserID, AllocationID: d.AllocationID, DeviceIP: d.DeviceIP, Bucket: day, RxBytes: d.RxBytes, TxBytes: d.TxBytes}
+			if err := tx.Clauses(addOnConflict(d)).Create(&daily).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addOnConflict adds the delta to an existing bucket row
+func addOnConflict(d Delta) clause.OnConflict {
+	return clause.OnConflict{
+		Columns: []clause.Column{{Name: "bucket"}, {Name: "user_id"}, {Name: "allocation_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"rx_bytes":  gorm.Expr("rx_bytes + ?", d.RxBytes),
+			"tx_bytes":  gorm.Expr("tx_bytes + ?", d.TxBytes),
+			"device_ip": d.DeviceIP,
+		}),
+	}
+}
+
+// prune deletes rows past their retention
+func (c *Collector) prune(now time.Time) {
+	if err := c.db.Where("bucket < ?", now.Add(-c.config.HourlyRetention).UTC()).Delete(&database.UsageHourly{}).Error; err != nil {
+		slog.Warn("failed to prune hourly usage", "error", err)
+	}
+	if err := c.db.Where("bucket < ?", now.Add(-c.config.DailyRetention).UTC()).Delete(&database.UsageDaily{}).Error; err != nil {
+		slog.Warn("failed to prune daily usage", "error", err)
+	}
+}
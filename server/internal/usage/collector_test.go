@@ -0,0 +1,134 @@
+package usage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/wireguard"
+)
+
+// testDB returns a database with users alice (ID 1, devices 1 and 2) and
+// bob (ID 2, device 3)
+func testDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []interface{}{
+		&database.User{ID: 1, Username: "alice", Email: "alice@example.com", PasswordHash: "x"},
+		&database.User{ID: 2, Username: "bob", Email: "bob@example.com", PasswordHash: "x"},
+		&database.AllocatedIP{ID: 1, UserID: 1, ServerID: 1, IPAddress: "10.0.0.2", PublicKey: "alice-laptop"},
+		&database.AllocatedIP{ID: 2, UserID: 1, ServerID: 1, IPAddress: "10.0.0.3", PublicKey: "alice-phone"},
+		&database.AllocatedIP{ID: 3, UserID: 2, ServerID: 1, IPAddress: "10.0.0.4", PublicKey: "bob-laptop"},
+	}
+	for _, record := range records {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func peer(key string, rx, tx int64) wireguard.PeerStat {
+	return wireguard.PeerStat{PublicKey: key, RxBytes: rx, TxBytes: tx}
+}
+
+func TestDeltas(t *testing.T) {
+	c := NewCollector(testDB(t), nil, Config{})
+
+	samples := []struct {
+		name  string
+		stats []wireguard.PeerStat
+		want  []Delta
+	}{
+		{"baseline", []wireguard.PeerStat{peer("alice-laptop", 1000, 2000), peer("bob-laptop", 500, 500)}, nil},
+		{"no traffic", []wireguard.PeerStat{peer("alice-laptop", 1000, 2000), peer("bob-laptop", 500, 500)}, nil},
+		{
+			"traffic",
+			[]wireguard.PeerStat{peer("alice-laptop", 1100, 2300), peer("bob-laptop", 500, 500)},
+			[]Delta{{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 100, TxBytes: 300}},
+		},
+		{
+			"new peer counts in full",
+			[]wireguard.PeerStat{peer("alice-laptop", 1100, 2300), peer("alice-phone", 40, 60), peer("bob-laptop", 500, 500)},
+			[]Delta{{UserID: 1, AllocationID: 2, DeviceIP: "10.0.0.3", RxBytes: 40, TxBytes: 60}},
+		},
+		{
+			"counter reset counts in full",
+			[]wireguard.PeerStat{peer("alice-laptop", 1100, 2300), peer("alice-phone", 40, 60), peer("bob-laptop", 10, 20)},
+			[]Delta{{UserID: 2, AllocationID: 3, DeviceIP: "10.0.0.4", RxBytes: 10, TxBytes: 20}},
+		},
+		{
+			"unknown peer ignored",
+			[]wireguard.PeerStat{peer("alice-laptop", 1100, 2300), peer("alice-phone", 40, 60), peer("bob-laptop", 10, 20), peer("stranger", 5, 5)},
+			nil,
+		},
+	}
+	for _, s := range samples {
+		got := c.deltas(s.stats)
+		if len(got) == 0 && len(s.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: deltas = %+v, want %+v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	db := testDB(t)
+	c := NewCollector(db, nil, Config{})
+
+	day := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	laptop := Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 100, TxBytes: 200}
+	writes := []struct {
+		at     time.Time
+		deltas []Delta
+	}{
+		{day.Add(10*time.Hour + 5*time.Minute), []Delta{laptop}},
+		{day.Add(10*time.Hour + 55*time.Minute), []Delta{laptop}},
+		{day.Add(23*time.Hour + 30*time.Minute), []Delta{laptop, {UserID: 2, AllocationID: 3, DeviceIP: "10.0.0.4", RxBytes: 7, TxBytes: 9}}},
+		{day.Add(25 * time.Hour), []Delta{laptop}},
+	}
+	for _, w := range writes {
+		if err := c.store(w.deltas, w.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var hourly []database.UsageHourly
+	db.Where("user_id = ?", 1).Order("bucket").Find(&hourly)
+	if len(hourly) != 3 || hourly[0].RxBytes != 200 || hourly[0].TxBytes != 400 || !hourly[0].Bucket.Equal(day.Add(10*time.Hour)) {
+		t.Errorf("hourly rows = %+v, want 3 with the first hour summed to 200/400", hourly)
+	}
+
+	var daily []database.UsageDaily
+	db.Where("user_id = ?", 1).Order("bucket").Find(&daily)
+	if len(daily) != 2 || daily[0].RxBytes != 300 || daily[1].RxBytes != 100 || !daily[1].Bucket.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("daily rows = %+v, want 300 on March 31 and 100 on April 1", daily)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db := testDB(t)
+	c := NewCollector(db, nil, Config{HourlyRetention: 48 * time.Hour, DailyRetention: 10 * 24 * time.Hour})
+
+	now := time.Date(2026, 4, 20, 12, 0, 0, 0, time.UTC)
+	d := Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 1, TxBytes: 1}
+	for _, age := range []time.Duration{time.Hour, 72 * time.Hour, 20 * 24 * time.Hour} {
+		if err := c.store([]Delta{d}, now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.prune(now)
+
+	var hourly, daily int64
+	db.Model(&database.UsageHourly{}).Count(&hourly)
+	db.Model(&database.UsageDaily{}).Count(&daily)
+	if hourly != 1 || daily != 2 {
+		t.Errorf("kept %d hourly and %d daily rows, want 1 and 2", hourly, daily)
+	}
+}
@@ -0,0 +1,187 @@
+package usage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Bucket sizes
+const (
+	BucketHour  = "hour"
+	BucketDay   = "day"
+	BucketMonth = "month" // Folded from daily rows
+)
+
+// Filter selects usage rows
+type Filter struct {
+	UserIDs  []uint    // Restrict to these users (nil for all)
+	From     time.Time // Inclusive
+	To       time.Time // Exclusive
+	Bucket   string    // BucketHour, BucketDay or BucketMonth
+	ByDevice bool      // One row per device instead of per user
+}
+
+// Row is the traffic of a user (or device) in one bucket
+type Row struct {
+	Bucket       time.Time `json:"bucket"`
+	UserID       uint      `json:"user_id"`
+	Username     string    `json:"username"`
+	AllocationID uint      `json:"allocation_id,omitempty"`
+	DeviceIP     string    `json:"device_ip,omitempty"`
+	RxBytes      int64     `json:"rx_bytes"`
+	TxBytes      int64     `json:"tx_bytes"`
+}
+
+// ValidBucket reports whether bucket is a known bucket size
+func ValidBucket(bucket string) bool {
+	return bucket == BucketHour || bucket == BucketDay || bucket == BucketMonth
+}
+
+// Query returns usage rows ordered by bucket, then user and device
+func Query(db *gorm.DB, f Filter) ([]Row, error) {
+	table := "usage_daily"
+	if f.Bucket == BucketHour {
+		table = "usage_hourly"
+	}
+
+	columns := "u.bucket, u.user_id, users.username, SUM(u.rx_bytes) AS rx_bytes, SUM(u.tx_bytes) AS tx_bytes"
+	groupBy := "u.bucket, u.user_id, users.username"
+	if f.ByDevice {
+		columns = "u.bucket, u.user_id, users.username, u.allocation_id, MAX(u.device_ip) AS device_ip, SUM(u.rx_bytes) AS rx_bytes, SUM(u.tx_bytes) AS tx_bytes"
+		groupBy += ", u.allocation_id"
+	}
+
+	query := db.Table(table + " AS u").
+		Select(columns).
+		Joins("LEFT JOIN users ON users.id = u.user_id").
+		Group(groupBy).
+		Order("u.bucket ASC, u.user_id ASC")
+	if !f.From.IsZero() {
+		query = query.Where("u.bucket >= ?", bucketStart(f.From, f.Bucket))
+	}
+	if !f.To.IsZero() {
+		query = query.Where("u.bucket < ?", f.To.UTC())
+	}
+	if f.UserIDs != nil {
+		query = query.Where("u.user_id IN ?", f.UserIDs)
+	}
+
+	var rows []Row
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	if f.Bucket == BucketMonth {
+		rows = foldMonths(rows)
+	}
+	return rows, nil
+}
+
+// bucketStart aligns t to the start of its bucket so partial buckets are included
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// foldMonths sums daily rows into monthly rows
+func foldMonths(rows []Row) []Row {
+	type key struct {
+		month        time.Time
+		userID       uint
+		allocationID uint
+	}
+	sums := make(map[key]*Row)
+	var keys []key
+	for _, r := range rows {
+		k := key{bucketStart(r.Bucket, BucketMonth), r.UserID, r.AllocationID}
+		sum, ok := sums[k]
+		if !ok {
+			row := r
+			row.Bucket = k.month
+			row.RxBytes, row.TxBytes = 0, 0
+			sum = &row
+			sums[k] = sum
+			keys = append(keys, k)
+		}
+		sum.RxBytes += r.RxBytes
+		sum.TxBytes += r.TxBytes
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].month.Equal(keys[j].month) {
+			return keys[i].month.Before(keys[j].month)
+		}
+		if keys[i].userID != keys[j].userID {
+			return keys[i].userID < keys[j].userID
+		}
+		return keys[i].allocationID < keys[j].allocationID
+	})
+
+	folded := make([]Row, 0, len(keys))
+	for _, k := range keys {
+		folded = append(folded, *sums[k])
+	}
+	return folded
+}
+
+// Totals returns the summed traffic of rows
+func Totals(rows []Row) (rx, tx int64) {
+	for _, r := range rows {
+		rx += r.RxBytes
+		tx += r.TxBytes
+	}
+	return rx, tx
+}
+
+// ParseTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (UTC midnight)
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected RFC 3339 or YYYY-MM-DD)", s)
+	}
+	return t, nil
+}
+
+// WriteCSV writes rows as CSV with a header line
+func WriteCSV(w io.Writer, rows []Row, byDevice bool) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"bucket", "user_id", "username"}
+	if byDevice {
+		header = append(header, "allocation_id", "device_ip")
+	}
+	header = append(header, "rx_bytes", "tx_bytes")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		record := []string{r.Bucket.UTC().Format(time.RFC3339), strconv.FormatUint(uint64(r.UserID), 10), r.Username}
+		if byDevice {
+			record = append(record, strconv.FormatUint(uint64(r.AllocationID), 10), r.DeviceIP)
+		}
+		record = append(record, strconv.FormatInt(r.RxBytes, 10), strconv.FormatInt(r.TxBytes, 10))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
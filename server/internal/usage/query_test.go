@@ -0,0 +1,131 @@
+package usage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	db := testDB(t)
+	c := NewCollector(db, nil, Config{})
+
+	// alice's laptop and phone on March 31, her laptop on April 1 and 2,
+	// bob's laptop on April 1
+	start := time.Date(2026, 3, 31, 10, 0, 0, 0, time.UTC)
+	writes := []struct {
+		at time.Time
+		d  Delta
+	}{
+		{start, Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 100, TxBytes: 1000}},
+		{start.Add(30 * time.Minute), Delta{UserID: 1, AllocationID: 2, DeviceIP: "10.0.0.3", RxBytes: 10, TxBytes: 20}},
+		{start.Add(2 * time.Hour), Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 1, TxBytes: 2}},
+		{start.Add(24 * time.Hour), Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 5, TxBytes: 5}},
+		{start.Add(24 * time.Hour), Delta{UserID: 2, AllocationID: 3, DeviceIP: "10.0.0.4", RxBytes: 7, TxBytes: 9}},
+		{start.Add(48 * time.Hour), Delta{UserID: 1, AllocationID: 1, DeviceIP: "10.0.0.2", RxBytes: 3, TxBytes: 3}},
+	}
+	for _, w := range writes {
+		if err := c.store([]Delta{w.d}, w.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	march31 := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	april1 := march31.AddDate(0, 0, 1)
+	type row struct {
+		bucket   time.Time
+		userID   uint
+		deviceIP string
+		rx, tx   int64
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []row
+	}{
+		{"daily", Filter{Bucket: BucketDay}, []row{
+			{march31, 1, "", 111, 1022},
+			{april1, 1, "", 5, 5},
+			{april1, 2, "", 7, 9},
+			{april1.AddDate(0, 0, 1), 1, "", 3, 3},
+		}},
+		{"hourly", Filter{Bucket: BucketHour, To: april1}, []row{
+			{start, 1, "", 110, 1020},
+			{start.Add(2 * time.Hour), 1, "", 1, 2},
+		}},
+		{"hourly by device", Filter{Bucket: BucketHour, To: start.Add(time.Hour), ByDevice: true}, []row{
+			{start, 1, "10.0.0.2", 100, 1000},
+			{start, 1, "10.0.0.3", 10, 20},
+		}},
+		{"monthly", Filter{Bucket: BucketMonth}, []row{
+			{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 1, "", 111, 1022},
+			{time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 1, "", 8, 8},
+			{time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 2, "", 7, 9},
+		}},
+		{"one user", Filter{Bucket: BucketMonth, UserIDs: []uint{2}}, []row{
+			{time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 2, "", 7, 9},
+		}},
+		{"no users", Filter{Bucket: BucketDay, UserIDs: []uint{}}, nil},
+		{"from inside a day", Filter{Bucket: BucketDay, From: april1.Add(12 * time.Hour), To: april1.AddDate(0, 0, 1)}, []row{
+			{april1, 1, "", 5, 5},
+			{april1, 2, "", 7, 9},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := Query(db.DB, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d: %+v", len(rows), len(tt.want), rows)
+			}
+			for i, want := range tt.want {
+				got := rows[i]
+				if !got.Bucket.Equal(want.bucket) || got.UserID != want.userID || got.DeviceIP != want.deviceIP || got.RxBytes != want.rx || got.TxBytes != want.tx {
+					t.Errorf("row %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2026-04-01", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2026-04-01T12:30:00Z", time.Date(2026, 4, 1, 12, 30, 0, 0, time.UTC), false},
+		{"2026-04-01T12:30:00+02:00", time.Date(2026, 4, 1, 10, 30, 0, 0, time.UTC), false},
+		{"04/01/2026", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rows := []Row{{Bucket: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), UserID: 1, Username: "alice", AllocationID: 2, DeviceIP: "10.0.0.3", RxBytes: 10, TxBytes: 20}}
+	tests := []struct {
+		byDevice bool
+		want     string
+	}{
+		{false, "bucket,user_id,username,rx_bytes,tx_bytes\n2026-04-01T00:00:00Z,1,alice,10,20\n"},
+		{true, "bucket,user_id,username,allocation_id,device_ip,rx_bytes,tx_bytes\n2026-04-01T00:00:00Z,1,alice,2,10.0.0.3,10,20\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteCSV(&buf, rows, tt.byDevice); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("WriteCSV(byDevice=%v) = %q, want %q", tt.byDevice, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package webhook
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"wire-socket-server/internal/audit"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Handler serves the webhook admin API
+type Handler struct {
+	db         *gorm.DB
+	dispatcher *Dispatcher
+}
+
+// NewHandler creates a Handler
+func NewHandler(db *gorm.DB, dispatcher *Dispatcher) *Handler {
+	return &Handler{db: db, dispatcher: dispatcher}
+}
+
+// webhookRequest is the body of create and update requests
+type webhookRequest struct {
+	Name         *string   `json:"name"`
+	URL          *string   `json:"url"`
+	Events       *[]string `json:"events"` // Empty = all events
+	IsActive     *bool     `json:"is_active"`
+	Secret       *string   `json:"secret"`        // Generated when empty
+	RotateSecret bool      `json:"rotate_secret"` // Update only: generate a new secret
+}
+
+// validate checks the fields that are set
+func (req webhookRequest) validate() string {
+	if req.Name != nil && *req.Name == "" {
+		return "name is required"
+	}
+	if req.URL != nil {
+		u, err := url.Parse(*req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "url must be an http or https URL"
+		}
+	}
+	if req.Events != nil {
+		for _, e := range *req.Events {
+			if e != "*" && !events.ValidType(e) {
+				return "invalid event type: " + e
+			}
+		}
+	}
+	return ""
+}
+
+// List handles GET /api/admin/webhooks
+func (h *Handler) List(c *gin.Context) {
+	var hooks []database.Webhook
+	if err := h.db.Order("id ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "event_types": events.Types})
+}
+
+// Get handles GET /api/admin/webhooks/:id
+func (h *Handler) Get(c *gin.Context) {
+	hook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	var deadLetters int64
+	h.db.Model(&database.WebhookDeadLetter{}).Where("webhook_id = ?", hook.ID).Count(&deadLetters)
+
+	c.JSON(http.StatusOK, gin.H{"webhook": hook, "dead_letters": deadLetters})
+}
+
+// Create handles POST /api/admin/webhooks. The signing secret is only
+// returned in this response.
+func (h *Handler) Create(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == nil || req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	hook := database.Webhook{Name: *req.Name, URL: *req.URL, IsActive: true}
+	if req.Events != nil {
+		hook.Events = *req.Events
+	}
+	if req.Secret != nil && *req.Secret != "" {
+		hook.Secret = *req.Secret
+	} else {
+		secret, err := GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+			return
+		}
+		hook.Secret = secret
+	}
+
+	if err := h.db.Create(&hook).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "webhook name already exists"})
+		return
+	}
+	// is_active defaults to true in the database, so a disabled webhook is saved in a second step
+	if req.IsActive != nil && !*req.IsActive {
+		hook.IsActive = false
+		h.db.Model(&hook).Update("is_active", false)
+	}
+
+	h.dispatcher.ReloadWebhooks()
+	audit.Log(c, h.db, "webhook.create", "webhook", hook.ID, nil, hook)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": hook,
+		"secret":  hook.Secret,
+		"message": "store this secret now, it will not be shown again",
+	})
+}
+
+// Update handles PUT /api/admin/webhooks/:id
+func (h *Handler) Update(c *gin.Context) {
+	hook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	before := hook
+	if req.Name != nil {
+		hook.Name = *req.Name
+	}
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Events != nil {
+		hook.Events = *req.Events
+	}
+	if req.IsActive != nil {
+		hook.IsActive = *req.IsActive
+	}
+
+	newSecret := ""
+	if req.Secret != nil && *req.Secret != "" {
+		newSecret = *req.Secret
+	} else if req.RotateSecret {
+		secret, err := GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+			return
+		}
+		newSecret = secret
+	}
+	if newSecret != "" {
+		hook.Secret = newSecret
+	}
+
+	if err := h.db.Save(&hook).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "webhook name already exists"})
+		return
+	}
+
+	h.dispatcher.ReloadWebhooks()
+	audit.Log(c, h.db, "webhook.update", "webhook", hook.ID, before, hook)
+	if newSecret != "" {
+		// Secrets are hidden from JSON, so record the rotation separately
+		audit.Log(c, h.db, "webhook.rotate_secret", "webhook", hook.ID, nil, nil)
+	}
+
+	resp := gin.H{"webhook": hook}
+	if newSecret != "" {
+		resp["secret"] = newSecret
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Delete handles DELETE /api/admin/webhooks/:id, removing its dead letters too
+func (h *Handler) Delete(c *gin.Context) {
+	hook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&database.WebhookDeadLetter{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&hook).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+
+	h.dispatcher.ReloadWebhooks()
+	audit.Log(c, h.db, "webhook.delete", "webhook", hook.ID, hook, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// Test handles POST /api/admin/webhooks/:id/test. It sends a webhook.test
+// event once, without retries, and reports the endpoint's response.
+func (h *Handler) Test(c *gin.Context) {
+	hook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	event, result := h.dispatcher.Test(hook)
+
+	c.JSON(http.StatusOK, gin.H{
+		"delivered": result.Delivered(),
+		"event":     event,
+		"result":    result,
+	})
+}
+
+// ListDeadLetters handles GET /api/admin/webhooks/:id/dead-letters
+func (h *Handler) ListDeadLetters(c *gin.Context) {
+	hook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var entries []database.WebhookDeadLetter
+	if err := h.db.Where("webhook_id = ?", hook.ID).Order("id DESC").Limit(limit).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dead_letters": entries})
+}
+
+// RedeliverDeadLetter handles POST /api/admin/webhooks/:id/dead-letters/:letter_id/redeliver
+func (h *Handler) RedeliverDeadLetter(c *gin.Context) {
+	entry, ok := h.findDeadLetter(c)
+	if !ok {
+		return
+	}
+
+	if err := h.dispatcher.Redeliver(entry); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit.Log(c, h.db, "webhook.redeliver", "webhook", entry.WebhookID, nil, map[string]interface{}{"event_id": entry.EventID})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "event queued for redelivery"})
+}
+
+// DeleteDeadLetter handles DELETE /api/admin/webhooks/:id/dead-letters/:letter_id
+func (h *Handler) DeleteDeadLetter(c *gin.Context) {
+	entry, ok := h.findDeadLetter(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete dead letter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dead letter deleted"})
+}
+
+// find loads the webhook named by the :id parameter, writing an error response if it fails
+func (h *Handler) find(c *gin.Context) (database.Webhook, bool) {
+	var hook database.Webhook
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return hook, false
+	}
+	if err := h.db.First(&hook, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return hook, false
+	}
+	return hook, true
+}
+
+// findDeadLetter loads the dead letter named by :id and :letter_id
+func (h *Handler) findDeadLetter(c *gin.Context) (database.WebhookDeadLetter, bool) {
+	var entry database.WebhookDeadLetter
+	id, err1 := strconv.ParseUint(c.Param("id"), 10, 32)
+	letterID, err2 := strconv.ParseUint(c.Param("letter_id"), 10, 32)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return entry, false
+	}
+	if err := h.db.Where("id = ? AND webhook_id = ?", letterID, id).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+		return entry, false
+	}
+	return entry, true
+}
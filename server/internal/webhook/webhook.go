@@ -0,0 +1,440 @@
+// Package webhook delivers events to HTTP endpoints as HMAC-signed JSON.
+// Failed deliveries are retried with exponential backoff; events that still
+// can't be delivered are stored as dead letters for redelivery.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"wire-socket-server/internal/database"
+	"wire-socket-server/internal/events"
+
+	"gorm.io/gorm"
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-WireSocket-Event"
+	HeaderDelivery  = "X-WireSocket-Delivery"
+	HeaderTimestamp = "X-WireSocket-Timestamp"
+	HeaderSignature = "X-WireSocket-Signature"
+)
+
+// hookCacheTTL bounds how long the cached list of webhooks is used. Changes
+// through the API apply at once; others, e.g. by another instance sharing the
+// database, within this time.
+const hookCacheTTL = time.Minute
+
+// Config controls delivery. Zero values are replaced by defaults.
+type Config struct {
+	Disabled       bool          `yaml:"disabled"`        // Turn off webhook delivery
+	MaxAttempts    int           `yaml:"max_attempts"`    // Attempts before an event is dead-lettered (default: 6)
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // Wait before the first retry, doubled on each further one (default: 10s)
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // Retry wait cap (default: 10m)
+	Timeout        time.Duration `yaml:"timeout"`         // Per-request timeout (default: 10s)
+	Workers        int           `yaml:"workers"`         // Concurrent deliveries (default: 4)
+	QueueSize      int           `yaml:"queue_size"`      // Pending deliveries before new ones are dead-lettered (default: 1000)
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 6
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 10 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Minute
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1000
+	}
+	return c
+}
+
+// Sign returns the signature header value for a delivery: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook secret, prefixed with "sha256=".
+// Receivers should recompute it and reject old timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// delivery is one event on its way to one webhook
+type delivery struct {
+	webhookID uint
+	eventID   string
+	eventType string
+	payload   []byte
+	attempts  int
+}
+
+// Result is the outcome of one delivery attempt
+type Result struct {
+	StatusCode int    `json:"status_code,omitempty"` // 0 when no response was received
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Delivered reports whether the endpoint accepted the event
+func (r Result) Delivered() bool {
+	return r.Error == ""
+}
+
+// Dispatcher delivers bus events to the webhooks stored in the database
+type Dispatcher struct {
+	config Config
+	db     *gorm.DB
+	client *http.Client
+
+	pending  chan events.Event // Published events awaiting fan-out to their webhooks
+	queue    chan delivery
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	retries  map[*delivery]*time.Timer // Deliveries waiting for a retry
+	stopped  bool
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	cancel   func()
+
+	hooksMu  sync.Mutex
+	hooks    []database.Webhook // Active webhooks
+	hooksAge time.Time          // When hooks was loaded; zero if it must be
+}
+
+// NewDispatcher creates a Dispatcher. db holds the webhooks and dead letters.
+func NewDispatcher(db *gorm.DB, config Config) *Dispatcher {
+	config = config.withDefaults()
+	return &Dispatcher{
+		config:  config,
+		db:      db,
+		client:  &http.Client{Timeout: config.Timeout},
+		pending: make(chan events.Event, config.QueueSize),
+		queue:   make(chan delivery, config.QueueSize),
+		retries: make(map[*delivery]*time.Timer),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start subscribes to bus and starts the delivery workers
+func (d *Dispatcher) Start(bus *events.Bus) {
+	if d.config.Disabled {
+		return
+	}
+
+	d.wg.Add(1 + d.config.Workers)
+	go d.fanOut()
+	for i := 0; i < d.config.Workers; i++ {
+		go d.worker()
+	}
+	d.cancel = bus.Subscribe(d.dispatch)
+
+	slog.Info("webhook delivery started", "workers", d.config.Workers)
+}
+
+// Stop unsubscribes from the bus and waits for in-flight deliveries.
+// Queued deliveries and pending retries are dead-lettered.
+func (d *Dispatcher) Stop() {
+	var stopped []delivery
+	d.stopOnce.Do(func() {
+		if d.cancel != nil {
+			d.cancel()
+		}
+		d.mu.Lock()
+		d.stopped = true
+		for job, timer := range d.retries {
+			if timer.Stop() {
+				stopped = append(stopped, *job)
+			}
+		}
+		d.mu.Unlock()
+		close(d.stopCh)
+	})
+	for _, job := range stopped {
+		d.deadLetter(job, Result{Error: "server shutting down"})
+	}
+	d.wg.Wait()
+
+	// Events not fanned out yet are dead-lettered by enqueue
+	for {
+		select {
+		case e := <-d.pending:
+			d.queueEvent(e)
+		case job := <-d.queue:
+			d.deadLetter(job, Result{Error: "server shutting down"})
+		default:
+			return
+		}
+	}
+}
+
+// dispatch hands a published event to the fan-out goroutine. It runs in the
+// publisher's goroutine, so it must not block: when too many events are
+// pending, the event is dropped.
+func (d *Dispatcher) dispatch(e events.Event) {
+	select {
+	case d.pending <- e:
+	default:
+		slog.Warn("webhook event queue full, dropping event", "event", e.Type)
+	}
+}
+
+// fanOut queues the pending events for their webhooks until the dispatcher
+// stops
+func (d *Dispatcher) fanOut() {
+	defer d.wg.Done()
+	for {
+		select {
+		case e := <-d.pending:
+			d.queueEvent(e)
+		case <-d.stopCh:
+			return
+		}
+	}
+}
+
+// ReloadWebhooks drops the cached list of webhooks, so that changes apply to
+// the next event
+func (d *Dispatcher) ReloadWebhooks() {
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	d.hooksAge = time.Time{}
+}
+
+// activeHooks returns the active webhooks, loading them when the cached list
+// is stale
+func (d *Dispatcher) activeHooks() ([]database.Webhook, error) {
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	if !d.hooksAge.IsZero() && time.Since(d.hooksAge) < hookCacheTTL {
+		return d.hooks, nil
+	}
+
+	var hooks []database.Webhook
+	if err := d.db.Where("is_active = ?", true).Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+	d.hooks, d.hooksAge = hooks, time.Now()
+	return hooks, nil
+}
+
+// queueEvent queues an event for every active webhook subscribed to it
+func (d *Dispatcher) queueEvent(e events.Event) {
+	hooks, err := d.activeHooks()
+	if err != nil {
+		slog.Warn("failed to load webhooks", "event", e.Type, "error", err)
+		return
+	}
+
+	var payload []byte
+	for _, hook := range hooks {
+		if !hook.Subscribed(e.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(e); err != nil {
+				slog.Warn("failed to encode event", "event", e.Type, "error", err)
+				return
+			}
+		}
+		d.enqueue(delivery{webhookID: hook.ID, eventID: e.ID, eventType: e.Type, payload: payload})
+	}
+}
+
+// enqueue adds a delivery to the queue, dead-lettering it if the queue is
+// full or the dispatcher has stopped
+func (d *Dispatcher) enqueue(job delivery) {
+	d.mu.Lock()
+	reason := ""
+	if d.stopped {
+		reason = "server shutting down"
+	} else {
+		select {
+		case d.queue <- job:
+		default:
+			reason = "delivery queue full"
+		}
+	}
+	d.mu.Unlock()
+
+	if reason != "" {
+		d.deadLetter(job, Result{Error: reason})
+	}
+}
+
+// worker delivers queued events until the dispatcher stops
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for {
+		select {
+		case job := <-d.queue:
+			d.attempt(job)
+		case <-d.stopCh:
+			return
+		}
+	}
+}
+
+// attempt makes one delivery attempt and schedules a retry or dead-letters
+// the event if it fails
+func (d *Dispatcher) attempt(job delivery) {
+	var hook database.Webhook
+	if err := d.db.First(&hook, job.webhookID).Error; err != nil {
+		return // Deleted since the event was queued
+	}
+
+	job.attempts++
+	result := d.send(hook, job)
+	if result.Delivered() {
+		return
+	}
+
+	if job.attempts >= d.config.MaxAttempts {
+		slog.Warn("webhook delivery gave up", "webhook", hook.Name, "event", job.eventType, "attempts", job.attempts, "error", result.Error)
+		d.deadLetter(job, result)
+		return
+	}
+
+	d.retry(job, result)
+}
+
+// retry queues the delivery again after its backoff
+func (d *Dispatcher) retry(job delivery, result Result) {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		d.deadLetter(job, result)
+		return
+	}
+
+	key := &job
+	d.retries[key] = time.AfterFunc(d.backoff(job.attempts), func() {
+		d.mu.Lock()
+		delete(d.retries, key)
+		d.mu.Unlock()
+		d.enqueue(job)
+	})
+	d.mu.Unlock()
+}
+
+// backoff returns the wait before the retry following attempt n
+func (d *Dispatcher) backoff(n int) time.Duration {
+	wait := d.config.InitialBackoff
+	for i := 1; i < n && wait < d.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.config.MaxBackoff {
+		wait = d.config.MaxBackoff
+	}
+	return wait
+}
+
+// send POSTs the payload to the webhook
+func (d *Dispatcher) send(hook database.Webhook, job delivery) Result {
+	start := time.Now()
+	timestamp := start.Unix()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(job.payload))
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WireSocket-Webhook/1")
+	req.Header.Set(HeaderEvent, job.eventType)
+	req.Header.Set(HeaderDelivery, job.eventID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, job.payload))
+
+	resp, err := d.client.Do(req)
+	result := Result{DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Error = "unexpected status " + resp.Status
+	}
+	return result
+}
+
+// deadLetter stores an undeliverable event
+func (d *Dispatcher) deadLetter(job delivery, result Result) {
+	entry := database.WebhookDeadLetter{
+		WebhookID:  job.webhookID,
+		EventID:    job.eventID,
+		EventType:  job.eventType,
+		Payload:    string(job.payload),
+		Attempts:   job.attempts,
+		LastStatus: result.StatusCode,
+		LastError:  result.Error,
+	}
+	if err := d.db.Create(&entry).Error; err != nil {
+		slog.Warn("failed to store webhook dead letter", "webhook_id", job.webhookID, "error", err)
+	}
+}
+
+// Test sends a webhook.test event to hook once, without retries
+func (d *Dispatcher) Test(hook database.Webhook) (events.Event, Result) {
+	e := events.New(events.WebhookTest, map[string]string{"webhook": hook.Name, "message": "test delivery"})
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return e, Result{Error: err.Error()}
+	}
+	return e, d.send(hook, delivery{webhookID: hook.ID, eventID: e.ID, eventType: e.Type, payload: payload, attempts: 1})
+}
+
+// Redeliver queues a dead letter again with a fresh set of attempts and
+// removes it; it is dead-lettered again if delivery still fails
+func (d *Dispatcher) Redeliver(entry database.WebhookDeadLetter) error {
+	if d.config.Disabled {
+		return fmt.Errorf("webhook delivery is disabled")
+	}
+	if err := d.db.Delete(&entry).Error; err != nil {
+		return err
+	}
+	d.enqueue(delivery{
+		webhookID: entry.WebhookID,
+		eventID:   entry.EventID,
+		eventType: entry.EventType,
+		payload:   []byte(entry.Payload),
+	})
+	return nil
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"user.created"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		want      string
+	}{
+		{"event", "whsec_test", 1700000000, body, "sha256=2309b3241c934edd598182cd8af8663e23a4ed93bae9e076fbd3e8df8202253b"},
+		{"other secret", "other", 1700000000, body, "sha256=989c47dc249ac863660d51529b3299fcf404ce593d36d98245138805a76962f2"},
+		{"other timestamp", "whsec_test", 1700000001, body, "sha256=e250e4c7c1f24d9f2e984f4cfa7e8e90274b6ed20a13e3211266210eb46bc1cd"},
+		{"empty body", "whsec_test", 0, nil, "sha256=a2fa7a43c6a1cf2e784eaf3327d65c65b3d2b790320ebed9aa5661bc42a8cccd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		n      int
+		want   time.Duration
+	}{
+		{"first retry", Config{}, 1, 10 * time.Second},
+		{"doubled", Config{}, 2, 20 * time.Second},
+		{"doubled again", Config{}, 4, 80 * time.Second},
+		{"default cap", Config{}, 10, 10 * time.Minute},
+		{"custom", Config{InitialBackoff: time.Second, MaxBackoff: time.Minute}, 3, 4 * time.Second},
+		{"custom cap", Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+		{"initial above cap", Config{InitialBackoff: time.Minute, MaxBackoff: time.Second}, 1, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dispatcher{config: tt.config.withDefaults()}
+			if got := d.backoff(tt.n); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package wireguard
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"time"
 	"wire-socket-server/internal/database"
@@ -230,12 +231,12 @@ func (g *ConfigGenerator) ReclaimStaleIPs(inactiveDays int) error {
 		// Remove peer from WireGuard
 		if err := g.wgManager.RemovePeer(allocation.PublicKey); err != nil {
 			// Log error but continue
-			fmt.Printf("failed to remove peer %s: %v\n", allocation.PublicKey, err)
+			slog.Warn("failed to remove stale peer", "peer", allocation.PublicKey, "device_ip", allocation.IPAddress, "error", err)
 		}
 
 		// Delete allocation from database
 		if err := g.db.Delete(&allocation).Error; err != nil {
-			fmt.Printf("failed to delete allocation for IP %s: %v\n", allocation.IPAddress, err)
+			slog.Warn("failed to delete stale allocation", "device_ip", allocation.IPAddress, "error", err)
 		}
 	}
 
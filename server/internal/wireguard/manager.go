@@ -3,6 +3,7 @@ package wireguard
 import (
 	"bufio"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
@@ -136,12 +137,13 @@ func (m *Manager) AddPeer(publicKey, allowedIP string) error {
 	if err != nil {
 		return fmt.Errorf("failed to add peer: %w", err)
 	}
+	slog.Debug("peer added", "peer", publicKey, "allowed_ip", allowedIP)
 
 	// Persist to config file
 	if m.privateKey != "" {
 		if err := m.SaveConfigFile(m.privateKey, m.address, m.listenPort); err != nil {
 			// Log but don't fail - WireGuard is already configured
-			fmt.Printf("Warning: failed to persist config: %v\n", err)
+			slog.Warn("failed to persist WireGuard config", "path", m.configPath, "error", err)
 		}
 	}
 
@@ -153,12 +155,13 @@ func (m *Manager) RemovePeer(publicKey string) error {
 	if err := m.backend.RemovePeer(publicKey); err != nil {
 		return fmt.Errorf("failed to remove peer: %w", err)
 	}
+	slog.Debug("peer removed", "peer", publicKey)
 
 	// Persist to config file
 	if m.privateKey != "" {
 		if err := m.SaveConfigFile(m.privateKey, m.address, m.listenPort); err != nil {
 			// Log but don't fail - WireGuard is already configured
-			fmt.Printf("Warning: failed to persist config: %v\n", err)
+			slog.Warn("failed to persist WireGuard config", "path", m.configPath, "error", err)
 		}
 	}
 